@@ -1,13 +1,17 @@
 package sipgo
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"strings"
 
 	"github.com/google/uuid"
 
 	"github.com/livekit/sipgo/sip"
+	"github.com/livekit/sipgo/transaction"
 	"github.com/livekit/sipgo/transport"
 )
 
@@ -20,6 +24,10 @@ type Client struct {
 	host string
 	port int
 	log  *slog.Logger
+
+	auth *digestAuthorizer
+	// routeSet is pre-loaded route set of requests outside dialog
+	routeSet []sip.Uri
 }
 
 type ClientOption func(c *Client) error
@@ -64,6 +72,16 @@ func WithClientAddr(addr string) ClientOption {
 	}
 }
 
+// WithClientRouteSet sets pre-loaded route set, for example outbound proxy. It is added as Route headers
+// to requests outside dialog which do not have Route. Routes without lr parameter are strict routers.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-8.1.2
+func WithClientRouteSet(routes ...sip.Uri) ClientOption {
+	return func(s *Client) error {
+		s.routeSet = routes
+		return nil
+	}
+}
+
 // NewClient creates client handle for user agent
 func NewClient(ua *UserAgent, options ...ClientOption) (*Client, error) {
 	c := &Client{
@@ -102,32 +120,228 @@ func (c *Client) GetHostname() string {
 // that you have request fully built
 // This is useful when using client handle in proxy building as request are already parsed
 func (c *Client) TransactionRequest(req *sip.Request, options ...ClientRequestOption) (sip.ClientTransaction, error) {
+	if err := clientRequestBuild(c, req, options...); err != nil {
+		return nil, err
+	}
+	return c.tx.Request(req)
+}
+
+// clientRequestBuild builds missing headers of request, or applies options if passed.
+// Request with strict router in first Route is rewritten after that.
+func clientRequestBuild(c *Client, req *sip.Request, options ...ClientRequestOption) error {
 	if len(options) == 0 {
-		clientRequestBuildReq(c, req)
-		return c.tx.Request(req)
+		if err := clientRequestBuildReq(c, req); err != nil {
+			return err
+		}
+		return requestRoute(req)
 	}
 
 	for _, o := range options {
 		if err := o(c, req); err != nil {
-			return nil, err
+			return err
+		}
+	}
+	return requestRoute(req)
+}
+
+// Do sends request using transaction layer and waits for final (non 1xx) response.
+// Request is built same way as in TransactionRequest.
+//
+// In case ctx is canceled before final response, INVITE transaction is canceled by sending CANCEL
+// and Do keeps waiting for final response. Any non 2xx final response is then returned with ctx error.
+// Other transactions are terminated and ctx error is returned.
+//
+// If client is created with WithClientDigestAuth, 401 and 407 challenges are answered and request is resent.
+//
+// Transaction errors are returned wrapped, so they can be checked with
+// errors.Is(err, transaction.ErrTimeout) or errors.Is(err, transaction.ErrTransport)
+//
+// NOTE: ACK for 2xx INVITE response is not sent and must be sent by caller
+func (c *Client) Do(ctx context.Context, req *sip.Request, options ...ClientRequestOption) (*sip.Response, error) {
+	return c.DoWithProvisional(ctx, req, nil, options...)
+}
+
+// DoWithProvisional is same as Do, but it calls onProvisional for every received 1xx response
+func (c *Client) DoWithProvisional(ctx context.Context, req *sip.Request, onProvisional func(res *sip.Response), options ...ClientRequestOption) (*sip.Response, error) {
+	res, err := c.do(ctx, req, onProvisional, options...)
+	if err != nil || c.auth == nil || !isDigestChallenge(res) {
+		return res, err
+	}
+	return c.doDigestAuth(ctx, c.auth, req, res, onProvisional)
+}
+
+func (c *Client) do(ctx context.Context, req *sip.Request, onProvisional func(res *sip.Response), options ...ClientRequestOption) (*sip.Response, error) {
+	tx, res, err := c.doTx(ctx, req, onProvisional, options...)
+	if tx != nil {
+		tx.Terminate()
+	}
+	return res, err
+}
+
+// doTx is same as do, but it does not terminate transaction after final response.
+// This allows receiving more 2xx responses for forked INVITE. Caller must terminate transaction.
+//
+// If server location resolves to multiple targets, request is sent to next target in new transaction
+// when transport fails, transaction times out or 503 is received.
+// https://datatracker.ietf.org/doc/html/rfc3263#section-4.3
+func (c *Client) doTx(ctx context.Context, req *sip.Request, onProvisional func(res *sip.Response), options ...ClientRequestOption) (sip.ClientTransaction, *sip.Response, error) {
+	if err := clientRequestBuild(c, req, options...); err != nil {
+		return nil, nil, err
+	}
+
+	var targets []transport.Target
+	if req.MessageData.Destination() == "" {
+		// Resolving errors are returned by transport layer when sending
+		targets, _ = c.tp.ResolveRequest(ctx, req)
+	}
+	if len(targets) < 2 || req.Via() == nil {
+		tx, err := c.txRequest(ctx, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		res, err := clientTxWaitFinal(ctx, req, tx, onProvisional)
+		return tx, res, err
+	}
+
+	// Via sent-by is rewritten by transport layer for each connection
+	via := req.Via()
+	viaHost, viaPort := via.Host, via.Port
+	for i, target := range targets {
+		if i > 0 {
+			via.Host, via.Port = viaHost, viaPort
+			via.Params.Add("branch", sip.GenerateBranchN(16))
 		}
+		clientRequestSetTarget(req, target)
+		last := i == len(targets)-1
+
+		tx, err := c.txRequest(ctx, req)
+		if err != nil {
+			if last {
+				return nil, nil, err
+			}
+			c.log.Debug("Failed to send request, trying next target", "err", err, "target", target.String())
+			continue
+		}
+
+		res, err := clientTxWaitFinal(ctx, req, tx, onProvisional)
+		if last || ctx.Err() != nil || !clientTargetFailed(res, err) {
+			return tx, res, err
+		}
+		tx.Terminate()
+		c.tp.DNSCache().Blacklist(target)
+		c.log.Debug("Request to target failed, trying next target", "err", err, "target", target.String())
+	}
+	return nil, nil, ErrTransactionTerminated
+}
+
+// clientRequestSetTarget sets destination and transport of request to resolved target
+func clientRequestSetTarget(req *sip.Request, target transport.Target) {
+	tp := strings.ToUpper(target.Network)
+	req.SetTransport(tp)
+	req.SetDestination(target.Addr.String())
+	if via := req.Via(); via != nil {
+		via.Transport = tp
+	}
+}
+
+// clientTargetFailed checks should request be sent to next target
+// https://datatracker.ietf.org/doc/html/rfc3263#section-4.3
+func clientTargetFailed(res *sip.Response, err error) bool {
+	if err != nil {
+		return errors.Is(err, transaction.ErrTransport) || errors.Is(err, transaction.ErrTimeout)
+	}
+	return res != nil && res.StatusCode == sip.StatusServiceUnavailable
+}
+
+type prackCSeqKey struct{}
+
+// withPrackCSeq makes INVITE sent with ctx take CSeq of PRACK from prackCSeq.
+// Context is used, so that it is kept when INVITE is resent, for example on digest challenge.
+func withPrackCSeq(ctx context.Context, prackCSeq func(res *sip.Response) uint32) context.Context {
+	return context.WithValue(ctx, prackCSeqKey{}, prackCSeq)
+}
+
+func (c *Client) txRequest(ctx context.Context, req *sip.Request) (*transaction.ClientTx, error) {
+	if prackCSeq, ok := ctx.Value(prackCSeqKey{}).(func(res *sip.Response) uint32); ok && req.IsInvite() {
+		return c.tx.RequestWithPrackCSeq(req, prackCSeq)
 	}
 	return c.tx.Request(req)
 }
 
+var (
+	// ErrTransactionTerminated is returned when transaction is terminated without final response and error
+	ErrTransactionTerminated = errors.New("transaction terminated")
+)
+
+func clientTxWaitFinal(ctx context.Context, req *sip.Request, tx sip.ClientTransaction, onProvisional func(res *sip.Response)) (*sip.Response, error) {
+	done := ctx.Done()
+	var ctxErr error
+	for {
+		select {
+		case res, more := <-tx.Responses():
+			if !more {
+				return nil, clientTxErr(tx, ctxErr)
+			}
+
+			if res.IsProvisional() {
+				if onProvisional != nil {
+					onProvisional(res)
+				}
+				continue
+			}
+
+			return clientTxFinal(res, ctxErr)
+
+		case <-tx.Done():
+			// Responses can be still buffered when transaction terminates
+			for res := range tx.Responses() {
+				if !res.IsProvisional() {
+					return clientTxFinal(res, ctxErr)
+				}
+			}
+			return nil, clientTxErr(tx, ctxErr)
+
+		case <-done:
+			ctxErr = ctx.Err()
+			if !req.IsInvite() {
+				return nil, ctxErr
+			}
+
+			// Stop selecting on context, and wait final response on CANCEL
+			done = nil
+			if err := tx.Cancel(); err != nil {
+				return nil, errors.Join(ctxErr, err)
+			}
+		}
+	}
+}
+
+func clientTxFinal(res *sip.Response, ctxErr error) (*sip.Response, error) {
+	if ctxErr != nil && !res.IsSuccess() {
+		return res, ctxErr
+	}
+	return res, nil
+}
+
+func clientTxErr(tx sip.ClientTransaction, ctxErr error) error {
+	err := tx.Err()
+	switch {
+	case err != nil && ctxErr != nil:
+		return errors.Join(ctxErr, err)
+	case err != nil:
+		return err
+	case ctxErr != nil:
+		return ctxErr
+	}
+	return ErrTransactionTerminated
+}
+
 // WriteRequest sends request directly to transport layer
 // Behavior is same as TransactionRequest
 // Non-transaction ACK request should be passed like this
 func (c *Client) WriteRequest(req *sip.Request, options ...ClientRequestOption) error {
-	if len(options) == 0 {
-		clientRequestBuildReq(c, req)
-		return c.tp.WriteMsg(req)
-	}
-
-	for _, o := range options {
-		if err := o(c, req); err != nil {
-			return err
-		}
+	if err := clientRequestBuild(c, req, options...); err != nil {
+		return err
 	}
 	return c.tp.WriteMsg(req)
 }
@@ -204,6 +418,11 @@ func clientRequestBuildReq(c *Client, req *sip.Request) error {
 		req.SetBody(nil)
 	}
 
+	// Pre-loaded route set is used only for requests outside dialog
+	if len(c.routeSet) > 0 && req.Route() == nil && !req.To().Params.Has("tag") {
+		dialogRequestRoute(req, req.Recipient, c.routeSet)
+	}
+
 	return nil
 }
 
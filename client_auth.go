@@ -0,0 +1,247 @@
+package sipgo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+const (
+	// digestAuthMaxAttempts limits number of answered challenges per request.
+	// More than one is needed for stale nonce or for proxy and UAS challenging same request
+	digestAuthMaxAttempts = 3
+)
+
+// DigestAuth are credentials used for answering digest challenges
+type DigestAuth struct {
+	Username string
+	Password string
+}
+
+// WithClientDigestAuth enables answering 401/407 challenges with digest credentials.
+// Requests sent with Do are then automatically resent with Authorization or Proxy-Authorization header
+func WithClientDigestAuth(auth DigestAuth) ClientOption {
+	return func(c *Client) error {
+		c.auth = newDigestAuthorizer(auth)
+		return nil
+	}
+}
+
+// DoDigestAuth answers challenge received in res and sends request again with incremented CSeq
+// and new Via branch. Use it when request is sent with TransactionRequest or Do without WithClientDigestAuth.
+// Returned response is final response of new request. Stale nonce challenges are handled as well.
+func (c *Client) DoDigestAuth(ctx context.Context, req *sip.Request, res *sip.Response, auth DigestAuth) (*sip.Response, error) {
+	a := c.auth
+	if a == nil || a.auth != auth {
+		a = newDigestAuthorizer(auth)
+	}
+	return c.doDigestAuth(ctx, a, req, res, nil)
+}
+
+func (c *Client) doDigestAuth(ctx context.Context, a *digestAuthorizer, req *sip.Request, res *sip.Response, onProvisional func(res *sip.Response)) (*sip.Response, error) {
+	tx, _, res, err := c.doDigestAuthTx(ctx, a, req, res, onProvisional)
+	if tx != nil {
+		tx.Terminate()
+	}
+	return res, err
+}
+
+// doDigestAuthTx is same as doDigestAuth, but transaction of last sent request is not terminated.
+// Last sent request is returned as well. Returned transaction is nil if request was not resent.
+func (c *Client) doDigestAuthTx(ctx context.Context, a *digestAuthorizer, req *sip.Request, res *sip.Response, onProvisional func(res *sip.Response)) (sip.ClientTransaction, *sip.Request, *sip.Response, error) {
+	var tx sip.ClientTransaction
+	answered := make(map[string]struct{})
+	for i := 0; i < digestAuthMaxAttempts && isDigestChallenge(res); i++ {
+		authReq, err := a.authorize(req, res, answered)
+		if err != nil {
+			return tx, req, res, err
+		}
+		if authReq == nil {
+			// Credentials are rejected
+			return tx, req, res, nil
+		}
+
+		if tx != nil {
+			tx.Terminate()
+		}
+
+		req = authReq
+		tx, res, err = c.doTx(ctx, req, onProvisional)
+		if err != nil {
+			return tx, req, res, err
+		}
+	}
+	return tx, req, res, nil
+}
+
+func isDigestChallenge(res *sip.Response) bool {
+	return res.StatusCode == sip.StatusUnauthorized || res.StatusCode == sip.StatusProxyAuthRequired
+}
+
+// digestAuthorizer builds authorized requests and tracks nonce count per realm
+type digestAuthorizer struct {
+	auth DigestAuth
+
+	mu     sync.Mutex
+	realms map[string]*digestNonce
+}
+
+type digestNonce struct {
+	nonce string
+	nc    uint32
+}
+
+func newDigestAuthorizer(auth DigestAuth) *digestAuthorizer {
+	return &digestAuthorizer{
+		auth:   auth,
+		realms: make(map[string]*digestNonce),
+	}
+}
+
+// authorize creates new request answering challenges in res.
+// It returns nil request if all challenges are already answered and none of them is stale.
+func (a *digestAuthorizer) authorize(req *sip.Request, res *sip.Response, answered map[string]struct{}) (*sip.Request, error) {
+	challengeName, authName := "WWW-Authenticate", "Authorization"
+	if res.StatusCode == sip.StatusProxyAuthRequired {
+		challengeName, authName = "Proxy-Authenticate", "Proxy-Authorization"
+	}
+
+	challenges, err := digestChallenges(res, challengeName)
+	if err != nil {
+		return nil, err
+	}
+
+	authReq := req.Clone()
+	authReq.SetBody(req.Body())
+	for authReq.RemoveHeader(authName) {
+	}
+
+	var retry bool
+	for _, chal := range challenges {
+		// Stale means our credentials were fine, but nonce expired
+		if _, exists := answered[chal.Realm]; !exists || chal.Stale {
+			retry = true
+		}
+		answered[chal.Realm] = struct{}{}
+
+		cred, err := a.credentials(authReq, chal)
+		if err != nil {
+			return nil, err
+		}
+		authReq.AppendHeader(sip.NewHeader(authName, cred.String()))
+	}
+
+	if !retry {
+		return nil, nil
+	}
+
+	// https://datatracker.ietf.org/doc/html/rfc3261#section-22.2
+	// Request is resent as new transaction with incremented CSeq
+	if cseq := authReq.CSeq(); cseq != nil {
+		cseq.SeqNo++
+	}
+	if via := authReq.Via(); via != nil {
+		via.Params.Add("branch", sip.GenerateBranchN(16))
+	}
+	return authReq, nil
+}
+
+func (a *digestAuthorizer) credentials(req *sip.Request, chal *sip.DigestChallenge) (*sip.DigestCredentials, error) {
+	var qop string
+	switch {
+	case chal.SupportsQop(sip.DigestQopAuth):
+		qop = sip.DigestQopAuth
+	case chal.SupportsQop(sip.DigestQopAuthInt):
+		qop = sip.DigestQopAuthInt
+	case len(chal.Qop) > 0:
+		return nil, fmt.Errorf("%w: %s", sip.ErrDigestQopNotSupported, strings.Join(chal.Qop, ","))
+	}
+
+	cred := &sip.DigestCredentials{
+		Username:  a.auth.Username,
+		Realm:     chal.Realm,
+		Nonce:     chal.Nonce,
+		URI:       req.Recipient.String(),
+		Algorithm: chal.Algorithm,
+		Opaque:    chal.Opaque,
+		Qop:       qop,
+	}
+
+	if qop != "" {
+		cred.Cnonce = sip.RandString(16)
+		cred.NC = a.nextNonceCount(chal.Realm, chal.Nonce)
+	}
+
+	resp, err := sip.DigestResponse(chal.Algorithm, a.auth.Username, chal.Realm, a.auth.Password,
+		string(req.Method), cred.URI, chal.Nonce, cred.NC, cred.Cnonce, qop, req.Body())
+	if err != nil {
+		return nil, err
+	}
+	cred.Response = resp
+	return cred, nil
+}
+
+func (a *digestAuthorizer) nextNonceCount(realm string, nonce string) uint32 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n, exists := a.realms[realm]
+	if !exists || n.nonce != nonce {
+		n = &digestNonce{nonce: nonce}
+		a.realms[realm] = n
+	}
+	n.nc++
+	return n.nc
+}
+
+// digestChallenges returns strongest supported challenge per realm
+func digestChallenges(res *sip.Response, name string) ([]*sip.DigestChallenge, error) {
+	var challenges []*sip.DigestChallenge
+	for _, h := range res.GetHeaders(name) {
+		chal, err := sip.ParseDigestChallenge(h.Value())
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", name, err)
+		}
+		prio := digestAlgorithmPriority(chal.Algorithm)
+		if prio < 0 {
+			continue
+		}
+
+		replaced := false
+		for i, c := range challenges {
+			if c.Realm != chal.Realm {
+				continue
+			}
+			if prio > digestAlgorithmPriority(c.Algorithm) {
+				challenges[i] = chal
+			}
+			replaced = true
+			break
+		}
+		if !replaced {
+			challenges = append(challenges, chal)
+		}
+	}
+
+	if len(challenges) == 0 {
+		return nil, fmt.Errorf("%w: no supported %s challenge", sip.ErrDigestAlgorithmNotSupported, name)
+	}
+	return challenges, nil
+}
+
+// https://datatracker.ietf.org/doc/html/rfc8760#section-2.4
+// Client should prefer stronger algorithm when multiple challenges are offered
+func digestAlgorithmPriority(algorithm string) int {
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5", "MD5-SESS":
+		return 0
+	case "SHA-256", "SHA-256-SESS":
+		return 1
+	case "SHA-512-256", "SHA-512-256-SESS":
+		return 2
+	}
+	return -1
+}
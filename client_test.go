@@ -1,14 +1,21 @@
 package sipgo
 
 import (
+	"context"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/livekit/sipgo/fakes"
 	"github.com/livekit/sipgo/sip"
+	"github.com/livekit/sipgo/transaction"
+	"github.com/livekit/sipgo/transport"
 )
 
 func TestClientRequestBuild(t *testing.T) {
@@ -155,3 +162,299 @@ func TestClientRequestOptions(t *testing.T) {
 
 	assert.Len(t, res.GetHeaders("Via"), 1)
 }
+
+func testServerUDP(t *testing.T, srv *Server) sip.Uri {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go srv.ServeUDP(conn)
+
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	return sip.Uri{
+		User: "bob",
+		Host: addr.IP.String(),
+		Port: addr.Port,
+	}
+}
+
+func testClient(t *testing.T, options ...ClientOption) *Client {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	t.Cleanup(func() { ua.Close() })
+
+	c, err := NewClient(ua, append([]ClientOption{WithClientHostname("127.0.0.1")}, options...)...)
+	require.NoError(t, err)
+	return c
+}
+
+func TestClientDo(t *testing.T) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer ua.Close()
+
+	srv, err := NewServer(ua)
+	require.NoError(t, err)
+
+	srv.OnOptions(func(req *sip.Request, tx sip.ServerTransaction) {
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+
+	srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+		tx.Respond(sip.NewResponseFromRequest(req, 180, "Ringing", nil))
+		select {
+		case cancel := <-tx.Cancels():
+			tx.Respond(sip.NewResponseFromRequest(cancel, 200, "OK", nil))
+			tx.Respond(sip.NewResponseFromRequest(req, 487, "Request Terminated", nil))
+		case <-tx.Done():
+		}
+	})
+
+	uri := testServerUDP(t, srv)
+	c := testClient(t)
+
+	t.Run("Final", func(t *testing.T) {
+		res, err := c.Do(context.Background(), sip.NewRequest(sip.OPTIONS, uri))
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusOK, res.StatusCode)
+	})
+
+	t.Run("CancelInvite", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var provisional []*sip.Response
+		res, err := c.DoWithProvisional(ctx, sip.NewRequest(sip.INVITE, uri), func(res *sip.Response) {
+			provisional = append(provisional, res)
+			cancel()
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		require.NotNil(t, res)
+		assert.Equal(t, sip.StatusRequestTerminated, res.StatusCode)
+		require.Len(t, provisional, 1)
+		assert.Equal(t, sip.StatusRinging, provisional[0].StatusCode)
+	})
+}
+
+func TestClientDigestAuth(t *testing.T) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer ua.Close()
+
+	srv, err := NewServer(ua)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var cseqs []uint32
+	var branches []string
+	nonces := []string{"nonce1", "nonce2"}
+	srv.OnRegister(func(req *sip.Request, tx sip.ServerTransaction) {
+		mu.Lock()
+		cseqs = append(cseqs, req.CSeq().SeqNo)
+		branches = append(branches, req.Via().Params["branch"])
+		mu.Unlock()
+
+		h := req.GetHeader("Authorization")
+		if h == nil {
+			res := sip.NewResponseFromRequest(req, 401, "Unauthorized", nil)
+			chal := sip.DigestChallenge{Realm: "test", Nonce: nonces[0], Qop: []string{"auth"}, Algorithm: sip.DigestAlgorithmSHA256}
+			res.AppendHeader(sip.NewHeader("WWW-Authenticate", chal.String()))
+			tx.Respond(res)
+			return
+		}
+
+		cred, err := sip.ParseDigestCredentials(h.Value())
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		// First nonce is stale, force client to retry with new one
+		if cred.Nonce == nonces[0] {
+			res := sip.NewResponseFromRequest(req, 401, "Unauthorized", nil)
+			chal := sip.DigestChallenge{Realm: "test", Nonce: nonces[1], Qop: []string{"auth"}, Algorithm: sip.DigestAlgorithmSHA256, Stale: true}
+			res.AppendHeader(sip.NewHeader("WWW-Authenticate", chal.String()))
+			tx.Respond(res)
+			return
+		}
+
+		expected, _ := sip.DigestResponse(cred.Algorithm, "alice", cred.Realm, "secret", string(req.Method), cred.URI, cred.Nonce, cred.NC, cred.Cnonce, cred.Qop, nil)
+		if expected != cred.Response {
+			tx.Respond(sip.NewResponseFromRequest(req, 403, "Forbidden", nil))
+			return
+		}
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+
+	uri := testServerUDP(t, srv)
+
+	t.Run("Success", func(t *testing.T) {
+		c := testClient(t, WithClientDigestAuth(DigestAuth{Username: "alice", Password: "secret"}))
+		res, err := c.Do(context.Background(), sip.NewRequest(sip.REGISTER, uri))
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusOK, res.StatusCode)
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, []uint32{1, 2, 3}, cseqs)
+		require.Len(t, branches, 3)
+		assert.NotEqual(t, branches[0], branches[1])
+		assert.NotEqual(t, branches[1], branches[2])
+	})
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		c := testClient(t)
+		req := sip.NewRequest(sip.REGISTER, uri)
+		res, err := c.Do(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, sip.StatusUnauthorized, res.StatusCode)
+
+		res, err = c.DoDigestAuth(context.Background(), req, res, DigestAuth{Username: "alice", Password: "wrong"})
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusForbidden, res.StatusCode)
+	})
+}
+
+func TestClientDoFailover(t *testing.T) {
+	var first, second atomic.Int32
+	newServer := func(status sip.StatusCode, count *atomic.Int32) sip.Uri {
+		ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+		require.NoError(t, err)
+		t.Cleanup(func() { ua.Close() })
+
+		srv, err := NewServer(ua)
+		require.NoError(t, err)
+		srv.OnOptions(func(req *sip.Request, tx sip.ServerTransaction) {
+			count.Add(1)
+			tx.Respond(sip.NewResponseFromRequest(req, status, "", nil))
+		})
+		return testServerUDP(t, srv)
+	}
+	uri1 := newServer(sip.StatusServiceUnavailable, &first)
+	uri2 := newServer(sip.StatusOK, &second)
+
+	table := transport.NewDNSTable()
+	table.AddSRV("sip", "udp", "example.com",
+		&net.SRV{Target: "sip.example.com.", Port: uint16(uri1.Port), Priority: 10},
+		&net.SRV{Target: "sip.example.com.", Port: uint16(uri2.Port), Priority: 20},
+	)
+	table.AddIP("sip.example.com", net.ParseIP("127.0.0.1"))
+
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")), WithUserAgentDNSCache(transport.NewDNSCache(table)))
+	require.NoError(t, err)
+	defer ua.Close()
+	c, err := NewClient(ua, WithClientHostname("127.0.0.1"))
+	require.NoError(t, err)
+
+	// 503 from first target makes request to be sent to next one
+	res, err := c.Do(context.Background(), sip.NewRequest(sip.OPTIONS, sip.Uri{User: "bob", Host: "example.com"}))
+	require.NoError(t, err)
+	assert.Equal(t, sip.StatusOK, res.StatusCode)
+	assert.EqualValues(t, 1, first.Load())
+	assert.EqualValues(t, 1, second.Load())
+
+	// Failed target is blacklisted and tried last
+	res, err = c.Do(context.Background(), sip.NewRequest(sip.OPTIONS, sip.Uri{User: "bob", Host: "example.com"}))
+	require.NoError(t, err)
+	assert.Equal(t, sip.StatusOK, res.StatusCode)
+	assert.EqualValues(t, 1, first.Load())
+	assert.EqualValues(t, 2, second.Load())
+}
+
+func TestClientTimers(t *testing.T) {
+	// Server which never responds, so transaction times out
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	var received atomic.Int32
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return
+			}
+			received.Add(1)
+		}
+	}()
+	addr := conn.LocalAddr().(*net.UDPAddr)
+
+	var overridden atomic.Int32
+	ua, err := NewUA(
+		WithUserAgentIP(net.ParseIP("127.0.0.1")),
+		WithUserAgentTimers(transaction.Timers{T1: 10 * time.Millisecond, T2: 40 * time.Millisecond}),
+		WithUserAgentTimersFunc(func(req *sip.Request, timers transaction.Timers) transaction.Timers {
+			if req.Recipient.User == "fast" {
+				overridden.Add(1)
+				timers.T1 = 5 * time.Millisecond
+			}
+			return timers
+		}),
+	)
+	require.NoError(t, err)
+	defer ua.Close()
+	assert.Equal(t, 10*time.Millisecond, ua.TransactionLayer().Timers().T1)
+	assert.Equal(t, transaction.T4, ua.TransactionLayer().Timers().T4)
+
+	c, err := NewClient(ua, WithClientHostname("127.0.0.1"))
+	require.NoError(t, err)
+
+	for _, user := range []string{"bob", "fast"} {
+		start := time.Now()
+		received.Store(0)
+		_, err = c.Do(context.Background(), sip.NewRequest(sip.OPTIONS, sip.Uri{User: user, Host: "127.0.0.1", Port: addr.Port}))
+		require.ErrorIs(t, err, transaction.ErrTimeout)
+		// Timer F is 64*T1 and retransmissions are capped at T2
+		assert.Less(t, time.Since(start), 2*time.Second)
+		assert.Greater(t, received.Load(), int32(5))
+	}
+	assert.EqualValues(t, 1, overridden.Load())
+}
+
+func TestClientFakeClock(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	var received atomic.Int32
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return
+			}
+			received.Add(1)
+		}
+	}()
+	addr := conn.LocalAddr().(*net.UDPAddr)
+
+	clock := fakes.NewClock(time.Now())
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")), WithUserAgentClock(clock))
+	require.NoError(t, err)
+	defer ua.Close()
+	c, err := NewClient(ua, WithClientHostname("127.0.0.1"))
+	require.NoError(t, err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c.Do(context.Background(), sip.NewRequest(sip.OPTIONS, sip.Uri{User: "bob", Host: "127.0.0.1", Port: addr.Port}))
+		errCh <- err
+	}()
+
+	// Timer E for retransmission and Timer F for timeout
+	require.True(t, clock.BlockUntil(2, 2*time.Second))
+	require.Eventually(t, func() bool { return received.Load() == 1 }, time.Second, time.Millisecond)
+
+	clock.Advance(transaction.T1)
+	require.Eventually(t, func() bool { return received.Load() == 2 }, time.Second, time.Millisecond)
+	select {
+	case err := <-errCh:
+		t.Fatalf("transaction ended before Timer F: %v", err)
+	default:
+	}
+
+	clock.Advance(64 * transaction.T1)
+	select {
+	case err := <-errCh:
+		require.ErrorIs(t, err, transaction.ErrTimeout)
+	case <-time.After(2 * time.Second):
+		t.Fatal("transaction did not time out")
+	}
+}
@@ -0,0 +1,446 @@
+package sipgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/livekit/sipgo/sip"
+	"github.com/livekit/sipgo/transaction"
+)
+
+var (
+	// ErrDialogDoesNotExists is returned when request within dialog can not be matched to any dialog
+	ErrDialogDoesNotExists = errors.New("dialog does not exists")
+	// ErrDialogInvalidCseq is returned when request within dialog has lower CSeq than previous one
+	ErrDialogInvalidCseq = errors.New("dialog invalid CSeq number")
+	// ErrDialogTerminated is returned when request is sent on already terminated dialog
+	ErrDialogTerminated = errors.New("dialog terminated")
+	// ErrDialogConfirmed is returned when Replaces with early-only matches confirmed dialog
+	ErrDialogConfirmed = errors.New("dialog already confirmed")
+)
+
+// DialogSession is dialog session of either side, DialogClientSession or DialogServerSession
+type DialogSession interface {
+	ID() string
+	State() int
+	Context() context.Context
+	RemoteTarget() sip.Uri
+	Do(ctx context.Context, req *sip.Request) (*sip.Response, error)
+	Bye(ctx context.Context) error
+	Close() error
+}
+
+// ErrDialogResponse is returned when dialog request is answered with non 2xx final response
+type ErrDialogResponse struct {
+	Res *sip.Response
+}
+
+func (e ErrDialogResponse) Error() string {
+	return fmt.Sprintf("invalid dialog response. code=%d reason=%s", e.Res.StatusCode, e.Res.Reason)
+}
+
+// dialogSession is dialog state shared by UAC and UAS sessions.
+// It tracks local and remote CSeq, remote target and route set, which are used
+// for building requests within dialog as described in RFC 3261 section 12.2.1
+type dialogSession struct {
+	c      *Client
+	id     string
+	ctx    context.Context
+	cancel context.CancelFunc
+	onEnd  func(id string)
+
+	// Headers used for requests within dialog
+	callID     sip.CallIDHeader
+	localHDR   sip.FromHeader
+	remoteHDR  sip.ToHeader
+	contactHDR *sip.ContactHeader
+
+	mu           sync.Mutex
+	state        int
+	localCSeq    uint32
+	remoteCSeq   uint32
+	remoteTarget sip.Uri
+	routeSet     []sip.Uri
+
+	// Session timer RFC 4028
+	timerCfg        SessionTimer
+	sessionInterval time.Duration
+	sessionMinSE    uint32
+	localRefresher  bool
+	sessionTimer    sip.Timer
+	clock           sip.Clock
+	// Last local session description, resent on session refresh with re-INVITE
+	localBody        []byte
+	localContentType sip.Header
+
+	// Sent REFER requests waiting for NOTIFY, by event id
+	refers map[string]*Refer
+}
+
+func newDialogSession(c *Client, id string, onEnd func(id string)) *dialogSession {
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := c.clock
+	if clock == nil {
+		clock = sip.SystemClock
+	}
+	return &dialogSession{
+		c:      c,
+		id:     id,
+		ctx:    ctx,
+		cancel: cancel,
+		onEnd:  onEnd,
+		state:  sip.DialogStateEstablished,
+		clock:  clock,
+	}
+}
+
+// ID returns dialog ID, created by Call-ID, remote tag and local tag
+func (s *dialogSession) ID() string {
+	return s.id
+}
+
+// State returns current dialog state. Check sip.DialogState... constants
+func (s *dialogSession) State() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Context is canceled when dialog is terminated
+func (s *dialogSession) Context() context.Context {
+	return s.ctx
+}
+
+// RemoteTarget returns URI where requests within dialog are sent
+func (s *dialogSession) RemoteTarget() sip.Uri {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.remoteTarget.Clone()
+}
+
+// Bye sends BYE and terminates dialog. Dialog is terminated even if BYE fails.
+// Non 2xx final response is returned as ErrDialogResponse
+func (s *dialogSession) Bye(ctx context.Context) error {
+	switch s.State() {
+	case sip.DialogStateEnded:
+		return nil
+	case sip.DialogStateEarly, sip.DialogStateEstablished:
+		return fmt.Errorf("dialog not confirmed. ACK not sent or received?")
+	}
+	return s.bye(ctx)
+}
+
+// bye sends BYE and ends dialog regardless of its state
+func (s *dialogSession) bye(ctx context.Context) error {
+	defer s.end()
+
+	res, err := s.Do(ctx, sip.NewRequest(sip.BYE, sip.Uri{}))
+	if err != nil {
+		return err
+	}
+
+	if !res.IsSuccess() {
+		return ErrDialogResponse{Res: res}
+	}
+	return nil
+}
+
+// ReInvite sends INVITE within dialog and sends ACK on 2xx response.
+// Remote target is updated with Contact of 2xx response.
+// Non 2xx final response is returned together with ErrDialogResponse.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-14.1
+func (s *dialogSession) ReInvite(ctx context.Context, body []byte, headers ...sip.Header) (*sip.Response, error) {
+	req := sip.NewRequest(sip.INVITE, sip.Uri{})
+	for _, h := range headers {
+		req.AppendHeader(h)
+	}
+	req.SetBody(body)
+
+	res, err := s.Do(ctx, req)
+	if err != nil {
+		return res, err
+	}
+
+	if !res.IsSuccess() {
+		return res, ErrDialogResponse{Res: res}
+	}
+
+	if _, err := s.ack(res.CSeq().SeqNo, nil); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// Do sends request within dialog and waits for final response.
+// Request-URI, From, To, Call-ID, CSeq and Route headers are set by dialog,
+// so request can be created as sip.NewRequest(method, sip.Uri{}).
+// 2xx responses for INVITE are not acknowledged, use ReInvite for that.
+//
+// Dialog is terminated when response is 481 or 408 or request times out.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-12.2.1.2
+func (s *dialogSession) Do(ctx context.Context, req *sip.Request) (*sip.Response, error) {
+	if err := s.prepareRequest(req, 0); err != nil {
+		return nil, err
+	}
+	return s.send(ctx, req)
+}
+
+// send sends request prepared with prepareRequest and reads response
+func (s *dialogSession) send(ctx context.Context, req *sip.Request) (*sip.Response, error) {
+	res, err := s.c.Do(ctx, req)
+	if err != nil {
+		if errors.Is(err, transaction.ErrTimeout) {
+			s.end()
+		}
+		return res, err
+	}
+
+	s.readResponse(req, res)
+	return res, nil
+}
+
+// Close terminates dialog without sending BYE
+func (s *dialogSession) Close() error {
+	s.end()
+	return nil
+}
+
+func (s *dialogSession) ack(cseq uint32, body []byte, headers ...sip.Header) (*sip.Request, error) {
+	req := sip.NewRequest(sip.ACK, sip.Uri{})
+	for _, h := range headers {
+		req.AppendHeader(h)
+	}
+	req.SetBody(body)
+
+	if err := s.prepareRequest(req, cseq); err != nil {
+		return nil, err
+	}
+	// ACK for 2xx is not part of INVITE transaction and it is sent directly
+	return req, s.c.WriteRequest(req)
+}
+
+// prepareRequest sets dialog headers on request. In case cseq is 0 next local CSeq is used.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-12.2.1.1
+func (s *dialogSession) prepareRequest(req *sip.Request, cseq uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == sip.DialogStateEnded {
+		return ErrDialogTerminated
+	}
+
+	if cseq == 0 {
+		s.localCSeq++
+		cseq = s.localCSeq
+	}
+
+	for _, name := range []string{"From", "To", "Call-ID", "CSeq"} {
+		for req.RemoveHeader(name) {
+		}
+	}
+
+	from := sip.FromHeader{
+		DisplayName: s.localHDR.DisplayName,
+		Address:     *s.localHDR.Address.Clone(),
+		Params:      s.localHDR.Params.Clone(),
+	}
+	to := sip.ToHeader{
+		DisplayName: s.remoteHDR.DisplayName,
+		Address:     *s.remoteHDR.Address.Clone(),
+		Params:      s.remoteHDR.Params.Clone(),
+	}
+	callID := s.callID
+
+	req.AppendHeader(&from)
+	req.AppendHeader(&to)
+	req.AppendHeader(&callID)
+	req.AppendHeader(&sip.CSeqHeader{SeqNo: cseq, MethodName: req.Method})
+
+	dialogRequestRoute(req, s.remoteTarget, s.routeSet)
+
+	if isTargetRefreshRequest(req.Method) && req.Contact() == nil && s.contactHDR != nil {
+		req.AppendHeader(s.contactHDR.Clone())
+	}
+	s.sessionTimerRequest(req)
+	return nil
+}
+
+func (s *dialogSession) readResponse(req *sip.Request, res *sip.Response) {
+	switch res.StatusCode {
+	case sip.StatusCallTransactionDoesNotExists, sip.StatusRequestTimeout:
+		s.end()
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Request could be resent with higher CSeq, for example on digest challenge
+	if cseq := res.CSeq(); cseq != nil && cseq.SeqNo > s.localCSeq {
+		s.localCSeq = cseq.SeqNo
+	}
+
+	if res.IsSuccess() && isTargetRefreshRequest(req.Method) {
+		if contact := res.Contact(); contact != nil {
+			s.remoteTarget = *contact.Address.Clone()
+		}
+	}
+	s.sessionTimerResponse(req, res)
+}
+
+// readRequest validates request within dialog and updates remote CSeq and remote target
+// https://datatracker.ietf.org/doc/html/rfc3261#section-12.2.2
+func (s *dialogSession) readRequest(req *sip.Request) error {
+	cseq := req.CSeq()
+	if cseq == nil {
+		return fmt.Errorf("missing CSeq header")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == sip.DialogStateEnded {
+		return ErrDialogDoesNotExists
+	}
+
+	if s.remoteCSeq != 0 && cseq.SeqNo < s.remoteCSeq {
+		return ErrDialogInvalidCseq
+	}
+	s.remoteCSeq = cseq.SeqNo
+
+	if isTargetRefreshRequest(req.Method) {
+		if contact := req.Contact(); contact != nil {
+			s.remoteTarget = *contact.Address.Clone()
+		}
+	}
+	s.sessionTimerRefreshRequest(req)
+	return nil
+}
+
+func (s *dialogSession) setState(state int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dialogStateOrder(s.state) < dialogStateOrder(state) {
+		s.state = state
+	}
+}
+
+// dialogStateOrder returns position of state in dialog lifetime, as early state is not first by value
+func dialogStateOrder(state int) int {
+	if state == sip.DialogStateEarly {
+		return -1
+	}
+	return state
+}
+
+func (s *dialogSession) end() {
+	s.mu.Lock()
+	s.state = sip.DialogStateEnded
+	s.stopSessionTimer()
+	s.mu.Unlock()
+	s.cancel()
+	s.endRefers()
+	s.onEnd(s.id)
+}
+
+// byeSessions sends BYE on confirmed dialogs concurrently. Other dialogs are skipped,
+// as they are terminated by their INVITE transaction
+func byeSessions(ctx context.Context, sessions []*dialogSession) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(sessions))
+	for i, s := range sessions {
+		if s.State() != sip.DialogStateConfirmed {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, s *dialogSession) {
+			defer wg.Done()
+			if err := s.Bye(ctx); err != nil {
+				errs[i] = fmt.Errorf("dialog %q: %w", s.ID(), err)
+			}
+		}(i, s)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// dialogRouteSet builds route set from Record-Route headers.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-12.1.2
+// UAC must use Record-Route in reverse order, UAS in same order
+func dialogRouteSet(hdrs []sip.Header, reverse bool) ([]sip.Uri, error) {
+	routes := make([]sip.Uri, 0, len(hdrs))
+	for _, h := range hdrs {
+		var uri sip.Uri
+		switch h := h.(type) {
+		case *sip.RecordRouteHeader:
+			uri = *h.Address.Clone()
+		default:
+			if _, err := sip.ParseAddressValue(h.Value(), &uri, nil); err != nil {
+				return nil, fmt.Errorf("parse Record-Route %q: %w", h.Value(), err)
+			}
+		}
+		routes = append(routes, uri)
+	}
+
+	if reverse {
+		for i, j := 0, len(routes)-1; i < j; i, j = i+1, j-1 {
+			routes[i], routes[j] = routes[j], routes[i]
+		}
+	}
+	return routes, nil
+}
+
+// dialogRequestRoute sets Request-URI and Route headers of request within dialog.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-12.2.1.1
+func dialogRequestRoute(req *sip.Request, remoteTarget sip.Uri, routeSet []sip.Uri) {
+	for req.RemoveHeader("Route") {
+	}
+
+	if len(routeSet) == 0 {
+		req.Recipient = *remoteTarget.Clone()
+		return
+	}
+
+	first := routeSet[0]
+	if isLooseRouter(first) {
+		// Loose routing
+		req.Recipient = *remoteTarget.Clone()
+		for _, r := range routeSet {
+			req.AppendHeader(&sip.RouteHeader{Address: *r.Clone()})
+		}
+		return
+	}
+
+	// Strict routing. First route becomes Request-URI and remote target is put as last route
+	req.Recipient = *first.Clone()
+	req.Recipient.Headers = nil
+	for _, r := range routeSet[1:] {
+		req.AppendHeader(&sip.RouteHeader{Address: *r.Clone()})
+	}
+	req.AppendHeader(&sip.RouteHeader{Address: *remoteTarget.Clone()})
+	requestStrictDestination(req)
+}
+
+// isTargetRefreshRequest checks can request within dialog update remote target
+// https://datatracker.ietf.org/doc/html/rfc3261#section-12.2
+func isTargetRefreshRequest(method sip.RequestMethod) bool {
+	switch method {
+	case sip.INVITE, sip.UPDATE, sip.SUBSCRIBE, sip.NOTIFY, sip.REFER:
+		return true
+	}
+	return false
+}
+
+// dialogErrorResponse creates response for request within dialog that failed to match dialog
+func dialogErrorResponse(req *sip.Request, err error) *sip.Response {
+	switch {
+	case errors.Is(err, ErrDialogDoesNotExists):
+		return sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil)
+	case errors.Is(err, ErrDialogInvalidCseq):
+		// https://datatracker.ietf.org/doc/html/rfc3261#section-12.2.2
+		return sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Server Internal Error", nil)
+	}
+	return sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil)
+}
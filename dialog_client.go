@@ -0,0 +1,531 @@
+package sipgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+// DialogClient is UAC dialog handle. It creates dialog sessions from 2xx INVITE responses
+// and keeps them until they are terminated, so that requests within dialog can be matched.
+//
+// Early dialogs are created from 1xx responses with To tag. In case INVITE forks, every fork
+// creates its own dialog. Only first 2xx dialog is returned, while every other 2xx is acknowledged
+// and passed to fork handler or terminated with BYE.
+type DialogClient struct {
+	c          *Client
+	contactHDR sip.ContactHeader
+	log        *slog.Logger
+
+	onEarly      func(s *DialogClientSession)
+	onFork       func(s *DialogClientSession)
+	sessionTimer SessionTimer
+	// reliableProvisional adds Supported: 100rel to INVITE
+	reliableProvisional bool
+
+	dialogs sync.Map // id -> *DialogClientSession
+}
+
+type DialogClientOption func(dc *DialogClient)
+
+// WithDialogClientEarlyHandler sets handler called when early dialog is created from 1xx response with To tag
+func WithDialogClientEarlyHandler(f func(s *DialogClientSession)) DialogClientOption {
+	return func(dc *DialogClient) {
+		dc.onEarly = f
+	}
+}
+
+// WithDialogClientForkHandler sets handler for dialogs created by additional 2xx responses of forked INVITE.
+// Passed dialogs are already acknowledged. Without handler these dialogs are terminated with BYE.
+func WithDialogClientForkHandler(f func(s *DialogClientSession)) DialogClientOption {
+	return func(dc *DialogClient) {
+		dc.onFork = f
+	}
+}
+
+// WithDialogClientReliableProvisional adds Supported: 100rel to INVITE, so UAS can send reliable provisional
+// responses. PRACK is sent by transaction layer. Reliable provisional responses are handled also without
+// this option, if INVITE is sent with 100rel in Supported or Require header.
+// https://datatracker.ietf.org/doc/html/rfc3262
+func WithDialogClientReliableProvisional() DialogClientOption {
+	return func(dc *DialogClient) {
+		dc.reliableProvisional = true
+	}
+}
+
+// WithDialogClientSessionTimer enables session timers. Session-Expires is requested in INVITE
+// and 422 responses are handled by resending INVITE with higher session interval.
+// https://datatracker.ietf.org/doc/html/rfc4028
+func WithDialogClientSessionTimer(t SessionTimer) DialogClientOption {
+	return func(dc *DialogClient) {
+		dc.sessionTimer = t
+	}
+}
+
+// NewDialogClient creates UAC dialog handle.
+// Contact header is added to INVITE and other target refresh requests within dialog.
+func NewDialogClient(client *Client, contactHDR sip.ContactHeader, options ...DialogClientOption) *DialogClient {
+	dc := &DialogClient{
+		c:          client,
+		contactHDR: contactHDR,
+		log:        client.log,
+	}
+
+	for _, o := range options {
+		o(dc)
+	}
+	return dc
+}
+
+// Invite sends INVITE request to recipient and waits for final response.
+// Check WriteInvite for more details
+func (dc *DialogClient) Invite(ctx context.Context, recipient sip.Uri, body []byte, headers ...sip.Header) (*DialogClientSession, error) {
+	req := sip.NewRequest(sip.INVITE, recipient)
+	for _, h := range headers {
+		req.AppendHeader(h)
+	}
+	req.SetBody(body)
+	return dc.WriteInvite(ctx, req)
+}
+
+// WriteInvite sends INVITE request and waits for final response. Request is built same way as in Client.Do.
+// On 2xx response dialog session is created, but ACK is not sent. Use DialogClientSession.Ack to confirm dialog.
+// On non 2xx final response ErrDialogResponse is returned.
+//
+// Transaction is kept after 2xx response to receive 2xx of other forks and 2xx retransmissions,
+// for which ACK is resent.
+//
+// Reliable provisional responses (RFC 3262) are requested with WithDialogClientReliableProvisional
+// and PRACK is sent by transaction layer.
+func (dc *DialogClient) WriteInvite(ctx context.Context, req *sip.Request, options ...ClientRequestOption) (*DialogClientSession, error) {
+	if req.Contact() == nil {
+		req.AppendHeader(dc.contactHDR.Clone())
+	}
+
+	if dc.reliableProvisional && !sip.HasOptionTag(req, "Supported", sip.OptionTag100rel) && !sip.HasOptionTag(req, "Require", sip.OptionTag100rel) {
+		req.AppendHeader(sip.NewHeader("Supported", sip.OptionTag100rel))
+	}
+
+	if dc.sessionTimer.enabled() && req.GetHeader("Session-Expires") == nil {
+		se := sip.SessionExpires{Delta: dc.sessionTimer.interval(), Refresher: dc.sessionTimer.Refresher}
+		sessionTimerRequestHeaders(req, se, dc.sessionTimer.minSE())
+	}
+
+	// Early dialogs cancel INVITE when they are replaced
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	inv := &dialogClientInvite{
+		dc:        dc,
+		req:       req,
+		cancel:    cancel,
+		sessions:  make(map[string]*DialogClientSession),
+		announced: make(map[string]struct{}),
+	}
+	// PRACK sent by transaction layer is request within early dialog
+	ctx = withPrackCSeq(ctx, inv.prackCSeq)
+
+	tx, res, err := dc.c.doTx(ctx, req, inv.onProvisional, options...)
+	if err == nil && dc.c.auth != nil && isDigestChallenge(res) {
+		var authTx sip.ClientTransaction
+		authTx, req, res, err = dc.c.doDigestAuthTx(ctx, dc.c.auth, req, res, inv.onProvisional)
+		if authTx != nil {
+			tx.Terminate()
+			tx = authTx
+			inv.setRequest(req)
+		}
+	}
+
+	if err == nil && dc.sessionTimer.enabled() {
+		if retry := sessionTimerRetry(req, res); retry != nil {
+			tx.Terminate()
+			req = retry
+			inv.setRequest(req)
+			tx, res, err = dc.c.doTx(ctx, req, inv.onProvisional, options...)
+		}
+	}
+
+	if err == nil && !res.IsSuccess() {
+		err = ErrDialogResponse{Res: res}
+	}
+
+	var sess *DialogClientSession
+	if err == nil {
+		sess, _, err = inv.onSuccess(res)
+	}
+
+	if err != nil {
+		if tx != nil {
+			tx.Terminate()
+		}
+		inv.endEarly()
+		return nil, err
+	}
+
+	go inv.receiveForks(tx)
+	return sess, nil
+}
+
+// NewSession creates dialog session from INVITE request and its 2xx response.
+// It is useful when INVITE is not sent with WriteInvite.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-12.1.2
+func (dc *DialogClient) NewSession(inviteReq *sip.Request, inviteRes *sip.Response) (*DialogClientSession, error) {
+	if !inviteRes.IsSuccess() {
+		return nil, ErrDialogResponse{Res: inviteRes}
+	}
+	return dc.newSession(inviteReq, inviteRes, nil)
+}
+
+func (dc *DialogClient) newSession(inviteReq *sip.Request, inviteRes *sip.Response, cancelInvite context.CancelFunc) (*DialogClientSession, error) {
+	id, err := sip.MakeDialogIDFromResponse(inviteRes)
+	if err != nil {
+		return nil, err
+	}
+
+	cseq := inviteRes.CSeq()
+	if cseq == nil {
+		return nil, fmt.Errorf("missing CSeq header")
+	}
+
+	s := &DialogClientSession{
+		dialogSession: newDialogSession(dc.c, id, dc.deleteSession),
+		InviteRequest: inviteReq,
+		inviteCSeq:    cseq.SeqNo,
+		cancelInvite:  cancelInvite,
+	}
+	s.callID = *inviteRes.CallID()
+	s.localHDR = *inviteRes.From()
+	s.contactHDR = inviteReq.Contact()
+	if s.contactHDR == nil {
+		s.contactHDR = &dc.contactHDR
+	}
+	s.localCSeq = cseq.SeqNo
+	s.timerCfg = dc.sessionTimer
+	if minSE, ok, err := sip.MessageMinSE(inviteReq); ok && err == nil {
+		// Min-SE could be raised by 422 response
+		s.sessionMinSE = minSE
+	}
+	s.localBody = inviteReq.Body()
+	if h := inviteReq.GetHeader("Content-Type"); h != nil {
+		s.localContentType = sip.HeaderClone(h)
+	}
+	if err := s.establish(inviteRes); err != nil {
+		return nil, err
+	}
+
+	dc.dialogs.Store(id, s)
+	return s, nil
+}
+
+// Session returns dialog session by dialog ID
+func (dc *DialogClient) Session(id string) (*DialogClientSession, bool) {
+	val, ok := dc.dialogs.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return val.(*DialogClientSession), true
+}
+
+// MatchRequest returns dialog session of request received within dialog.
+// Remote CSeq is validated and remote target is updated in case of target refresh request.
+// ErrDialogDoesNotExists is returned if there is no such dialog
+// and ErrDialogInvalidCseq if request is out of order.
+func (dc *DialogClient) MatchRequest(req *sip.Request) (*DialogClientSession, error) {
+	id, err := sip.UACReadRequestDialogID(req)
+	if err != nil {
+		return nil, errors.Join(ErrDialogDoesNotExists, err)
+	}
+
+	s, ok := dc.Session(id)
+	if !ok {
+		return nil, ErrDialogDoesNotExists
+	}
+
+	if err := s.readRequest(req); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ReadBye handles BYE received within dialog. Dialog is terminated and 200 OK is responded.
+// For unknown dialog 481 is responded and ErrDialogDoesNotExists returned.
+func (dc *DialogClient) ReadBye(req *sip.Request, tx sip.ServerTransaction) error {
+	s, err := dc.MatchRequest(req)
+	if err != nil {
+		if rerr := tx.Respond(dialogErrorResponse(req, err)); rerr != nil {
+			return errors.Join(err, rerr)
+		}
+		return err
+	}
+
+	s.end()
+	return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+}
+
+// ByeAll sends BYE on all confirmed dialogs. It can be used on shutdown with UserAgent.OnShutdown
+func (dc *DialogClient) ByeAll(ctx context.Context) error {
+	var sessions []*dialogSession
+	dc.dialogs.Range(func(_, v any) bool {
+		sessions = append(sessions, v.(*DialogClientSession).dialogSession)
+		return true
+	})
+	return byeSessions(ctx, sessions)
+}
+
+func (dc *DialogClient) deleteSession(id string) {
+	dc.dialogs.Delete(id)
+}
+
+// dialogClientInvite tracks dialogs created by responses of single INVITE transaction.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-13.2.2.4
+type dialogClientInvite struct {
+	dc     *DialogClient
+	req    *sip.Request
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	sessions map[string]*DialogClientSession
+	// announced are early dialogs passed to early handler
+	announced map[string]struct{}
+}
+
+// setRequest updates INVITE request when it is resent
+func (inv *dialogClientInvite) setRequest(req *sip.Request) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.req = req
+}
+
+// onProvisional creates early dialog for every 1xx response with new To tag
+func (inv *dialogClientInvite) onProvisional(res *sip.Response) {
+	s := inv.earlySession(res)
+	if s == nil {
+		return
+	}
+
+	inv.mu.Lock()
+	_, announced := inv.announced[s.ID()]
+	inv.announced[s.ID()] = struct{}{}
+	inv.mu.Unlock()
+	if !announced && inv.dc.onEarly != nil {
+		inv.dc.onEarly(s)
+	}
+}
+
+// prackCSeq returns CSeq of PRACK sent by transaction layer for reliable provisional response.
+// It is next local CSeq of early dialog, so it is not reused by other requests within early dialog.
+// Transaction layer calls it before response is passed up, so early dialog can be created here.
+// https://datatracker.ietf.org/doc/html/rfc3262#section-7.1
+func (inv *dialogClientInvite) prackCSeq(res *sip.Response) uint32 {
+	s := inv.earlySession(res)
+	if s == nil {
+		return res.CSeq().SeqNo + 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.localCSeq++
+	return s.localCSeq
+}
+
+// earlySession returns early dialog of 1xx response and creates it for new To tag
+func (inv *dialogClientInvite) earlySession(res *sip.Response) *DialogClientSession {
+	if res.StatusCode == sip.StatusTrying || res.To() == nil || !res.To().Params.Has("tag") {
+		return nil
+	}
+
+	id, err := sip.MakeDialogIDFromResponse(res)
+	if err != nil {
+		inv.dc.log.Debug("Failed to create early dialog id", "err", err)
+		return nil
+	}
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if s, exists := inv.sessions[id]; exists {
+		return s
+	}
+
+	s, err := inv.dc.newSession(inv.req, res, inv.cancel)
+	if err != nil {
+		inv.dc.log.Error("Failed to create early dialog", "err", err)
+		return nil
+	}
+	inv.sessions[id] = s
+	return s
+}
+
+// onSuccess returns dialog for 2xx response. Early dialog with same To tag is established,
+// otherwise new dialog is created. Returned created is false if dialog was already established,
+// meaning that response is retransmission.
+func (inv *dialogClientInvite) onSuccess(res *sip.Response) (s *DialogClientSession, created bool, err error) {
+	id, err := sip.MakeDialogIDFromResponse(res)
+	if err != nil {
+		return nil, false, err
+	}
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	if s, exists := inv.sessions[id]; exists {
+		if s.State() != sip.DialogStateEarly {
+			return s, false, nil
+		}
+		// https://datatracker.ietf.org/doc/html/rfc3261#section-12.2.1.2
+		// Route set must be recomputed based on 2xx response
+		return s, true, s.establish(res)
+	}
+
+	s, err = inv.dc.newSession(inv.req, res, inv.cancel)
+	if err != nil {
+		return nil, false, err
+	}
+	inv.sessions[id] = s
+	return s, true, nil
+}
+
+// receiveForks reads 2xx responses until INVITE transaction terminates.
+// Retransmissions are acknowledged again and forked dialogs are acknowledged
+// and passed to fork handler or terminated.
+func (inv *dialogClientInvite) receiveForks(tx sip.ClientTransaction) {
+	defer inv.endEarly()
+	for {
+		select {
+		case res, more := <-tx.Responses():
+			if !more {
+				return
+			}
+			if !res.IsSuccess() {
+				continue
+			}
+
+			s, created, err := inv.onSuccess(res)
+			if err != nil {
+				inv.dc.log.Error("Failed to create forked dialog", "err", err, "res", res.Short())
+				continue
+			}
+
+			if !created {
+				if err := s.resendAck(); err != nil {
+					inv.dc.log.Error("Failed to resend ACK", "err", err, "res", res.Short())
+				}
+				continue
+			}
+
+			if err := s.Ack(context.Background()); err != nil {
+				inv.dc.log.Error("Failed to ACK forked dialog", "err", err, "res", res.Short())
+				continue
+			}
+
+			if inv.dc.onFork != nil {
+				inv.dc.onFork(s)
+				continue
+			}
+
+			go func() {
+				if err := s.Bye(context.Background()); err != nil {
+					inv.dc.log.Info("Failed to terminate forked dialog", "err", err, "id", s.ID())
+				}
+			}()
+
+		case <-tx.Done():
+			return
+		}
+	}
+}
+
+// endEarly terminates all dialogs that did not receive 2xx
+func (inv *dialogClientInvite) endEarly() {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	for _, s := range inv.sessions {
+		if s.State() == sip.DialogStateEarly {
+			s.end()
+		}
+	}
+}
+
+// DialogClientSession is UAC dialog created by 1xx or 2xx INVITE response.
+// It tracks local and remote CSeq, remote target and route set, which are used
+// for building requests within dialog as described in RFC 3261 section 12.2.1
+type DialogClientSession struct {
+	*dialogSession
+
+	InviteRequest *sip.Request
+	// InviteResponse is response that created dialog. It is updated with 2xx when early dialog is established
+	InviteResponse *sip.Response
+
+	inviteCSeq uint32
+	ackReq     *sip.Request
+	// cancelInvite cancels INVITE while dialog is early
+	cancelInvite context.CancelFunc
+}
+
+// Ack sends ACK for 2xx INVITE response and confirms dialog.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-13.2.2.4
+func (s *DialogClientSession) Ack(ctx context.Context) error {
+	return s.AckWithBody(ctx, nil)
+}
+
+// AckWithBody is same as Ack, but it allows sending answer in ACK when INVITE was sent without offer
+func (s *DialogClientSession) AckWithBody(ctx context.Context, body []byte, headers ...sip.Header) error {
+	if s.State() == sip.DialogStateEarly {
+		return fmt.Errorf("dialog is in early state. 2xx not received")
+	}
+
+	req, err := s.ack(s.inviteCSeq, body, headers...)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.ackReq = req
+	s.mu.Unlock()
+
+	s.setState(sip.DialogStateConfirmed)
+	return nil
+}
+
+// resendAck sends same ACK again on 2xx retransmission. Nothing is sent if ACK was not sent yet
+func (s *DialogClientSession) resendAck() error {
+	s.mu.Lock()
+	req := s.ackReq
+	s.mu.Unlock()
+
+	if req == nil {
+		return nil
+	}
+	return s.c.WriteRequest(req)
+}
+
+// establish updates dialog with response creating dialog.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-12.1.2
+func (s *DialogClientSession) establish(res *sip.Response) error {
+	// Remote target is Contact of response. Without Contact we can only use Request-URI
+	remoteTarget := s.InviteRequest.Recipient
+	if contact := res.Contact(); contact != nil {
+		remoteTarget = contact.Address
+	}
+
+	routeSet, err := dialogRouteSet(res.GetHeaders("Record-Route"), true)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.InviteResponse = res
+	s.remoteHDR = *res.To()
+	s.remoteTarget = *remoteTarget.Clone()
+	s.routeSet = routeSet
+	s.state = sip.DialogStateEstablished
+	if res.IsProvisional() {
+		s.state = sip.DialogStateEarly
+		return nil
+	}
+	s.sessionTimerResponse(s.InviteRequest, res)
+	return nil
+}
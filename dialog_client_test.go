@@ -0,0 +1,344 @@
+package sipgo
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+func TestDialogClient(t *testing.T) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer ua.Close()
+
+	srv, err := NewServer(ua)
+	require.NoError(t, err)
+
+	reqs := make(chan *sip.Request, 10)
+	srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+		reqs <- req
+		res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+		res.AppendHeader(&sip.ContactHeader{Address: req.Recipient})
+		if _, ok := req.To().Params.Get("tag"); !ok {
+			rr := sip.Uri{Host: req.Recipient.Host, Port: req.Recipient.Port, UriParams: sip.HeaderParams{"lr": ""}}
+			res.AppendHeader(&sip.RecordRouteHeader{Address: rr})
+		}
+		tx.Respond(res)
+	})
+	srv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {
+		reqs <- req
+	})
+	srv.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+		reqs <- req
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+
+	uri := testServerUDP(t, srv)
+	c := testClient(t)
+	dc := NewDialogClient(c, sip.ContactHeader{Address: sip.Uri{User: "alice", Host: "127.0.0.1", Port: 5090}})
+
+	readReq := func(method sip.RequestMethod) *sip.Request {
+		t.Helper()
+		select {
+		case req := <-reqs:
+			require.Equal(t, method, req.Method)
+			return req
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s not received", method)
+		}
+		return nil
+	}
+
+	ctx := context.Background()
+	sess, err := dc.Invite(ctx, uri, nil)
+	require.NoError(t, err)
+	assert.Equal(t, sip.DialogStateEstablished, sess.State())
+	readReq(sip.INVITE)
+
+	require.NoError(t, sess.Ack(ctx))
+	ack := readReq(sip.ACK)
+	assert.Equal(t, uint32(1), ack.CSeq().SeqNo)
+	assert.Equal(t, uri.User, ack.Recipient.User)
+	// Route of record routing server points to itself, so it is removed on receive
+	assert.Nil(t, ack.Route())
+	assert.Equal(t, sip.DialogStateConfirmed, sess.State())
+
+	res, err := sess.ReInvite(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, sip.StatusOK, res.StatusCode)
+	reinvite := readReq(sip.INVITE)
+	assert.Equal(t, uint32(2), reinvite.CSeq().SeqNo)
+	assert.Equal(t, sess.InviteResponse.To().Params["tag"], reinvite.To().Params["tag"])
+	assert.NotNil(t, reinvite.Contact())
+	ack = readReq(sip.ACK)
+	assert.Equal(t, uint32(2), ack.CSeq().SeqNo)
+
+	// Remote side refers to our dialog with reversed tags
+	inDialog := sip.NewRequest(sip.INFO, sip.Uri{})
+	inDialog.AppendHeader(&sip.FromHeader{Address: uri, Params: sip.HeaderParams{"tag": sess.InviteResponse.To().Params["tag"]}})
+	inDialog.AppendHeader(&sip.ToHeader{Address: uri, Params: sip.HeaderParams{"tag": sess.InviteResponse.From().Params["tag"]}})
+	inDialog.AppendHeader(sip.HeaderClone(sess.InviteResponse.CallID()))
+	inDialog.AppendHeader(&sip.CSeqHeader{SeqNo: 5, MethodName: sip.INFO})
+	matched, err := dc.MatchRequest(inDialog)
+	require.NoError(t, err)
+	assert.Equal(t, sess, matched)
+
+	inDialog.CSeq().SeqNo = 4
+	_, err = dc.MatchRequest(inDialog)
+	require.ErrorIs(t, err, ErrDialogInvalidCseq)
+
+	require.NoError(t, sess.Bye(ctx))
+	bye := readReq(sip.BYE)
+	assert.Equal(t, uint32(3), bye.CSeq().SeqNo)
+	assert.Equal(t, sip.DialogStateEnded, sess.State())
+	assert.Error(t, sess.Context().Err())
+
+	_, err = dc.MatchRequest(inDialog)
+	require.ErrorIs(t, err, ErrDialogDoesNotExists)
+	_, err = sess.Do(ctx, sip.NewRequest(sip.INFO, sip.Uri{}))
+	require.ErrorIs(t, err, ErrDialogTerminated)
+}
+
+func TestDialogRequestRoute(t *testing.T) {
+	target := sip.Uri{User: "bob", Host: "10.0.0.1", Port: 5060}
+	strict := sip.Uri{Host: "proxy1.example.com"}
+	loose := sip.Uri{Host: "proxy2.example.com", UriParams: sip.HeaderParams{"lr": ""}}
+
+	t.Run("Loose", func(t *testing.T) {
+		req := sip.NewRequest(sip.BYE, sip.Uri{})
+		dialogRequestRoute(req, target, []sip.Uri{loose, strict})
+		assert.Equal(t, target.String(), req.Recipient.String())
+
+		routes := req.GetHeaders("Route")
+		require.Len(t, routes, 2)
+		assert.Equal(t, "<"+loose.String()+">", routes[0].Value())
+		assert.Equal(t, "<"+strict.String()+">", routes[1].Value())
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		req := sip.NewRequest(sip.BYE, sip.Uri{})
+		dialogRequestRoute(req, target, []sip.Uri{strict, loose})
+		assert.Equal(t, strict.String(), req.Recipient.String())
+
+		routes := req.GetHeaders("Route")
+		require.Len(t, routes, 2)
+		assert.Equal(t, "<"+loose.String()+">", routes[0].Value())
+		assert.Equal(t, "<"+target.String()+">", routes[1].Value())
+	})
+}
+
+func TestDialogClientForking(t *testing.T) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer ua.Close()
+
+	srv, err := NewServer(ua)
+	require.NoError(t, err)
+
+	acks := make(chan *sip.Request, 10)
+	byes := make(chan *sip.Request, 10)
+	done := make(chan struct{})
+	defer close(done)
+	srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+		// Simulate forking proxy. Each fork responds with different To tag
+		respond := func(code sip.StatusCode, reason string, tag string) {
+			res := sip.NewResponseFromRequest(req, code, reason, nil)
+			res.To().Params.Add("tag", tag)
+			res.AppendHeader(&sip.ContactHeader{Address: req.Recipient})
+			assert.NoError(t, tx.Respond(res))
+		}
+
+		respond(180, "Ringing", "fork-a")
+		respond(180, "Ringing", "fork-b")
+		respond(200, "OK", "fork-a")
+		select {
+		case <-acks:
+		case <-done:
+			return
+		}
+		respond(200, "OK", "fork-b")
+		// Retransmission must be acknowledged again
+		respond(200, "OK", "fork-a")
+		<-done
+	})
+	srv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {
+		acks <- req
+	})
+	srv.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+		byes <- req
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+
+	uri := testServerUDP(t, srv)
+	c := testClient(t)
+
+	early := make(chan *DialogClientSession, 2)
+	dc := NewDialogClient(c, sip.ContactHeader{Address: sip.Uri{User: "alice", Host: "127.0.0.1", Port: 5090}},
+		WithDialogClientEarlyHandler(func(s *DialogClientSession) {
+			early <- s
+		}),
+	)
+
+	ctx := context.Background()
+	sess, err := dc.Invite(ctx, uri, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "fork-a", sess.InviteResponse.To().Params["tag"])
+	require.Len(t, early, 2)
+	earlyA, earlyB := <-early, <-early
+	assert.Equal(t, sess, earlyA)
+	assert.Equal(t, sip.DialogStateEarly, earlyB.State())
+
+	require.NoError(t, sess.Ack(ctx))
+
+	readReq := func(ch chan *sip.Request) *sip.Request {
+		t.Helper()
+		select {
+		case req := <-ch:
+			return req
+		case <-time.After(2 * time.Second):
+			t.Fatal("request not received")
+		}
+		return nil
+	}
+
+	ackTags := []string{
+		readReq(acks).To().Params["tag"],
+		readReq(acks).To().Params["tag"],
+	}
+	assert.ElementsMatch(t, []string{"fork-a", "fork-b"}, ackTags)
+
+	bye := readReq(byes)
+	assert.Equal(t, "fork-b", bye.To().Params["tag"])
+	assert.Eventually(t, func() bool {
+		return earlyB.State() == sip.DialogStateEnded
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, sip.DialogStateConfirmed, sess.State())
+}
+
+func TestDialogClientReliableProvisional(t *testing.T) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer ua.Close()
+
+	srv, err := NewServer(ua)
+	require.NoError(t, err)
+
+	reqs := make(chan *sip.Request, 10)
+	updates := make(chan struct{}, 1)
+	srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+		reqs <- req
+		respond := func(code sip.StatusCode, reason string) {
+			res := sip.NewResponseFromRequest(req, code, reason, nil)
+			res.To().Params.Add("tag", "uas")
+			res.AppendHeader(&sip.ContactHeader{Address: req.Recipient})
+			if code < 200 {
+				res.AppendHeader(sip.NewHeader("Require", sip.OptionTag100rel))
+			}
+			// Second provisional and 2xx are held until previous is acknowledged
+			assert.NoError(t, tx.Respond(res))
+		}
+		respond(183, "Session Progress")
+		// UPDATE within early dialog is sent between PRACKs
+		<-updates
+		respond(180, "Ringing")
+		respond(200, "OK")
+	})
+	srv.OnUpdate(func(req *sip.Request, tx sip.ServerTransaction) {
+		reqs <- req
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+		updates <- struct{}{}
+	})
+	srv.OnPrack(func(req *sip.Request, tx sip.ServerTransaction) {
+		reqs <- req
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+	srv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {
+		reqs <- req
+	})
+	srv.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+		reqs <- req
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+
+	uri := testServerUDP(t, srv)
+	c := testClient(t)
+	early := make(chan *DialogClientSession, 1)
+	dc := NewDialogClient(c, sip.ContactHeader{Address: sip.Uri{User: "alice", Host: "127.0.0.1", Port: 5090}},
+		WithDialogClientReliableProvisional(),
+		WithDialogClientEarlyHandler(func(s *DialogClientSession) {
+			early <- s
+		}),
+	)
+
+	readReq := func(method sip.RequestMethod) *sip.Request {
+		t.Helper()
+		select {
+		case req := <-reqs:
+			require.Equal(t, method, req.Method)
+			return req
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s not received", method)
+		}
+		return nil
+	}
+
+	ctx := context.Background()
+	type inviteResult struct {
+		sess *DialogClientSession
+		err  error
+	}
+	results := make(chan inviteResult, 1)
+	go func() {
+		sess, err := dc.Invite(ctx, uri, nil)
+		results <- inviteResult{sess, err}
+	}()
+	invite := readReq(sip.INVITE)
+	assert.True(t, sip.HasOptionTag(invite, "Supported", sip.OptionTag100rel))
+
+	prack1 := readReq(sip.PRACK)
+	earlySess := <-early
+	res, err := earlySess.Do(ctx, sip.NewRequest(sip.UPDATE, sip.Uri{}))
+	require.NoError(t, err)
+	assert.Equal(t, sip.StatusOK, res.StatusCode)
+	update := readReq(sip.UPDATE)
+	prack2 := readReq(sip.PRACK)
+	result := <-results
+	require.NoError(t, result.err)
+	sess := result.sess
+	assert.Equal(t, earlySess, sess)
+	rack1, err := sip.ParseRAck(prack1.GetHeader("RAck").Value())
+	require.NoError(t, err)
+	rack2, err := sip.ParseRAck(prack2.GetHeader("RAck").Value())
+	require.NoError(t, err)
+	assert.Equal(t, rack1.RSeq+1, rack2.RSeq)
+	assert.Equal(t, sip.RAck{RSeq: rack1.RSeq, CSeq: 1, Method: sip.INVITE}, rack1)
+	// PRACK and UPDATE share local CSeq of early dialog
+	assert.Equal(t, uint32(2), prack1.CSeq().SeqNo)
+	assert.Equal(t, uint32(3), update.CSeq().SeqNo)
+	assert.Equal(t, uint32(4), prack2.CSeq().SeqNo)
+	assert.Equal(t, "uas", prack1.To().Params["tag"])
+
+	require.NoError(t, sess.Ack(ctx))
+	ack := readReq(sip.ACK)
+	assert.Equal(t, uint32(1), ack.CSeq().SeqNo)
+
+	require.NoError(t, sess.Bye(ctx))
+	bye := readReq(sip.BYE)
+	assert.Equal(t, uint32(5), bye.CSeq().SeqNo)
+
+	t.Run("UnmatchedPrack", func(t *testing.T) {
+		// PRACK not matching transaction with reliable provisional response is left to handler
+		req := sip.NewRequest(sip.PRACK, uri)
+		req.AppendHeader(sip.NewHeader("RAck", "1 1 INVITE"))
+		res, err := c.Do(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusOK, res.StatusCode)
+		readReq(sip.PRACK)
+	})
+}
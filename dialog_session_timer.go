@@ -0,0 +1,253 @@
+package sipgo
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+// SessionTimer configures session timers of dialogs.
+// Session is refreshed with re-INVITE or UPDATE by refresher and terminated with BYE
+// when it is not refreshed within session interval.
+// https://datatracker.ietf.org/doc/html/rfc4028
+type SessionTimer struct {
+	// Interval is requested session interval (Session-Expires). Zero disables session timers
+	Interval time.Duration
+	// MinSE is lowest accepted session interval. It can not be lower than 90s
+	MinSE time.Duration
+	// Refresher is refresher requested by UAC, sip.RefresherUAC or sip.RefresherUAS.
+	// Empty lets UAS decide
+	Refresher string
+	// RefreshMethod is sip.INVITE or sip.UPDATE. Default is re-INVITE with last local session description
+	RefreshMethod sip.RequestMethod
+}
+
+func (t SessionTimer) enabled() bool {
+	return t.Interval > 0
+}
+
+// minSE returns lowest accepted session interval in seconds
+func (t SessionTimer) minSE() uint32 {
+	return max(uint32(t.MinSE/time.Second), sip.MinSessionExpires)
+}
+
+// interval returns session interval in seconds
+func (t SessionTimer) interval() uint32 {
+	return max(uint32(t.Interval/time.Second), t.minSE())
+}
+
+// sessionTimerRequestHeaders sets session timer headers on INVITE or UPDATE
+// https://datatracker.ietf.org/doc/html/rfc4028#section-7.1
+func sessionTimerRequestHeaders(req *sip.Request, se sip.SessionExpires, minSE uint32) {
+	for req.RemoveHeader("Session-Expires") {
+	}
+	for req.RemoveHeader("Min-SE") {
+	}
+	req.AppendHeader(sip.NewHeader("Session-Expires", se.String()))
+	req.AppendHeader(sip.NewHeader("Min-SE", strconv.FormatUint(uint64(minSE), 10)))
+	if !sip.HasOptionTag(req, "Supported", sip.OptionTagTimer) {
+		req.AppendHeader(sip.NewHeader("Supported", sip.OptionTagTimer))
+	}
+}
+
+// sessionTimerRetry builds request to resend after 422 Session Interval Too Small,
+// with session interval raised to Min-SE of response and incremented CSeq.
+// It returns nil if request can not be retried.
+// https://datatracker.ietf.org/doc/html/rfc4028#section-7.3
+func sessionTimerRetry(req *sip.Request, res *sip.Response) *sip.Request {
+	if res.StatusCode != sip.StatusSessionIntervalTooSmall {
+		return nil
+	}
+
+	minSE, ok, err := sip.MessageMinSE(res)
+	if !ok || err != nil {
+		return nil
+	}
+	se, ok, err := sip.MessageSessionExpires(req)
+	if !ok || err != nil || se.Delta >= minSE {
+		return nil
+	}
+	se.Delta = minSE
+
+	retry := req.Clone()
+	retry.SetBody(req.Body())
+	sessionTimerRequestHeaders(retry, se, minSE)
+	if cseq := retry.CSeq(); cseq != nil {
+		cseq.SeqNo++
+	}
+	if via := retry.Via(); via != nil {
+		via.Params.Add("branch", sip.GenerateBranchN(16))
+	}
+	return retry
+}
+
+// sessionTimerRequest adds Session-Expires to INVITE or UPDATE sent within dialog.
+// Refresher is kept same as in last negotiation.
+// https://datatracker.ietf.org/doc/html/rfc4028#section-7.4
+// Must be called under lock
+func (s *dialogSession) sessionTimerRequest(req *sip.Request) {
+	if !s.timerCfg.enabled() || (!req.IsInvite() && req.Method != sip.UPDATE) || req.GetHeader("Session-Expires") != nil {
+		return
+	}
+
+	se := sip.SessionExpires{
+		Delta:     s.timerCfg.interval(),
+		Refresher: s.timerCfg.Refresher,
+	}
+	if s.sessionInterval > 0 {
+		se.Delta = uint32(s.sessionInterval / time.Second)
+		se.Refresher = sip.RefresherUAS
+		if s.localRefresher {
+			se.Refresher = sip.RefresherUAC
+		}
+	}
+	minSE := max(s.timerCfg.minSE(), s.sessionMinSE)
+	se.Delta = max(se.Delta, minSE)
+	sessionTimerRequestHeaders(req, se, minSE)
+}
+
+// sessionTimerResponse reads session timer negotiated by response on our INVITE or UPDATE.
+// Response without Session-Expires turns session timer off.
+// https://datatracker.ietf.org/doc/html/rfc4028#section-7.2
+// Must be called under lock
+func (s *dialogSession) sessionTimerResponse(req *sip.Request, res *sip.Response) {
+	if !s.timerCfg.enabled() || (!req.IsInvite() && req.Method != sip.UPDATE) {
+		return
+	}
+
+	if res.StatusCode == sip.StatusSessionIntervalTooSmall {
+		if minSE, ok, err := sip.MessageMinSE(res); ok && err == nil {
+			s.sessionMinSE = max(s.sessionMinSE, minSE)
+		}
+		return
+	}
+
+	if !res.IsSuccess() {
+		return
+	}
+
+	se, ok, err := sip.MessageSessionExpires(res)
+	if !ok || err != nil {
+		s.stopSessionTimer()
+		return
+	}
+	// We are UAC of this transaction
+	s.startSessionTimer(time.Duration(se.Delta)*time.Second, se.Refresher != sip.RefresherUAS)
+}
+
+// sessionTimerRefreshRequest reads session refresh received within dialog.
+// Must be called under lock
+func (s *dialogSession) sessionTimerRefreshRequest(req *sip.Request) {
+	if !s.timerCfg.enabled() || (!req.IsInvite() && req.Method != sip.UPDATE) {
+		return
+	}
+
+	se, ok, err := sip.MessageSessionExpires(req)
+	if !ok || err != nil {
+		return
+	}
+
+	// We are UAS of this transaction
+	local := s.localRefresher
+	switch se.Refresher {
+	case sip.RefresherUAC:
+		local = false
+	case sip.RefresherUAS:
+		local = true
+	}
+	s.startSessionTimer(time.Duration(se.Delta)*time.Second, local)
+}
+
+// startSessionTimer schedules refresh at half of session interval if we are refresher,
+// otherwise BYE is sent before session interval expires.
+// https://datatracker.ietf.org/doc/html/rfc4028#section-10
+// Must be called under lock
+func (s *dialogSession) startSessionTimer(interval time.Duration, localRefresher bool) {
+	s.stopSessionTimer()
+	if s.state == sip.DialogStateEnded {
+		return
+	}
+
+	s.sessionInterval = interval
+	s.localRefresher = localRefresher
+	if localRefresher {
+		s.sessionTimer = s.clock.AfterFunc(interval/2, s.refreshSession)
+		return
+	}
+	s.sessionTimer = s.clock.AfterFunc(interval-min(32*time.Second, interval/3), s.expireSession)
+}
+
+// stopSessionTimer must be called under lock
+func (s *dialogSession) stopSessionTimer() {
+	if s.sessionTimer != nil {
+		s.sessionTimer.Stop()
+		s.sessionTimer = nil
+	}
+	s.sessionInterval = 0
+}
+
+// SessionExpires returns negotiated session interval and is refresher on our side.
+// Zero interval means that session timer is not active.
+func (s *dialogSession) SessionExpires() (interval time.Duration, localRefresher bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessionInterval, s.localRefresher
+}
+
+// refreshSession sends session refresh. Request is resent once in case of 422 response.
+// If refresh fails, session expires at the end of session interval unless other side refreshes it.
+func (s *dialogSession) refreshSession() {
+	for i := 0; i < 2; i++ {
+		res, err := s.sendSessionRefresh()
+		if err == nil && res.IsSuccess() {
+			return
+		}
+		if res == nil || res.StatusCode != sip.StatusSessionIntervalTooSmall {
+			s.c.log.Info("Session refresh failed", "err", err, "id", s.id)
+			break
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == sip.DialogStateEnded || s.sessionInterval == 0 {
+		return
+	}
+	s.sessionTimer = s.clock.AfterFunc(s.sessionInterval/2, s.expireSession)
+}
+
+func (s *dialogSession) sendSessionRefresh() (*sip.Response, error) {
+	if s.timerCfg.RefreshMethod == sip.UPDATE {
+		return s.Do(s.ctx, sip.NewRequest(sip.UPDATE, sip.Uri{}))
+	}
+
+	s.mu.Lock()
+	body := s.localBody
+	var headers []sip.Header
+	if s.localContentType != nil {
+		headers = append(headers, sip.HeaderClone(s.localContentType))
+	}
+	s.mu.Unlock()
+
+	res, err := s.ReInvite(s.ctx, body, headers...)
+	var resErr ErrDialogResponse
+	if errors.As(err, &resErr) {
+		return res, nil
+	}
+	return res, err
+}
+
+// expireSession terminates session which was not refreshed in time
+// https://datatracker.ietf.org/doc/html/rfc4028#section-10
+func (s *dialogSession) expireSession() {
+	s.c.log.Info("Session expired", "id", s.id)
+	ctx, cancel := context.WithTimeout(context.Background(), 32*time.Second)
+	defer cancel()
+	if err := s.Bye(ctx); err != nil {
+		s.c.log.Info("Failed to send BYE for expired session", "err", err, "id", s.id)
+	}
+	s.end()
+}
@@ -0,0 +1,167 @@
+package sipgo
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sipgo/fakes"
+	"github.com/livekit/sipgo/sip"
+)
+
+func TestDialogSessionTimer(t *testing.T) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer ua.Close()
+
+	srv, err := NewServerDialog(ua)
+	require.NoError(t, err)
+	srv.SetSessionTimer(SessionTimer{Interval: 1800 * time.Second, MinSE: 600 * time.Second})
+
+	reqs := make(chan *sip.Request, 10)
+	sessions := make(chan *DialogServerSession, 2)
+	srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+		reqs <- req
+		res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+		res.AppendHeader(&sip.ContactHeader{Address: req.Recipient})
+		if !assert.NoError(t, tx.Respond(res)) {
+			return
+		}
+		id, _ := sip.MakeDialogIDFromResponse(res)
+		if sess, ok := srv.Session(id); ok {
+			sessions <- sess
+		}
+	})
+	srv.OnUpdate(func(req *sip.Request, tx sip.ServerTransaction) {
+		reqs <- req
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+	srv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {
+		if _, err := srv.MatchDialogRequest(req); err != nil {
+			// ACK of 422 response
+			return
+		}
+		reqs <- req
+	})
+	srv.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+		reqs <- req
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+
+	uri := testServerUDP(t, &srv.Server)
+
+	// Session timers of client are driven by fake clock
+	clock := fakes.NewClock(time.Now())
+	cua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")), WithUserAgentClock(clock))
+	require.NoError(t, err)
+	defer cua.Close()
+	c, err := NewClient(cua, WithClientHostname("127.0.0.1"))
+	require.NoError(t, err)
+	contact := sip.ContactHeader{Address: sip.Uri{User: "alice", Host: "127.0.0.1", Port: 5090}}
+	dc := NewDialogClient(c, contact,
+		WithDialogClientSessionTimer(SessionTimer{Interval: 120 * time.Second, RefreshMethod: sip.UPDATE}),
+	)
+
+	readReq := func(method sip.RequestMethod) *sip.Request {
+		t.Helper()
+		select {
+		case req := <-reqs:
+			require.Equal(t, method, req.Method)
+			return req
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s not received", method)
+		}
+		return nil
+	}
+
+	ctx := context.Background()
+	sess, err := dc.Invite(ctx, uri, nil)
+	require.NoError(t, err)
+	require.NoError(t, sess.Ack(ctx))
+
+	// First INVITE is rejected with 422 and resent with Min-SE of server
+	invite := readReq(sip.INVITE)
+	assert.Equal(t, uint32(2), invite.CSeq().SeqNo)
+	assert.True(t, sip.HasOptionTag(invite, "Supported", sip.OptionTagTimer))
+	se, ok, err := sip.MessageSessionExpires(invite)
+	require.True(t, ok)
+	require.NoError(t, err)
+	assert.Equal(t, sip.SessionExpires{Delta: 600}, se)
+	readReq(sip.ACK)
+
+	se, ok, err = sip.MessageSessionExpires(sess.InviteResponse)
+	require.True(t, ok)
+	require.NoError(t, err)
+	assert.Equal(t, sip.SessionExpires{Delta: 600, Refresher: sip.RefresherUAC}, se)
+	assert.True(t, sip.HasOptionTag(sess.InviteResponse, "Require", sip.OptionTagTimer))
+
+	interval, local := sess.SessionExpires()
+	assert.Equal(t, 600*time.Second, interval)
+	assert.True(t, local)
+
+	srvSess := <-sessions
+	interval, local = srvSess.SessionExpires()
+	assert.Equal(t, 600*time.Second, interval)
+	assert.False(t, local)
+
+	t.Run("Refresh", func(t *testing.T) {
+		// Refresher sends refresh at half of session interval
+		clock.Advance(300*time.Second - time.Millisecond)
+		assert.Len(t, reqs, 0)
+		clock.Advance(time.Millisecond)
+
+		update := readReq(sip.UPDATE)
+		assert.Equal(t, uint32(3), update.CSeq().SeqNo)
+		se, _, _ := sip.MessageSessionExpires(update)
+		assert.Equal(t, sip.SessionExpires{Delta: 600, Refresher: sip.RefresherUAC}, se)
+
+		interval, local := sess.SessionExpires()
+		assert.Equal(t, 600*time.Second, interval)
+		assert.True(t, local)
+
+		// Next refresh is scheduled after response
+		clock.Advance(300 * time.Second)
+		update = readReq(sip.UPDATE)
+		assert.Equal(t, uint32(4), update.CSeq().SeqNo)
+
+		// Timer is stopped when dialog ends
+		require.NoError(t, sess.Bye(ctx))
+		readReq(sip.BYE)
+		clock.Advance(time.Hour)
+		assert.Len(t, reqs, 0)
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		dc := NewDialogClient(c, contact,
+			WithDialogClientSessionTimer(SessionTimer{Interval: 600 * time.Second, Refresher: sip.RefresherUAS}),
+		)
+		sess, err := dc.Invite(ctx, uri, nil)
+		require.NoError(t, err)
+		require.NoError(t, sess.Ack(ctx))
+		readReq(sip.INVITE)
+		readReq(sip.ACK)
+		srvSess := <-sessions
+
+		interval, local := sess.SessionExpires()
+		assert.Equal(t, 600*time.Second, interval)
+		assert.False(t, local)
+
+		// Non refresher sends BYE when session is not refreshed, 32s before session interval ends
+		clock.Advance(568*time.Second - time.Millisecond)
+		assert.Len(t, reqs, 0)
+		clock.Advance(time.Millisecond)
+
+		bye := readReq(sip.BYE)
+		assert.Equal(t, uint32(2), bye.CSeq().SeqNo)
+		assert.Equal(t, sip.DialogStateEnded, sess.State())
+		assert.Eventually(t, func() bool {
+			return srvSess.State() == sip.DialogStateEnded
+		}, time.Second, 10*time.Millisecond)
+		interval, _ = sess.SessionExpires()
+		assert.Zero(t, interval)
+	})
+}
@@ -0,0 +1,143 @@
+package fakes
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+// Clock is fake sip.Clock where time moves only with Advance.
+// Timer functions are called synchronously by Advance in order of their deadline.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*clockTimer
+	changed chan struct{}
+}
+
+// NewClock creates fake clock starting at now
+func NewClock(now time.Time) *Clock {
+	return &Clock{
+		now:     now,
+		changed: make(chan struct{}),
+	}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) AfterFunc(d time.Duration, f func()) sip.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &clockTimer{clock: c, f: f}
+	c.schedule(t, d)
+	return t
+}
+
+// Advance moves time forward and calls functions of timers which expire, including timers
+// scheduled by those functions within advanced duration.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		if len(c.timers) == 0 || c.timers[0].deadline.After(end) {
+			break
+		}
+		t := c.timers[0]
+		c.remove(t)
+		if t.deadline.After(c.now) {
+			c.now = t.deadline
+		}
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+// Pending returns number of active timers
+func (c *Clock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil blocks until there are at least n active timers or timeout passes in real time.
+// It returns false on timeout. This is useful to wait for timers started by other goroutines before Advance.
+func (c *Clock) BlockUntil(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		c.mu.Lock()
+		if len(c.timers) >= n {
+			c.mu.Unlock()
+			return true
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// schedule must be called under lock
+func (c *Clock) schedule(t *clockTimer, d time.Duration) {
+	t.deadline = c.now.Add(d)
+	t.active = true
+	c.timers = append(c.timers, t)
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].deadline.Before(c.timers[j].deadline)
+	})
+	c.notify()
+}
+
+// remove must be called under lock
+func (c *Clock) remove(t *clockTimer) bool {
+	if !t.active {
+		return false
+	}
+	t.active = false
+	for i, v := range c.timers {
+		if v == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			break
+		}
+	}
+	c.notify()
+	return true
+}
+
+func (c *Clock) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+type clockTimer struct {
+	clock    *Clock
+	f        func()
+	deadline time.Time
+	active   bool
+}
+
+func (t *clockTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *clockTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.clock.remove(t)
+	t.clock.schedule(t, d)
+	return active
+}
@@ -0,0 +1,182 @@
+package sipgo
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+// Binding is contact bound to address of record by registration
+// https://datatracker.ietf.org/doc/html/rfc3261#section-10.3
+type Binding struct {
+	// Contact is registered contact without expires parameter
+	Contact sip.ContactHeader
+	// Expires is time when binding expires
+	Expires time.Time
+	// Q is preference of contact from 0 to 1. Contact without q parameter has preference 1
+	Q float64
+	// CallID and CSeq of last REGISTER updating binding
+	CallID string
+	CSeq   uint32
+	// Path is list of Path header values of REGISTER, which must be used as Route
+	// for requests sent to contact. RFC 3327
+	Path []string
+}
+
+// Key identifies binding. For SIP Outbound it is instance ID with reg-id, otherwise contact URI.
+// https://datatracker.ietf.org/doc/html/rfc5626#section-6
+func (b *Binding) Key() string {
+	return bindingKey(&b.Contact)
+}
+
+func bindingKey(c *sip.ContactHeader) string {
+	if instance := sip.ContactInstance(c); instance != "" {
+		if regID, ok, _ := sip.ContactRegID(c); ok {
+			return instance + ";" + sip.ParamRegID + "=" + strconv.Itoa(regID)
+		}
+		return instance
+	}
+
+	uri := c.Address
+	key := strings.ToLower(uri.Scheme) + ":" + uri.User + "@" + strings.ToLower(uri.Host)
+	if uri.Port > 0 {
+		key += ":" + strconv.Itoa(uri.Port)
+	}
+	if tp, ok := uri.UriParams.Get("transport"); ok {
+		key += ";transport=" + strings.ToLower(tp)
+	}
+	return key
+}
+
+// LocationStore keeps bindings of addresses of record. It must be safe for concurrent use.
+type LocationStore interface {
+	// Bindings returns bindings of address of record which are not expired
+	Bindings(ctx context.Context, aor string) ([]Binding, error)
+	// UpdateBindings atomically replaces bindings of address of record with result of f, which
+	// gets bindings that are not expired. Error returned by f aborts update and it is returned.
+	// Updated bindings are returned.
+	UpdateBindings(ctx context.Context, aor string, f func(bindings []Binding) ([]Binding, error)) ([]Binding, error)
+}
+
+type MemoryLocationStoreOption func(s *MemoryLocationStore)
+
+// WithMemoryLocationStoreClock sets clock used for expiring bindings
+// Default: sip.SystemClock
+func WithMemoryLocationStoreClock(c sip.Clock) MemoryLocationStoreOption {
+	return func(s *MemoryLocationStore) {
+		s.clock = c
+	}
+}
+
+type memoryAOR struct {
+	bindings []Binding
+	timer    sip.Timer
+}
+
+// MemoryLocationStore is in-memory LocationStore. Address of record is removed when its last binding expires.
+type MemoryLocationStore struct {
+	mu    sync.Mutex
+	clock sip.Clock
+	aors  map[string]*memoryAOR
+}
+
+// NewMemoryLocationStore creates in-memory location store
+func NewMemoryLocationStore(options ...MemoryLocationStoreOption) *MemoryLocationStore {
+	s := &MemoryLocationStore{
+		clock: sip.SystemClock,
+		aors:  make(map[string]*memoryAOR),
+	}
+	for _, o := range options {
+		o(s)
+	}
+	return s
+}
+
+// Bindings returns bindings of address of record which are not expired
+func (s *MemoryLocationStore) Bindings(ctx context.Context, aor string) ([]Binding, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, exists := s.aors[aor]
+	if !exists {
+		return nil, nil
+	}
+	return s.active(a.bindings), nil
+}
+
+// UpdateBindings replaces bindings of address of record with result of f.
+// Store is locked while f is called, so f must not use store.
+func (s *MemoryLocationStore) UpdateBindings(ctx context.Context, aor string, f func(bindings []Binding) ([]Binding, error)) ([]Binding, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, exists := s.aors[aor]
+	var current []Binding
+	if exists {
+		current = s.active(a.bindings)
+	}
+	bindings, err := f(current)
+	if err != nil {
+		return nil, err
+	}
+	bindings = s.active(bindings)
+
+	if exists {
+		a.timer.Stop()
+	}
+	if len(bindings) == 0 {
+		delete(s.aors, aor)
+		return nil, nil
+	}
+	// New entry makes timer of previous one noop, even if it already fired
+	a = &memoryAOR{bindings: bindings}
+	s.aors[aor] = a
+	s.scheduleExpire(aor, a)
+	return slices.Clone(bindings), nil
+}
+
+// Len returns number of addresses of record with bindings
+func (s *MemoryLocationStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.aors)
+}
+
+// active returns copy of bindings which are not expired
+func (s *MemoryLocationStore) active(bindings []Binding) []Binding {
+	now := s.clock.Now()
+	active := make([]Binding, 0, len(bindings))
+	for _, b := range bindings {
+		if b.Expires.After(now) {
+			active = append(active, b)
+		}
+	}
+	return active
+}
+
+// scheduleExpire sets timer for removing bindings of address of record when first of them expires
+func (s *MemoryLocationStore) scheduleExpire(aor string, a *memoryAOR) {
+	next := a.bindings[0].Expires
+	for _, b := range a.bindings[1:] {
+		if b.Expires.Before(next) {
+			next = b.Expires
+		}
+	}
+	a.timer = s.clock.AfterFunc(next.Sub(s.clock.Now()), func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.aors[aor] != a {
+			return
+		}
+		a.bindings = s.active(a.bindings)
+		if len(a.bindings) == 0 {
+			delete(s.aors, aor)
+			return
+		}
+		s.scheduleExpire(aor, a)
+	})
+}
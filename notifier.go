@@ -0,0 +1,539 @@
+package sipgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+var (
+	// ErrSubscriptionRejected can be returned by EventPackage.Subscribe to reject SUBSCRIBE with 403 Forbidden
+	ErrSubscriptionRejected = errors.New("subscription rejected")
+	// ErrSubscriptionTerminated is returned when NOTIFY is sent for terminated subscription
+	ErrSubscriptionTerminated = errors.New("subscription terminated")
+)
+
+// EventPackage is plug-in implementing event package for Notifier. Methods can be called concurrently
+// for different subscriptions.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-7
+type EventPackage interface {
+	// Subscribe authorizes new subscription and returns its state, sip.SubscriptionStateActive
+	// or sip.SubscriptionStatePending. Pending subscription is activated with NotifierSubscription.Activate.
+	// ErrSubscriptionRejected rejects SUBSCRIBE with 403, other errors with 500.
+	Subscribe(sub *NotifierSubscription) (string, error)
+	// Content returns current state of resource sent in NOTIFY. Nil body is sent without Content-Type.
+	// It is not called for pending subscription, as its NOTIFY must not reveal state.
+	Content(sub *NotifierSubscription) (contentType string, body []byte, err error)
+	// Unsubscribe is called once subscription is terminated for any reason
+	Unsubscribe(sub *NotifierSubscription)
+}
+
+type NotifierOption func(n *Notifier)
+
+// WithNotifierPackage registers event package under name used in Event header
+func WithNotifierPackage(name string, p EventPackage) NotifierOption {
+	return func(n *Notifier) {
+		n.packages[strings.ToLower(name)] = p
+	}
+}
+
+// WithNotifierDefaultExpires sets duration of subscription for SUBSCRIBE without Expires
+// Default: 3600s
+func WithNotifierDefaultExpires(d time.Duration) NotifierOption {
+	return func(n *Notifier) {
+		n.defaultExpires = d
+	}
+}
+
+// WithNotifierMinExpires sets minimal duration of subscription. Shorter duration is rejected
+// with 423 Interval Too Brief.
+// Default: 60s
+func WithNotifierMinExpires(d time.Duration) NotifierOption {
+	return func(n *Notifier) {
+		n.minExpires = d
+	}
+}
+
+// WithNotifierMaxExpires sets maximal duration of subscription. Longer duration is shortened.
+// Default: 0, no limit
+func WithNotifierMaxExpires(d time.Duration) NotifierOption {
+	return func(n *Notifier) {
+		n.maxExpires = d
+	}
+}
+
+// WithNotifierClock sets clock used for expiration of subscriptions
+// Default: sip.SystemClock
+func WithNotifierClock(c sip.Clock) NotifierOption {
+	return func(n *Notifier) {
+		n.clock = c
+	}
+}
+
+// Notifier handles SUBSCRIBE requests and keeps subscriptions of registered event packages.
+// Every subscription creates dialog, or it is added to existing one when SUBSCRIBE is sent within dialog.
+// Subscriptions are identified within dialog by event package and id.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.2
+type Notifier struct {
+	c          *Client
+	contactHDR sip.ContactHeader
+	log        *slog.Logger
+	clock      sip.Clock
+	packages   map[string]EventPackage
+
+	defaultExpires time.Duration
+	minExpires     time.Duration
+	maxExpires     time.Duration
+
+	mu      sync.Mutex
+	dialogs map[string]*notifierDialog
+}
+
+// notifierDialog is dialog shared by subscriptions
+type notifierDialog struct {
+	sess *dialogSession
+	subs map[string]*NotifierSubscription // event key -> subscription
+}
+
+// NewNotifier creates notifier. Contact header is added to responses and NOTIFY requests.
+// Subscribe can be used as server handler:
+//
+//	srv.OnSubscribe(notifier.Subscribe)
+func NewNotifier(client *Client, contactHDR sip.ContactHeader, options ...NotifierOption) *Notifier {
+	n := &Notifier{
+		c:              client,
+		contactHDR:     contactHDR,
+		log:            slog.With("caller", "Notifier"),
+		clock:          sip.SystemClock,
+		packages:       make(map[string]EventPackage),
+		defaultExpires: 3600 * time.Second,
+		minExpires:     60 * time.Second,
+		dialogs:        make(map[string]*notifierDialog),
+	}
+	for _, o := range options {
+		o(n)
+	}
+	return n
+}
+
+// Subscribe handles SUBSCRIBE request. New subscription is created, or existing one is refreshed
+// or terminated, and NOTIFY with current state is sent after 2xx response.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.2.1
+func (n *Notifier) Subscribe(req *sip.Request, tx sip.ServerTransaction) {
+	res, sub, reason := n.subscribe(req)
+	if err := tx.Respond(res); err != nil {
+		n.log.Error("Failed to respond SUBSCRIBE", "err", err, "res", res.StartLine())
+	}
+	if sub == nil {
+		return
+	}
+
+	var err error
+	if reason != "" {
+		err = sub.Terminate(context.Background(), reason)
+	} else {
+		err = sub.Notify(context.Background())
+	}
+	if err != nil {
+		n.log.Info("Failed to send NOTIFY", "err", err, "event", sub.Event.String())
+	}
+}
+
+// Subscriptions returns all active and pending subscriptions
+func (n *Notifier) Subscriptions() []*NotifierSubscription {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var subs []*NotifierSubscription
+	for _, d := range n.dialogs {
+		for _, sub := range d.subs {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// subscribe returns response for SUBSCRIBE and subscription for which NOTIFY must be sent.
+// If returned reason is not empty, subscription must be terminated with it.
+func (n *Notifier) subscribe(req *sip.Request) (*sip.Response, *NotifierSubscription, string) {
+	if req.CallID() == nil || req.CSeq() == nil || req.From() == nil || req.To() == nil {
+		return sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil), nil, ""
+	}
+
+	event, ok, err := sip.MessageEvent(req)
+	if err != nil {
+		return sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Invalid Event", nil), nil, ""
+	}
+	pkg, exists := n.packages[strings.ToLower(event.Package)]
+	if !ok || !exists {
+		// https://datatracker.ietf.org/doc/html/rfc6665#section-4.2.1.1
+		res := sip.NewResponseFromRequest(req, sip.StatusBadEvent, "Bad Event", nil)
+		res.AppendHeader(sip.NewHeader("Allow-Events", n.allowEvents()))
+		return res, nil, ""
+	}
+
+	expires, res := n.expires(req)
+	if res != nil {
+		return res, nil, ""
+	}
+
+	if req.To().Params.Has("tag") {
+		return n.subscribeDialog(req, event, pkg, expires)
+	}
+
+	if req.Contact() == nil {
+		return sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Missing Contact", nil), nil, ""
+	}
+	res = sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	if !res.To().Params.Has("tag") {
+		res.To().Params.Add("tag", sip.GenerateTagN(16))
+	}
+	res.AppendHeader(n.contactHDR.Clone())
+
+	d, err := n.newDialog(req, res)
+	if err != nil {
+		n.log.Error("Failed to create subscription dialog", "err", err)
+		return sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil), nil, ""
+	}
+	sub, res := n.newSubscription(d, req, res, event, pkg, expires)
+	if sub == nil {
+		d.sess.end()
+		return res, nil, ""
+	}
+	return res, sub, n.fetchReason(expires)
+}
+
+// subscribeDialog handles SUBSCRIBE within dialog, which refreshes or terminates existing subscription,
+// or creates new one within dialog
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.2.1.2
+func (n *Notifier) subscribeDialog(req *sip.Request, event sip.Event, pkg EventPackage, expires time.Duration) (*sip.Response, *NotifierSubscription, string) {
+	id, err := sip.UASReadRequestDialogID(req)
+	if err != nil {
+		return dialogErrorResponse(req, errors.Join(ErrDialogDoesNotExists, err)), nil, ""
+	}
+
+	n.mu.Lock()
+	d, exists := n.dialogs[id]
+	var sub *NotifierSubscription
+	if exists {
+		sub = d.subs[eventKey(event)]
+	}
+	n.mu.Unlock()
+	if !exists {
+		return dialogErrorResponse(req, ErrDialogDoesNotExists), nil, ""
+	}
+	if err := d.sess.readRequest(req); err != nil {
+		return dialogErrorResponse(req, err), nil, ""
+	}
+
+	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	res.AppendHeader(n.contactHDR.Clone())
+	if sub == nil {
+		sub, res = n.newSubscription(d, req, res, event, pkg, expires)
+		if sub == nil {
+			return res, nil, ""
+		}
+		return res, sub, n.fetchReason(expires)
+	}
+
+	if !sub.refresh(expires) {
+		return dialogErrorResponse(req, ErrDialogDoesNotExists), nil, ""
+	}
+	exp := sip.ExpiresHeader(expires / time.Second)
+	res.AppendHeader(&exp)
+	return res, sub, n.fetchReason(expires)
+}
+
+// fetchReason returns reason of terminating subscription with zero expiration. Such SUBSCRIBE
+// fetches current state or removes subscription.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.4.3
+func (n *Notifier) fetchReason(expires time.Duration) string {
+	if expires == 0 {
+		return sip.SubscriptionReasonTimeout
+	}
+	return ""
+}
+
+// expires returns granted expiration of subscription or error response
+func (n *Notifier) expires(req *sip.Request) (time.Duration, *sip.Response) {
+	h := req.GetHeader("Expires")
+	if h == nil {
+		return n.defaultExpires, nil
+	}
+	sec, err := strconv.ParseUint(strings.TrimSpace(h.Value()), 10, 32)
+	if err != nil {
+		return 0, sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Invalid Expires", nil)
+	}
+	expires := time.Duration(sec) * time.Second
+	if expires > 0 && expires < n.minExpires {
+		res := sip.NewResponseFromRequest(req, sip.StatusIntervalToBrief, "Interval Too Brief", nil)
+		res.AppendHeader(sip.NewHeader("Min-Expires", strconv.Itoa(int(n.minExpires/time.Second))))
+		return 0, res
+	}
+	if n.maxExpires > 0 && expires > n.maxExpires {
+		expires = n.maxExpires
+	}
+	return expires, nil
+}
+
+func (n *Notifier) allowEvents() string {
+	names := make([]string, 0, len(n.packages))
+	for name := range n.packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// newDialog creates dialog from SUBSCRIBE and our 2xx response
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.2.1
+func (n *Notifier) newDialog(req *sip.Request, res *sip.Response) (*notifierDialog, error) {
+	id, err := sip.MakeDialogIDFromResponse(res)
+	if err != nil {
+		return nil, err
+	}
+	routeSet, err := dialogRouteSet(req.GetHeaders("Record-Route"), false)
+	if err != nil {
+		return nil, err
+	}
+
+	sess := newDialogSession(n.c, id, n.endDialog)
+	to, from := res.To(), req.From()
+	sess.callID = *req.CallID()
+	sess.localHDR = sip.FromHeader{DisplayName: to.DisplayName, Address: to.Address, Params: to.Params}
+	sess.remoteHDR = sip.ToHeader{DisplayName: from.DisplayName, Address: from.Address, Params: from.Params}
+	sess.contactHDR = n.contactHDR.Clone()
+	sess.remoteCSeq = req.CSeq().SeqNo
+	sess.remoteTarget = *req.Contact().Address.Clone()
+	sess.routeSet = routeSet
+
+	d := &notifierDialog{sess: sess, subs: make(map[string]*NotifierSubscription)}
+	n.mu.Lock()
+	n.dialogs[id] = d
+	n.mu.Unlock()
+	return d, nil
+}
+
+// newSubscription authorizes subscription with event package and adds it to dialog.
+// Nil subscription is returned with error response in case it is rejected.
+func (n *Notifier) newSubscription(d *notifierDialog, req *sip.Request, res *sip.Response, event sip.Event, pkg EventPackage, expires time.Duration) (*NotifierSubscription, *sip.Response) {
+	sub := &NotifierSubscription{
+		n:       n,
+		d:       d,
+		pkg:     pkg,
+		Event:   event,
+		Request: req,
+	}
+
+	state, err := pkg.Subscribe(sub)
+	switch {
+	case errors.Is(err, ErrSubscriptionRejected):
+		return nil, sip.NewResponseFromRequest(req, sip.StatusForbidden, "Forbidden", nil)
+	case err != nil:
+		n.log.Error("Failed to subscribe", "err", err, "event", event.String())
+		return nil, sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil)
+	case state != sip.SubscriptionStateActive && state != sip.SubscriptionStatePending:
+		n.log.Error("Invalid subscription state", "state", state, "event", event.String())
+		return nil, sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil)
+	}
+	sub.state = state
+
+	n.mu.Lock()
+	d.subs[eventKey(event)] = sub
+	n.mu.Unlock()
+
+	sub.refresh(expires)
+	exp := sip.ExpiresHeader(expires / time.Second)
+	res.AppendHeader(&exp)
+	return sub, res
+}
+
+// removeSubscription removes subscription from dialog. Dialog without subscriptions is terminated.
+func (n *Notifier) removeSubscription(sub *NotifierSubscription) {
+	n.mu.Lock()
+	delete(sub.d.subs, eventKey(sub.Event))
+	id := sub.d.sess.ID()
+	last := len(sub.d.subs) == 0 && n.dialogs[id] == sub.d
+	if last {
+		delete(n.dialogs, id)
+	}
+	n.mu.Unlock()
+
+	if last {
+		sub.d.sess.end()
+	}
+}
+
+// endDialog terminates subscriptions of dialog, when it is terminated without NOTIFY,
+// for example on 481 response
+func (n *Notifier) endDialog(id string) {
+	n.mu.Lock()
+	d, exists := n.dialogs[id]
+	delete(n.dialogs, id)
+	var subs []*NotifierSubscription
+	if exists {
+		for _, sub := range d.subs {
+			subs = append(subs, sub)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.end()
+	}
+}
+
+// eventKey identifies subscription within dialog
+func eventKey(e sip.Event) string {
+	return strings.ToLower(e.Package) + ";" + e.ID
+}
+
+// NotifierSubscription is subscription created by SUBSCRIBE. State changes of resource are sent
+// to subscriber with Notify.
+type NotifierSubscription struct {
+	n   *Notifier
+	d   *notifierDialog
+	pkg EventPackage
+
+	// Event is event package and id of subscription
+	Event sip.Event
+	// Request is SUBSCRIBE that created subscription
+	Request *sip.Request
+
+	mu      sync.Mutex
+	state   string
+	expires time.Time
+	timer   sip.Timer
+	ended   bool
+}
+
+// DialogID returns ID of dialog used by subscription
+func (sub *NotifierSubscription) DialogID() string {
+	return sub.d.sess.ID()
+}
+
+// State returns subscription state. Check sip.SubscriptionState... constants
+func (sub *NotifierSubscription) State() string {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.state
+}
+
+// Expires returns time when subscription expires, unless it is refreshed
+func (sub *NotifierSubscription) Expires() time.Time {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.expires
+}
+
+// Notify sends NOTIFY with current state from event package. Subscription is terminated
+// if NOTIFY fails or it is not answered with 2xx.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.2.2
+func (sub *NotifierSubscription) Notify(ctx context.Context) error {
+	return sub.notify(ctx, "", "")
+}
+
+// Activate moves pending subscription to active state and sends NOTIFY
+func (sub *NotifierSubscription) Activate(ctx context.Context) error {
+	return sub.notify(ctx, sip.SubscriptionStateActive, "")
+}
+
+// Terminate sends NOTIFY with terminated state and reason, after which subscription is removed.
+// Check sip.SubscriptionReason... constants.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.2.2
+func (sub *NotifierSubscription) Terminate(ctx context.Context, reason string) error {
+	return sub.notify(ctx, sip.SubscriptionStateTerminated, reason)
+}
+
+func (sub *NotifierSubscription) notify(ctx context.Context, state string, reason string) error {
+	sub.mu.Lock()
+	if sub.state == sip.SubscriptionStateTerminated {
+		sub.mu.Unlock()
+		return ErrSubscriptionTerminated
+	}
+	if state != "" {
+		sub.state = state
+	}
+	ss := sip.SubscriptionState{State: sub.state, Reason: reason}
+	if sub.state == sip.SubscriptionStateTerminated {
+		if sub.timer != nil {
+			sub.timer.Stop()
+		}
+	} else if remaining := sub.expires.Sub(sub.n.clock.Now()); remaining > 0 {
+		ss.Expires = uint32((remaining + time.Second - 1) / time.Second)
+	}
+	sub.mu.Unlock()
+
+	req := sip.NewRequest(sip.NOTIFY, sip.Uri{})
+	req.AppendHeader(sip.NewHeader("Event", sub.Event.String()))
+	req.AppendHeader(sip.NewHeader("Subscription-State", ss.String()))
+	if ss.State != sip.SubscriptionStatePending {
+		contentType, body, err := sub.pkg.Content(sub)
+		if err != nil {
+			sub.end()
+			return fmt.Errorf("event package content: %w", err)
+		}
+		if body != nil {
+			req.AppendHeader(sip.NewHeader("Content-Type", contentType))
+			req.SetBody(body)
+		}
+	}
+
+	res, err := sub.d.sess.Do(ctx, req)
+	if err == nil && !res.IsSuccess() {
+		err = ErrDialogResponse{Res: res}
+	}
+	if err != nil || ss.State == sip.SubscriptionStateTerminated {
+		sub.end()
+	}
+	return err
+}
+
+// refresh sets new expiration. It returns false if subscription is already terminated.
+func (sub *NotifierSubscription) refresh(expires time.Duration) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.state == sip.SubscriptionStateTerminated {
+		return false
+	}
+	if sub.timer != nil {
+		sub.timer.Stop()
+		sub.timer = nil
+	}
+	sub.expires = sub.n.clock.Now().Add(expires)
+	if expires == 0 {
+		// Subscription is terminated by caller
+		return true
+	}
+	sub.timer = sub.n.clock.AfterFunc(expires, func() {
+		if err := sub.Terminate(context.Background(), sip.SubscriptionReasonTimeout); err != nil && !errors.Is(err, ErrSubscriptionTerminated) {
+			sub.n.log.Info("Failed to terminate expired subscription", "err", err, "event", sub.Event.String())
+		}
+	})
+	return true
+}
+
+// end removes subscription without sending NOTIFY
+func (sub *NotifierSubscription) end() {
+	sub.mu.Lock()
+	if sub.ended {
+		sub.mu.Unlock()
+		return
+	}
+	sub.ended = true
+	sub.state = sip.SubscriptionStateTerminated
+	if sub.timer != nil {
+		sub.timer.Stop()
+	}
+	sub.mu.Unlock()
+
+	sub.n.removeSubscription(sub)
+	sub.pkg.Unsubscribe(sub)
+}
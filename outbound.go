@@ -0,0 +1,148 @@
+package sipgo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/livekit/sipgo/sip"
+	"github.com/livekit/sipgo/transport"
+)
+
+// ClientRequestOutbound returns option which marks Contact of REGISTER as outbound registration flow
+// with instance ID and regID, and adds Supported: outbound, path. Contact header must be present.
+// After successful registration keepalives should be started with Client.KeepAliveFlow.
+// https://datatracker.ietf.org/doc/html/rfc5626#section-4.2
+func ClientRequestOutbound(instance string, regID int) ClientRequestOption {
+	return func(c *Client, r *sip.Request) error {
+		contact := r.Contact()
+		if contact == nil {
+			return fmt.Errorf("missing Contact header")
+		}
+		sip.SetContactOutbound(contact, instance, regID)
+
+		if !sip.HasOptionTag(r, "Supported", sip.OptionTagOutbound) {
+			r.AppendHeader(sip.NewHeader("Supported", sip.OptionTagOutbound))
+		}
+		if !sip.HasOptionTag(r, "Supported", sip.OptionTagPath) {
+			r.AppendHeader(sip.NewHeader("Supported", sip.OptionTagPath))
+		}
+		return nil
+	}
+}
+
+// KeepAliveFlow starts keepalives on flow over which response to REGISTER was received.
+// Interval is taken from Flow-Timer of response, or transport default is used.
+// Flow failures are reported to handlers added with TransportLayer().OnFlowFailure,
+// after which user agent should register flow again.
+// https://datatracker.ietf.org/doc/html/rfc5626#section-4.4
+func (c *Client) KeepAliveFlow(res *sip.Response) (*transport.FlowKeepAlive, error) {
+	var interval time.Duration
+	sec, ok, err := sip.MessageFlowTimer(res)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		interval = time.Duration(sec) * time.Second
+	}
+	return c.tp.KeepAlive(res.Transport(), res.Source(), interval)
+}
+
+// OutboundEdge implements edge proxy part of SIP Outbound. It puts flow over which
+// request was received into Path or Record-Route as token, and routes requests
+// containing token back over same flow.
+// https://datatracker.ietf.org/doc/html/rfc5626#section-5
+type OutboundEdge struct {
+	tp     *transport.Layer
+	tokens *transport.FlowTokens
+}
+
+// NewOutboundEdge creates edge proxy handle for user agent.
+// Key is used to sign flow tokens and it must be shared by edge proxies in cluster.
+// If empty random key is used.
+func NewOutboundEdge(ua *UserAgent, key []byte) *OutboundEdge {
+	return &OutboundEdge{
+		tp:     ua.TransportLayer(),
+		tokens: transport.NewFlowTokens(key),
+	}
+}
+
+// FlowURI returns URI of proxy with token of flow over which request was received
+func (e *OutboundEdge) FlowURI(c *Client, r *sip.Request) (sip.Uri, error) {
+	flow, err := e.tp.RequestFlow(r)
+	if err != nil {
+		return sip.Uri{}, err
+	}
+	network := transport.NetworkToLower(r.Transport())
+	return sip.Uri{
+		User: e.tokens.Encode(flow),
+		Host: c.host,
+		Port: c.tp.GetListenPort(network),
+		UriParams: sip.HeaderParams{
+			"transport": network,
+			"lr":        "",
+		},
+		Headers: sip.NewParams(),
+	}, nil
+}
+
+// ClientRequestAddPath is option for adding Path with flow token to REGISTER forwarded by edge proxy.
+// For outbound registration "ob" parameter is added, which tells registrar that flow is kept alive.
+// https://datatracker.ietf.org/doc/html/rfc5626#section-5.1
+func (e *OutboundEdge) ClientRequestAddPath(c *Client, r *sip.Request) error {
+	uri, err := e.FlowURI(c, r)
+	if err != nil {
+		return err
+	}
+	if contact := r.Contact(); contact != nil {
+		if _, ok, _ := sip.ContactRegID(contact); ok {
+			uri.UriParams.Add(sip.ParamOutbound, "")
+		}
+	}
+	r.PrependHeader(sip.NewHeader("Path", "<"+uri.String()+">"))
+	return nil
+}
+
+// ClientRequestAddRecordRoute is option for adding Record-Route with flow token to dialog forming
+// request forwarded by edge proxy, so that requests within dialog are routed back over same flow.
+func (e *OutboundEdge) ClientRequestAddRecordRoute(c *Client, r *sip.Request) error {
+	uri, err := e.FlowURI(c, r)
+	if err != nil {
+		return err
+	}
+	r.PrependHeader(&sip.RecordRouteHeader{Address: uri})
+	return nil
+}
+
+// RouteFlow checks top Route of request for flow token of this proxy. If it is found, Route is removed
+// and request destination is set to flow, unless request was received over same flow, in which case
+// it should be forwarded as usual. Returned bool is true if request must be sent over flow.
+// Error wrapping transport.ErrFlowFailed is returned if flow does not exist anymore and
+// request should be rejected with 430 Flow Failed.
+// https://datatracker.ietf.org/doc/html/rfc5626#section-5.3
+func (e *OutboundEdge) RouteFlow(r *sip.Request) (bool, error) {
+	route := r.Route()
+	if route == nil || route.Address.User == "" {
+		return false, nil
+	}
+	flow, err := e.tokens.Decode(route.Address.User)
+	if err != nil {
+		// Not our token
+		return false, nil
+	}
+	r.RemoveHeader("Route")
+
+	if in, err := e.tp.RequestFlow(r); err == nil && in == flow {
+		return false, nil
+	}
+
+	conn, err := e.tp.FlowConnection(flow)
+	if err != nil {
+		return true, err
+	}
+	conn.TryClose()
+
+	r.SetTransport(strings.ToUpper(flow.Network))
+	r.SetDestination(flow.RemoteAddr)
+	return true, nil
+}
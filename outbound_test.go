@@ -0,0 +1,104 @@
+package sipgo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sipgo/sip"
+	"github.com/livekit/sipgo/transport"
+)
+
+func TestOutboundEdge(t *testing.T) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer ua.Close()
+	srv, err := NewServer(ua)
+	require.NoError(t, err)
+	edgeClient, err := NewClient(ua)
+	require.NoError(t, err)
+	edge := NewOutboundEdge(ua, []byte("secret"))
+
+	paths := make(chan string, 1)
+	srv.OnRegister(func(req *sip.Request, tx sip.ServerTransaction) {
+		if err := edge.ClientRequestAddPath(edgeClient, req); err != nil {
+			tx.Respond(sip.NewResponseFromRequest(req, sip.StatusInternalServerError, err.Error(), nil))
+			return
+		}
+		paths <- req.GetHeader("Path").Value()
+
+		res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+		res.AppendHeader(sip.NewHeader("Require", sip.OptionTagOutbound))
+		res.AppendHeader(sip.NewHeader("Flow-Timer", "30"))
+		tx.Respond(res)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.ServeTCP(ln)
+	defer ln.Close()
+
+	phoneUA, err := NewUA()
+	require.NoError(t, err)
+	phone, err := NewClient(phoneUA, WithClientHostname("127.0.0.1"))
+	require.NoError(t, err)
+
+	edgeAddr := ln.Addr().(*net.TCPAddr)
+	req := sip.NewRequest(sip.REGISTER, sip.Uri{Host: "127.0.0.1", Port: edgeAddr.Port, UriParams: sip.HeaderParams{"transport": "tcp"}})
+	req.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: "alice", Host: "127.0.0.1"}})
+	require.NoError(t, clientRequestBuildReq(phone, req))
+	instance := sip.NewInstanceID()
+	require.NoError(t, ClientRequestOutbound(instance, 1)(phone, req))
+	assert.True(t, sip.HasOptionTag(req, "Supported", sip.OptionTagOutbound))
+	assert.Equal(t, instance, sip.ContactInstance(req.Contact()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := phone.Do(ctx, req, func(c *Client, r *sip.Request) error { return nil })
+	require.NoError(t, err)
+	require.Equal(t, sip.StatusOK, res.StatusCode)
+
+	k, err := phone.KeepAliveFlow(res)
+	require.NoError(t, err)
+	assert.Equal(t, "tcp", k.Flow().Network)
+	assert.Equal(t, ln.Addr().String(), k.Flow().RemoteAddr)
+	k.Stop()
+
+	// Request routed by registrar with Path is sent back over phone flow
+	var path sip.Uri
+	_, err = sip.ParseAddressValue(<-paths, &path, sip.NewParams())
+	require.NoError(t, err)
+	assert.True(t, path.UriParams.Has(sip.ParamOutbound))
+	assert.True(t, path.UriParams.Has("lr"))
+
+	invite := sip.NewRequest(sip.INVITE, sip.Uri{User: "alice", Host: "127.0.0.1"})
+	invite.AppendHeader(&sip.RouteHeader{Address: path})
+	ok, err := edge.RouteFlow(invite)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Nil(t, invite.Route())
+	assert.Equal(t, "TCP", invite.Transport())
+	assert.Equal(t, k.Flow().LocalAddr, invite.Destination())
+
+	// Flow is gone after phone closes connection
+	phoneUA.Close()
+	require.Eventually(t, func() bool {
+		invite := sip.NewRequest(sip.INVITE, sip.Uri{User: "alice", Host: "127.0.0.1"})
+		invite.AppendHeader(&sip.RouteHeader{Address: path})
+		_, err := edge.RouteFlow(invite)
+		return errors.Is(err, transport.ErrFlowFailed)
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Tokens of other proxies are ignored
+	other := sip.NewRequest(sip.INVITE, sip.Uri{User: "alice", Host: "127.0.0.1"})
+	other.AppendHeader(&sip.RouteHeader{Address: sip.Uri{User: "proxy", Host: "10.0.0.1"}})
+	ok, err = NewOutboundEdge(ua, nil).RouteFlow(other)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.NotNil(t, other.Route())
+}
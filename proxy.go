@@ -0,0 +1,795 @@
+package sipgo
+
+import (
+	"errors"
+	"hash/fnv"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/livekit/sipgo/sip"
+	"github.com/livekit/sipgo/transaction"
+	"github.com/livekit/sipgo/transport"
+)
+
+// ProxyLocator returns target set for request outside of dialog, for example from location service.
+// Empty target set is responded with 480 Temporarily Unavailable.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-16.5
+type ProxyLocator func(req *sip.Request) ([]sip.Uri, error)
+
+type ProxyOption func(p *Proxy)
+
+// WithProxyLocator sets function returning target set of request
+// Default: Request-URI is only target
+func WithProxyLocator(f ProxyLocator) ProxyOption {
+	return func(p *Proxy) {
+		p.locate = f
+	}
+}
+
+// WithProxyRecordRoute makes proxy add Record-Route to requests outside of dialog,
+// so that it stays on path of requests within dialog.
+func WithProxyRecordRoute() ProxyOption {
+	return func(p *Proxy) {
+		p.recordRoute = true
+	}
+}
+
+// WithProxySerialForking makes proxy try targets one by one in order of target set,
+// until 2xx or 6xx response is received.
+// Default: targets are tried in parallel
+func WithProxySerialForking() ProxyOption {
+	return func(p *Proxy) {
+		p.serial = true
+	}
+}
+
+// WithProxyRecursion makes proxy add Contacts of 3xx responses to target set
+func WithProxyRecursion() ProxyOption {
+	return func(p *Proxy) {
+		p.recurse = true
+	}
+}
+
+// WithProxyTimerC sets time after which INVITE branch without final response is canceled.
+// RFC 3261 requires it to be larger than 3 minutes.
+// Default: 181s
+func WithProxyTimerC(d time.Duration) ProxyOption {
+	return func(p *Proxy) {
+		p.timerC = d
+	}
+}
+
+// WithProxyStateless makes proxy forward messages without transactions, as described in RFC 3261 section 16.11.
+// Request is forwarded only to first target, so locator must return same target for retransmissions.
+// Locator is called also for ACK, and for ACK of 2xx it should return Request-URI, which is Contact of UAS.
+// Serial forking, recursion and Timer C are not used.
+func WithProxyStateless() ProxyOption {
+	return func(p *Proxy) {
+		p.stateless = true
+	}
+}
+
+// Proxy is stateful SIP proxy as described in RFC 3261 section 16.
+// Requests are validated, forwarded to every target of target set, and responses are forwarded back
+// with best final response selected from all branches. CANCEL is propagated to all pending branches.
+// With WithProxyStateless messages bypass transaction layer and responses are routed back by Via.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-16
+type Proxy struct {
+	srv    *Server
+	client *Client
+	log    *slog.Logger
+	clock  sip.Clock
+
+	locate      ProxyLocator
+	recordRoute bool
+	serial      bool
+	recurse     bool
+	timerC      time.Duration
+	stateless   bool
+}
+
+// NewProxy creates proxy, which receives requests with server and forwards them with client.
+// Proxy handles all requests without handler registered on server. Methods handled locally,
+// like REGISTER, can be registered on server and request can still be proxied with Proxy.Forward.
+// Stateless proxy intercepts all messages on transport layer, except requests addressed to proxy itself
+// without user part, like OPTIONS pings, which are passed to server.
+func NewProxy(srv *Server, client *Client, options ...ProxyOption) *Proxy {
+	p := &Proxy{
+		srv:    srv,
+		client: client,
+		log:    srv.log,
+		clock:  srv.clock,
+		timerC: 181 * time.Second,
+	}
+	if p.clock == nil {
+		p.clock = sip.SystemClock
+	}
+	for _, o := range options {
+		o(p)
+	}
+
+	if p.stateless {
+		client.TransportLayer().OnMessageIntercept(p.handleStateless)
+		return p
+	}
+	srv.OnNoRoute(p.Forward)
+	return p
+}
+
+// Forward proxies request and blocks until final response is forwarded upstream
+// and all branches are terminated. It can be used as request handler.
+func (p *Proxy) Forward(req *sip.Request, tx sip.ServerTransaction) {
+	loopHash := proxyLoopHash(req)
+	if res := p.validate(req, loopHash); res != nil {
+		if req.IsAck() {
+			p.log.Debug("Dropping invalid ACK", "status", res.StatusCode, "req", req.Short())
+			return
+		}
+		p.respond(tx, res)
+		return
+	}
+	p.preprocessRoute(req)
+
+	if req.IsAck() {
+		// ACK for 2xx is end to end and it is forwarded without transaction
+		ack := p.forwardRequest(req, req.Recipient, sip.GenerateBranchN(16)+"."+loopHash)
+		if err := p.client.WriteRequest(ack, proxyNoop); err != nil {
+			p.log.Error("Failed to forward ACK", "err", err, "req", req.Short())
+		}
+		return
+	}
+
+	if stx, ok := tx.(*transaction.ServerTx); ok {
+		stx.SetProxy()
+	}
+
+	targets := []sip.Uri{req.Recipient}
+	if p.locate != nil && !proxyInDialog(req) {
+		var err error
+		targets, err = p.locate(req)
+		if err != nil {
+			p.log.Error("Failed to locate targets", "err", err, "req", req.Short())
+			p.respond(tx, sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil))
+			return
+		}
+	}
+	if len(targets) == 0 {
+		p.respond(tx, sip.NewResponseFromRequest(req, sip.StatusTemporarilyUnavailable, "Temporarily Unavailable", nil))
+		return
+	}
+
+	pc := newProxyContext(p, req, tx, loopHash)
+	pc.run(targets)
+}
+
+func (p *Proxy) respond(tx sip.ServerTransaction, res *sip.Response) {
+	if err := tx.Respond(res); err != nil {
+		p.log.Error("Failed to respond", "err", err, "res", res.StartLine())
+	}
+}
+
+// validate checks request as described in RFC 3261 section 16.3 and returns error response if it is not valid.
+// Proxy-Authorization is not checked, it can be done with request middleware.
+func (p *Proxy) validate(req *sip.Request, loopHash string) *sip.Response {
+	switch req.Recipient.Scheme {
+	case "", "sip", "sips":
+	default:
+		return sip.NewResponseFromRequest(req, sip.StatusRequestedRangeNotSatisfiable, "Unsupported URI Scheme", nil)
+	}
+
+	if mf := req.MaxForwards(); mf != nil && mf.Val() == 0 {
+		return sip.NewResponseFromRequest(req, sip.StatusTooManyHops, "Too Many Hops", nil)
+	}
+
+	if p.isLoop(req, loopHash) {
+		return sip.NewResponseFromRequest(req, sip.StatusLoopDetected, "Loop Detected", nil)
+	}
+
+	// No extensions are supported by proxy
+	if hdrs := req.GetHeaders("Proxy-Require"); len(hdrs) > 0 {
+		res := sip.NewResponseFromRequest(req, sip.StatusBadExtension, "Bad Extension", nil)
+		for _, h := range hdrs {
+			res.AppendHeader(sip.NewHeader("Unsupported", h.Value()))
+		}
+		return res
+	}
+	return nil
+}
+
+// isLoop checks is request already forwarded by this proxy. Request with our Via is loop
+// only if it is not changed since, otherwise it is spiral and it must be forwarded.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-16.3
+func (p *Proxy) isLoop(req *sip.Request, loopHash string) bool {
+	for _, h := range req.GetHeaders("Via") {
+		via, ok := h.(*sip.ViaHeader)
+		if !ok || !p.isOwnAddr(via.Host, via.Port, via.Transport) {
+			continue
+		}
+		if branch, _ := via.Params.Get("branch"); strings.HasSuffix(branch, "."+loopHash) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyLoopHash hashes fields of request that are changed by spiral.
+// To tag is not hashed, as ACK of non 2xx response must get same branch as INVITE.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-16.6
+func proxyLoopHash(req *sip.Request) string {
+	h := fnv.New64a()
+	h.Write([]byte(req.Recipient.String()))
+	if from := req.From(); from != nil {
+		tag, _ := from.Params.Get("tag")
+		h.Write([]byte(tag))
+	}
+	if callID := req.CallID(); callID != nil {
+		h.Write([]byte(callID.Value()))
+	}
+	if cseq := req.CSeq(); cseq != nil {
+		h.Write([]byte(strconv.FormatUint(uint64(cseq.SeqNo), 10)))
+	}
+	if route := req.Route(); route != nil {
+		h.Write([]byte(route.Value()))
+	}
+	for _, hdr := range req.GetHeaders("Proxy-Require") {
+		h.Write([]byte(hdr.Value()))
+	}
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// preprocessRoute removes Route of this proxy
+// https://datatracker.ietf.org/doc/html/rfc3261#section-16.4
+func (p *Proxy) preprocessRoute(req *sip.Request) {
+	p.client.preprocessRoute(req, p.client.host)
+}
+
+// isOwnURI checks does URI point to this proxy
+func (p *Proxy) isOwnURI(uri sip.Uri) bool {
+	return p.client.isOwnURI(uri, p.client.host)
+}
+
+// isOwnAddr checks is host and port one of our listen addresses
+func (p *Proxy) isOwnAddr(host string, port int, network string) bool {
+	return p.client.isOwnAddr(host, port, network, p.client.host)
+}
+
+// forwardRequest creates copy of request for target with branch of our Via
+// https://datatracker.ietf.org/doc/html/rfc3261#section-16.6
+func (p *Proxy) forwardRequest(req *sip.Request, target sip.Uri, branch string) *sip.Request {
+	fwd := req.Clone()
+	fwd.SetBody(req.Body())
+	fwd.Recipient = *target.Clone()
+	fwd.SetSource("")
+	fwd.SetDestination("")
+	fwd.SetTransport(proxyRequestTransport(fwd))
+
+	if mf := fwd.MaxForwards(); mf != nil {
+		mf.Dec()
+	} else {
+		maxfwd := sip.MaxForwardsHeader(70)
+		fwd.AppendHeader(&maxfwd)
+	}
+
+	if p.recordRoute && !fwd.IsAck() && !proxyInDialog(fwd) {
+		ClientRequestAddRecordRoute(p.client, fwd)
+	}
+
+	// Next hop can be strict router
+	// https://datatracker.ietf.org/doc/html/rfc3261#section-16.6 step 6
+	requestRoute(fwd)
+
+	ClientRequestAddVia(p.client, fwd)
+	fwd.Via().Params.Add("branch", branch)
+	return fwd
+}
+
+// proxyRequestTransport returns transport of forwarded request by top Route or Request-URI
+func proxyRequestTransport(req *sip.Request) string {
+	uri := req.Recipient
+	if route := req.Route(); route != nil {
+		uri = route.Address
+	}
+	if tp := uriTransport(uri); tp != "" {
+		return tp
+	}
+	return transport.TransportUDP
+}
+
+// proxyInDialog checks is request sent within dialog
+func proxyInDialog(req *sip.Request) bool {
+	to := req.To()
+	return to != nil && to.Params.Has("tag")
+}
+
+// proxyNoop is client option for sending request as it is
+func proxyNoop(c *Client, req *sip.Request) error {
+	return nil
+}
+
+// proxyResponse creates copy of response received on branch, to be forwarded upstream
+// https://datatracker.ietf.org/doc/html/rfc3261#section-16.7
+func proxyResponse(req *sip.Request, res *sip.Response) *sip.Response {
+	fwd := res.Clone()
+	fwd.SetBody(res.Body())
+	fwd.RemoveHeader("Via")
+	fwd.SetTransport(req.Transport())
+	fwd.SetSource(req.Destination())
+	fwd.SetDestination(req.Source())
+	return fwd
+}
+
+// proxyBranch is client transaction of request forwarded to single target
+type proxyBranch struct {
+	target      sip.Uri
+	tx          sip.ClientTransaction
+	provisional bool
+	// cancel is set when branch must be canceled after first provisional response
+	cancel bool
+	done   bool
+	timerC sip.Timer
+}
+
+type proxyEvent struct {
+	branch *proxyBranch
+	res    *sip.Response
+	err    error
+	// timerC is set when Timer C of branch fires
+	timerC bool
+}
+
+// proxyContext is response context of forwarded request. Responses kept in context
+// are already prepared for forwarding upstream.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-16.7
+type proxyContext struct {
+	p        *Proxy
+	req      *sip.Request
+	tx       sip.ServerTransaction
+	loopHash string
+
+	// events of all branches are handled in single goroutine
+	events chan proxyEvent
+	done   chan struct{}
+
+	branches  []*proxyBranch
+	queue     []sip.Uri
+	tried     map[string]struct{}
+	responses []*sip.Response
+	// final is set when final response is forwarded upstream
+	final bool
+	// stop is set when no new branches should be started
+	stop bool
+}
+
+func newProxyContext(p *Proxy, req *sip.Request, tx sip.ServerTransaction, loopHash string) *proxyContext {
+	return &proxyContext{
+		p:        p,
+		req:      req,
+		tx:       tx,
+		loopHash: loopHash,
+		events:   make(chan proxyEvent),
+		done:     make(chan struct{}),
+		tried:    make(map[string]struct{}),
+	}
+}
+
+func (pc *proxyContext) run(targets []sip.Uri) {
+	defer close(pc.done)
+	pc.addTargets(targets)
+
+	for {
+		pc.startBranches()
+		if pc.active() == 0 {
+			break
+		}
+
+		select {
+		case ev := <-pc.events:
+			pc.handleEvent(ev)
+		case cancel := <-pc.tx.Cancels():
+			pc.p.respond(pc.tx, sip.NewResponseFromRequest(cancel, sip.StatusOK, "OK", nil))
+			pc.cancelBranches()
+		case <-pc.tx.Done():
+			// Upstream transaction is terminated, there is no one to forward responses
+			pc.final = true
+			pc.cancelBranches()
+		}
+	}
+
+	if !pc.final {
+		pc.p.respond(pc.tx, pc.bestResponse())
+	}
+}
+
+// addTargets adds targets to target set. Targets already tried are skipped
+func (pc *proxyContext) addTargets(targets []sip.Uri) int {
+	added := 0
+	for _, t := range targets {
+		key := t.String()
+		if _, exists := pc.tried[key]; exists {
+			continue
+		}
+		pc.tried[key] = struct{}{}
+		pc.queue = append(pc.queue, t)
+		added++
+	}
+	return added
+}
+
+func (pc *proxyContext) active() int {
+	n := 0
+	for _, b := range pc.branches {
+		if !b.done {
+			n++
+		}
+	}
+	return n
+}
+
+// startBranches forwards request to targets in queue. With serial forking next target
+// is tried only after all branches are completed.
+func (pc *proxyContext) startBranches() {
+	for !pc.stop && len(pc.queue) > 0 {
+		if pc.p.serial && pc.active() > 0 {
+			return
+		}
+		target := pc.queue[0]
+		pc.queue = pc.queue[1:]
+		pc.startBranch(target)
+	}
+}
+
+func (pc *proxyContext) startBranch(target sip.Uri) {
+	fwd := pc.p.forwardRequest(pc.req, target, sip.GenerateBranchN(16)+"."+pc.loopHash)
+	b := &proxyBranch{target: target}
+
+	tx, err := pc.p.client.tx.ProxyRequest(fwd)
+	if err != nil {
+		// https://datatracker.ietf.org/doc/html/rfc3261#section-16.9
+		pc.p.log.Debug("Failed to forward request", "err", err, "target", target.String())
+		b.done = true
+		pc.branches = append(pc.branches, b)
+		pc.responses = append(pc.responses, sip.NewResponseFromRequest(pc.req, sip.StatusServiceUnavailable, "Service Unavailable", nil))
+		return
+	}
+	b.tx = tx
+	pc.branches = append(pc.branches, b)
+	pc.resetTimerC(b)
+	go pc.receive(b)
+}
+
+// receive passes responses of branch as events until final response
+func (pc *proxyContext) receive(b *proxyBranch) {
+	for {
+		select {
+		case res, more := <-b.tx.Responses():
+			if !more {
+				pc.send(proxyEvent{branch: b, err: b.tx.Err()})
+				return
+			}
+			pc.send(proxyEvent{branch: b, res: res})
+			if !res.IsProvisional() {
+				return
+			}
+		case <-b.tx.Done():
+			// Responses can be still buffered when transaction terminates
+			for res := range b.tx.Responses() {
+				pc.send(proxyEvent{branch: b, res: res})
+				if !res.IsProvisional() {
+					return
+				}
+			}
+			pc.send(proxyEvent{branch: b, err: b.tx.Err()})
+			return
+		}
+	}
+}
+
+func (pc *proxyContext) send(ev proxyEvent) {
+	select {
+	case pc.events <- ev:
+	case <-pc.done:
+	}
+}
+
+// resetTimerC starts or restarts Timer C of INVITE branch
+func (pc *proxyContext) resetTimerC(b *proxyBranch) {
+	if !pc.req.IsInvite() || pc.p.timerC <= 0 {
+		return
+	}
+	if b.timerC != nil {
+		b.timerC.Stop()
+	}
+	b.timerC = pc.p.clock.AfterFunc(pc.p.timerC, func() {
+		pc.send(proxyEvent{branch: b, timerC: true})
+	})
+}
+
+func (pc *proxyContext) handleEvent(ev proxyEvent) {
+	b := ev.branch
+	if b.done {
+		return
+	}
+
+	switch {
+	case ev.timerC:
+		pc.p.log.Debug("Timer C fired", "target", b.target.String())
+		if b.provisional {
+			b.tx.Cancel()
+			return
+		}
+		pc.completeBranch(b)
+		b.tx.Terminate()
+		pc.responses = append(pc.responses, sip.NewResponseFromRequest(pc.req, sip.StatusRequestTimeout, "Request Timeout", nil))
+
+	case ev.res == nil:
+		pc.completeBranch(b)
+		// Transaction failure is treated as 408 or 503 response
+		// https://datatracker.ietf.org/doc/html/rfc3261#section-16.7
+		res := sip.NewResponseFromRequest(pc.req, sip.StatusRequestTimeout, "Request Timeout", nil)
+		if ev.err != nil && !errors.Is(ev.err, transaction.ErrTimeout) {
+			res = sip.NewResponseFromRequest(pc.req, sip.StatusServiceUnavailable, "Service Unavailable", nil)
+		}
+		pc.responses = append(pc.responses, res)
+
+	case ev.res.IsProvisional():
+		pc.handleProvisional(b, ev.res)
+
+	default:
+		pc.completeBranch(b)
+		pc.handleFinal(b, ev.res)
+	}
+}
+
+func (pc *proxyContext) handleProvisional(b *proxyBranch, res *sip.Response) {
+	b.provisional = true
+	pc.resetTimerC(b)
+	if b.cancel {
+		b.cancel = false
+		b.tx.Cancel()
+	}
+	if res.StatusCode == sip.StatusTrying || pc.final {
+		return
+	}
+	pc.p.respond(pc.tx, proxyResponse(pc.req, res))
+}
+
+func (pc *proxyContext) handleFinal(b *proxyBranch, res *sip.Response) {
+	switch {
+	case res.IsSuccess():
+		// Every 2xx of INVITE is forwarded, as each can establish dialog
+		if pc.final && !pc.req.IsInvite() {
+			return
+		}
+		pc.final = true
+		pc.p.respond(pc.tx, proxyResponse(pc.req, res))
+		pc.cancelBranches()
+		return
+
+	case res.StatusCode >= 600:
+		pc.cancelBranches()
+
+	case res.IsRedirection() && pc.p.recurse:
+		var targets []sip.Uri
+		for _, h := range res.GetHeaders("Contact") {
+			if contact, ok := h.(*sip.ContactHeader); ok {
+				targets = append(targets, contact.Address)
+			}
+		}
+		if pc.addTargets(targets) > 0 {
+			// Response is not kept when contacts are recursed
+			return
+		}
+	}
+	pc.responses = append(pc.responses, proxyResponse(pc.req, res))
+}
+
+// completeBranch marks branch as completed. Non 2xx final response is acknowledged by transaction
+func (pc *proxyContext) completeBranch(b *proxyBranch) {
+	b.done = true
+	if b.timerC != nil {
+		b.timerC.Stop()
+	}
+}
+
+// cancelBranches stops forking and cancels pending branches. INVITE branch is canceled only
+// after provisional response, while other requests are terminated.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-16.10
+func (pc *proxyContext) cancelBranches() {
+	pc.stop = true
+	pc.queue = nil
+	for _, b := range pc.branches {
+		if b.done {
+			continue
+		}
+		if !pc.req.IsInvite() {
+			pc.completeBranch(b)
+			b.tx.Terminate()
+			continue
+		}
+		if b.provisional {
+			b.tx.Cancel()
+		} else {
+			b.cancel = true
+		}
+	}
+}
+
+// bestResponse selects response forwarded upstream when all branches are completed
+// https://datatracker.ietf.org/doc/html/rfc3261#section-16.7
+func (pc *proxyContext) bestResponse() *sip.Response {
+	var best *sip.Response
+	for _, res := range pc.responses {
+		if best == nil || proxyResponseBetter(res, best) {
+			best = res
+		}
+	}
+	if best == nil {
+		if pc.stop {
+			return sip.NewResponseFromRequest(pc.req, sip.StatusRequestTerminated, "Request Terminated", nil)
+		}
+		return sip.NewResponseFromRequest(pc.req, sip.StatusRequestTimeout, "Request Timeout", nil)
+	}
+
+	res := best
+	switch res.StatusCode {
+	case sip.StatusServiceUnavailable:
+		// 503 would make upstream stop using this proxy
+		res.StatusCode = sip.StatusInternalServerError
+		res.Reason = "Internal Server Error"
+	case sip.StatusUnauthorized, sip.StatusProxyAuthRequired:
+		// Challenges of all branches are collected
+		for _, other := range pc.responses {
+			if other == best {
+				continue
+			}
+			for _, name := range []string{"WWW-Authenticate", "Proxy-Authenticate"} {
+				for _, h := range other.GetHeaders(name) {
+					res.AppendHeader(sip.HeaderClone(h))
+				}
+			}
+		}
+	}
+	return res
+}
+
+// proxyResponseBetter checks is response a better than b. 6xx is preferred, then lowest class.
+// Within 4xx, responses that allow resubmitting request are preferred.
+func proxyResponseBetter(a, b *sip.Response) bool {
+	ca, cb := proxyResponseClass(a), proxyResponseClass(b)
+	if ca != cb {
+		return ca < cb
+	}
+	return proxyResubmit(a) && !proxyResubmit(b)
+}
+
+func proxyResponseClass(res *sip.Response) int {
+	class := int(res.StatusCode) / 100
+	if class == 6 {
+		return 0
+	}
+	return class
+}
+
+func proxyResubmit(res *sip.Response) bool {
+	switch res.StatusCode {
+	case sip.StatusUnauthorized, sip.StatusProxyAuthRequired, sip.StatusUnsupportedMediaType,
+		sip.StatusBadExtension, sip.StatusAddressIncomplete:
+		return true
+	}
+	return false
+}
+
+// handleStateless is transport layer interceptor of stateless proxy
+func (p *Proxy) handleStateless(msg sip.Message) bool {
+	switch m := msg.(type) {
+	case *sip.Request:
+		if m.Route() == nil && m.Recipient.User == "" && p.isOwnURI(m.Recipient) {
+			return false
+		}
+		go p.ForwardStateless(m)
+		return true
+	case *sip.Response:
+		// Responses with single Via are for requests sent by this user agent
+		vias := m.GetHeaders("Via")
+		if len(vias) < 2 {
+			return false
+		}
+		if via, ok := vias[0].(*sip.ViaHeader); !ok || !p.isOwnAddr(via.Host, via.Port, via.Transport) {
+			return false
+		}
+		p.forwardStatelessResponse(m)
+		return true
+	}
+	return false
+}
+
+// ForwardStateless forwards request to first target without creating transactions.
+// Errors are responded statelessly and ACK is dropped.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-16.11
+func (p *Proxy) ForwardStateless(req *sip.Request) {
+	loopHash := proxyLoopHash(req)
+	if res := p.validate(req, loopHash); res != nil {
+		p.respondStateless(req, res)
+		return
+	}
+	p.preprocessRoute(req)
+
+	// ACK of non 2xx response has To tag, but it must reach same target as INVITE
+	target := req.Recipient
+	if p.locate != nil && (req.IsAck() || !proxyInDialog(req)) {
+		targets, err := p.locate(req)
+		if err != nil {
+			p.log.Error("Failed to locate targets", "err", err, "req", req.Short())
+			p.respondStateless(req, sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil))
+			return
+		}
+		if len(targets) == 0 {
+			p.respondStateless(req, sip.NewResponseFromRequest(req, sip.StatusTemporarilyUnavailable, "Temporarily Unavailable", nil))
+			return
+		}
+		target = targets[0]
+	}
+
+	fwd := p.forwardRequest(req, target, proxyStatelessBranch(req)+"."+loopHash)
+	if err := p.client.WriteRequest(fwd, proxyNoop); err != nil {
+		p.log.Debug("Failed to forward request", "err", err, "req", req.Short())
+		p.respondStateless(req, sip.NewResponseFromRequest(req, sip.StatusServiceUnavailable, "Service Unavailable", nil))
+	}
+}
+
+func (p *Proxy) respondStateless(req *sip.Request, res *sip.Response) {
+	if req.IsAck() {
+		p.log.Debug("Dropping ACK", "status", res.StatusCode, "req", req.Short())
+		return
+	}
+	if err := p.client.TransportLayer().WriteMsg(res); err != nil {
+		p.log.Error("Failed to respond", "err", err, "res", res.StartLine())
+	}
+}
+
+// forwardStatelessResponse removes our Via and sends response to address of next Via
+func (p *Proxy) forwardStatelessResponse(res *sip.Response) {
+	fwd := res.Clone()
+	fwd.SetBody(res.Body())
+	fwd.RemoveHeader("Via")
+	via := fwd.Via()
+	fwd.SetTransport(via.Transport)
+	fwd.SetSource("")
+	fwd.SetDestination("")
+	if err := p.client.TransportLayer().WriteMsg(fwd); err != nil {
+		p.log.Debug("Failed to forward response", "err", err, "res", res.StartLine())
+	}
+}
+
+// proxyStatelessBranch returns branch which is same for retransmissions of request, CANCEL and ACK
+// for non 2xx response. Branch of RFC 3261 request is hashed, otherwise fields identifying transaction
+// except To tag, which is added by response.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-16.11
+func proxyStatelessBranch(req *sip.Request) string {
+	h := fnv.New64a()
+	var branch string
+	if via := req.Via(); via != nil {
+		branch, _ = via.Params.Get("branch")
+	}
+	if strings.HasPrefix(branch, sip.RFC3261BranchMagicCookie) {
+		h.Write([]byte(branch))
+	} else {
+		if via := req.Via(); via != nil {
+			h.Write([]byte(via.Value()))
+		}
+		if from := req.From(); from != nil {
+			tag, _ := from.Params.Get("tag")
+			h.Write([]byte(tag))
+		}
+		if callID := req.CallID(); callID != nil {
+			h.Write([]byte(callID.Value()))
+		}
+		if cseq := req.CSeq(); cseq != nil {
+			h.Write([]byte(strconv.FormatUint(uint64(cseq.SeqNo), 10)))
+		}
+		h.Write([]byte(req.Recipient.String()))
+	}
+	return sip.RFC3261BranchMagicCookie + strconv.FormatUint(h.Sum64(), 36)
+}
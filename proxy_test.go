@@ -0,0 +1,344 @@
+package sipgo
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+func testProxy(t *testing.T, options ...ProxyOption) (*Proxy, sip.Uri) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	t.Cleanup(func() { ua.Close() })
+	srv, err := NewServer(ua)
+	require.NoError(t, err)
+	client, err := NewClient(ua, WithClientHostname("127.0.0.1"))
+	require.NoError(t, err)
+
+	p := NewProxy(srv, client, options...)
+	uri := testServerUDP(t, srv)
+	require.Eventually(t, func() bool {
+		return ua.TransportLayer().GetListenPort("udp") == uri.Port
+	}, time.Second, 10*time.Millisecond)
+	return p, uri
+}
+
+func testUAS(t *testing.T, handler RequestHandler) sip.Uri {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	t.Cleanup(func() { ua.Close() })
+	srv, err := NewServer(ua)
+	require.NoError(t, err)
+	srv.OnNoRoute(handler)
+	return testServerUDP(t, srv)
+}
+
+func TestProxy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := testClient(t)
+
+	received := make(chan *sip.Request, 1)
+	uas := testUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		received <- req
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+	})
+	_, proxyURI := testProxy(t, WithProxyLocator(func(req *sip.Request) ([]sip.Uri, error) {
+		return []sip.Uri{uas}, nil
+	}))
+
+	res, err := c.Do(ctx, sip.NewRequest(sip.OPTIONS, proxyURI))
+	require.NoError(t, err)
+	assert.Equal(t, sip.StatusOK, res.StatusCode)
+	assert.Len(t, res.GetHeaders("Via"), 1)
+
+	req := <-received
+	assert.Len(t, req.GetHeaders("Via"), 2)
+	assert.Equal(t, 69, int(req.MaxForwards().Val()))
+	assert.Equal(t, uas.String(), req.Recipient.String())
+
+	t.Run("Validation", func(t *testing.T) {
+		req := sip.NewRequest(sip.OPTIONS, proxyURI)
+		maxfwd := sip.MaxForwardsHeader(0)
+		req.AppendHeader(&maxfwd)
+		res, err := c.Do(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusTooManyHops, res.StatusCode)
+
+		req = sip.NewRequest(sip.OPTIONS, proxyURI)
+		req.AppendHeader(sip.NewHeader("Proxy-Require", "foo"))
+		res, err = c.Do(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusBadExtension, res.StatusCode)
+		assert.Equal(t, "foo", res.GetHeader("Unsupported").Value())
+	})
+
+	t.Run("Loop", func(t *testing.T) {
+		// Without locator request is forwarded to Request-URI, which is proxy itself
+		_, proxyURI := testProxy(t)
+		res, err := c.Do(ctx, sip.NewRequest(sip.OPTIONS, proxyURI))
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusLoopDetected, res.StatusCode)
+	})
+}
+
+func TestProxyForking(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := testClient(t)
+
+	busy := testUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBusyHere, "Busy Here", nil))
+	})
+	acks := make(chan *sip.Request, 1)
+	var answer sip.Uri
+	answer = testUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		if req.IsAck() {
+			acks <- req
+			return
+		}
+		assert.NotNil(t, req.RecordRoute())
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusRinging, "Ringing", nil))
+		res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+		res.AppendHeader(&sip.ContactHeader{Address: answer})
+		tx.Respond(res)
+	})
+
+	for _, serial := range []bool{false, true} {
+		options := []ProxyOption{
+			WithProxyRecordRoute(),
+			WithProxyLocator(func(req *sip.Request) ([]sip.Uri, error) {
+				return []sip.Uri{busy, answer}, nil
+			}),
+		}
+		if serial {
+			options = append(options, WithProxySerialForking())
+		}
+		_, proxyURI := testProxy(t, options...)
+
+		var provisional []*sip.Response
+		inv := sip.NewRequest(sip.INVITE, proxyURI)
+		res, err := c.DoWithProvisional(ctx, inv, func(res *sip.Response) {
+			provisional = append(provisional, res)
+		})
+		require.NoError(t, err)
+		require.Equal(t, sip.StatusOK, res.StatusCode)
+		require.NotEmpty(t, provisional)
+		assert.Equal(t, sip.StatusRinging, provisional[len(provisional)-1].StatusCode)
+		require.NotNil(t, res.RecordRoute())
+
+		// ACK is routed through proxy by Record-Route
+		require.NoError(t, c.WriteRequest(sip.NewAckRequest(inv, res, nil)))
+		select {
+		case ack := <-acks:
+			assert.Equal(t, answer.String(), ack.Recipient.String())
+			assert.Nil(t, ack.Route())
+		case <-time.After(2 * time.Second):
+			t.Fatal("ACK not forwarded")
+		}
+	}
+}
+
+func TestProxyBestResponse(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := testClient(t)
+
+	respond := func(code sip.StatusCode, reason string) sip.Uri {
+		return testUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+			res := sip.NewResponseFromRequest(req, code, reason, nil)
+			if code == sip.StatusProxyAuthRequired {
+				res.AppendHeader(sip.NewHeader("Proxy-Authenticate", `Digest realm="`+reason+`"`))
+			}
+			tx.Respond(res)
+		})
+	}
+	notFound := respond(sip.StatusNotFound, "Not Found")
+	decline := respond(sip.StatusGlobalDecline, "Decline")
+	unavailable := respond(sip.StatusServiceUnavailable, "Service Unavailable")
+	auth1 := respond(sip.StatusProxyAuthRequired, "a")
+	auth2 := respond(sip.StatusProxyAuthRequired, "b")
+
+	for _, tc := range []struct {
+		name    string
+		targets []sip.Uri
+		status  sip.StatusCode
+	}{
+		{name: "Global", targets: []sip.Uri{notFound, decline}, status: sip.StatusGlobalDecline},
+		{name: "LowestClass", targets: []sip.Uri{unavailable, notFound}, status: sip.StatusNotFound},
+		{name: "Unavailable", targets: []sip.Uri{unavailable}, status: sip.StatusInternalServerError},
+		{name: "Auth", targets: []sip.Uri{notFound, auth1, auth2}, status: sip.StatusProxyAuthRequired},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, proxyURI := testProxy(t, WithProxyLocator(func(req *sip.Request) ([]sip.Uri, error) {
+				return tc.targets, nil
+			}))
+			res, err := c.Do(ctx, sip.NewRequest(sip.INVITE, proxyURI))
+			require.NoError(t, err)
+			assert.Equal(t, tc.status, res.StatusCode)
+			if tc.status == sip.StatusProxyAuthRequired {
+				assert.Len(t, res.GetHeaders("Proxy-Authenticate"), 2)
+			}
+		})
+	}
+}
+
+func TestProxyCancel(t *testing.T) {
+	c := testClient(t)
+
+	cancels := make(chan struct{}, 2)
+	ringing := func(req *sip.Request, tx sip.ServerTransaction) {
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusRinging, "Ringing", nil))
+		select {
+		case cancel := <-tx.Cancels():
+			cancels <- struct{}{}
+			tx.Respond(sip.NewResponseFromRequest(cancel, sip.StatusOK, "OK", nil))
+			tx.Respond(sip.NewResponseFromRequest(req, sip.StatusRequestTerminated, "Request Terminated", nil))
+		case <-tx.Done():
+		}
+	}
+	uas1, uas2 := testUAS(t, ringing), testUAS(t, ringing)
+	_, proxyURI := testProxy(t, WithProxyLocator(func(req *sip.Request) ([]sip.Uri, error) {
+		return []sip.Uri{uas1, uas2}, nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rings := 0
+	res, err := c.DoWithProvisional(ctx, sip.NewRequest(sip.INVITE, proxyURI), func(res *sip.Response) {
+		if res.StatusCode == sip.StatusRinging {
+			rings++
+		}
+		if rings == 2 {
+			cancel()
+		}
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotNil(t, res)
+	assert.Equal(t, sip.StatusRequestTerminated, res.StatusCode)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-cancels:
+		case <-time.After(2 * time.Second):
+			t.Fatal("CANCEL not propagated")
+		}
+	}
+}
+
+func TestStatelessProxy(t *testing.T) {
+	c := testClient(t)
+
+	received := make(chan *sip.Request, 2)
+	cancels := make(chan struct{}, 1)
+	uas := testUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		received <- req
+		if !req.IsInvite() {
+			tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+			return
+		}
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusRinging, "Ringing", nil))
+		select {
+		case cancel := <-tx.Cancels():
+			cancels <- struct{}{}
+			tx.Respond(sip.NewResponseFromRequest(cancel, sip.StatusOK, "OK", nil))
+			tx.Respond(sip.NewResponseFromRequest(req, sip.StatusRequestTerminated, "Request Terminated", nil))
+		case <-tx.Done():
+		}
+	})
+	busyReqs := make(chan *sip.Request, 2)
+	busyUAS := testUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		busyReqs <- req
+		if !req.IsInvite() {
+			return
+		}
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBusyHere, "Busy Here", nil))
+		// Keep transaction to absorb ACK
+		select {
+		case <-tx.Done():
+		case <-time.After(time.Second):
+		}
+	})
+	_, proxyURI := testProxy(t, WithProxyStateless(), WithProxyLocator(func(req *sip.Request) ([]sip.Uri, error) {
+		if req.Recipient.User == "busy" {
+			return []sip.Uri{busyUAS}, nil
+		}
+		return []sip.Uri{uas}, nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := c.Do(ctx, sip.NewRequest(sip.OPTIONS, proxyURI))
+	require.NoError(t, err)
+	assert.Equal(t, sip.StatusOK, res.StatusCode)
+	assert.Len(t, res.GetHeaders("Via"), 1)
+	req := <-received
+	assert.Len(t, req.GetHeaders("Via"), 2)
+	assert.Equal(t, uas.String(), req.Recipient.String())
+
+	// CANCEL hashes to same branch as INVITE, so it matches transaction on UAS
+	res, err = c.DoWithProvisional(ctx, sip.NewRequest(sip.INVITE, proxyURI), func(res *sip.Response) {
+		cancel()
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotNil(t, res)
+	assert.Equal(t, sip.StatusRequestTerminated, res.StatusCode)
+	select {
+	case <-cancels:
+	case <-time.After(2 * time.Second):
+		t.Fatal("CANCEL not forwarded")
+	}
+
+	t.Run("Branch", func(t *testing.T) {
+		inv := sip.NewRequest(sip.INVITE, proxyURI)
+		require.NoError(t, clientRequestBuildReq(c, inv))
+		branch := proxyStatelessBranch(inv)
+		assert.True(t, strings.HasPrefix(branch, sip.RFC3261BranchMagicCookie))
+		assert.Equal(t, branch, proxyStatelessBranch(inv.Clone()))
+		assert.Equal(t, branch, proxyStatelessBranch(sip.NewCancelRequest(inv)))
+
+		// ACK of non 2xx response has To tag of response
+		res := sip.NewResponseFromRequest(inv, sip.StatusRequestTerminated, "Request Terminated", nil)
+		ack := sip.NewAckRequest(inv, res, nil)
+		require.True(t, ack.To().Params.Has("tag"))
+		assert.Equal(t, branch, proxyStatelessBranch(ack))
+		assert.Equal(t, proxyLoopHash(inv), proxyLoopHash(ack))
+
+		other := sip.NewRequest(sip.INVITE, proxyURI)
+		require.NoError(t, clientRequestBuildReq(c, other))
+		assert.NotEqual(t, branch, proxyStatelessBranch(other))
+	})
+
+	t.Run("AckNon2xx", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		busy := proxyURI
+		busy.User = "busy"
+		res, err := c.Do(ctx, sip.NewRequest(sip.INVITE, busy))
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusBusyHere, res.StatusCode)
+		assert.True(t, (<-busyReqs).IsInvite())
+
+		// ACK matches INVITE transaction on UAS, so it does not reach handler
+		select {
+		case req := <-busyReqs:
+			t.Fatalf("ACK not matched with INVITE transaction: %s", req.Short())
+		case <-time.After(300 * time.Millisecond):
+		}
+	})
+
+	t.Run("Own", func(t *testing.T) {
+		// Request to proxy itself is handled by server
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		res, err := c.Do(ctx, sip.NewRequest(sip.OPTIONS, sip.Uri{Host: proxyURI.Host, Port: proxyURI.Port}))
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusMethodNotAllowed, res.StatusCode)
+	})
+}
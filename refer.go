@@ -0,0 +1,369 @@
+package sipgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+// Refer is REFER sent within dialog. Recipient reports progress of referred request with NOTIFY
+// in implicit subscription, which must be passed to ReadNotify of dialog handle.
+// https://datatracker.ietf.org/doc/html/rfc3515
+type Refer struct {
+	// Request is sent REFER
+	Request *sip.Request
+	// Event identifies implicit subscription within dialog
+	Event sip.Event
+
+	mu     sync.Mutex
+	code   sip.StatusCode
+	reason string
+	done   chan struct{}
+}
+
+// Status returns last status of referred request reported by NOTIFY. It is 0 until first NOTIFY.
+func (r *Refer) Status() (sip.StatusCode, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.code, r.reason
+}
+
+// Done is closed when implicit subscription is terminated or dialog ends
+func (r *Refer) Done() <-chan struct{} {
+	return r.done
+}
+
+// Wait waits until implicit subscription is terminated and returns final status of referred request.
+// Status is 0 if subscription is terminated without final status.
+func (r *Refer) Wait(ctx context.Context) (sip.StatusCode, error) {
+	select {
+	case <-r.done:
+		code, _ := r.Status()
+		return code, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (r *Refer) terminate() {
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+}
+
+// Refer sends REFER within dialog asking remote party to send request to referTo, for example
+// for blind transfer. Referred-By with local address is added unless passed in headers.
+// On 2xx response Refer is returned, which follows progress of referred request.
+// Non 2xx final response is returned as ErrDialogResponse.
+// https://datatracker.ietf.org/doc/html/rfc3515#section-2.4.1
+func (s *dialogSession) Refer(ctx context.Context, referTo sip.Uri, headers ...sip.Header) (*Refer, error) {
+	req := sip.NewRequest(sip.REFER, sip.Uri{})
+	req.AppendHeader(sip.NewHeader("Refer-To", "<"+referTo.String()+">"))
+	for _, h := range headers {
+		req.AppendHeader(h)
+	}
+	if req.GetHeader("Referred-By") == nil && req.GetHeader("b") == nil {
+		s.mu.Lock()
+		local := s.localHDR.Address
+		s.mu.Unlock()
+		req.AppendHeader(sip.NewHeader("Referred-By", "<"+local.String()+">"))
+	}
+
+	if err := s.prepareRequest(req, 0); err != nil {
+		return nil, err
+	}
+	// NOTIFY can arrive before response, so subscription is known before sending
+	r := &Refer{Request: req, done: make(chan struct{})}
+	s.setRefer(r, req.CSeq().SeqNo)
+
+	res, err := s.send(ctx, req)
+	if err == nil && !res.IsSuccess() {
+		err = ErrDialogResponse{Res: res}
+	}
+	if err != nil {
+		s.deleteRefer(r)
+		return nil, err
+	}
+
+	// REFER could be resent with higher CSeq on digest challenge
+	if cseq := res.CSeq(); cseq != nil && strconv.FormatUint(uint64(cseq.SeqNo), 10) != r.Event.ID {
+		s.deleteRefer(r)
+		s.setRefer(r, cseq.SeqNo)
+	}
+	return r, nil
+}
+
+// ReferToReplaces returns URI for Refer-To of attended transfer, which asks transferee to replace
+// this dialog. It is remote target of dialog with Replaces header identifying dialog on remote side.
+// https://datatracker.ietf.org/doc/html/rfc5589#section-7
+func (s *dialogSession) ReferToReplaces() sip.Uri {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	localTag, _ := s.localHDR.Params.Get("tag")
+	remoteTag, _ := s.remoteHDR.Params.Get("tag")
+	replaces := sip.Replaces{CallID: s.callID.Value(), ToTag: remoteTag, FromTag: localTag}
+
+	uri := *s.remoteTarget.Clone()
+	uri.Headers = nil
+	sip.UriSetHeader(&uri, "Replaces", replaces.String())
+	return uri
+}
+
+func (s *dialogSession) setRefer(r *Refer, cseq uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.Event = sip.Event{Package: sip.EventRefer, ID: strconv.FormatUint(uint64(cseq), 10)}
+	if s.refers == nil {
+		s.refers = make(map[string]*Refer)
+	}
+	s.refers[r.Event.ID] = r
+}
+
+func (s *dialogSession) deleteRefer(r *Refer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refers[r.Event.ID] == r {
+		delete(s.refers, r.Event.ID)
+	}
+}
+
+// endRefers terminates all REFER subscriptions when dialog ends
+func (s *dialogSession) endRefers() {
+	s.mu.Lock()
+	refers := s.refers
+	s.refers = nil
+	s.mu.Unlock()
+	for _, r := range refers {
+		r.terminate()
+	}
+}
+
+// readReferNotify handles NOTIFY of implicit REFER subscription and responds to it.
+// First REFER subscription is matched also by NOTIFY without id.
+// https://datatracker.ietf.org/doc/html/rfc3515#section-2.4.4
+func (s *dialogSession) readReferNotify(req *sip.Request, tx sip.ServerTransaction) error {
+	event, ok, err := sip.MessageEvent(req)
+	if err == nil && (!ok || !strings.EqualFold(event.Package, sip.EventRefer)) {
+		err = fmt.Errorf("not refer event")
+	}
+	if err != nil {
+		res := sip.NewResponseFromRequest(req, sip.StatusBadEvent, "Bad Event", nil)
+		return errors.Join(err, tx.Respond(res))
+	}
+
+	ss, ok, err := sip.MessageSubscriptionState(req)
+	if err == nil && !ok {
+		err = fmt.Errorf("missing Subscription-State header")
+	}
+	if err != nil {
+		res := sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil)
+		return errors.Join(err, tx.Respond(res))
+	}
+
+	s.mu.Lock()
+	r := s.refers[event.ID]
+	if r == nil && event.ID == "" && len(s.refers) == 1 {
+		for _, first := range s.refers {
+			r = first
+		}
+	}
+	s.mu.Unlock()
+	if r == nil {
+		return errors.Join(ErrDialogDoesNotExists, tx.Respond(dialogErrorResponse(req, ErrDialogDoesNotExists)))
+	}
+
+	if len(req.Body()) > 0 {
+		code, reason, err := sip.ParseSipfrag(req.Body())
+		if err != nil {
+			res := sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil)
+			return errors.Join(err, tx.Respond(res))
+		}
+		r.mu.Lock()
+		r.code, r.reason = code, reason
+		r.mu.Unlock()
+	}
+
+	if ss.State == sip.SubscriptionStateTerminated {
+		s.deleteRefer(r)
+		r.terminate()
+	}
+	return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+}
+
+// readRefer accepts REFER with 202 and sends initial NOTIFY of implicit subscription
+// https://datatracker.ietf.org/doc/html/rfc3515#section-2.4.2
+func (s *dialogSession) readRefer(ctx context.Context, req *sip.Request, tx sip.ServerTransaction) (*ReferSubscription, error) {
+	referTo, ok, err := sip.MessageReferTo(req)
+	if err == nil && !ok {
+		err = fmt.Errorf("missing Refer-To header")
+	}
+	if err != nil {
+		res := sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil)
+		return nil, errors.Join(err, tx.Respond(res))
+	}
+	referredBy, _, err := sip.MessageReferredBy(req)
+	if err != nil {
+		res := sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil)
+		return nil, errors.Join(err, tx.Respond(res))
+	}
+
+	if err := tx.Respond(sip.NewResponseFromRequest(req, sip.StatusAccepted, "Accepted", nil)); err != nil {
+		return nil, err
+	}
+
+	sub := &ReferSubscription{
+		s:          s,
+		Event:      sip.Event{Package: sip.EventRefer, ID: strconv.FormatUint(uint64(req.CSeq().SeqNo), 10)},
+		Request:    req,
+		ReferTo:    referTo,
+		ReferredBy: referredBy,
+	}
+	return sub, sub.Notify(ctx, sip.StatusTrying, "Trying")
+}
+
+// ReferSubscription is implicit subscription created by received REFER. Progress of referred request
+// is reported to referrer with Notify, until final status is sent.
+// https://datatracker.ietf.org/doc/html/rfc3515#section-2.4.4
+type ReferSubscription struct {
+	s *dialogSession
+
+	// Event identifies subscription within dialog
+	Event sip.Event
+	// Request is received REFER
+	Request *sip.Request
+	// ReferTo is URI to which request must be sent. It can contain headers, like Replaces.
+	ReferTo sip.Uri
+	// ReferredBy is URI of Referred-By header. It is empty if header is not present.
+	ReferredBy sip.Uri
+
+	mu         sync.Mutex
+	terminated bool
+}
+
+// Notify sends NOTIFY with status of referred request as message/sipfrag body.
+// Final status terminates subscription.
+func (sub *ReferSubscription) Notify(ctx context.Context, code sip.StatusCode, reason string) error {
+	ss := sip.SubscriptionState{State: sip.SubscriptionStateActive}
+	if code >= 200 {
+		ss = sip.SubscriptionState{State: sip.SubscriptionStateTerminated, Reason: sip.SubscriptionReasonNoResource}
+	}
+
+	sub.mu.Lock()
+	if sub.terminated {
+		sub.mu.Unlock()
+		return ErrSubscriptionTerminated
+	}
+	sub.terminated = ss.State == sip.SubscriptionStateTerminated
+	sub.mu.Unlock()
+
+	req := sip.NewRequest(sip.NOTIFY, sip.Uri{})
+	req.AppendHeader(sip.NewHeader("Event", sub.Event.String()))
+	req.AppendHeader(sip.NewHeader("Subscription-State", ss.String()))
+	req.AppendHeader(sip.NewHeader("Content-Type", sip.ContentTypeSipfrag))
+	req.SetBody(sip.NewSipfrag(code, reason))
+
+	if err := sub.s.prepareRequest(req, 0); err != nil {
+		return err
+	}
+	// Failure of NOTIFY terminates only subscription and not INVITE dialog it shares
+	// https://datatracker.ietf.org/doc/html/rfc5057#section-5.1
+	res, err := sub.s.c.Do(ctx, req)
+	if err != nil {
+		sub.terminate()
+		return err
+	}
+	if res.StatusCode != sip.StatusCallTransactionDoesNotExists {
+		sub.s.readResponse(req, res)
+	}
+	if !res.IsSuccess() {
+		// Referrer is not interested in progress anymore
+		sub.terminate()
+		return ErrDialogResponse{Res: res}
+	}
+	return nil
+}
+
+func (sub *ReferSubscription) terminate() {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.terminated = true
+}
+
+// NewRequest creates request for Refer-To URI. Headers of URI, like Replaces, are added to request
+// and Referred-By is copied from REFER. Method of Refer-To is used, INVITE by default.
+// https://datatracker.ietf.org/doc/html/rfc3515#section-2.4.3
+func (sub *ReferSubscription) NewRequest() *sip.Request {
+	method := sip.INVITE
+	if m, ok := sub.ReferTo.UriParams.Get("method"); ok && m != "" {
+		method = sip.RequestMethod(strings.ToUpper(m))
+	}
+
+	recipient := *sub.ReferTo.Clone()
+	recipient.Headers = nil
+	if recipient.UriParams != nil {
+		recipient.UriParams.Remove("method")
+	}
+	req := sip.NewRequest(method, recipient)
+
+	if sub.ReferTo.Headers != nil {
+		for _, name := range sub.ReferTo.Headers.Keys() {
+			val, _ := sip.UriHeader(sub.ReferTo, name)
+			req.AppendHeader(sip.NewHeader(name, val))
+		}
+	}
+	if h := sub.Request.GetHeader("Referred-By"); h != nil {
+		req.AppendHeader(sip.HeaderClone(h))
+	} else if h := sub.Request.GetHeader("b"); h != nil {
+		req.AppendHeader(sip.NewHeader("Referred-By", h.Value()))
+	}
+	return req
+}
+
+// ReadRefer handles REFER received within dialog. REFER is accepted with 202 and initial NOTIFY
+// is sent. Returned subscription is used to report progress of referred request.
+// For unknown dialog 481 is responded and ErrDialogDoesNotExists returned.
+func (dc *DialogClient) ReadRefer(ctx context.Context, req *sip.Request, tx sip.ServerTransaction) (*ReferSubscription, error) {
+	s, err := dc.MatchRequest(req)
+	if err != nil {
+		return nil, errors.Join(err, tx.Respond(dialogErrorResponse(req, err)))
+	}
+	return s.readRefer(ctx, req, tx)
+}
+
+// ReadNotify handles NOTIFY of REFER sent within dialog and updates status of Refer.
+// For unknown dialog or subscription 481 is responded and ErrDialogDoesNotExists returned.
+func (dc *DialogClient) ReadNotify(req *sip.Request, tx sip.ServerTransaction) error {
+	s, err := dc.MatchRequest(req)
+	if err != nil {
+		return errors.Join(err, tx.Respond(dialogErrorResponse(req, err)))
+	}
+	return s.readReferNotify(req, tx)
+}
+
+// ReadRefer handles REFER received within dialog. REFER is accepted with 202 and initial NOTIFY
+// is sent. Returned subscription is used to report progress of referred request.
+// For unknown dialog 481 is responded and ErrDialogDoesNotExists returned.
+func (s *ServerDialog) ReadRefer(ctx context.Context, req *sip.Request, tx sip.ServerTransaction) (*ReferSubscription, error) {
+	sess, err := s.MatchDialogRequest(req)
+	if err != nil {
+		return nil, errors.Join(err, tx.Respond(dialogErrorResponse(req, err)))
+	}
+	return sess.readRefer(ctx, req, tx)
+}
+
+// ReadNotify handles NOTIFY of REFER sent within dialog and updates status of Refer.
+// For unknown dialog or subscription 481 is responded and ErrDialogDoesNotExists returned.
+func (s *ServerDialog) ReadNotify(req *sip.Request, tx sip.ServerTransaction) error {
+	sess, err := s.MatchDialogRequest(req)
+	if err != nil {
+		return errors.Join(err, tx.Respond(dialogErrorResponse(req, err)))
+	}
+	return sess.readReferNotify(req, tx)
+}
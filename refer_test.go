@@ -0,0 +1,123 @@
+package sipgo
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+func TestDialogRefer(t *testing.T) {
+	uasUA, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer uasUA.Close()
+
+	srv, err := NewServerDialog(uasUA)
+	require.NoError(t, err)
+
+	sessions := make(chan *DialogServerSession, 1)
+	srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+		res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+		res.AppendHeader(&sip.ContactHeader{Address: req.Recipient})
+		if !assert.NoError(t, tx.Respond(res)) {
+			return
+		}
+		id, _ := sip.MakeDialogIDFromResponse(res)
+		sess, ok := srv.Session(id)
+		assert.True(t, ok)
+		sessions <- sess
+	})
+	srv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {})
+	srv.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+	refers := make(chan *ReferSubscription, 1)
+	srv.OnRefer(func(req *sip.Request, tx sip.ServerTransaction) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		sub, err := srv.ReadRefer(ctx, req, tx)
+		if !assert.NoError(t, err) {
+			return
+		}
+		// Transferee reports progress of referred INVITE
+		assert.NoError(t, sub.Notify(ctx, sip.StatusRinging, "Ringing"))
+		assert.NoError(t, sub.Notify(ctx, sip.StatusOK, "OK"))
+		assert.ErrorIs(t, sub.Notify(ctx, sip.StatusOK, "OK"), ErrSubscriptionTerminated)
+		refers <- sub
+	})
+	uasURI := testServerUDP(t, &srv.Server)
+
+	uacUA, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer uacUA.Close()
+	uacSrv, err := NewServer(uacUA)
+	require.NoError(t, err)
+	c, err := NewClient(uacUA, WithClientHostname("127.0.0.1"))
+	require.NoError(t, err)
+	dc := NewDialogClient(c, sip.ContactHeader{})
+
+	notifies := make(chan *sip.Request, 10)
+	uacSrv.OnNotify(func(req *sip.Request, tx sip.ServerTransaction) {
+		if err := dc.ReadNotify(req, tx); err == nil {
+			notifies <- req
+		}
+	})
+	uacURI := testServerUDP(t, uacSrv)
+	uacURI.User = "alice"
+	dc.contactHDR = sip.ContactHeader{Address: uacURI}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	uacSess, err := dc.Invite(ctx, uasURI, nil)
+	require.NoError(t, err)
+	require.NoError(t, uacSess.Ack(ctx))
+	uasSess := <-sessions
+
+	carol := sip.Uri{User: "carol", Host: "127.0.0.1", Port: 5099}
+	refer, err := uacSess.Refer(ctx, carol)
+	require.NoError(t, err)
+	assert.Equal(t, "refer;id="+refer.Event.ID, refer.Event.String())
+	code, err := refer.Wait(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, sip.StatusOK, code)
+
+	for _, state := range []string{"active", "active", "terminated;reason=noresource"} {
+		req := <-notifies
+		assert.Equal(t, state, req.GetHeader("Subscription-State").Value())
+		assert.Equal(t, sip.ContentTypeSipfrag, req.ContentType().Value())
+	}
+
+	sub := <-refers
+	assert.Equal(t, carol.String(), sub.ReferTo.String())
+	assert.Equal(t, uacSess.InviteRequest.From().Address.User, sub.ReferredBy.User)
+
+	// Attended transfer asks to replace dialog with UAS
+	referTo := uacSess.ReferToReplaces()
+	sub.ReferTo = referTo
+	invite := sub.NewRequest()
+	assert.Equal(t, sip.INVITE, invite.Method)
+	assert.Nil(t, invite.Recipient.Headers)
+	replaces, ok, err := sip.MessageReplaces(invite)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, uasSess.InviteRequest.CallID().Value(), replaces.CallID)
+	assert.Equal(t, uasSess.InviteResponse.To().Params["tag"], replaces.ToTag)
+	assert.Equal(t, uasSess.InviteResponse.From().Params["tag"], replaces.FromTag)
+	assert.NotNil(t, invite.GetHeader("Referred-By"))
+
+	t.Run("UnknownSubscription", func(t *testing.T) {
+		sub := &ReferSubscription{s: uasSess.dialogSession, Event: sip.Event{Package: sip.EventRefer, ID: "999"}}
+		var resErr ErrDialogResponse
+		require.ErrorAs(t, sub.Notify(ctx, sip.StatusOK, "OK"), &resErr)
+		assert.Equal(t, sip.StatusCallTransactionDoesNotExists, resErr.Res.StatusCode)
+		// Dialog is not terminated by failed subscription
+		assert.NotEqual(t, sip.DialogStateEnded, uasSess.State())
+	})
+
+	require.NoError(t, uacSess.Bye(ctx))
+}
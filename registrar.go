@@ -0,0 +1,373 @@
+package sipgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+var (
+	// ErrRegistrarNotFound can be returned by address of record function when registrar
+	// is not responsible for domain or user. REGISTER is rejected with 404 Not Found.
+	ErrRegistrarNotFound = errors.New("address of record not found")
+
+	errRegistrarOrder = errors.New("REGISTER out of order")
+)
+
+// sipDateFormat is layout of SIP-date, which is always in GMT
+// https://datatracker.ietf.org/doc/html/rfc3261#section-25.1
+const sipDateFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+type RegistrarOption func(r *Registrar)
+
+// WithRegistrarDefaultExpires sets expiration of contacts for which REGISTER does not request it
+// Default: 3600s
+func WithRegistrarDefaultExpires(d time.Duration) RegistrarOption {
+	return func(r *Registrar) {
+		r.defaultExpires = d
+	}
+}
+
+// WithRegistrarMinExpires sets minimal expiration of contacts. Shorter expiration is rejected
+// with 423 Interval Too Brief.
+// Default: 60s
+func WithRegistrarMinExpires(d time.Duration) RegistrarOption {
+	return func(r *Registrar) {
+		r.minExpires = d
+	}
+}
+
+// WithRegistrarMaxExpires sets maximal expiration of contacts. Longer expiration is shortened.
+// Default: 0, no limit
+func WithRegistrarMaxExpires(d time.Duration) RegistrarOption {
+	return func(r *Registrar) {
+		r.maxExpires = d
+	}
+}
+
+// WithRegistrarAOR sets function returning address of record for To URI of REGISTER.
+// It can return ErrRegistrarNotFound for domains registrar is not responsible for.
+// Default: canonical URI sip:user@host
+func WithRegistrarAOR(f func(uri sip.Uri) (string, error)) RegistrarOption {
+	return func(r *Registrar) {
+		r.aor = f
+	}
+}
+
+// WithRegistrarClock sets clock used for expiration of bindings
+// Default: sip.SystemClock
+func WithRegistrarClock(c sip.Clock) RegistrarOption {
+	return func(r *Registrar) {
+		r.clock = c
+	}
+}
+
+// Registrar handles REGISTER requests and keeps bindings in location store.
+// Authentication is not done by registrar, it can be added with server middleware.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-10.3
+type Registrar struct {
+	store LocationStore
+	log   *slog.Logger
+	clock sip.Clock
+	aor   func(uri sip.Uri) (string, error)
+
+	defaultExpires time.Duration
+	minExpires     time.Duration
+	maxExpires     time.Duration
+}
+
+// NewRegistrar creates registrar with location store. Register can be used as server handler:
+//
+//	srv.OnRegister(registrar.Register)
+func NewRegistrar(store LocationStore, options ...RegistrarOption) *Registrar {
+	r := &Registrar{
+		store:          store,
+		log:            slog.With("caller", "Registrar"),
+		clock:          sip.SystemClock,
+		aor:            registrarAOR,
+		defaultExpires: 3600 * time.Second,
+		minExpires:     60 * time.Second,
+	}
+	for _, o := range options {
+		o(r)
+	}
+	return r
+}
+
+// registrarAOR returns canonical address of record, without parameters and port
+func registrarAOR(uri sip.Uri) (string, error) {
+	if uri.Host == "" {
+		return "", ErrRegistrarNotFound
+	}
+	scheme := strings.ToLower(uri.Scheme)
+	if scheme == "" {
+		scheme = "sip"
+	}
+	return scheme + ":" + uri.User + "@" + strings.ToLower(uri.Host), nil
+}
+
+// Register handles REGISTER request. Bindings are added, refreshed or removed and 200 OK
+// is responded with all current bindings.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-10.3
+func (r *Registrar) Register(req *sip.Request, tx sip.ServerTransaction) {
+	res := r.register(context.Background(), req)
+	if err := tx.Respond(res); err != nil {
+		r.log.Error("Failed to respond REGISTER", "err", err, "res", res.StartLine())
+	}
+}
+
+func (r *Registrar) register(ctx context.Context, req *sip.Request) *sip.Response {
+	to, callID, cseq := req.To(), req.CallID(), req.CSeq()
+	if to == nil || callID == nil || cseq == nil {
+		return sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil)
+	}
+
+	aor, err := r.aor(to.Address)
+	if err != nil {
+		if errors.Is(err, ErrRegistrarNotFound) {
+			return sip.NewResponseFromRequest(req, sip.StatusNotFound, "Not Found", nil)
+		}
+		r.log.Error("Failed to get address of record", "err", err, "to", to.Address.String())
+		return sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil)
+	}
+
+	update, res := r.parseContacts(req)
+	if res != nil {
+		return res
+	}
+
+	var bindings []Binding
+	if update == nil {
+		// Query of current bindings
+		bindings, err = r.store.Bindings(ctx, aor)
+	} else {
+		bindings, err = r.store.UpdateBindings(ctx, aor, update)
+	}
+	switch {
+	case errors.Is(err, errRegistrarOrder):
+		return sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Out Of Order", nil)
+	case err != nil:
+		r.log.Error("Failed to update bindings", "err", err, "aor", aor)
+		return sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil)
+	}
+	return r.response(req, bindings)
+}
+
+// parseContacts validates Contact headers of REGISTER and returns function updating bindings.
+// Nil function is returned if REGISTER has no Contact. In case of error response is returned.
+func (r *Registrar) parseContacts(req *sip.Request) (func(bindings []Binding) ([]Binding, error), *sip.Response) {
+	callID := req.CallID().Value()
+	cseq := req.CSeq().SeqNo
+
+	var contacts []*sip.ContactHeader
+	wildcard := false
+	for _, h := range req.GetHeaders("Contact") {
+		c, ok := h.(*sip.ContactHeader)
+		if !ok {
+			return nil, sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Invalid Contact", nil)
+		}
+		if c.Address.Wildcard {
+			wildcard = true
+		}
+		contacts = append(contacts, c)
+	}
+	if len(contacts) == 0 {
+		return nil, nil
+	}
+
+	headerExpires, hasExpires, err := registrarExpiresHeader(req)
+	if err != nil {
+		return nil, sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Invalid Expires", nil)
+	}
+
+	// Wildcard removes all bindings and it is allowed only with Expires: 0
+	// https://datatracker.ietf.org/doc/html/rfc3261#section-10.3 step 6
+	if wildcard {
+		if len(contacts) > 1 || !hasExpires || headerExpires != 0 {
+			return nil, sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Invalid Wildcard Contact", nil)
+		}
+		return func(bindings []Binding) ([]Binding, error) {
+			for _, b := range bindings {
+				if b.CallID == callID && b.CSeq >= cseq {
+					return nil, errRegistrarOrder
+				}
+			}
+			return nil, nil
+		}, nil
+	}
+
+	now := r.clock.Now()
+	type contactUpdate struct {
+		key     string
+		binding Binding
+		remove  bool
+	}
+	updates := make([]contactUpdate, 0, len(contacts))
+	var path []string
+	for _, h := range req.GetHeaders("Path") {
+		path = append(path, h.Value())
+	}
+	for _, c := range contacts {
+		expires := r.defaultExpires
+		if hasExpires {
+			expires = headerExpires
+		}
+		if val, ok := c.Params.Get("expires"); ok {
+			sec, err := strconv.ParseUint(strings.TrimSpace(val), 10, 32)
+			if err != nil {
+				return nil, sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Invalid Contact Expires", nil)
+			}
+			expires = time.Duration(sec) * time.Second
+		}
+
+		if expires > 0 && expires < r.minExpires {
+			res := sip.NewResponseFromRequest(req, sip.StatusIntervalToBrief, "Interval Too Brief", nil)
+			res.AppendHeader(sip.NewHeader("Min-Expires", strconv.Itoa(int(r.minExpires/time.Second))))
+			return nil, res
+		}
+		if r.maxExpires > 0 && expires > r.maxExpires {
+			expires = r.maxExpires
+		}
+
+		q := 1.0
+		if val, ok := c.Params.Get("q"); ok {
+			q, err = strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err != nil || q < 0 || q > 1 {
+				return nil, sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Invalid Contact q", nil)
+			}
+		}
+
+		contact := sip.ContactHeader{
+			DisplayName: c.DisplayName,
+			Address:     *c.Address.Clone(),
+			Params:      c.Params.Clone(),
+		}
+		contact.Params.Remove("expires")
+		updates = append(updates, contactUpdate{
+			key: bindingKey(&contact),
+			binding: Binding{
+				Contact: contact,
+				Expires: now.Add(expires),
+				Q:       q,
+				CallID:  callID,
+				CSeq:    cseq,
+				Path:    path,
+			},
+			remove: expires == 0,
+		})
+	}
+
+	return func(bindings []Binding) ([]Binding, error) {
+		for _, u := range updates {
+			i := slices.IndexFunc(bindings, func(b Binding) bool { return b.Key() == u.key })
+			if i >= 0 && bindings[i].CallID == callID && bindings[i].CSeq >= cseq {
+				return nil, errRegistrarOrder
+			}
+		}
+
+		for _, u := range updates {
+			i := slices.IndexFunc(bindings, func(b Binding) bool { return b.Key() == u.key })
+			if i < 0 {
+				if !u.remove {
+					bindings = append(bindings, u.binding)
+				}
+				continue
+			}
+			if u.remove {
+				bindings = slices.Delete(bindings, i, i+1)
+				continue
+			}
+			bindings[i] = u.binding
+		}
+		return bindings, nil
+	}, nil
+}
+
+// registrarExpiresHeader returns Expires header of request. Returned bool is false if header is not present
+func registrarExpiresHeader(req *sip.Request) (time.Duration, bool, error) {
+	h := req.GetHeader("Expires")
+	if h == nil {
+		return 0, false, nil
+	}
+	sec, err := strconv.ParseUint(strings.TrimSpace(h.Value()), 10, 32)
+	if err != nil {
+		return 0, true, fmt.Errorf("invalid Expires %q: %w", h.Value(), err)
+	}
+	return time.Duration(sec) * time.Second, true, nil
+}
+
+// response creates 200 OK with all current bindings
+// https://datatracker.ietf.org/doc/html/rfc3261#section-10.3 step 8
+func (r *Registrar) response(req *sip.Request, bindings []Binding) *sip.Response {
+	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	now := r.clock.Now()
+	outbound := false
+	for _, b := range bindings {
+		contact := b.Contact.Clone()
+		if contact.Params == nil {
+			contact.Params = sip.NewParams()
+		}
+		expires := b.Expires.Sub(now).Round(time.Second)
+		contact.Params.Add("expires", strconv.Itoa(int(expires/time.Second)))
+		res.AppendHeader(contact)
+
+		if _, ok, _ := sip.ContactRegID(&b.Contact); ok {
+			outbound = true
+		}
+	}
+
+	// https://datatracker.ietf.org/doc/html/rfc3327#section-5.3
+	sip.CopyHeaders("Path", req, res)
+	// https://datatracker.ietf.org/doc/html/rfc5626#section-6
+	if outbound && sip.HasOptionTag(req, "Supported", sip.OptionTagOutbound) {
+		res.AppendHeader(sip.NewHeader("Require", sip.OptionTagOutbound))
+	}
+	res.AppendHeader(sip.NewHeader("Date", now.UTC().Format(sipDateFormat)))
+	return res
+}
+
+// Lookup returns bindings of address of record of URI, ordered by q-value from highest
+func (r *Registrar) Lookup(ctx context.Context, uri sip.Uri) ([]Binding, error) {
+	aor, err := r.aor(uri)
+	if err != nil {
+		return nil, err
+	}
+	bindings, err := r.store.Bindings(ctx, aor)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(bindings, func(a, b Binding) int {
+		switch {
+		case a.Q > b.Q:
+			return -1
+		case a.Q < b.Q:
+			return 1
+		}
+		return 0
+	})
+	return bindings, nil
+}
+
+// Locate returns registered contacts of Request-URI. It can be used as ProxyLocator.
+// Unknown address of record has empty target set.
+func (r *Registrar) Locate(req *sip.Request) ([]sip.Uri, error) {
+	bindings, err := r.Lookup(context.Background(), req.Recipient)
+	if errors.Is(err, ErrRegistrarNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]sip.Uri, 0, len(bindings))
+	for _, b := range bindings {
+		targets = append(targets, *b.Contact.Address.Clone())
+	}
+	return targets, nil
+}
@@ -0,0 +1,149 @@
+package sipgo
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sipgo/fakes"
+	"github.com/livekit/sipgo/sip"
+)
+
+func testRegister(callID string, cseq uint32, contacts ...*sip.ContactHeader) *sip.Request {
+	aor := sip.Uri{User: "alice", Host: "example.com"}
+	req := sip.NewRequest(sip.REGISTER, sip.Uri{Host: "example.com"})
+	req.AppendHeader(&sip.ToHeader{Address: aor, Params: sip.NewParams()})
+	req.AppendHeader(&sip.FromHeader{Address: aor, Params: sip.HeaderParams{"tag": "1234"}})
+	id := sip.CallIDHeader(callID)
+	req.AppendHeader(&id)
+	req.AppendHeader(&sip.CSeqHeader{SeqNo: cseq, MethodName: sip.REGISTER})
+	for _, c := range contacts {
+		req.AppendHeader(c)
+	}
+	return req
+}
+
+func testContact(host string, params sip.HeaderParams) *sip.ContactHeader {
+	return &sip.ContactHeader{Address: sip.Uri{User: "alice", Host: host}, Params: params}
+}
+
+func TestRegistrar(t *testing.T) {
+	ctx := context.Background()
+	clock := fakes.NewClock(time.Now())
+	store := NewMemoryLocationStore(WithMemoryLocationStoreClock(clock))
+	r := NewRegistrar(store, WithRegistrarClock(clock), WithRegistrarMaxExpires(time.Hour))
+	alice := sip.Uri{User: "alice", Host: "example.com"}
+
+	res := r.register(ctx, testRegister("call1", 1,
+		testContact("10.0.0.1", sip.HeaderParams{"q": "0.5"}),
+		testContact("10.0.0.2", sip.HeaderParams{"expires": "120"}),
+	))
+	require.Equal(t, sip.StatusOK, res.StatusCode)
+	contacts := res.GetHeaders("Contact")
+	require.Len(t, contacts, 2)
+	expires, _ := contacts[0].(*sip.ContactHeader).Params.Get("expires")
+	assert.Equal(t, "3600", expires)
+	expires, _ = contacts[1].(*sip.ContactHeader).Params.Get("expires")
+	assert.Equal(t, "120", expires)
+	date := res.GetHeader("Date").Value()
+	assert.Regexp(t, `^[A-Z][a-z]{2}, \d{2} [A-Z][a-z]{2} \d{4} \d{2}:\d{2}:\d{2} GMT$`, date)
+	parsed, err := time.Parse(time.RFC1123, date)
+	require.NoError(t, err)
+	assert.Equal(t, clock.Now().Unix(), parsed.Unix())
+
+	// Contacts are ordered by q-value
+	targets, err := r.Locate(sip.NewRequest(sip.INVITE, alice))
+	require.NoError(t, err)
+	require.Len(t, targets, 2)
+	assert.Equal(t, "10.0.0.2", targets[0].Host)
+	assert.Equal(t, "10.0.0.1", targets[1].Host)
+
+	t.Run("Query", func(t *testing.T) {
+		res := r.register(ctx, testRegister("call2", 1))
+		require.Equal(t, sip.StatusOK, res.StatusCode)
+		assert.Len(t, res.GetHeaders("Contact"), 2)
+	})
+
+	t.Run("OutOfOrder", func(t *testing.T) {
+		res := r.register(ctx, testRegister("call1", 1, testContact("10.0.0.1", nil)))
+		assert.Equal(t, sip.StatusInternalServerError, res.StatusCode)
+		// Other Call-ID can update binding with any CSeq
+		res = r.register(ctx, testRegister("call2", 1, testContact("10.0.0.1", sip.HeaderParams{"expires": "7200"})))
+		require.Equal(t, sip.StatusOK, res.StatusCode)
+		for _, h := range res.GetHeaders("Contact") {
+			c := h.(*sip.ContactHeader)
+			if c.Address.Host == "10.0.0.1" {
+				expires, _ := c.Params.Get("expires")
+				assert.Equal(t, "3600", expires)
+			}
+		}
+	})
+
+	t.Run("IntervalTooBrief", func(t *testing.T) {
+		res := r.register(ctx, testRegister("call3", 1, testContact("10.0.0.3", sip.HeaderParams{"expires": "10"})))
+		assert.Equal(t, sip.StatusIntervalToBrief, res.StatusCode)
+		assert.Equal(t, "60", res.GetHeader("Min-Expires").Value())
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		res := r.register(ctx, testRegister("call1", 2, testContact("10.0.0.2", sip.HeaderParams{"expires": "0"})))
+		require.Equal(t, sip.StatusOK, res.StatusCode)
+		assert.Len(t, res.GetHeaders("Contact"), 1)
+	})
+
+	t.Run("Wildcard", func(t *testing.T) {
+		wildcard := &sip.ContactHeader{Address: sip.Uri{Wildcard: true}}
+		res := r.register(ctx, testRegister("call4", 1, wildcard))
+		assert.Equal(t, sip.StatusBadRequest, res.StatusCode)
+
+		req := testRegister("call4", 1, wildcard)
+		expires := sip.ExpiresHeader(0)
+		req.AppendHeader(&expires)
+		res = r.register(ctx, req)
+		require.Equal(t, sip.StatusOK, res.StatusCode)
+		assert.Empty(t, res.GetHeaders("Contact"))
+		assert.Equal(t, 0, store.Len())
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		res := r.register(ctx, testRegister("call5", 1, testContact("10.0.0.1", sip.HeaderParams{"expires": "60"})))
+		require.Equal(t, sip.StatusOK, res.StatusCode)
+		assert.Equal(t, 1, store.Len())
+		clock.Advance(time.Minute)
+		assert.Equal(t, 0, store.Len())
+		targets, err := r.Locate(sip.NewRequest(sip.INVITE, alice))
+		require.NoError(t, err)
+		assert.Empty(t, targets)
+	})
+}
+
+func TestRegistrarServer(t *testing.T) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer ua.Close()
+	srv, err := NewServer(ua)
+	require.NoError(t, err)
+	r := NewRegistrar(NewMemoryLocationStore())
+	srv.OnRegister(r.Register)
+	uri := testServerUDP(t, srv)
+
+	c := testClient(t)
+	req := sip.NewRequest(sip.REGISTER, uri)
+	req.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: "alice", Host: "127.0.0.1", Port: 5090}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := c.Do(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, sip.StatusOK, res.StatusCode)
+	require.NotNil(t, res.Contact())
+	assert.Equal(t, 5090, res.Contact().Address.Port)
+
+	bindings, err := r.Lookup(ctx, uri)
+	require.NoError(t, err)
+	require.Len(t, bindings, 1)
+	assert.Equal(t, req.CallID().Value(), bindings[0].CallID)
+}
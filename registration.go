@@ -0,0 +1,403 @@
+package sipgo
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livekit/sipgo/sip"
+	"github.com/livekit/sipgo/transport"
+)
+
+// RegistrationState is state of client registration
+type RegistrationState int
+
+const (
+	// RegistrationStateUnregistered is state before first REGISTER and after Close
+	RegistrationStateUnregistered RegistrationState = iota
+	// RegistrationStateRegistering is state while REGISTER is sent
+	RegistrationStateRegistering
+	// RegistrationStateRegistered is state after 2xx response, until binding expires
+	RegistrationStateRegistered
+	// RegistrationStateFailed is state after REGISTER failed, until it is retried
+	RegistrationStateFailed
+)
+
+func (s RegistrationState) String() string {
+	switch s {
+	case RegistrationStateUnregistered:
+		return "Unregistered"
+	case RegistrationStateRegistering:
+		return "Registering"
+	case RegistrationStateRegistered:
+		return "Registered"
+	case RegistrationStateFailed:
+		return "Failed"
+	}
+	return "Unknown"
+}
+
+// ErrRegistrationResponse is returned when REGISTER is answered with non 2xx response
+type ErrRegistrationResponse struct {
+	Res *sip.Response
+}
+
+func (e ErrRegistrationResponse) Error() string {
+	return "registration failed: " + e.Res.StartLine()
+}
+
+type RegistrationOption func(r *Registration)
+
+// WithRegistrationRegistrar sets Request-URI of REGISTER
+// Default: domain of address of record
+func WithRegistrationRegistrar(uri sip.Uri) RegistrationOption {
+	return func(r *Registration) {
+		r.registrar = uri
+	}
+}
+
+// WithRegistrationContact sets registered contact
+// Default: user of address of record at client host and port
+func WithRegistrationContact(c sip.ContactHeader) RegistrationOption {
+	return func(r *Registration) {
+		r.contact = c
+	}
+}
+
+// WithRegistrationExpires sets requested expiration of binding. Registrar can grant shorter one.
+// Default: 3600s
+func WithRegistrationExpires(d time.Duration) RegistrationOption {
+	return func(r *Registration) {
+		r.expires = d
+	}
+}
+
+// WithRegistrationDigestAuth sets credentials for answering challenges of registrar.
+// Default: credentials of WithClientDigestAuth
+func WithRegistrationDigestAuth(auth DigestAuth) RegistrationOption {
+	return func(r *Registration) {
+		r.auth = newDigestAuthorizer(auth)
+	}
+}
+
+// WithRegistrationRetry sets base and max time of exponential backoff after failure.
+// 503 response with Retry-After is retried after that time instead.
+// Default: 30s and 1800s as recommended by RFC 5626 section 4.5
+func WithRegistrationRetry(base time.Duration, max time.Duration) RegistrationOption {
+	return func(r *Registration) {
+		r.retryBase = base
+		r.retryMax = max
+	}
+}
+
+// WithRegistrationOnState sets function called on every state change. Error is set for failed state.
+// It must not block, as it is called within refresh of registration.
+func WithRegistrationOnState(f func(state RegistrationState, err error)) RegistrationOption {
+	return func(r *Registration) {
+		r.onState = f
+	}
+}
+
+// WithRegistrationClock sets clock used for refresh and retry timers
+// Default: sip.SystemClock
+func WithRegistrationClock(c sip.Clock) RegistrationOption {
+	return func(r *Registration) {
+		r.clock = c
+	}
+}
+
+// Registration keeps binding of contact to address of record on registrar. Binding is refreshed
+// before it expires and REGISTER is retried with backoff after failure.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-10.2
+type Registration struct {
+	c   *Client
+	log *slog.Logger
+
+	aor       sip.Uri
+	registrar sip.Uri
+	contact   sip.ContactHeader
+	expires   time.Duration
+	auth      *digestAuthorizer
+	retryBase time.Duration
+	retryMax  time.Duration
+	onState   func(state RegistrationState, err error)
+	clock     sip.Clock
+
+	callID  sip.CallIDHeader
+	fromTag string
+	// ctx is canceled on Close to stop REGISTER in progress
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// sendMu serializes sending of REGISTER, so CSeq is always increasing
+	sendMu sync.Mutex
+	cseq   uint32
+
+	mu       sync.Mutex
+	state    RegistrationState
+	bound    time.Time
+	failures int
+	timer    sip.Timer
+	closed   bool
+}
+
+// Register starts registration of address of record. First REGISTER is sent in background,
+// use WithRegistrationOnState to follow result. Close unregisters and stops refreshing.
+func (c *Client) Register(aor sip.Uri, options ...RegistrationOption) *Registration {
+	r := &Registration{
+		c:         c,
+		log:       c.log.With("aor", aor.String()),
+		aor:       *aor.Clone(),
+		registrar: sip.Uri{Scheme: aor.Scheme, Host: aor.Host, Port: aor.Port, UriParams: aor.UriParams},
+		expires:   3600 * time.Second,
+		auth:      c.auth,
+		retryBase: 30 * time.Second,
+		retryMax:  1800 * time.Second,
+		clock:     sip.SystemClock,
+		callID:    sip.CallIDHeader(sip.GenerateTagN(32)),
+		fromTag:   sip.GenerateTagN(16),
+	}
+	for _, o := range options {
+		o(r)
+	}
+	if r.contact.Address.Host == "" {
+		port := c.port
+		if port == 0 {
+			port = c.tp.GetListenPort(transport.NetworkToLower(uriTransport(r.registrar)))
+		}
+		r.contact = sip.ContactHeader{Address: sip.Uri{User: aor.User, Host: c.host, Port: port}}
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	go r.refresh()
+	return r
+}
+
+// State returns current state of registration
+func (r *Registration) State() RegistrationState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// Expires returns time when binding granted by registrar expires. It is zero if not registered.
+func (r *Registration) Expires() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.bound
+}
+
+// Close stops refreshing and removes binding from registrar if it is registered.
+func (r *Registration) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.mu.Unlock()
+
+	// Stop REGISTER in progress and wait for it
+	r.cancel()
+	r.sendMu.Lock()
+	defer r.sendMu.Unlock()
+
+	r.mu.Lock()
+	registered := !r.bound.IsZero()
+	r.mu.Unlock()
+
+	var err error
+	if registered {
+		_, err = r.send(ctx, 0)
+	}
+	r.setState(RegistrationStateUnregistered, time.Time{}, nil)
+	return err
+}
+
+// refresh sends REGISTER and schedules next refresh or retry
+func (r *Registration) refresh() {
+	r.sendMu.Lock()
+	defer r.sendMu.Unlock()
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Unlock()
+
+	// Refresh of active binding keeps registered state
+	if r.State() != RegistrationStateRegistered {
+		r.setState(RegistrationStateRegistering, r.Expires(), nil)
+	}
+	granted, err := r.send(r.ctx, r.expires)
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	var delay time.Duration
+	if err == nil && granted <= 0 {
+		err = errors.New("registration failed: registrar granted no expiration")
+	}
+	if err == nil {
+		r.failures = 0
+		// Jitter spreads refreshes of many registrations
+		delay = granted/2 + time.Duration(rand.Int63n(int64(granted/4)+1))
+	} else {
+		r.failures++
+		delay = r.retryDelay(err)
+	}
+	r.timer = r.clock.AfterFunc(delay, r.refresh)
+	r.mu.Unlock()
+
+	if err != nil {
+		r.log.Debug("Registration failed", "err", err, "retry", delay)
+		bound := r.Expires()
+		if !bound.IsZero() && !bound.After(r.clock.Now()) {
+			bound = time.Time{}
+		}
+		r.setState(RegistrationStateFailed, bound, err)
+		return
+	}
+	r.setState(RegistrationStateRegistered, r.clock.Now().Add(granted), nil)
+}
+
+// retryDelay returns backoff after failure. Must be called with lock held.
+// https://datatracker.ietf.org/doc/html/rfc5626#section-4.5
+func (r *Registration) retryDelay(err error) time.Duration {
+	var resErr ErrRegistrationResponse
+	if errors.As(err, &resErr) && resErr.Res.StatusCode == sip.StatusServiceUnavailable {
+		// Zero Retry-After would resend immediately, so backoff is used instead
+		if d, ok := responseRetryAfter(resErr.Res); ok && d > 0 {
+			return d
+		}
+	}
+
+	delay := r.retryMax
+	if n := r.failures - 1; n < 32 {
+		if d := r.retryBase << n; d > 0 && d < delay {
+			delay = d
+		}
+	}
+	// Random wait between 50% and 100% of backoff
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (r *Registration) setState(state RegistrationState, bound time.Time, err error) {
+	r.mu.Lock()
+	changed := r.state != state
+	r.state = state
+	r.bound = bound
+	r.mu.Unlock()
+
+	if changed && r.onState != nil {
+		r.onState(state, err)
+	}
+}
+
+// send sends REGISTER with expires and returns expiration granted by registrar.
+// Must be called with sendMu held.
+func (r *Registration) send(ctx context.Context, expires time.Duration) (time.Duration, error) {
+	for {
+		res, err := r.do(ctx, expires)
+		if err != nil {
+			return 0, err
+		}
+		if res.IsSuccess() {
+			return r.granted(res, expires), nil
+		}
+
+		// Registrar requires longer expiration
+		// https://datatracker.ietf.org/doc/html/rfc3261#section-10.2.8
+		if res.StatusCode == sip.StatusIntervalToBrief && expires > 0 {
+			if h := res.GetHeader("Min-Expires"); h != nil {
+				sec, err := strconv.ParseUint(strings.TrimSpace(h.Value()), 10, 32)
+				if min := time.Duration(sec) * time.Second; err == nil && min > expires {
+					r.log.Debug("Registration interval too brief, increasing expires", "expires", min)
+					expires = min
+					r.expires = min
+					continue
+				}
+			}
+		}
+		return 0, ErrRegistrationResponse{Res: res}
+	}
+}
+
+// do sends single REGISTER, answering digest challenges
+func (r *Registration) do(ctx context.Context, expires time.Duration) (*sip.Response, error) {
+	r.cseq++
+	req := sip.NewRequest(sip.REGISTER, *r.registrar.Clone())
+	req.AppendHeader(&sip.ToHeader{Address: *r.aor.Clone(), Params: sip.NewParams()})
+	req.AppendHeader(&sip.FromHeader{Address: *r.aor.Clone(), Params: sip.HeaderParams{"tag": r.fromTag}})
+	callID := r.callID
+	req.AppendHeader(&callID)
+	req.AppendHeader(&sip.CSeqHeader{SeqNo: r.cseq, MethodName: sip.REGISTER})
+	req.AppendHeader(r.contact.Clone())
+	exp := sip.ExpiresHeader(expires / time.Second)
+	req.AppendHeader(&exp)
+
+	res, err := r.c.do(ctx, req, nil)
+	if err != nil || r.auth == nil || !isDigestChallenge(res) {
+		return res, err
+	}
+
+	tx, last, res, err := r.c.doDigestAuthTx(ctx, r.auth, req, res, nil)
+	if tx != nil {
+		tx.Terminate()
+	}
+	if cseq := last.CSeq(); cseq != nil && cseq.SeqNo > r.cseq {
+		r.cseq = cseq.SeqNo
+	}
+	return res, err
+}
+
+// granted returns expiration of our contact in 2xx response, or Expires header if contact has no expires
+// https://datatracker.ietf.org/doc/html/rfc3261#section-10.2.4
+func (r *Registration) granted(res *sip.Response, expires time.Duration) time.Duration {
+	key := bindingKey(&r.contact)
+	for _, h := range res.GetHeaders("Contact") {
+		c, ok := h.(*sip.ContactHeader)
+		if !ok || bindingKey(c) != key {
+			continue
+		}
+		if val, ok := c.Params.Get("expires"); ok {
+			if sec, err := strconv.ParseUint(strings.TrimSpace(val), 10, 32); err == nil {
+				return time.Duration(sec) * time.Second
+			}
+		}
+	}
+	if h := res.GetHeader("Expires"); h != nil {
+		if sec, err := strconv.ParseUint(strings.TrimSpace(h.Value()), 10, 32); err == nil {
+			return time.Duration(sec) * time.Second
+		}
+	}
+	return expires
+}
+
+// responseRetryAfter returns Retry-After of response, ignoring comment and parameters
+func responseRetryAfter(res *sip.Response) (time.Duration, bool) {
+	h := res.GetHeader("Retry-After")
+	if h == nil {
+		return 0, false
+	}
+	val := strings.TrimSpace(h.Value())
+	if i := strings.IndexAny(val, " ;("); i >= 0 {
+		val = val[:i]
+	}
+	sec, err := strconv.ParseUint(val, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(sec) * time.Second, true
+}
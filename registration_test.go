@@ -0,0 +1,127 @@
+package sipgo
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sipgo/fakes"
+	"github.com/livekit/sipgo/sip"
+)
+
+func TestRegistration(t *testing.T) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer ua.Close()
+	srv, err := NewServer(ua)
+	require.NoError(t, err)
+	r := NewRegistrar(NewMemoryLocationStore())
+	auth := NewDigestAuthenticator("sipgo.test", StaticCredentialStore{"bob": "secret"})
+	srv.OnRegister(auth.Middleware(r.Register))
+	uri := testServerUDP(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	clock := fakes.NewClock(time.Now())
+	states := make(chan RegistrationState, 10)
+	c := testClient(t)
+	reg := c.Register(uri,
+		WithRegistrationRegistrar(uri),
+		WithRegistrationExpires(120*time.Second),
+		WithRegistrationDigestAuth(DigestAuth{Username: "bob", Password: "secret"}),
+		WithRegistrationClock(clock),
+		WithRegistrationOnState(func(state RegistrationState, err error) {
+			assert.NoError(t, err)
+			states <- state
+		}),
+	)
+	assert.Equal(t, RegistrationStateRegistering, <-states)
+	require.Equal(t, RegistrationStateRegistered, <-states)
+	assert.Equal(t, clock.Now().Add(120*time.Second), reg.Expires())
+
+	bindings, err := r.Lookup(ctx, uri)
+	require.NoError(t, err)
+	require.Len(t, bindings, 1)
+	cseq := bindings[0].CSeq
+
+	// Refresh is sent at most at 75% of expiration
+	clock.Advance(90 * time.Second)
+	bindings, err = r.Lookup(ctx, uri)
+	require.NoError(t, err)
+	require.Len(t, bindings, 1)
+	assert.Greater(t, bindings[0].CSeq, cseq)
+	assert.Equal(t, RegistrationStateRegistered, reg.State())
+
+	require.NoError(t, reg.Close(ctx))
+	assert.Equal(t, RegistrationStateUnregistered, <-states)
+	bindings, err = r.Lookup(ctx, uri)
+	require.NoError(t, err)
+	assert.Empty(t, bindings)
+}
+
+func TestRegistrationRetryAfter(t *testing.T) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer ua.Close()
+	srv, err := NewServer(ua)
+	require.NoError(t, err)
+	var count atomic.Int32
+	srv.OnRegister(func(req *sip.Request, tx sip.ServerTransaction) {
+		if count.Add(1) == 1 {
+			res := sip.NewResponseFromRequest(req, sip.StatusServiceUnavailable, "Service Unavailable", nil)
+			res.AppendHeader(sip.NewHeader("Retry-After", "5 (maintenance)"))
+			tx.Respond(res)
+			return
+		}
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+	})
+	uri := testServerUDP(t, srv)
+
+	clock := fakes.NewClock(time.Now())
+	states := make(chan RegistrationState, 10)
+	c := testClient(t)
+	reg := c.Register(uri,
+		WithRegistrationRegistrar(uri),
+		WithRegistrationClock(clock),
+		WithRegistrationOnState(func(state RegistrationState, err error) {
+			if state == RegistrationStateFailed {
+				var resErr ErrRegistrationResponse
+				assert.ErrorAs(t, err, &resErr)
+			}
+			states <- state
+		}),
+	)
+	assert.Equal(t, RegistrationStateRegistering, <-states)
+	require.Equal(t, RegistrationStateFailed, <-states)
+	assert.True(t, reg.Expires().IsZero())
+
+	clock.Advance(4 * time.Second)
+	assert.Equal(t, int32(1), count.Load())
+	clock.Advance(time.Second)
+	assert.Equal(t, RegistrationStateRegistering, <-states)
+	assert.Equal(t, RegistrationStateRegistered, <-states)
+	// Expires header is missing, so requested expiration is granted
+	assert.Equal(t, clock.Now().Add(3600*time.Second), reg.Expires())
+	assert.Equal(t, int32(2), count.Load())
+}
+
+func TestRegistrationRetryDelay(t *testing.T) {
+	r := &Registration{retryBase: 30 * time.Second, retryMax: 1800 * time.Second, failures: 1}
+	unavailable := func(retryAfter string) error {
+		res := sip.NewResponse(sip.StatusServiceUnavailable, "Service Unavailable")
+		res.AppendHeader(sip.NewHeader("Retry-After", retryAfter))
+		return ErrRegistrationResponse{Res: res}
+	}
+
+	assert.Equal(t, 5*time.Second, r.retryDelay(unavailable("5")))
+
+	// Zero Retry-After falls back to backoff
+	d := r.retryDelay(unavailable("0"))
+	assert.GreaterOrEqual(t, d, 15*time.Second)
+	assert.LessOrEqual(t, d, 30*time.Second)
+}
@@ -0,0 +1,185 @@
+package sipgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+// ReadReplaces matches INVITE with Replaces header against existing dialog, for attended transfer
+// or call pickup. Dialogs of ServerDialog are searched first and then dialogs of passed DialogClients.
+// Returned session is *DialogServerSession or *DialogClientSession.
+//
+// Request that can not replace dialog is rejected as required by RFC 3891 and error is returned:
+// 481 when dialog does not exist or it is early dialog not initiated by us, 603 when dialog is terminated
+// and 486 when early-only dialog is already confirmed. On success handler continues with INVITE.
+//
+// Replaced dialog is terminated once dialog created by INVITE is confirmed with ACK:
+// confirmed dialog with BYE and early dialog of our INVITE by canceling INVITE.
+// https://datatracker.ietf.org/doc/html/rfc3891#section-3
+func (s *ServerDialog) ReadReplaces(req *sip.Request, tx sip.ServerTransaction, clients ...*DialogClient) (DialogSession, error) {
+	sess, err := s.matchReplaces(req, clients)
+	if err != nil {
+		return nil, errors.Join(err, tx.Respond(takeoverErrorResponse(req, err)))
+	}
+	s.replaced.Store(inviteKey(req), sess)
+	return sess, nil
+}
+
+// ReadJoin matches INVITE with Join header against existing dialog, which new dialog joins,
+// for example into conference. Dialogs are searched same way as in ReadReplaces.
+// Request that can not join dialog is rejected as required by RFC 3911 and error is returned.
+// Joined dialog is not terminated.
+// https://datatracker.ietf.org/doc/html/rfc3911#section-3
+func (s *ServerDialog) ReadJoin(req *sip.Request, tx sip.ServerTransaction, clients ...*DialogClient) (DialogSession, error) {
+	sess, err := s.matchJoin(req, clients)
+	if err != nil {
+		return nil, errors.Join(err, tx.Respond(takeoverErrorResponse(req, err)))
+	}
+	return sess, nil
+}
+
+// takeoverErrorResponse returns response for INVITE which can not replace or join dialog
+// https://datatracker.ietf.org/doc/html/rfc3891#section-3
+func takeoverErrorResponse(req *sip.Request, err error) *sip.Response {
+	switch {
+	case errors.Is(err, ErrDialogTerminated):
+		return sip.NewResponseFromRequest(req, sip.StatusGlobalDecline, "Decline", nil)
+	case errors.Is(err, ErrDialogConfirmed):
+		return sip.NewResponseFromRequest(req, sip.StatusBusyHere, "Busy Here", nil)
+	}
+	return dialogErrorResponse(req, err)
+}
+
+func (s *ServerDialog) matchReplaces(req *sip.Request, clients []*DialogClient) (DialogSession, error) {
+	if err := validateTakeover(req); err != nil {
+		return nil, err
+	}
+	replaces, ok, err := sip.MessageReplaces(req)
+	if err == nil && !ok {
+		err = fmt.Errorf("missing Replaces header")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := s.matchDialog(replaces.CallID, replaces.ToTag, replaces.FromTag, clients)
+	if err != nil {
+		return nil, err
+	}
+	if replaces.EarlyOnly && sess.State() != sip.DialogStateEarly {
+		return nil, ErrDialogConfirmed
+	}
+	return sess, nil
+}
+
+func (s *ServerDialog) matchJoin(req *sip.Request, clients []*DialogClient) (DialogSession, error) {
+	if err := validateTakeover(req); err != nil {
+		return nil, err
+	}
+	join, ok, err := sip.MessageJoin(req)
+	if err == nil && !ok {
+		err = fmt.Errorf("missing Join header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.matchDialog(join.CallID, join.ToTag, join.FromTag, clients)
+}
+
+// validateTakeover checks that Replaces or Join is used only in initial INVITE and not together
+func validateTakeover(req *sip.Request) error {
+	if !req.IsInvite() {
+		return fmt.Errorf("%s can not replace or join dialog", req.Method)
+	}
+	if req.GetHeader("Replaces") != nil && req.GetHeader("Join") != nil {
+		// https://datatracker.ietf.org/doc/html/rfc3911#section-4
+		return fmt.Errorf("both Replaces and Join headers")
+	}
+	return nil
+}
+
+// matchDialog returns INVITE dialog by Call-ID and tags seen from our side. Early dialog can be matched only
+// if we are UAC of it, as dialog can not be taken over before we answered it.
+func (s *ServerDialog) matchDialog(callID string, localTag string, remoteTag string, clients []*DialogClient) (DialogSession, error) {
+	var sess DialogSession
+	// Dialog ID is built from UAS tag first
+	if uas, ok := s.Session(sip.MakeDialogID(callID, localTag, remoteTag)); ok {
+		if uas.State() == sip.DialogStateEarly {
+			return nil, ErrDialogDoesNotExists
+		}
+		sess = uas
+	}
+	for _, dc := range clients {
+		if sess != nil {
+			break
+		}
+		if uac, ok := dc.Session(sip.MakeDialogID(callID, remoteTag, localTag)); ok {
+			sess = uac
+		}
+	}
+	if sess == nil {
+		return nil, ErrDialogDoesNotExists
+	}
+	if sess.State() == sip.DialogStateEnded {
+		return nil, ErrDialogTerminated
+	}
+	return sess, nil
+}
+
+// confirmReplaces terminates dialog replaced by INVITE of confirmed dialog
+func (s *ServerDialog) confirmReplaces(sess *DialogServerSession) {
+	val, ok := s.replaced.LoadAndDelete(inviteKey(sess.InviteRequest))
+	if !ok {
+		return
+	}
+	replaced := val.(DialogSession)
+	go func() {
+		if err := terminateReplaced(context.Background(), replaced); err != nil {
+			s.log.Info("Failed to terminate replaced dialog", "err", err, "id", replaced.ID())
+		}
+	}()
+}
+
+// terminateReplaced ends replaced dialog. Dialog with 2xx is terminated with BYE
+// and early dialog of our INVITE by canceling INVITE.
+// https://datatracker.ietf.org/doc/html/rfc3891#section-3
+func terminateReplaced(ctx context.Context, sess DialogSession) error {
+	switch sess.State() {
+	case sip.DialogStateConfirmed:
+		return sess.Bye(ctx)
+	case sip.DialogStateEnded:
+		return nil
+	case sip.DialogStateEstablished:
+		switch sess := sess.(type) {
+		case *DialogClientSession:
+			// 2xx must be acknowledged before BYE
+			if err := sess.Ack(ctx); err != nil {
+				return err
+			}
+			return sess.Bye(ctx)
+		case *DialogServerSession:
+			// ACK for our 2xx is not received yet, but dialog is replaced already
+			return sess.bye(ctx)
+		}
+	}
+	if uac, ok := sess.(*DialogClientSession); ok && uac.cancelInvite != nil {
+		uac.cancelInvite()
+		return nil
+	}
+	return sess.Close()
+}
+
+// inviteKey identifies initial INVITE by Call-ID and From tag
+func inviteKey(req *sip.Request) string {
+	var callID, fromTag string
+	if h := req.CallID(); h != nil {
+		callID = h.Value()
+	}
+	if h := req.From(); h != nil {
+		fromTag, _ = h.Params.Get("tag")
+	}
+	return callID + ";" + fromTag
+}
@@ -0,0 +1,250 @@
+package sipgo
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+func TestDialogReplaces(t *testing.T) {
+	bobUA, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer bobUA.Close()
+	srv, err := NewServerDialog(bobUA)
+	require.NoError(t, err)
+
+	// Bob has outgoing call ringing at Dave, which can be picked up
+	daveUA, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer daveUA.Close()
+	daveSrv, err := NewServer(daveUA)
+	require.NoError(t, err)
+	cancels := make(chan *sip.Request, 1)
+	daveSrv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+		res := sip.NewResponseFromRequest(req, 180, "Ringing", nil)
+		res.To().Params.Add("tag", "dave")
+		res.AppendHeader(&sip.ContactHeader{Address: req.Recipient})
+		assert.NoError(t, tx.Respond(res))
+		select {
+		case cancel := <-tx.Cancels():
+			cancels <- cancel
+			res := sip.NewResponseFromRequest(req, sip.StatusRequestTerminated, "Request Terminated", nil)
+			res.To().Params.Add("tag", "dave")
+			tx.Respond(res)
+		case <-tx.Done():
+		}
+	})
+	daveURI := testServerUDP(t, daveSrv)
+	early := make(chan *DialogClientSession, 1)
+	bobDC := NewDialogClient(testClient(t), sip.ContactHeader{Address: sip.Uri{User: "bob", Host: "127.0.0.1", Port: 5090}},
+		WithDialogClientEarlyHandler(func(s *DialogClientSession) {
+			early <- s
+		}),
+	)
+
+	matched := make(chan DialogSession, 1)
+	srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+		var sess DialogSession
+		var err error
+		switch {
+		case req.GetHeader("Replaces") != nil:
+			sess, err = srv.ReadReplaces(req, tx, bobDC)
+		case req.GetHeader("Join") != nil:
+			sess, err = srv.ReadJoin(req, tx, bobDC)
+		}
+		if err != nil {
+			return
+		}
+		res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+		res.AppendHeader(&sip.ContactHeader{Address: req.Recipient})
+		if assert.NoError(t, tx.Respond(res)) && sess != nil {
+			matched <- sess
+		}
+	})
+	srv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {})
+	srv.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+	bobURI := testServerUDP(t, &srv.Server)
+
+	aliceUA, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer aliceUA.Close()
+	aliceSrv, err := NewServer(aliceUA)
+	require.NoError(t, err)
+	aliceClient, err := NewClient(aliceUA, WithClientHostname("127.0.0.1"))
+	require.NoError(t, err)
+	alice := NewDialogClient(aliceClient, sip.ContactHeader{})
+	aliceSrv.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+		assert.NoError(t, alice.ReadBye(req, tx))
+	})
+	aliceURI := testServerUDP(t, aliceSrv)
+	aliceURI.User = "alice"
+	alice.contactHDR = sip.ContactHeader{Address: aliceURI}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	aliceSess, err := alice.Invite(ctx, bobURI, nil)
+	require.NoError(t, err)
+	require.NoError(t, aliceSess.Ack(ctx))
+	bobSess, ok := srv.Session(aliceSess.ID())
+	require.True(t, ok)
+	require.Eventually(t, func() bool {
+		return bobSess.State() == sip.DialogStateConfirmed
+	}, time.Second, 10*time.Millisecond)
+
+	carol := NewDialogClient(testClient(t), sip.ContactHeader{Address: sip.Uri{User: "carol", Host: "127.0.0.1", Port: 5091}})
+	// Replaces as Alice would pass it in Refer-To for attended transfer
+	val, ok := sip.UriHeader(aliceSess.ReferToReplaces(), "Replaces")
+	require.True(t, ok)
+	replaces, err := sip.ParseReplaces(val)
+	require.NoError(t, err)
+
+	inviteCode := func(header sip.Header) sip.StatusCode {
+		t.Helper()
+		sess, err := carol.Invite(ctx, bobURI, nil, header)
+		if err != nil {
+			var resErr ErrDialogResponse
+			require.ErrorAs(t, err, &resErr)
+			return resErr.Res.StatusCode
+		}
+		require.NoError(t, sess.Ack(ctx))
+		return sess.InviteResponse.StatusCode
+	}
+
+	t.Run("Rejected", func(t *testing.T) {
+		earlyOnly := replaces
+		earlyOnly.EarlyOnly = true
+		assert.Equal(t, sip.StatusBusyHere, inviteCode(sip.NewHeader("Replaces", earlyOnly.String())))
+
+		unknown := replaces
+		unknown.ToTag = "unknown"
+		assert.Equal(t, sip.StatusCallTransactionDoesNotExists, inviteCode(sip.NewHeader("Replaces", unknown.String())))
+		assert.Equal(t, sip.StatusBadRequest, inviteCode(sip.NewHeader("Replaces", "invalid")))
+	})
+
+	t.Run("Join", func(t *testing.T) {
+		join := sip.Join{CallID: replaces.CallID, ToTag: replaces.ToTag, FromTag: replaces.FromTag}
+		assert.Equal(t, sip.StatusOK, inviteCode(sip.NewHeader("Join", join.String())))
+		assert.Equal(t, bobSess, <-matched)
+		// Joined dialog is kept
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, sip.DialogStateConfirmed, bobSess.State())
+	})
+
+	assert.Equal(t, sip.StatusOK, inviteCode(sip.NewHeader("Replaces", replaces.String())))
+	assert.Equal(t, bobSess, <-matched)
+	// Replaced dialog is terminated with BYE after ACK
+	select {
+	case <-aliceSess.Context().Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("replaced dialog not terminated")
+	}
+	assert.Eventually(t, func() bool {
+		return bobSess.State() == sip.DialogStateEnded
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, sip.StatusCallTransactionDoesNotExists, inviteCode(sip.NewHeader("Replaces", replaces.String())))
+
+	t.Run("EstablishedUAS", func(t *testing.T) {
+		// Bob answered, but ACK was not received yet
+		aliceSess, err := alice.Invite(ctx, bobURI, nil)
+		require.NoError(t, err)
+		bobSess, ok := srv.Session(aliceSess.ID())
+		require.True(t, ok)
+		assert.Equal(t, sip.DialogStateEstablished, bobSess.State())
+
+		val, _ := sip.UriHeader(aliceSess.ReferToReplaces(), "Replaces")
+		assert.Equal(t, sip.StatusOK, inviteCode(sip.NewHeader("Replaces", val)))
+		assert.Equal(t, bobSess, <-matched)
+
+		select {
+		case <-aliceSess.Context().Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("replaced dialog not terminated")
+		}
+		assert.Eventually(t, func() bool {
+			return bobSess.State() == sip.DialogStateEnded
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("EstablishedUAC", func(t *testing.T) {
+		erinUA, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+		require.NoError(t, err)
+		defer erinUA.Close()
+		erinSrv, err := NewServer(erinUA)
+		require.NoError(t, err)
+		reqs := make(chan *sip.Request, 2)
+		erinSrv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+			res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+			res.To().Params.Add("tag", "erin")
+			res.AppendHeader(&sip.ContactHeader{Address: req.Recipient})
+			tx.Respond(res)
+		})
+		erinSrv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {
+			reqs <- req
+		})
+		erinSrv.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+			reqs <- req
+			tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+		})
+		erinURI := testServerUDP(t, erinSrv)
+
+		// Bob received 2xx, but did not send ACK yet
+		erinSess, err := bobDC.Invite(ctx, erinURI, nil)
+		require.NoError(t, err)
+		pickup := sip.Replaces{
+			CallID:  erinSess.InviteRequest.CallID().Value(),
+			ToTag:   erinSess.InviteRequest.From().Params["tag"],
+			FromTag: "erin",
+		}
+		assert.Equal(t, sip.StatusOK, inviteCode(sip.NewHeader("Replaces", pickup.String())))
+		assert.Equal(t, erinSess, <-matched)
+
+		// 2xx is acknowledged and dialog is terminated with BYE.
+		// Requests are handled concurrently, so their order is not checked
+		var methods []sip.RequestMethod
+		for i := 0; i < 2; i++ {
+			select {
+			case req := <-reqs:
+				methods = append(methods, req.Method)
+			case <-time.After(2 * time.Second):
+				t.Fatal("ACK and BYE not received")
+			}
+		}
+		assert.ElementsMatch(t, []sip.RequestMethod{sip.ACK, sip.BYE}, methods)
+		assert.Eventually(t, func() bool {
+			return erinSess.State() == sip.DialogStateEnded
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Pickup", func(t *testing.T) {
+		inviteErr := make(chan error, 1)
+		go func() {
+			_, err := bobDC.Invite(ctx, daveURI, nil)
+			inviteErr <- err
+		}()
+		ringing := <-early
+		pickup := sip.Replaces{
+			CallID:  ringing.InviteRequest.CallID().Value(),
+			ToTag:   ringing.InviteRequest.From().Params["tag"],
+			FromTag: "dave",
+		}
+		assert.Equal(t, sip.StatusOK, inviteCode(sip.NewHeader("Replaces", pickup.String())))
+		assert.Equal(t, ringing, <-matched)
+
+		// Early dialog of our INVITE is replaced by canceling INVITE
+		select {
+		case <-cancels:
+		case <-time.After(2 * time.Second):
+			t.Fatal("CANCEL not received")
+		}
+		require.Error(t, <-inviteErr)
+		<-ringing.Context().Done()
+	})
+}
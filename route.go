@@ -0,0 +1,126 @@
+package sipgo
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/livekit/sipgo/sip"
+	"github.com/livekit/sipgo/transport"
+)
+
+// requestRoute applies route set of request before sending. Request with loose router in first Route
+// is sent to it by transport layer. If first Route is strict router, request is rewritten as described
+// in RFC 3261 section 12.2.1.1 and it is sent to Request-URI.
+// Request with destination already set is not changed.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-8.1.2
+func requestRoute(req *sip.Request) error {
+	if req.MessageData.Destination() != "" {
+		return nil
+	}
+	route := req.Route()
+	if route == nil || isLooseRouter(route.Address) {
+		return nil
+	}
+
+	routes, err := dialogRouteSet(req.GetHeaders("Route"), false)
+	if err != nil {
+		return err
+	}
+	dialogRequestRoute(req, req.Recipient, routes)
+	return nil
+}
+
+// isLooseRouter checks does URI of route contain lr parameter
+func isLooseRouter(uri sip.Uri) bool {
+	return uri.UriParams != nil && uri.UriParams.Has("lr")
+}
+
+// requestStrictDestination sets destination and transport of request to Request-URI,
+// because transport layer sends request to first Route by default
+func requestStrictDestination(req *sip.Request) {
+	uri := req.Recipient
+	tp := uriTransport(uri)
+	if tp == "" {
+		tp = req.MessageData.Transport()
+	}
+	if tp == "" {
+		tp = transport.TransportUDP
+		if via := req.Via(); via != nil && via.Transport != "" {
+			tp = strings.ToUpper(via.Transport)
+		}
+	}
+	port := uri.Port
+	if port == 0 {
+		port = sip.DefaultPort(tp)
+	}
+	req.SetTransport(tp)
+	req.SetDestination(uri.Host + ":" + strconv.Itoa(port))
+}
+
+// uriTransport returns transport forced by URI transport parameter or sips scheme.
+// Empty string is returned if URI does not force transport.
+func uriTransport(uri sip.Uri) string {
+	if tp, ok := uri.UriParams.Get("transport"); ok && tp != "" {
+		tp = strings.ToUpper(tp)
+		if uri.IsEncrypted() && tp == transport.TransportTCP {
+			return transport.TransportTLS
+		}
+		return tp
+	}
+	if uri.IsEncrypted() {
+		return transport.TransportTLS
+	}
+	return ""
+}
+
+// preprocessRoute removes top Route pointing to this user agent. If previous hop is strict router,
+// Request-URI is our Record-Route, recognized by lr parameter, and it is replaced with last Route.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-16.4
+func (ua *UserAgent) preprocessRoute(req *sip.Request, hostname string) {
+	if isLooseRouter(req.Recipient) && ua.isOwnURI(req.Recipient, hostname) {
+		routes := req.GetHeaders("Route")
+		if len(routes) > 0 {
+			var uri sip.Uri
+			if _, err := sip.ParseAddressValue(routes[len(routes)-1].Value(), &uri, sip.NewParams()); err == nil {
+				req.Recipient = uri
+				for req.RemoveHeader("Route") {
+				}
+				for _, h := range routes[:len(routes)-1] {
+					req.AppendHeader(h)
+				}
+			}
+		}
+	}
+
+	if route := req.Route(); route != nil && ua.isOwnURI(route.Address, hostname) {
+		req.RemoveHeader("Route")
+	}
+}
+
+// isOwnURI checks does URI point to this user agent
+func (ua *UserAgent) isOwnURI(uri sip.Uri, hostname string) bool {
+	network := "udp"
+	if tp, ok := uri.UriParams.Get("transport"); ok && tp != "" {
+		network = tp
+	}
+	if uri.IsEncrypted() {
+		network = "tls"
+	}
+	return ua.isOwnAddr(uri.Host, uri.Port, network, hostname)
+}
+
+// isOwnAddr checks is host and port one of our listen addresses. Host can be IP of user agent or hostname.
+func (ua *UserAgent) isOwnAddr(host string, port int, network string, hostname string) bool {
+	if host != hostname && host != ua.GetIP().String() {
+		return false
+	}
+	if port == 0 {
+		port = sip.DefaultPort(network)
+	}
+	for _, nw := range []string{"udp", "tcp", "tls", "ws", "wss"} {
+		if ua.tp.GetListenPort(nw) == port {
+			return true
+		}
+	}
+	return false
+}
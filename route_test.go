@@ -0,0 +1,110 @@
+package sipgo
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+func TestRequestRoute(t *testing.T) {
+	target := sip.Uri{User: "bob", Host: "10.0.0.1"}
+	strict := sip.Uri{Host: "10.0.0.2", Port: 5070, UriParams: sip.HeaderParams{"transport": "tcp"}}
+	loose := sip.Uri{Host: "10.0.0.3", UriParams: sip.HeaderParams{"lr": ""}}
+
+	t.Run("Loose", func(t *testing.T) {
+		req := sip.NewRequest(sip.INVITE, target)
+		req.AppendHeader(&sip.RouteHeader{Address: loose})
+		req.AppendHeader(&sip.RouteHeader{Address: strict})
+		require.NoError(t, requestRoute(req))
+		assert.Equal(t, target.String(), req.Recipient.String())
+		assert.Len(t, req.GetHeaders("Route"), 2)
+		assert.Equal(t, "10.0.0.3:5060", req.Destination())
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		req := sip.NewRequest(sip.INVITE, target)
+		req.AppendHeader(&sip.RouteHeader{Address: strict})
+		req.AppendHeader(&sip.RouteHeader{Address: loose})
+		require.NoError(t, requestRoute(req))
+		assert.Equal(t, strict.String(), req.Recipient.String())
+
+		routes := req.GetHeaders("Route")
+		require.Len(t, routes, 2)
+		assert.Equal(t, "<"+loose.String()+">", routes[0].Value())
+		assert.Equal(t, "<"+target.String()+">", routes[1].Value())
+		assert.Equal(t, "10.0.0.2:5070", req.Destination())
+		assert.Equal(t, "TCP", req.Transport())
+
+		// Already routed request is not changed
+		require.NoError(t, requestRoute(req))
+		assert.Equal(t, strict.String(), req.Recipient.String())
+		assert.Len(t, req.GetHeaders("Route"), 2)
+	})
+}
+
+func TestClientRouteSet(t *testing.T) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer ua.Close()
+	srv, err := NewServer(ua)
+	require.NoError(t, err)
+
+	reqs := make(chan *sip.Request, 1)
+	srv.OnOptions(func(req *sip.Request, tx sip.ServerTransaction) {
+		reqs <- req
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+	})
+	uri := testServerUDP(t, srv)
+	require.Eventually(t, func() bool {
+		return ua.TransportLayer().GetListenPort("udp") == uri.Port
+	}, time.Second, 10*time.Millisecond)
+
+	target := sip.Uri{User: "alice", Host: "example.invalid"}
+	proxy := sip.Uri{Host: uri.Host, Port: uri.Port}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("Loose", func(t *testing.T) {
+		loose := proxy
+		loose.UriParams = sip.HeaderParams{"lr": ""}
+		c := testClient(t, WithClientRouteSet(loose))
+		res, err := c.Do(ctx, sip.NewRequest(sip.OPTIONS, target))
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusOK, res.StatusCode)
+
+		// Own Route is removed by server
+		req := <-reqs
+		assert.Equal(t, target.String(), req.Recipient.String())
+		assert.Nil(t, req.Route())
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		c := testClient(t, WithClientRouteSet(proxy))
+		res, err := c.Do(ctx, sip.NewRequest(sip.OPTIONS, target))
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusOK, res.StatusCode)
+
+		req := <-reqs
+		assert.Equal(t, proxy.String(), req.Recipient.String())
+		require.NotNil(t, req.Route())
+		assert.Equal(t, target.String(), req.Route().Address.String())
+		// To is built from target, not from strict router
+		assert.Equal(t, "alice", req.To().Address.User)
+	})
+
+	t.Run("InDialog", func(t *testing.T) {
+		c := testClient(t, WithClientRouteSet(sip.Uri{Host: "10.0.0.1", UriParams: sip.HeaderParams{"lr": ""}}))
+		req := sip.NewRequest(sip.OPTIONS, uri)
+		req.AppendHeader(&sip.ToHeader{Address: uri, Params: sip.HeaderParams{"tag": "1234"}})
+		res, err := c.Do(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusOK, res.StatusCode)
+		assert.Nil(t, (<-reqs).Route())
+	})
+}
@@ -17,6 +17,10 @@ import (
 // RequestHandler is a callback that will be called on the incoming request
 type RequestHandler func(req *sip.Request, tx sip.ServerTransaction)
 
+// RequestMiddleware wraps request handler. Unlike ServeRequest middlewares, it can reject
+// request by responding on transaction and not calling next handler.
+type RequestMiddleware func(next RequestHandler) RequestHandler
+
 // Server is a SIP server
 type Server struct {
 	*UserAgent
@@ -26,9 +30,12 @@ type Server struct {
 	noRouteHandler  RequestHandler
 
 	log *slog.Logger
+	// hostname is used for recognizing own Route besides IP of user agent
+	hostname string
 
 	requestMiddlewares  []func(r *sip.Request)
 	responseMiddlewares []func(r *sip.Response)
+	handlerMiddlewares  []RequestMiddleware
 }
 
 type ServerOption func(s *Server) error
@@ -41,6 +48,16 @@ func WithServerLogger(logger *slog.Logger) ServerOption {
 	}
 }
 
+// WithServerHostname sets hostname of server, for example used in Record-Route by proxy.
+// Top Route with hostname or IP of user agent is removed from received requests.
+// Default: IP of user agent only
+func WithServerHostname(hostname string) ServerOption {
+	return func(s *Server) error {
+		s.hostname = hostname
+		return nil
+	}
+}
+
 // NewServer creates new instance of SIP server handle.
 // Allows creating server transaction handlers
 // It uses User Agent transport and transaction layer
@@ -227,11 +244,19 @@ func (srv *Server) onRequest(req *sip.Request, tx sip.ServerTransaction) {
 
 // handleRequest must be run in seperate goroutine
 func (srv *Server) handleRequest(req *sip.Request, tx sip.ServerTransaction) {
+	// Route with user part, like flow token of OutboundEdge, is left to handler
+	if route := req.Route(); route == nil || route.Address.User == "" {
+		srv.preprocessRoute(req, srv.hostname)
+	}
+
 	for _, mid := range srv.requestMiddlewares {
 		mid(req)
 	}
 
 	handler := srv.getHandler(req.Method)
+	for i := len(srv.handlerMiddlewares) - 1; i >= 0; i-- {
+		handler = srv.handlerMiddlewares[i](handler)
+	}
 	handler(req, tx)
 	if tx != nil {
 		// Must be called to prevent any transaction leaks
@@ -239,7 +264,7 @@ func (srv *Server) handleRequest(req *sip.Request, tx sip.ServerTransaction) {
 	}
 }
 
-// WriteResponse will proxy message to transport layer. Use it in stateless mode
+// WriteResponse will proxy message to transport layer. Use it in stateless mode, see WithProxyStateless
 func (srv *Server) WriteResponse(r *sip.Response) error {
 	return srv.tp.WriteMsg(r)
 }
@@ -249,6 +274,11 @@ func (srv *Server) Close() error {
 	return nil
 }
 
+// Shutdown gracefully stops server by shutting down its UserAgent. Check UserAgent.Shutdown
+func (srv *Server) Shutdown(ctx context.Context) error {
+	return srv.UserAgent.Shutdown(ctx)
+}
+
 // OnRequest registers new request callback. Can be used as generic way to add handler
 func (srv *Server) OnRequest(method sip.RequestMethod, handler RequestHandler) {
 	srv.requestHandlers[method] = handler
@@ -344,6 +374,9 @@ func (srv *Server) RegisteredMethods() []string {
 func (srv *Server) getHandler(method sip.RequestMethod) (handler RequestHandler) {
 	handler, ok := srv.requestHandlers[method]
 	if !ok {
+		if method == sip.PRACK {
+			return srv.defaultPrackHandler
+		}
 		return srv.noRouteHandler
 	}
 	return handler
@@ -358,11 +391,26 @@ func (srv *Server) defaultUnhandledHandler(req *sip.Request, tx sip.ServerTransa
 	}
 }
 
+// defaultPrackHandler accepts PRACK when no handler is registered. PRACK not matching reliable provisional
+// response sent by transaction layer is rejected before reaching handler.
+func (srv *Server) defaultPrackHandler(req *sip.Request, tx sip.ServerTransaction) {
+	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	if err := tx.Respond(res); err != nil {
+		srv.log.Error("respond '200 OK' for PRACK failed", "err", err)
+	}
+}
+
 // ServeRequest can be used as middleware for preprocessing message
 func (srv *Server) ServeRequest(f func(r *sip.Request)) {
 	srv.requestMiddlewares = append(srv.requestMiddlewares, f)
 }
 
+// ServeRequestMiddleware adds middleware wrapping all request handlers.
+// Middlewares are called in order they are added, before request handler
+func (srv *Server) ServeRequestMiddleware(m RequestMiddleware) {
+	srv.handlerMiddlewares = append(srv.handlerMiddlewares, m)
+}
+
 func (srv *Server) onTransportMessage(m sip.Message) {
 	//Register transport middleware
 	// this avoids allocations and it forces devs to avoid sip.Message usage
@@ -0,0 +1,298 @@
+package sipgo
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+var (
+	// ErrCredentialsNotFound should be returned by CredentialStore when user does not exist
+	ErrCredentialsNotFound = errors.New("credentials not found")
+)
+
+// CredentialStore provides user credentials for digest authentication
+type CredentialStore interface {
+	// DigestHA1 returns hex encoded H(username:realm:password) for given algorithm.
+	// Algorithm is always one without -sess suffix
+	DigestHA1(username string, realm string, algorithm string) (string, error)
+}
+
+// StaticCredentialStore is CredentialStore with plain passwords mapped by username
+type StaticCredentialStore map[string]string
+
+func (s StaticCredentialStore) DigestHA1(username string, realm string, algorithm string) (string, error) {
+	password, ok := s[username]
+	if !ok {
+		return "", ErrCredentialsNotFound
+	}
+	return sip.DigestHA1(algorithm, username, realm, password, "", "")
+}
+
+// DigestAuthenticator authenticates requests with digest challenges.
+// Nonces are stateless, signed with HMAC and timestamp, so they can be validated by any instance sharing same secret.
+// Because of that nonce count is not tracked and credentials can be replayed for same method and Request-URI
+// until nonce expires. Keep nonce expiry short if this is concern.
+type DigestAuthenticator struct {
+	realm       string
+	store       CredentialStore
+	secret      []byte
+	nonceExpiry time.Duration
+	algorithms  []string
+	proxy       bool
+	clock       sip.Clock
+	log         *slog.Logger
+}
+
+type DigestAuthenticatorOption func(a *DigestAuthenticator)
+
+// WithDigestAuthenticatorSecret sets secret used for signing nonces.
+// Default is random secret, which is not shared between instances
+func WithDigestAuthenticatorSecret(secret []byte) DigestAuthenticatorOption {
+	return func(a *DigestAuthenticator) {
+		a.secret = secret
+	}
+}
+
+// WithDigestAuthenticatorNonceExpiry sets how long nonce is valid.
+// Expired nonce is rechallenged with stale=true. Default: 5 min
+func WithDigestAuthenticatorNonceExpiry(d time.Duration) DigestAuthenticatorOption {
+	return func(a *DigestAuthenticator) {
+		a.nonceExpiry = d
+	}
+}
+
+// WithDigestAuthenticatorAlgorithms sets offered algorithms in order of preference.
+// Default: MD5
+func WithDigestAuthenticatorAlgorithms(algorithms ...string) DigestAuthenticatorOption {
+	return func(a *DigestAuthenticator) {
+		a.algorithms = algorithms
+	}
+}
+
+// WithDigestAuthenticatorProxy makes authenticator challenge with
+// 407 Proxy-Authenticate instead of 401 WWW-Authenticate
+func WithDigestAuthenticatorProxy() DigestAuthenticatorOption {
+	return func(a *DigestAuthenticator) {
+		a.proxy = true
+	}
+}
+
+// WithDigestAuthenticatorClock sets clock used for nonce timestamps and expiry
+// Default: sip.SystemClock
+func WithDigestAuthenticatorClock(c sip.Clock) DigestAuthenticatorOption {
+	return func(a *DigestAuthenticator) {
+		a.clock = c
+	}
+}
+
+// NewDigestAuthenticator creates server side digest authenticator for realm.
+// Use it with Server.ServeRequestMiddleware(a.Middleware)
+func NewDigestAuthenticator(realm string, store CredentialStore, options ...DigestAuthenticatorOption) *DigestAuthenticator {
+	a := &DigestAuthenticator{
+		realm:       realm,
+		store:       store,
+		nonceExpiry: 5 * time.Minute,
+		algorithms:  []string{sip.DigestAlgorithmMD5},
+		clock:       sip.SystemClock,
+		log:         slog.With("caller", "DigestAuthenticator"),
+	}
+
+	for _, o := range options {
+		o(a)
+	}
+
+	if a.secret == nil {
+		a.secret = make([]byte, 32)
+		if _, err := rand.Read(a.secret); err != nil {
+			panic(err)
+		}
+	}
+	return a
+}
+
+// Middleware rejects not authenticated requests with challenge, otherwise it calls next handler.
+// ACK and CANCEL can not be challenged and they are passed
+func (a *DigestAuthenticator) Middleware(next RequestHandler) RequestHandler {
+	return func(req *sip.Request, tx sip.ServerTransaction) {
+		if req.IsAck() || req.IsCancel() {
+			next(req, tx)
+			return
+		}
+
+		if _, res := a.Authenticate(req); res != nil {
+			if err := tx.Respond(res); err != nil {
+				a.log.Error("Failed to respond auth challenge", "err", err)
+			}
+			return
+		}
+		next(req, tx)
+	}
+}
+
+// Authenticate validates request credentials.
+// On success it returns authenticated username and nil response.
+// Otherwise it returns response that should be sent, which is either challenge or 403 Forbidden,
+// or 500 Server Internal Error when CredentialStore fails
+func (a *DigestAuthenticator) Authenticate(req *sip.Request) (string, *sip.Response) {
+	authName := "Authorization"
+	if a.proxy {
+		authName = "Proxy-Authorization"
+	}
+
+	var cred *sip.DigestCredentials
+	for _, h := range req.GetHeaders(authName) {
+		c, err := sip.ParseDigestCredentials(h.Value())
+		if err != nil {
+			a.log.Debug("Failed to parse credentials", "err", err)
+			continue
+		}
+		if c.Realm == a.realm {
+			cred = c
+			break
+		}
+	}
+
+	if cred == nil {
+		return "", a.challenge(req, false)
+	}
+
+	nonceTime, ok := a.validNonce(cred.Nonce)
+	if !ok {
+		return "", a.challenge(req, false)
+	}
+
+	algorithm, ok := a.algorithm(cred.Algorithm)
+	if !ok {
+		return "", a.challenge(req, false)
+	}
+
+	switch cred.Qop {
+	case sip.DigestQopAuth, sip.DigestQopAuthInt:
+	default:
+		// We always offer qop, so RFC 2069 is not accepted
+		return "", a.challenge(req, false)
+	}
+
+	if !digestURIMatches(cred.URI, req.Recipient) {
+		// https://datatracker.ietf.org/doc/html/rfc7616#section-3.4.6
+		a.log.Debug("Digest uri does not match Request-URI", "uri", cred.URI, "recipient", req.Recipient.String())
+		return "", sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil)
+	}
+
+	ha1, err := a.store.DigestHA1(cred.Username, a.realm, strings.TrimSuffix(algorithm, "-sess"))
+	if err != nil {
+		if !errors.Is(err, ErrCredentialsNotFound) {
+			a.log.Error("Credential store failed", "err", err, "username", cred.Username)
+			return "", sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Server Internal Error", nil)
+		}
+		return "", sip.NewResponseFromRequest(req, sip.StatusForbidden, "Forbidden", nil)
+	}
+
+	if strings.HasSuffix(algorithm, "-sess") {
+		// Session variant HA1 is computed with base HA1 as password hash input
+		ha1, err = sip.DigestSessHA1(algorithm, ha1, cred.Nonce, cred.Cnonce)
+		if err != nil {
+			return "", a.challenge(req, false)
+		}
+	}
+
+	expected, err := sip.DigestResponseHA1(algorithm, ha1, string(req.Method), cred.URI, cred.Nonce, cred.NC, cred.Cnonce, cred.Qop, req.Body())
+	if err != nil || !hmac.Equal([]byte(expected), []byte(cred.Response)) {
+		return "", sip.NewResponseFromRequest(req, sip.StatusForbidden, "Forbidden", nil)
+	}
+
+	if a.clock.Now().Sub(nonceTime) > a.nonceExpiry {
+		// Credentials are correct, client only needs to retry with fresh nonce
+		return "", a.challenge(req, true)
+	}
+
+	return cred.Username, nil
+}
+
+// digestURIMatches checks that digest uri identifies same resource as Request-URI
+func digestURIMatches(digestURI string, recipient sip.Uri) bool {
+	var uri sip.Uri
+	if err := sip.ParseUri(digestURI, &uri); err != nil {
+		return false
+	}
+	return strings.EqualFold(uriScheme(uri), uriScheme(recipient)) &&
+		uri.User == recipient.User &&
+		strings.EqualFold(uri.Host, recipient.Host) &&
+		uri.Port == recipient.Port
+}
+
+func uriScheme(uri sip.Uri) string {
+	if uri.Scheme == "" {
+		return "sip"
+	}
+	return uri.Scheme
+}
+
+func (a *DigestAuthenticator) algorithm(alg string) (string, bool) {
+	if alg == "" {
+		alg = sip.DigestAlgorithmMD5
+	}
+	for _, o := range a.algorithms {
+		if strings.EqualFold(o, alg) {
+			return o, true
+		}
+	}
+	return "", false
+}
+
+func (a *DigestAuthenticator) challenge(req *sip.Request, stale bool) *sip.Response {
+	code, reason, name := sip.StatusUnauthorized, "Unauthorized", "WWW-Authenticate"
+	if a.proxy {
+		code, reason, name = sip.StatusProxyAuthRequired, "Proxy Authentication Required", "Proxy-Authenticate"
+	}
+
+	res := sip.NewResponseFromRequest(req, code, reason, nil)
+	nonce := a.newNonce(a.clock.Now())
+	for _, alg := range a.algorithms {
+		chal := sip.DigestChallenge{
+			Realm:     a.realm,
+			Nonce:     nonce,
+			Algorithm: alg,
+			Qop:       []string{sip.DigestQopAuth, sip.DigestQopAuthInt},
+			Stale:     stale,
+		}
+		res.AppendHeader(sip.NewHeader(name, chal.String()))
+	}
+	return res
+}
+
+// newNonce creates nonce in format hex(timestamp) + hex(hmac(timestamp))
+func (a *DigestAuthenticator) newNonce(now time.Time) string {
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(now.UnixNano()))
+	return hex.EncodeToString(ts[:]) + hex.EncodeToString(a.nonceMac(ts[:]))
+}
+
+func (a *DigestAuthenticator) validNonce(nonce string) (time.Time, bool) {
+	data, err := hex.DecodeString(nonce)
+	if err != nil || len(data) != 8+sha256.Size {
+		return time.Time{}, false
+	}
+
+	ts, mac := data[:8], data[8:]
+	if !hmac.Equal(mac, a.nonceMac(ts)) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(ts))), true
+}
+
+func (a *DigestAuthenticator) nonceMac(ts []byte) []byte {
+	h := hmac.New(sha256.New, a.secret)
+	h.Write(ts)
+	h.Write([]byte(a.realm))
+	return h.Sum(nil)
+}
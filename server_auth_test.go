@@ -0,0 +1,151 @@
+package sipgo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sipgo/fakes"
+	"github.com/livekit/sipgo/sip"
+)
+
+func TestServerDigestAuth(t *testing.T) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer ua.Close()
+
+	srv, err := NewServer(ua)
+	require.NoError(t, err)
+
+	auth := NewDigestAuthenticator("sipgo.test", StaticCredentialStore{"alice": "secret"},
+		WithDigestAuthenticatorAlgorithms(sip.DigestAlgorithmSHA256, sip.DigestAlgorithmMD5),
+	)
+	srv.ServeRequestMiddleware(auth.Middleware)
+
+	var handled atomic.Int32
+	srv.OnRegister(func(req *sip.Request, tx sip.ServerTransaction) {
+		handled.Add(1)
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+
+	uri := testServerUDP(t, srv)
+
+	t.Run("NoCredentials", func(t *testing.T) {
+		c := testClient(t)
+		res, err := c.Do(context.Background(), sip.NewRequest(sip.REGISTER, uri))
+		require.NoError(t, err)
+		require.Equal(t, sip.StatusUnauthorized, res.StatusCode)
+		assert.Len(t, res.GetHeaders("WWW-Authenticate"), 2)
+		assert.EqualValues(t, 0, handled.Load())
+	})
+
+	t.Run("Authenticated", func(t *testing.T) {
+		c := testClient(t, WithClientDigestAuth(DigestAuth{Username: "alice", Password: "secret"}))
+		res, err := c.Do(context.Background(), sip.NewRequest(sip.REGISTER, uri))
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusOK, res.StatusCode)
+		assert.EqualValues(t, 1, handled.Load())
+	})
+
+	t.Run("OtherRequestURI", func(t *testing.T) {
+		c := testClient(t)
+		req := sip.NewRequest(sip.REGISTER, uri)
+		res, err := c.Do(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, sip.StatusUnauthorized, res.StatusCode)
+
+		// Credentials computed for other Request-URI must not be accepted
+		chal, err := sip.ParseDigestChallenge(res.GetHeader("WWW-Authenticate").Value())
+		require.NoError(t, err)
+		other := uri
+		other.User = "carol"
+		cred, err := newDigestAuthorizer(DigestAuth{Username: "alice", Password: "secret"}).credentials(sip.NewRequest(sip.REGISTER, other), chal)
+		require.NoError(t, err)
+		req = sip.NewRequest(sip.REGISTER, uri)
+		req.AppendHeader(sip.NewHeader("Authorization", cred.String()))
+		res, err = c.Do(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusBadRequest, res.StatusCode)
+		assert.EqualValues(t, 1, handled.Load())
+	})
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		c := testClient(t, WithClientDigestAuth(DigestAuth{Username: "alice", Password: "wrong"}))
+		res, err := c.Do(context.Background(), sip.NewRequest(sip.REGISTER, uri))
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusForbidden, res.StatusCode)
+		assert.EqualValues(t, 1, handled.Load())
+	})
+}
+
+func TestDigestAuthenticatorNonce(t *testing.T) {
+	a := NewDigestAuthenticator("sipgo.test", StaticCredentialStore{}, WithDigestAuthenticatorNonceExpiry(time.Minute))
+	now := time.Now()
+
+	nonce := a.newNonce(now)
+	ts, ok := a.validNonce(nonce)
+	require.True(t, ok)
+	assert.Equal(t, now.UnixNano(), ts.UnixNano())
+
+	// Different secret must not accept nonce
+	b := NewDigestAuthenticator("sipgo.test", StaticCredentialStore{})
+	_, ok = b.validNonce(nonce)
+	assert.False(t, ok)
+
+	_, ok = a.validNonce(nonce[:len(nonce)-2] + "00")
+	assert.False(t, ok)
+}
+
+type failingCredentialStore struct{}
+
+func (failingCredentialStore) DigestHA1(username string, realm string, algorithm string) (string, error) {
+	return "", errors.New("store unavailable")
+}
+
+func TestDigestAuthenticatorAuthenticate(t *testing.T) {
+	clock := fakes.NewClock(time.Now())
+	authorized := func(a *DigestAuthenticator) *sip.Request {
+		req := testRegister("auth", 1)
+		_, res := a.Authenticate(req)
+		require.Equal(t, sip.StatusUnauthorized, res.StatusCode)
+		chal, err := sip.ParseDigestChallenge(res.GetHeader("WWW-Authenticate").Value())
+		require.NoError(t, err)
+		cred, err := newDigestAuthorizer(DigestAuth{Username: "alice", Password: "secret"}).credentials(req, chal)
+		require.NoError(t, err)
+		req.AppendHeader(sip.NewHeader("Authorization", cred.String()))
+		return req
+	}
+
+	t.Run("NonceExpiry", func(t *testing.T) {
+		a := NewDigestAuthenticator("sipgo.test", StaticCredentialStore{"alice": "secret"},
+			WithDigestAuthenticatorNonceExpiry(time.Minute),
+			WithDigestAuthenticatorClock(clock),
+		)
+		req := authorized(a)
+		clock.Advance(time.Minute)
+		user, res := a.Authenticate(req)
+		require.Nil(t, res)
+		assert.Equal(t, "alice", user)
+
+		clock.Advance(time.Second)
+		_, res = a.Authenticate(req)
+		require.NotNil(t, res)
+		require.Equal(t, sip.StatusUnauthorized, res.StatusCode)
+		chal, err := sip.ParseDigestChallenge(res.GetHeader("WWW-Authenticate").Value())
+		require.NoError(t, err)
+		assert.True(t, chal.Stale)
+	})
+
+	t.Run("StoreError", func(t *testing.T) {
+		a := NewDigestAuthenticator("sipgo.test", failingCredentialStore{}, WithDigestAuthenticatorClock(clock))
+		_, res := a.Authenticate(authorized(a))
+		require.NotNil(t, res)
+		assert.Equal(t, sip.StatusInternalServerError, res.StatusCode)
+	})
+}
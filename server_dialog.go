@@ -1,14 +1,29 @@
 package sipgo
 
 import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
 	"github.com/livekit/sipgo/sip"
 )
 
-// ServerDialog is extension of Server to support Dialog handling
+// ServerDialog is extension of Server to support Dialog handling.
+// Dialog sessions are created on 2xx INVITE responses and kept until BYE,
+// so requests within dialog can be sent from UAS side with DialogServerSession.
+// Requests within dialog that do not match any dialog are passed to handler, as they can belong to
+// dialogs of DialogClient or subscriptions on same user agent. Use SetRejectUnknownDialogs to reject them with 481.
 type ServerDialog struct {
 	Server
 
-	onDialog func(d sip.Dialog)
+	onDialog      func(d sip.Dialog)
+	client        *Client
+	dialogs       sync.Map // id -> *DialogServerSession
+	replaced      sync.Map // INVITE key -> DialogSession replaced by INVITE
+	sessionTimer  SessionTimer
+	rejectUnknown bool
 }
 
 func NewServerDialog(ua *UserAgent, options ...ServerOption) (*ServerDialog, error) {
@@ -17,8 +32,14 @@ func NewServerDialog(ua *UserAgent, options ...ServerOption) (*ServerDialog, err
 		return nil, err
 	}
 
+	client, err := NewClient(ua, WithClientLogger(base.log))
+	if err != nil {
+		return nil, err
+	}
+
 	s := &ServerDialog{
 		Server: *base,
+		client: client,
 	}
 
 	// s.tp = transport.NewLayer(s.dnsResolver)
@@ -32,10 +53,59 @@ func (s *ServerDialog) onRequestDialog(r *sip.Request, tx sip.ServerTransaction)
 }
 
 func (s *ServerDialog) handleRequestDialog(r *sip.Request, tx sip.ServerTransaction) {
+	if to := r.To(); to != nil && to.Params.Has("tag") {
+		sess, err := s.MatchDialogRequest(r)
+		switch {
+		case err == nil:
+			s.readSessionRequest(r, sess)
+
+		case errors.Is(err, ErrDialogDoesNotExists) && !s.rejectUnknown:
+			// Not our UAS dialog, handler decides
+			s.publishRequest(r)
+
+		default:
+			s.log.Debug("Request within dialog not matched", "err", err, "req", sip.MessageShortString(r))
+			if !r.IsAck() {
+				// ACK can not be responded
+				if err := tx.Respond(dialogErrorResponse(r, err)); err != nil {
+					s.log.Error("Failed to respond dialog error", "err", err)
+				}
+			}
+			tx.Terminate()
+			return
+		}
+	}
+
+	if s.sessionTimer.enabled() && (r.IsInvite() || r.Method == sip.UPDATE) {
+		if res := s.sessionTimerCheck(r); res != nil {
+			if err := tx.Respond(res); err != nil {
+				s.log.Error("Failed to respond session interval too small", "err", err)
+			}
+			tx.Terminate()
+			return
+		}
+	}
+
+	// This makes allocation, but hard to override
+	// Maybe goign on transaction layer
+	wraptx := &dialogServerTx{ServerTransaction: tx, s: s, req: r}
+	s.Server.handleRequest(r, wraptx)
+}
+
+// readSessionRequest confirms dialog session on ACK and ends it on BYE
+func (s *ServerDialog) readSessionRequest(r *sip.Request, sess *DialogServerSession) {
 	switch r.Method {
-	// Early state
-	// case sip.INVITE:
+	case sip.ACK:
+		sess.confirm()
+		s.confirmReplaces(sess)
+	case sip.BYE:
+		sess.end()
+	}
+	s.publishRequest(r)
+}
 
+func (s *ServerDialog) publishRequest(r *sip.Request) {
+	switch r.Method {
 	case sip.ACK:
 		s.publish(r, sip.Dialog{
 			State: sip.DialogStateConfirmed,
@@ -46,14 +116,13 @@ func (s *ServerDialog) handleRequestDialog(r *sip.Request, tx sip.ServerTransact
 			State: sip.DialogStateEnded,
 		})
 	}
-
-	// This makes allocation, but hard to override
-	// Maybe goign on transaction layer
-	wraptx := &dialogServerTx{tx, s}
-	s.Server.handleRequest(r, wraptx)
 }
 
 func (s *ServerDialog) publish(r sip.Message, d sip.Dialog) {
+	if s.onDialog == nil {
+		return
+	}
+
 	id, err := sip.MakeDialogIDFromMessage(r)
 	if err != nil {
 		s.log.Error("Failed to create dialog id", "err", err, "msg", sip.MessageShortString(r))
@@ -76,22 +145,344 @@ func (s *ServerDialog) OnDialogChan(ch chan sip.Dialog) {
 	}
 }
 
+// SetRejectUnknownDialogs sets rejecting requests within dialog, which do not match any dialog of ServerDialog,
+// with 481 before reaching handler. It should be used only if user agent has no other dialogs,
+// like dialogs of DialogClient or subscriptions.
+func (s *ServerDialog) SetRejectUnknownDialogs(reject bool) {
+	s.rejectUnknown = reject
+}
+
+// SetSessionTimer enables session timers for dialogs. Requests with lower session interval than MinSE
+// are rejected with 422 and session interval is added to 2xx responses for INVITE and UPDATE.
+// https://datatracker.ietf.org/doc/html/rfc4028
+func (s *ServerDialog) SetSessionTimer(t SessionTimer) {
+	s.sessionTimer = t
+}
+
+// sessionTimerCheck returns 422 response if requested session interval is too small
+// https://datatracker.ietf.org/doc/html/rfc4028#section-8.1
+func (s *ServerDialog) sessionTimerCheck(req *sip.Request) *sip.Response {
+	se, ok, err := sip.MessageSessionExpires(req)
+	if !ok {
+		return nil
+	}
+	if err != nil {
+		return sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil)
+	}
+
+	minSE := s.sessionTimer.minSE()
+	if se.Delta >= minSE {
+		return nil
+	}
+	res := sip.NewResponseFromRequest(req, sip.StatusSessionIntervalTooSmall, "Session Interval Too Small", nil)
+	res.AppendHeader(sip.NewHeader("Min-SE", strconv.FormatUint(uint64(minSE), 10)))
+	return res
+}
+
+// sessionTimerResponse adds Session-Expires to 2xx response for INVITE or UPDATE.
+// Session-Expires already set on response is kept.
+// https://datatracker.ietf.org/doc/html/rfc4028#section-9
+func (s *ServerDialog) sessionTimerResponse(req *sip.Request, res *sip.Response) (sip.SessionExpires, bool) {
+	if se, ok, err := sip.MessageSessionExpires(res); ok {
+		return se, err == nil
+	}
+
+	reqSE, ok, err := sip.MessageSessionExpires(req)
+	if err != nil {
+		return sip.SessionExpires{}, false
+	}
+
+	se := sip.SessionExpires{Delta: s.sessionTimer.interval(), Refresher: sip.RefresherUAS}
+	if ok {
+		// UAS may reduce session interval, but not lower than Min-SE of request
+		minSE, _, _ := sip.MessageMinSE(req)
+		se.Delta = min(reqSE.Delta, max(se.Delta, minSE, sip.MinSessionExpires))
+		se.Refresher = reqSE.Refresher
+		if se.Refresher == "" {
+			se.Refresher = sip.RefresherUAS
+			if sip.HasOptionTag(req, "Supported", sip.OptionTagTimer) {
+				se.Refresher = sip.RefresherUAC
+			}
+		}
+	}
+
+	res.AppendHeader(sip.NewHeader("Session-Expires", se.String()))
+	if se.Refresher == sip.RefresherUAC {
+		res.AppendHeader(sip.NewHeader("Require", sip.OptionTagTimer))
+	}
+	return se, true
+}
+
+// Session returns dialog session by dialog ID.
+// ID of dialog created by response can be built with sip.MakeDialogIDFromResponse
+func (s *ServerDialog) Session(id string) (*DialogServerSession, bool) {
+	val, ok := s.dialogs.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return val.(*DialogServerSession), true
+}
+
+// MatchDialogRequest returns dialog session of request received within dialog.
+// Remote CSeq is validated and remote target is updated in case of target refresh request.
+// ErrDialogDoesNotExists is returned if there is no such dialog
+// and ErrDialogInvalidCseq if request is out of order.
+func (s *ServerDialog) MatchDialogRequest(req *sip.Request) (*DialogServerSession, error) {
+	id, err := sip.UASReadRequestDialogID(req)
+	if err != nil {
+		return nil, errors.Join(ErrDialogDoesNotExists, err)
+	}
+
+	sess, ok := s.Session(id)
+	if !ok {
+		return nil, ErrDialogDoesNotExists
+	}
+
+	if err := sess.readRequest(req); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// newSession creates dialog session from INVITE request and our 1xx or 2xx response
+// https://datatracker.ietf.org/doc/html/rfc3261#section-12.1.1
+func (s *ServerDialog) newSession(req *sip.Request, res *sip.Response) (*DialogServerSession, error) {
+	id, err := sip.MakeDialogIDFromResponse(res)
+	if err != nil {
+		return nil, err
+	}
+
+	routeSet, err := dialogRouteSet(req.GetHeaders("Record-Route"), false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remote target is Contact of request. Without Contact we can only use From address
+	remoteTarget := req.From().Address
+	if contact := req.Contact(); contact != nil {
+		remoteTarget = contact.Address
+	}
+
+	sess := &DialogServerSession{
+		dialogSession:  newDialogSession(s.client, id, s.deleteSession),
+		InviteRequest:  req,
+		InviteResponse: res,
+	}
+
+	to, from := res.To(), req.From()
+	sess.callID = *req.CallID()
+	sess.localHDR = sip.FromHeader{DisplayName: to.DisplayName, Address: to.Address, Params: to.Params}
+	sess.remoteHDR = sip.ToHeader{DisplayName: from.DisplayName, Address: from.Address, Params: from.Params}
+	sess.contactHDR = res.Contact()
+	sess.remoteCSeq = req.CSeq().SeqNo
+	sess.remoteTarget = *remoteTarget.Clone()
+	sess.routeSet = routeSet
+	sess.timerCfg = s.sessionTimer
+	sess.setLocalBody(res)
+	if res.IsProvisional() {
+		sess.state = sip.DialogStateEarly
+	}
+
+	s.dialogs.Store(id, sess)
+	return sess, nil
+}
+
+// ByeAll sends BYE on all confirmed dialogs. It can be used on shutdown with UserAgent.OnShutdown
+func (s *ServerDialog) ByeAll(ctx context.Context) error {
+	var sessions []*dialogSession
+	s.dialogs.Range(func(_, v any) bool {
+		sessions = append(sessions, v.(*DialogServerSession).dialogSession)
+		return true
+	})
+	return byeSessions(ctx, sessions)
+}
+
+// waitAck ends dialog session if our 2xx is not acknowledged before INVITE server transaction
+// would terminate with Timer H, for example when peer disappeared.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-13.3.1.4
+func (s *ServerDialog) waitAck(sess *DialogServerSession) {
+	timeout := s.tx.RequestTimers(sess.InviteRequest).H()
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.ackTimer = sess.clock.AfterFunc(timeout, func() {
+		sess.mu.Lock()
+		state, res := sess.state, sess.InviteResponse
+		sess.mu.Unlock()
+		if state != sip.DialogStateEstablished {
+			return
+		}
+		s.log.Info("Dialog not confirmed with ACK. Terminating", "id", sess.ID())
+		sess.end()
+		s.publish(res, sip.Dialog{
+			State: sip.DialogStateEnded,
+		})
+	})
+}
+
+func (s *ServerDialog) deleteSession(id string) {
+	if val, ok := s.dialogs.LoadAndDelete(id); ok {
+		s.replaced.Delete(inviteKey(val.(*DialogServerSession).InviteRequest))
+	}
+}
+
+// DialogServerSession is UAS dialog created by 1xx or 2xx INVITE response.
+// It allows sending requests within dialog like BYE, re-INVITE, INFO or NOTIFY from UAS side.
+type DialogServerSession struct {
+	*dialogSession
+
+	InviteRequest *sip.Request
+	// InviteResponse is response that created dialog. It is updated with 2xx when early dialog is established
+	InviteResponse *sip.Response
+
+	// ackTimer ends dialog if 2xx is not acknowledged
+	ackTimer sip.Timer
+}
+
+// confirm moves dialog to confirmed state on ACK of our 2xx
+func (s *DialogServerSession) confirm() {
+	s.setState(sip.DialogStateConfirmed)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ackTimer != nil {
+		s.ackTimer.Stop()
+		s.ackTimer = nil
+	}
+}
+
+// establish moves early dialog to established state with our 2xx response.
+// It returns false if dialog is not in early state
+func (s *DialogServerSession) establish(res *sip.Response) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != sip.DialogStateEarly {
+		return false
+	}
+
+	s.InviteResponse = res
+	if contact := res.Contact(); contact != nil {
+		s.contactHDR = contact
+	}
+	s.setLocalBody(res)
+	s.state = sip.DialogStateEstablished
+	return true
+}
+
+// setLocalBody keeps session description of our response for session refresh. Must be called under lock
+func (s *DialogServerSession) setLocalBody(res *sip.Response) {
+	if len(res.Body()) == 0 {
+		return
+	}
+	s.localBody = res.Body()
+	if h := res.GetHeader("Content-Type"); h != nil {
+		s.localContentType = sip.HeaderClone(h)
+	}
+}
+
 // this is just wrapper to allow listening response
 type dialogServerTx struct {
 	sip.ServerTransaction
-	s *ServerDialog
+	s   *ServerDialog
+	req *sip.Request
+
+	// toTag is tag of first response creating dialog
+	toTag string
 }
 
 func (tx *dialogServerTx) Respond(r *sip.Response) error {
+	var se sip.SessionExpires
+	var sessionTimer bool
+	if r.IsSuccess() && tx.s.sessionTimer.enabled() && (tx.req.IsInvite() || tx.req.Method == sip.UPDATE) {
+		se, sessionTimer = tx.s.sessionTimerResponse(tx.req, r)
+	}
+
+	if tx.req.IsInvite() && !tx.req.To().Params.Has("tag") && r.StatusCode != sip.StatusTrying {
+		tx.respondInitialInvite(r)
+	}
+
+	if err := tx.ServerTransaction.Respond(r); err != nil {
+		return err
+	}
+
+	if sessionTimer {
+		tx.startSessionTimer(r, se)
+	}
+	return nil
+}
+
+// startSessionTimer starts session timer negotiated by our 2xx response. We are UAS of this transaction
+func (tx *dialogServerTx) startSessionTimer(r *sip.Response, se sip.SessionExpires) {
+	id, err := sip.MakeDialogIDFromResponse(r)
+	if err != nil {
+		return
+	}
+	sess, exists := tx.s.Session(id)
+	if !exists {
+		return
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.startSessionTimer(time.Duration(se.Delta)*time.Second, se.Refresher == sip.RefresherUAS)
+}
+
+// respondInitialInvite creates early dialog on 1xx, establishes it on 2xx and terminates it on failure response.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-12.1.1
+func (tx *dialogServerTx) respondInitialInvite(r *sip.Response) {
+	to := r.To()
+	if to == nil {
+		return
+	}
+
+	// https://datatracker.ietf.org/doc/html/rfc3261#section-8.2.6.2
+	// Same tag MUST be used for all responses to request, except 100
+	if tx.toTag == "" {
+		tx.toTag, _ = to.Params.Get("tag")
+	} else {
+		to.Params.Add("tag", tx.toTag)
+	}
+
+	id, err := sip.MakeDialogIDFromResponse(r)
+	if err != nil {
+		tx.s.log.Error("Failed to create dialog id", "err", err, "msg", sip.MessageShortString(r))
+		return
+	}
+	sess, exists := tx.s.Session(id)
+
 	switch {
-	// EARLY STATE NEEDS more definition
-	// case r.IsProvisional():
+	case r.IsProvisional():
+		if exists {
+			return
+		}
+		if _, err := tx.s.newSession(tx.req, r); err != nil {
+			tx.s.log.Error("Failed to create early dialog session", "err", err, "msg", sip.MessageShortString(r))
+			return
+		}
+		tx.s.publish(r, sip.Dialog{
+			State: sip.DialogStateEarly,
+		})
 
 	case r.IsSuccess():
+		if exists {
+			if !sess.establish(r) {
+				// Retransmission of 2xx
+				return
+			}
+		} else if sess, err = tx.s.newSession(tx.req, r); err != nil {
+			tx.s.log.Error("Failed to create dialog session", "err", err, "msg", sip.MessageShortString(r))
+			return
+		}
+		tx.s.waitAck(sess)
 		tx.s.publish(r, sip.Dialog{
 			State: sip.DialogStateEstablished,
 		})
-	}
 
-	return tx.ServerTransaction.Respond(r)
+	default:
+		// https://datatracker.ietf.org/doc/html/rfc3261#section-12.3
+		// Non 2xx final response terminates early dialog
+		if exists {
+			sess.end()
+		}
+		tx.s.replaced.Delete(inviteKey(tx.req))
+	}
 }
@@ -1,12 +1,15 @@
 package sipgo
 
 import (
+	"context"
 	"io"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/livekit/sipgo/fakes"
 	"github.com/livekit/sipgo/sip"
+	"github.com/livekit/sipgo/transaction"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -80,3 +83,255 @@ func TestDialog(t *testing.T) {
 	d = <-ch
 	assert.Equal(t, sip.DialogStateEnded, d.State)
 }
+
+func TestDialogServerSession(t *testing.T) {
+	uasUA, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer uasUA.Close()
+
+	srv, err := NewServerDialog(uasUA)
+	require.NoError(t, err)
+	srv.SetRejectUnknownDialogs(true)
+
+	sessions := make(chan *DialogServerSession, 1)
+	srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+		res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+		res.AppendHeader(&sip.ContactHeader{Address: req.Recipient})
+		if !assert.NoError(t, tx.Respond(res)) {
+			return
+		}
+		id, _ := sip.MakeDialogIDFromResponse(res)
+		sess, ok := srv.Session(id)
+		assert.True(t, ok)
+		sessions <- sess
+	})
+	acks := make(chan struct{}, 1)
+	srv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {
+		acks <- struct{}{}
+	})
+	uasURI := testServerUDP(t, &srv.Server)
+
+	// UAC side must listen for requests from UAS
+	uacUA, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer uacUA.Close()
+
+	uacSrv, err := NewServer(uacUA)
+	require.NoError(t, err)
+
+	c, err := NewClient(uacUA, WithClientHostname("127.0.0.1"))
+	require.NoError(t, err)
+	dc := NewDialogClient(c, sip.ContactHeader{})
+
+	infos := make(chan *sip.Request, 1)
+	uacSrv.OnInfo(func(req *sip.Request, tx sip.ServerTransaction) {
+		if _, err := dc.MatchRequest(req); err != nil {
+			tx.Respond(dialogErrorResponse(req, err))
+			return
+		}
+		infos <- req
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+	uacSrv.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+		assert.NoError(t, dc.ReadBye(req, tx))
+	})
+	uacURI := testServerUDP(t, uacSrv)
+	uacURI.User = "alice"
+	dc.contactHDR = sip.ContactHeader{Address: uacURI}
+
+	ctx := context.Background()
+	uacSess, err := dc.Invite(ctx, uasURI, nil)
+	require.NoError(t, err)
+	require.NoError(t, uacSess.Ack(ctx))
+
+	uasSess := <-sessions
+	select {
+	case <-acks:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ACK not received")
+	}
+	assert.Equal(t, sip.DialogStateConfirmed, uasSess.State())
+	target := uasSess.RemoteTarget()
+	assert.Equal(t, uacURI.String(), target.String())
+
+	res, err := uasSess.Do(ctx, sip.NewRequest(sip.INFO, sip.Uri{}))
+	require.NoError(t, err)
+	assert.Equal(t, sip.StatusOK, res.StatusCode)
+	info := <-infos
+	assert.Equal(t, uint32(1), info.CSeq().SeqNo)
+	assert.Equal(t, uacSess.InviteResponse.To().Params["tag"], info.From().Params["tag"])
+	assert.Equal(t, uacSess.InviteResponse.From().Params["tag"], info.To().Params["tag"])
+
+	require.NoError(t, uasSess.Bye(ctx))
+	assert.Equal(t, sip.DialogStateEnded, uasSess.State())
+	assert.Equal(t, sip.DialogStateEnded, uacSess.State())
+
+	t.Run("UnknownDialog", func(t *testing.T) {
+		// Dialog is terminated so UAS must reject it
+		bye := sip.NewRequest(sip.BYE, uasURI)
+		bye.AppendHeader(sip.HeaderClone(uacSess.InviteResponse.From()))
+		bye.AppendHeader(sip.HeaderClone(uacSess.InviteResponse.To()))
+		bye.AppendHeader(sip.HeaderClone(uacSess.InviteResponse.CallID()))
+		res, err := c.Do(ctx, bye)
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusCallTransactionDoesNotExists, res.StatusCode)
+	})
+}
+
+func TestDialogServerNotConfirmed(t *testing.T) {
+	clock := fakes.NewClock(time.Now())
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")), WithUserAgentClock(clock))
+	require.NoError(t, err)
+	defer ua.Close()
+
+	srv, err := NewServerDialog(ua)
+	require.NoError(t, err)
+
+	dialogs := make(chan sip.Dialog, 10)
+	srv.OnDialogChan(dialogs)
+	srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+	byes := make(chan *sip.Request, 1)
+	srv.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+		byes <- req
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+	uri := testServerUDP(t, &srv.Server)
+	c := testClient(t)
+
+	// 2xx is never acknowledged
+	ctx := context.Background()
+	res, err := c.Do(ctx, sip.NewRequest(sip.INVITE, uri))
+	require.NoError(t, err)
+	require.Equal(t, sip.StatusOK, res.StatusCode)
+
+	d := <-dialogs
+	assert.Equal(t, sip.DialogStateEstablished, d.State)
+	sess, ok := srv.Session(d.ID)
+	require.True(t, ok)
+
+	clock.Advance(64*transaction.T1 - time.Millisecond)
+	assert.Equal(t, sip.DialogStateEstablished, sess.State())
+	clock.Advance(time.Millisecond)
+
+	d = <-dialogs
+	assert.Equal(t, sip.DialogStateEnded, d.State)
+	assert.Equal(t, sip.DialogStateEnded, sess.State())
+	_, ok = srv.Session(d.ID)
+	assert.False(t, ok)
+
+	t.Run("UnknownDialog", func(t *testing.T) {
+		// Request within dialog not known by ServerDialog is left to handler
+		bye := sip.NewRequest(sip.BYE, uri)
+		bye.AppendHeader(sip.HeaderClone(res.From()))
+		bye.AppendHeader(sip.HeaderClone(res.To()))
+		bye.AppendHeader(sip.HeaderClone(res.CallID()))
+		res, err := c.Do(ctx, bye)
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusOK, res.StatusCode)
+		require.Len(t, byes, 1)
+	})
+}
+
+func TestDialogServerEarly(t *testing.T) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer ua.Close()
+
+	srv, err := NewServerDialog(ua)
+	require.NoError(t, err)
+
+	dialogs := make(chan sip.Dialog, 10)
+	srv.OnDialogChan(dialogs)
+	srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+		// Responses are created with different To tags, but dialog must keep first one
+		tx.Respond(sip.NewResponseFromRequest(req, 180, "Ringing", nil))
+		if req.Recipient.User == "busy" {
+			tx.Respond(sip.NewResponseFromRequest(req, 486, "Busy Here", nil))
+			return
+		}
+		tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+	uri := testServerUDP(t, &srv.Server)
+	c := testClient(t)
+
+	ctx := context.Background()
+	var ringing *sip.Response
+	res, err := c.DoWithProvisional(ctx, sip.NewRequest(sip.INVITE, uri), func(res *sip.Response) {
+		ringing = res
+	})
+	require.NoError(t, err)
+	require.NotNil(t, ringing)
+	assert.Equal(t, sip.StatusOK, res.StatusCode)
+	assert.Equal(t, ringing.To().Params["tag"], res.To().Params["tag"])
+
+	d := <-dialogs
+	assert.Equal(t, sip.DialogStateEarly, d.State)
+	d = <-dialogs
+	assert.Equal(t, sip.DialogStateEstablished, d.State)
+
+	sess, ok := srv.Session(d.ID)
+	require.True(t, ok)
+	assert.Equal(t, sip.DialogStateEstablished, sess.State())
+	assert.Equal(t, res.To().Params["tag"], sess.InviteResponse.To().Params["tag"])
+
+	t.Run("Failure", func(t *testing.T) {
+		busy := uri
+		busy.User = "busy"
+		res, err := c.Do(ctx, sip.NewRequest(sip.INVITE, busy))
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusBusyHere, res.StatusCode)
+
+		d := <-dialogs
+		assert.Equal(t, sip.DialogStateEarly, d.State)
+		_, ok := srv.Session(d.ID)
+		assert.False(t, ok)
+	})
+}
+
+func TestDialogServerByeAll(t *testing.T) {
+	uasUA, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer uasUA.Close()
+	srv, err := NewServerDialog(uasUA)
+	require.NoError(t, err)
+	srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+		res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+		res.AppendHeader(&sip.ContactHeader{Address: req.Recipient})
+		tx.Respond(res)
+	})
+	acks := make(chan struct{}, 1)
+	srv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {
+		acks <- struct{}{}
+	})
+	uasURI := testServerUDP(t, &srv.Server)
+
+	uacUA, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer uacUA.Close()
+	uacSrv, err := NewServer(uacUA)
+	require.NoError(t, err)
+	c, err := NewClient(uacUA, WithClientHostname("127.0.0.1"))
+	require.NoError(t, err)
+	dc := NewDialogClient(c, sip.ContactHeader{})
+	uacSrv.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+		assert.NoError(t, dc.ReadBye(req, tx))
+	})
+	uacURI := testServerUDP(t, uacSrv)
+	uacURI.User = "alice"
+	dc.contactHDR = sip.ContactHeader{Address: uacURI}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sess, err := dc.Invite(ctx, uasURI, nil)
+	require.NoError(t, err)
+	require.NoError(t, sess.Ack(ctx))
+	<-acks
+
+	uasUA.OnShutdown(func(ctx context.Context) {
+		assert.NoError(t, srv.ByeAll(ctx))
+	})
+	require.NoError(t, srv.Shutdown(ctx))
+	assert.Equal(t, sip.DialogStateEnded, sess.State())
+}
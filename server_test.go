@@ -1,6 +1,7 @@
 package sipgo
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -403,3 +404,80 @@ func ExampleServer_OnNoRoute() {
 		}
 	})
 }
+
+func TestServerShutdown(t *testing.T) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")), WithUserAgentShutdownRetryAfter(30*time.Second))
+	require.NoError(t, err)
+	defer ua.Close()
+	srv, err := NewServer(ua)
+	require.NoError(t, err)
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv.OnOptions(func(req *sip.Request, tx sip.ServerTransaction) {
+		started <- struct{}{}
+		<-release
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+	})
+	draining := make(chan struct{})
+	ua.OnShutdown(func(ctx context.Context) {
+		close(draining)
+	})
+	uri := testServerUDP(t, srv)
+	c := testClient(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	inflight := make(chan *sip.Response, 1)
+	go func() {
+		res, err := c.Do(ctx, sip.NewRequest(sip.OPTIONS, uri))
+		assert.NoError(t, err)
+		inflight <- res
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- srv.Shutdown(ctx)
+	}()
+	<-draining
+
+	// New transactions are rejected
+	res, err := c.Do(ctx, sip.NewRequest(sip.OPTIONS, uri))
+	require.NoError(t, err)
+	assert.Equal(t, sip.StatusServiceUnavailable, res.StatusCode)
+	assert.Equal(t, "30", res.GetHeader("Retry-After").Value())
+
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown finished before in-flight transaction")
+	default:
+	}
+
+	// In-flight transaction finishes
+	close(release)
+	res = <-inflight
+	assert.Equal(t, sip.StatusOK, res.StatusCode)
+	require.NoError(t, <-shutdown)
+
+	t.Run("Timeout", func(t *testing.T) {
+		ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+		require.NoError(t, err)
+		srv, err := NewServer(ua)
+		require.NoError(t, err)
+
+		release := make(chan struct{})
+		defer close(release)
+		srv.OnOptions(func(req *sip.Request, tx sip.ServerTransaction) {
+			started <- struct{}{}
+			<-release
+		})
+		uri := testServerUDP(t, srv)
+		go c.Do(ctx, sip.NewRequest(sip.OPTIONS, uri))
+		<-started
+
+		sctx, scancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer scancel()
+		require.ErrorIs(t, srv.Shutdown(sctx), context.DeadlineExceeded)
+	})
+}
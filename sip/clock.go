@@ -0,0 +1,30 @@
+package sip
+
+import "time"
+
+// Clock provides time for timers of transaction and transport layer.
+// It can be replaced with fake clock in tests to advance time without waiting.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc waits for duration to elapse and then calls f in its own goroutine
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is timer created by Clock. *time.Timer implements it
+type Timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// SystemClock is Clock based on time package
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
@@ -7,11 +7,17 @@ const (
 	DialogStateConfirmed
 	// Dialog received BYE
 	DialogStateEnded
+	// Dialog received or sent 1xx response with To tag. It precedes established state,
+	// but it is appended to keep values of existing states
+	DialogStateEarly
 )
 
 // DialogStateString maps state to string
 func DialogStateString(state int) string {
 	switch state {
+	case DialogStateEarly:
+		return "early"
+
 	case DialogStateEstablished:
 		return "established"
 
@@ -34,7 +40,7 @@ type Dialog struct {
 }
 
 // StateString returns string version of state
-// established, confirmed, ended
+// early, established, confirmed, ended
 func (d *Dialog) StateString() string {
 	return DialogStateString(d.State)
 }
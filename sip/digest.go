@@ -0,0 +1,329 @@
+package sip
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// Digest authentication based on
+// https://datatracker.ietf.org/doc/html/rfc3261#section-22.4
+// https://datatracker.ietf.org/doc/html/rfc7616
+// https://datatracker.ietf.org/doc/html/rfc8760
+
+const (
+	DigestAlgorithmMD5           = "MD5"
+	DigestAlgorithmMD5Sess       = "MD5-sess"
+	DigestAlgorithmSHA256        = "SHA-256"
+	DigestAlgorithmSHA256Sess    = "SHA-256-sess"
+	DigestAlgorithmSHA512256     = "SHA-512-256"
+	DigestAlgorithmSHA512256Sess = "SHA-512-256-sess"
+
+	DigestQopAuth    = "auth"
+	DigestQopAuthInt = "auth-int"
+)
+
+var (
+	ErrDigestAlgorithmNotSupported = errors.New("digest algorithm not supported")
+	ErrDigestQopNotSupported       = errors.New("digest qop not supported")
+)
+
+// DigestChallenge is parsed value of WWW-Authenticate or Proxy-Authenticate header
+type DigestChallenge struct {
+	Realm     string
+	Domain    string
+	Nonce     string
+	Opaque    string
+	Algorithm string
+	// Qop is list of offered qop values. Empty means RFC 2069 compatibility
+	Qop   []string
+	Stale bool
+}
+
+// ParseDigestChallenge parses WWW-Authenticate or Proxy-Authenticate header value
+func ParseDigestChallenge(value string) (*DigestChallenge, error) {
+	params, err := parseDigestParams(value)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &DigestChallenge{
+		Realm:     params["realm"],
+		Domain:    params["domain"],
+		Nonce:     params["nonce"],
+		Opaque:    params["opaque"],
+		Algorithm: params["algorithm"],
+		Stale:     strings.EqualFold(params["stale"], "true"),
+	}
+	if c.Nonce == "" {
+		return nil, fmt.Errorf("digest challenge missing nonce")
+	}
+
+	if qop := params["qop"]; qop != "" {
+		for _, q := range strings.Split(qop, ",") {
+			if q = strings.TrimSpace(q); q != "" {
+				c.Qop = append(c.Qop, q)
+			}
+		}
+	}
+	return c, nil
+}
+
+func (c *DigestChallenge) String() string {
+	var b strings.Builder
+	b.WriteString("Digest ")
+	writeDigestParam(&b, "realm", c.Realm, true, true)
+	if c.Domain != "" {
+		writeDigestParam(&b, "domain", c.Domain, true, false)
+	}
+	writeDigestParam(&b, "nonce", c.Nonce, true, false)
+	if c.Opaque != "" {
+		writeDigestParam(&b, "opaque", c.Opaque, true, false)
+	}
+	if c.Algorithm != "" {
+		writeDigestParam(&b, "algorithm", c.Algorithm, false, false)
+	}
+	if len(c.Qop) > 0 {
+		writeDigestParam(&b, "qop", strings.Join(c.Qop, ","), true, false)
+	}
+	if c.Stale {
+		writeDigestParam(&b, "stale", "true", false, false)
+	}
+	return b.String()
+}
+
+// SupportsQop checks is qop offered by challenge
+func (c *DigestChallenge) SupportsQop(qop string) bool {
+	for _, q := range c.Qop {
+		if strings.EqualFold(q, qop) {
+			return true
+		}
+	}
+	return false
+}
+
+// DigestCredentials is parsed value of Authorization or Proxy-Authorization header
+type DigestCredentials struct {
+	Username  string
+	Realm     string
+	Nonce     string
+	URI       string
+	Response  string
+	Algorithm string
+	Cnonce    string
+	Opaque    string
+	Qop       string
+	// NC is nonce count. It is sent in hex format with 8 digits
+	NC uint32
+}
+
+// ParseDigestCredentials parses Authorization or Proxy-Authorization header value
+func ParseDigestCredentials(value string) (*DigestCredentials, error) {
+	params, err := parseDigestParams(value)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &DigestCredentials{
+		Username:  params["username"],
+		Realm:     params["realm"],
+		Nonce:     params["nonce"],
+		URI:       params["uri"],
+		Response:  params["response"],
+		Algorithm: params["algorithm"],
+		Cnonce:    params["cnonce"],
+		Opaque:    params["opaque"],
+		Qop:       params["qop"],
+	}
+
+	if nc := params["nc"]; nc != "" {
+		n, err := strconv.ParseUint(nc, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("digest credentials bad nonce count %q: %w", nc, err)
+		}
+		c.NC = uint32(n)
+	}
+
+	if c.Username == "" || c.Nonce == "" || c.Response == "" {
+		return nil, fmt.Errorf("digest credentials missing username, nonce or response")
+	}
+	return c, nil
+}
+
+func (c *DigestCredentials) String() string {
+	var b strings.Builder
+	b.WriteString("Digest ")
+	writeDigestParam(&b, "username", c.Username, true, true)
+	writeDigestParam(&b, "realm", c.Realm, true, false)
+	writeDigestParam(&b, "nonce", c.Nonce, true, false)
+	writeDigestParam(&b, "uri", c.URI, true, false)
+	writeDigestParam(&b, "response", c.Response, true, false)
+	if c.Algorithm != "" {
+		writeDigestParam(&b, "algorithm", c.Algorithm, false, false)
+	}
+	if c.Qop != "" {
+		writeDigestParam(&b, "qop", c.Qop, false, false)
+		writeDigestParam(&b, "nc", fmt.Sprintf("%08x", c.NC), false, false)
+		writeDigestParam(&b, "cnonce", c.Cnonce, true, false)
+	}
+	if c.Opaque != "" {
+		writeDigestParam(&b, "opaque", c.Opaque, true, false)
+	}
+	return b.String()
+}
+
+// DigestResponse computes digest response value.
+// For qop empty, RFC 2069 response is computed and nc and cnonce are ignored.
+// Body is only used for qop=auth-int
+func DigestResponse(algorithm string, username, realm, password string, method string, uri string, nonce string, nc uint32, cnonce string, qop string, body []byte) (string, error) {
+	ha1, err := DigestHA1(algorithm, username, realm, password, nonce, cnonce)
+	if err != nil {
+		return "", err
+	}
+	return DigestResponseHA1(algorithm, ha1, method, uri, nonce, nc, cnonce, qop, body)
+}
+
+// DigestHA1 computes HA1 value. For -sess algorithms nonce and cnonce are included.
+// It can be stored instead of plain password
+func DigestHA1(algorithm string, username, realm, password string, nonce string, cnonce string) (string, error) {
+	h, sess, err := digestHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	ha1 := digestHex(h, username+":"+realm+":"+password)
+	if sess {
+		return DigestSessHA1(algorithm, ha1, nonce, cnonce)
+	}
+	return ha1, nil
+}
+
+// DigestSessHA1 computes HA1 for -sess algorithms from HA1 of username, realm and password
+func DigestSessHA1(algorithm string, ha1 string, nonce string, cnonce string) (string, error) {
+	h, _, err := digestHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	return digestHex(h, ha1+":"+nonce+":"+cnonce), nil
+}
+
+// DigestResponseHA1 computes digest response value from already computed HA1
+func DigestResponseHA1(algorithm string, ha1 string, method string, uri string, nonce string, nc uint32, cnonce string, qop string, body []byte) (string, error) {
+	h, _, err := digestHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	var ha2 string
+	switch qop {
+	case "", DigestQopAuth:
+		ha2 = digestHex(h, method+":"+uri)
+	case DigestQopAuthInt:
+		ha2 = digestHex(h, method+":"+uri+":"+digestHex(h, string(body)))
+	default:
+		return "", fmt.Errorf("%w: %s", ErrDigestQopNotSupported, qop)
+	}
+
+	if qop == "" {
+		return digestHex(h, ha1+":"+nonce+":"+ha2), nil
+	}
+	return digestHex(h, ha1+":"+nonce+":"+fmt.Sprintf("%08x", nc)+":"+cnonce+":"+qop+":"+ha2), nil
+}
+
+func digestHash(algorithm string) (h func() hash.Hash, sess bool, err error) {
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5":
+		return md5.New, false, nil
+	case "MD5-SESS":
+		return md5.New, true, nil
+	case "SHA-256":
+		return sha256.New, false, nil
+	case "SHA-256-SESS":
+		return sha256.New, true, nil
+	case "SHA-512-256":
+		return sha512.New512_256, false, nil
+	case "SHA-512-256-SESS":
+		return sha512.New512_256, true, nil
+	}
+	return nil, false, fmt.Errorf("%w: %s", ErrDigestAlgorithmNotSupported, algorithm)
+}
+
+func digestHex(h func() hash.Hash, s string) string {
+	hh := h()
+	hh.Write([]byte(s))
+	return hex.EncodeToString(hh.Sum(nil))
+}
+
+func writeDigestParam(b *strings.Builder, key string, value string, quote bool, first bool) {
+	if !first {
+		b.WriteString(", ")
+	}
+	b.WriteString(key)
+	b.WriteString("=")
+	if quote {
+		b.WriteString("\"")
+		b.WriteString(value)
+		b.WriteString("\"")
+		return
+	}
+	b.WriteString(value)
+}
+
+// parseDigestParams parses auth-param list in format
+// Digest key1="value1", key2=value2
+// Keys are returned in lower case
+func parseDigestParams(value string) (map[string]string, error) {
+	value = strings.TrimSpace(value)
+	scheme, rest, _ := strings.Cut(value, " ")
+	if !strings.EqualFold(scheme, "Digest") {
+		return nil, fmt.Errorf("unsupported auth scheme %q", scheme)
+	}
+
+	params := make(map[string]string)
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("digest param missing value in %q", rest)
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimSpace(rest[eq+1:])
+
+		var val string
+		if strings.HasPrefix(rest, "\"") {
+			end := 1
+			for ; end < len(rest); end++ {
+				if rest[end] == '\\' {
+					end++
+					continue
+				}
+				if rest[end] == '"' {
+					break
+				}
+			}
+			if end >= len(rest) {
+				return nil, fmt.Errorf("digest param %q missing closing quote", key)
+			}
+			val = strings.ReplaceAll(rest[1:end], "\\", "")
+			rest = rest[end+1:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			val = strings.TrimSpace(rest[:end])
+			rest = rest[end:]
+		}
+		params[key] = val
+
+		rest = strings.TrimSpace(rest)
+		rest = strings.TrimPrefix(rest, ",")
+		rest = strings.TrimSpace(rest)
+	}
+	return params, nil
+}
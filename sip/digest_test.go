@@ -0,0 +1,67 @@
+package sip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestResponse(t *testing.T) {
+	t.Run("RFC2617", func(t *testing.T) {
+		res, err := DigestResponse(DigestAlgorithmMD5, "Mufasa", "testrealm@host.com", "Circle Of Life",
+			"GET", "/dir/index.html", "dcd98b7102dd2f0e8b11d0f600bfb0c093", 1, "0a4f113b", DigestQopAuth, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "6629fae49393a05397450978507c4ef1", res)
+	})
+
+	t.Run("RFC7616SHA256", func(t *testing.T) {
+		res, err := DigestResponse(DigestAlgorithmSHA256, "Mufasa", "http-auth@example.org", "Circle of Life",
+			"GET", "/dir/index.html", "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", 1, "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", DigestQopAuth, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1", res)
+	})
+
+	t.Run("NotSupported", func(t *testing.T) {
+		_, err := DigestResponse("SHA-1", "a", "b", "c", "REGISTER", "sip:b", "n", 1, "c", DigestQopAuth, nil)
+		assert.ErrorIs(t, err, ErrDigestAlgorithmNotSupported)
+
+		_, err = DigestResponse(DigestAlgorithmSHA512256, "a", "b", "c", "REGISTER", "sip:b", "n", 1, "c", "auth-conf", nil)
+		assert.ErrorIs(t, err, ErrDigestQopNotSupported)
+	})
+}
+
+func TestDigestParse(t *testing.T) {
+	chal, err := ParseDigestChallenge(`Digest realm="sip.example.com", nonce="abc,def", qop="auth,auth-int", algorithm=SHA-256, opaque="xyz", stale=TRUE`)
+	require.NoError(t, err)
+	assert.Equal(t, "sip.example.com", chal.Realm)
+	assert.Equal(t, "abc,def", chal.Nonce)
+	assert.Equal(t, []string{"auth", "auth-int"}, chal.Qop)
+	assert.Equal(t, "SHA-256", chal.Algorithm)
+	assert.Equal(t, "xyz", chal.Opaque)
+	assert.True(t, chal.Stale)
+
+	chal2, err := ParseDigestChallenge(chal.String())
+	require.NoError(t, err)
+	assert.Equal(t, chal, chal2)
+
+	cred := &DigestCredentials{
+		Username:  "alice",
+		Realm:     "sip.example.com",
+		Nonce:     "abc",
+		URI:       "sip:sip.example.com",
+		Response:  "123",
+		Algorithm: "MD5",
+		Cnonce:    "cn",
+		Qop:       "auth",
+		NC:        10,
+	}
+	assert.Contains(t, cred.String(), "nc=0000000a")
+
+	cred2, err := ParseDigestCredentials(cred.String())
+	require.NoError(t, err)
+	assert.Equal(t, cred, cred2)
+
+	_, err = ParseDigestChallenge(`Basic realm="x"`)
+	assert.Error(t, err)
+}
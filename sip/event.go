@@ -0,0 +1,152 @@
+package sip
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SIP-Specific Event Notification RFC 6665
+// https://datatracker.ietf.org/doc/html/rfc6665
+const (
+	SubscriptionStateActive     = "active"
+	SubscriptionStatePending    = "pending"
+	SubscriptionStateTerminated = "terminated"
+
+	// Reasons of terminated subscription
+	// https://datatracker.ietf.org/doc/html/rfc6665#section-4.1.3
+	SubscriptionReasonDeactivated = "deactivated"
+	SubscriptionReasonProbation   = "probation"
+	SubscriptionReasonRejected    = "rejected"
+	SubscriptionReasonTimeout     = "timeout"
+	SubscriptionReasonGiveUp      = "giveup"
+	SubscriptionReasonNoResource  = "noresource"
+	SubscriptionReasonInvariant   = "invariant"
+)
+
+// Event is value of Event header. Package and ID identify subscription within dialog.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-8.2.1
+type Event struct {
+	Package string
+	ID      string
+}
+
+func (e Event) String() string {
+	if e.ID == "" {
+		return e.Package
+	}
+	return e.Package + ";id=" + e.ID
+}
+
+// Equal compares events. Package is compared case insensitive, ID case sensitive.
+func (e Event) Equal(other Event) bool {
+	return strings.EqualFold(e.Package, other.Package) && e.ID == other.ID
+}
+
+// ParseEvent parses Event header value in format "<package>[;id=<id>]". Other parameters are ignored.
+func ParseEvent(value string) (Event, error) {
+	parts := strings.Split(value, ";")
+	e := Event{Package: strings.TrimSpace(parts[0])}
+	if e.Package == "" {
+		return Event{}, fmt.Errorf("invalid Event %q: missing package", value)
+	}
+	for _, p := range parts[1:] {
+		name, val, _ := strings.Cut(strings.TrimSpace(p), "=")
+		if strings.EqualFold(strings.TrimSpace(name), "id") {
+			e.ID = strings.TrimSpace(val)
+		}
+	}
+	return e, nil
+}
+
+// MessageEvent returns Event of message, also in compact form. Returned bool is false if header is not present
+func MessageEvent(msg Message) (Event, bool, error) {
+	hdrs := msg.GetHeaders("Event")
+	if len(hdrs) == 0 {
+		// Compact form is not expanded by parser
+		hdrs = msg.GetHeaders("o")
+	}
+	if len(hdrs) == 0 {
+		return Event{}, false, nil
+	}
+	e, err := ParseEvent(hdrs[0].Value())
+	return e, true, err
+}
+
+// SubscriptionState is value of Subscription-State header
+// https://datatracker.ietf.org/doc/html/rfc6665#section-8.2.3
+type SubscriptionState struct {
+	// State is active, pending or terminated
+	State string
+	// Reason is set only for terminated subscription
+	Reason string
+	// Expires is remaining duration of subscription in seconds, 0 if not present
+	Expires uint32
+	// RetryAfter is time in seconds after which subscriber can subscribe again, 0 if not present
+	RetryAfter uint32
+}
+
+func (s SubscriptionState) String() string {
+	val := s.State
+	if s.Reason != "" {
+		val += ";reason=" + s.Reason
+	}
+	if s.Expires > 0 {
+		val += ";expires=" + strconv.FormatUint(uint64(s.Expires), 10)
+	}
+	if s.RetryAfter > 0 {
+		val += ";retry-after=" + strconv.FormatUint(uint64(s.RetryAfter), 10)
+	}
+	return val
+}
+
+// CanRetry reports can subscriber create new subscription after subscription is terminated with reason.
+// Subscription terminated with unknown or missing reason can be retried.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.1.3
+func (s SubscriptionState) CanRetry() bool {
+	switch s.Reason {
+	case SubscriptionReasonRejected, SubscriptionReasonNoResource, SubscriptionReasonInvariant:
+		return false
+	}
+	return true
+}
+
+// ParseSubscriptionState parses Subscription-State header value in format
+// "<state>[;reason=<reason>][;expires=<delta>][;retry-after=<delta>]". Unknown parameters are ignored.
+func ParseSubscriptionState(value string) (SubscriptionState, error) {
+	parts := strings.Split(value, ";")
+	s := SubscriptionState{State: strings.ToLower(strings.TrimSpace(parts[0]))}
+	if s.State == "" {
+		return SubscriptionState{}, fmt.Errorf("invalid Subscription-State %q: missing state", value)
+	}
+
+	for _, p := range parts[1:] {
+		name, val, _ := strings.Cut(strings.TrimSpace(p), "=")
+		name, val = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(val)
+		switch name {
+		case "reason":
+			s.Reason = strings.ToLower(val)
+		case "expires", "retry-after":
+			delta, err := strconv.ParseUint(val, 10, 32)
+			if err != nil {
+				return SubscriptionState{}, fmt.Errorf("invalid Subscription-State %s %q: %w", name, val, err)
+			}
+			if name == "expires" {
+				s.Expires = uint32(delta)
+			} else {
+				s.RetryAfter = uint32(delta)
+			}
+		}
+	}
+	return s, nil
+}
+
+// MessageSubscriptionState returns Subscription-State of message. Returned bool is false if header is not present
+func MessageSubscriptionState(msg Message) (SubscriptionState, bool, error) {
+	hdrs := msg.GetHeaders("Subscription-State")
+	if len(hdrs) == 0 {
+		return SubscriptionState{}, false, nil
+	}
+	s, err := ParseSubscriptionState(hdrs[0].Value())
+	return s, true, err
+}
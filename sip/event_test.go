@@ -0,0 +1,46 @@
+package sip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEvent(t *testing.T) {
+	e, err := ParseEvent("presence ; id=1234;foo=bar")
+	require.NoError(t, err)
+	assert.Equal(t, Event{Package: "presence", ID: "1234"}, e)
+	assert.Equal(t, "presence;id=1234", e.String())
+	assert.True(t, e.Equal(Event{Package: "Presence", ID: "1234"}))
+	assert.False(t, e.Equal(Event{Package: "presence"}))
+
+	_, err = ParseEvent(";id=1")
+	require.Error(t, err)
+
+	req := NewRequest(NOTIFY, Uri{Host: "example.com"})
+	req.AppendHeader(NewHeader("o", "dialog"))
+	e, ok, err := MessageEvent(req)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, Event{Package: "dialog"}, e)
+}
+
+func TestParseSubscriptionState(t *testing.T) {
+	s, err := ParseSubscriptionState("Active;expires=600")
+	require.NoError(t, err)
+	assert.Equal(t, SubscriptionState{State: SubscriptionStateActive, Expires: 600}, s)
+	assert.Equal(t, "active;expires=600", s.String())
+
+	s, err = ParseSubscriptionState("terminated;reason=probation;retry-after=30")
+	require.NoError(t, err)
+	assert.Equal(t, SubscriptionState{State: SubscriptionStateTerminated, Reason: SubscriptionReasonProbation, RetryAfter: 30}, s)
+	assert.True(t, s.CanRetry())
+
+	s, err = ParseSubscriptionState("terminated;reason=rejected")
+	require.NoError(t, err)
+	assert.False(t, s.CanRetry())
+
+	_, err = ParseSubscriptionState("active;expires=soon")
+	require.Error(t, err)
+}
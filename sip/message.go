@@ -19,7 +19,8 @@ const (
 	StatusQueued            StatusCode = 182
 	StatusSessionInProgress StatusCode = 183
 
-	StatusOK StatusCode = 200
+	StatusOK       StatusCode = 200
+	StatusAccepted StatusCode = 202
 
 	StatusMovedPermanently StatusCode = 301
 	StatusMovedTemporarily StatusCode = 302
@@ -42,7 +43,10 @@ const (
 	StatusRequestedRangeNotSatisfiable StatusCode = 416
 	StatusBadExtension                 StatusCode = 420
 	StatusExtensionRequired            StatusCode = 421
+	StatusSessionIntervalTooSmall      StatusCode = 422
 	StatusIntervalToBrief              StatusCode = 423
+	StatusFlowFailed                   StatusCode = 430
+	StatusFirstHopLacksOutbound        StatusCode = 439
 	StatusTemporarilyUnavailable       StatusCode = 480
 	StatusCallTransactionDoesNotExists StatusCode = 481
 	StatusLoopDetected                 StatusCode = 482
@@ -52,6 +56,7 @@ const (
 	StatusBusyHere                     StatusCode = 486
 	StatusRequestTerminated            StatusCode = 487
 	StatusNotAcceptableHere            StatusCode = 488
+	StatusBadEvent                     StatusCode = 489
 
 	StatusInternalServerError StatusCode = 500
 	StatusNotImplemented      StatusCode = 501
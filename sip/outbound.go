@@ -0,0 +1,87 @@
+package sip
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// SIP Outbound RFC 5626
+// https://datatracker.ietf.org/doc/html/rfc5626
+const (
+	// OptionTagOutbound is option tag for client initiated connections
+	OptionTagOutbound = "outbound"
+	// OptionTagPath is option tag for Path header. RFC 3327
+	OptionTagPath = "path"
+
+	// ParamInstance is Contact parameter with instance ID of user agent
+	ParamInstance = "+sip.instance"
+	// ParamRegID is Contact parameter with registration flow ID
+	ParamRegID = "reg-id"
+	// ParamOutbound is URI parameter marking outbound proxy or edge proxy Path
+	ParamOutbound = "ob"
+)
+
+// NewInstanceID generates instance ID as UUID URN
+// https://datatracker.ietf.org/doc/html/rfc5626#section-4.1
+func NewInstanceID() string {
+	return "urn:uuid:" + uuid.NewString()
+}
+
+// SetContactOutbound adds +sip.instance and reg-id parameters to Contact.
+// Instance is URN without brackets, for example generated with NewInstanceID.
+// Registration flows must use different regID starting from 1.
+// https://datatracker.ietf.org/doc/html/rfc5626#section-4.2
+func SetContactOutbound(c *ContactHeader, instance string, regID int) {
+	if c.Params == nil {
+		c.Params = NewParams()
+	}
+	c.Params.Add(ParamInstance, `"<`+instance+`>"`)
+	c.Params.Add(ParamRegID, strconv.Itoa(regID))
+}
+
+// ContactInstance returns instance ID of Contact without quotes and brackets.
+// Empty string is returned if parameter is not present
+func ContactInstance(c *ContactHeader) string {
+	if c.Params == nil {
+		return ""
+	}
+	val, _ := c.Params.Get(ParamInstance)
+	val = strings.Trim(val, `"`)
+	val = strings.TrimPrefix(val, "<")
+	val = strings.TrimSuffix(val, ">")
+	return val
+}
+
+// ContactRegID returns reg-id of Contact. Returned bool is false if parameter is not present
+func ContactRegID(c *ContactHeader) (int, bool, error) {
+	if c.Params == nil {
+		return 0, false, nil
+	}
+	val, ok := c.Params.Get(ParamRegID)
+	if !ok {
+		return 0, false, nil
+	}
+	id, err := strconv.Atoi(val)
+	if err != nil || id <= 0 {
+		return 0, true, fmt.Errorf("invalid reg-id %q", val)
+	}
+	return id, true, nil
+}
+
+// MessageFlowTimer returns Flow-Timer of message in seconds. Returned bool is false if header is not present
+// https://datatracker.ietf.org/doc/html/rfc5626#section-10
+func MessageFlowTimer(msg Message) (uint32, bool, error) {
+	hdrs := msg.GetHeaders("Flow-Timer")
+	if len(hdrs) == 0 {
+		return 0, false, nil
+	}
+	h := hdrs[0]
+	val, err := strconv.ParseUint(strings.TrimSpace(h.Value()), 10, 32)
+	if err != nil {
+		return 0, true, fmt.Errorf("invalid Flow-Timer %q: %w", h.Value(), err)
+	}
+	return uint32(val), true, nil
+}
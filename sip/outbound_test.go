@@ -0,0 +1,35 @@
+package sip
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContactOutbound(t *testing.T) {
+	instance := NewInstanceID()
+	assert.True(t, strings.HasPrefix(instance, "urn:uuid:"))
+
+	c := &ContactHeader{Address: Uri{User: "alice", Host: "10.0.0.1"}}
+	SetContactOutbound(c, instance, 2)
+	// Params are not ordered
+	assert.True(t, strings.HasPrefix(c.Value(), "<sip:alice@10.0.0.1>;"))
+	assert.Contains(t, c.Value(), `;+sip.instance="<`+instance+`>"`)
+	assert.Contains(t, c.Value(), ";reg-id=2")
+	assert.Equal(t, instance, ContactInstance(c))
+
+	id, ok, err := ContactRegID(c)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 2, id)
+
+	c.Params.Add(ParamRegID, "0")
+	_, _, err = ContactRegID(c)
+	require.Error(t, err)
+
+	_, ok, err = ContactRegID(&ContactHeader{})
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,119 @@
+package sip
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Call transfer with REFER RFC 3515
+// https://datatracker.ietf.org/doc/html/rfc3515
+const (
+	// EventRefer is event package of implicit subscription created by REFER
+	EventRefer = "refer"
+	// ContentTypeSipfrag is content type of NOTIFY body reporting progress of referred request. RFC 3420
+	ContentTypeSipfrag = "message/sipfrag;version=2.0"
+)
+
+// MessageReferTo returns URI of Refer-To, also in compact form. Returned bool is false if header is not present.
+// URI headers, like Replaces, are kept escaped. Use UriHeader to read them.
+func MessageReferTo(msg Message) (Uri, bool, error) {
+	return messageAddress(msg, "Refer-To", "r")
+}
+
+// MessageReferredBy returns URI of Referred-By, also in compact form. Returned bool is false if header is not present
+// https://datatracker.ietf.org/doc/html/rfc3892
+func MessageReferredBy(msg Message) (Uri, bool, error) {
+	return messageAddress(msg, "Referred-By", "b")
+}
+
+func messageAddress(msg Message, name string, compact string) (Uri, bool, error) {
+	hdrs := msg.GetHeaders(name)
+	if len(hdrs) == 0 {
+		// Compact form is not expanded by parser
+		hdrs = msg.GetHeaders(compact)
+	}
+	if len(hdrs) == 0 {
+		return Uri{}, false, nil
+	}
+	if len(hdrs) > 1 {
+		return Uri{}, true, fmt.Errorf("multiple %s headers", name)
+	}
+
+	var uri Uri
+	if _, err := ParseAddressValue(hdrs[0].Value(), &uri, nil); err != nil {
+		return Uri{}, true, fmt.Errorf("invalid %s %q: %w", name, hdrs[0].Value(), err)
+	}
+	return uri, true, nil
+}
+
+// UriSetHeader sets URI header, which becomes header of request sent to URI. Value is escaped.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-19.1.1
+func UriSetHeader(uri *Uri, name string, value string) {
+	if uri.Headers == nil {
+		uri.Headers = NewParams()
+	}
+	uri.Headers.Add(name, uriEscapeHeader(value))
+}
+
+// UriHeader returns unescaped value of URI header
+func UriHeader(uri Uri, name string) (string, bool) {
+	if uri.Headers == nil {
+		return "", false
+	}
+	for _, k := range uri.Headers.Keys() {
+		if !strings.EqualFold(k, name) {
+			continue
+		}
+		val, _ := uri.Headers.Get(k)
+		if unescaped, err := url.PathUnescape(val); err == nil {
+			val = unescaped
+		}
+		return val, true
+	}
+	return "", false
+}
+
+// uriEscapeHeader escapes all characters not allowed in hvalue of URI header
+func uriEscapeHeader(value string) string {
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+			sb.WriteByte(c)
+		case strings.IndexByte("-_.!~*'()[]/?:+$", c) >= 0:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// NewSipfrag creates message/sipfrag body with status line of response
+// https://datatracker.ietf.org/doc/html/rfc3515#section-2.4.5
+func NewSipfrag(code StatusCode, reason string) []byte {
+	return []byte("SIP/2.0 " + strconv.Itoa(int(code)) + " " + reason + "\r\n")
+}
+
+// ParseSipfrag returns status code and reason of status line in message/sipfrag body
+func ParseSipfrag(body []byte) (StatusCode, string, error) {
+	line, _, err := bufio.NewReader(bytes.NewReader(body)).ReadLine()
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid sipfrag: %w", err)
+	}
+	version, status, _ := strings.Cut(string(line), " ")
+	if !strings.EqualFold(version, "SIP/2.0") {
+		return 0, "", fmt.Errorf("invalid sipfrag status line %q", line)
+	}
+	codeStr, reason, _ := strings.Cut(status, " ")
+	code, err := strconv.Atoi(codeStr)
+	if err != nil || code < 100 || code > 699 {
+		return 0, "", fmt.Errorf("invalid sipfrag status line %q", line)
+	}
+	return StatusCode(code), reason, nil
+}
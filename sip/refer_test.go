@@ -0,0 +1,54 @@
+package sip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReferTo(t *testing.T) {
+	replaces := Replaces{CallID: "abc@10.0.0.1", ToTag: "1234", FromTag: "5678"}
+	target := Uri{User: "carol", Host: "example.com"}
+	UriSetHeader(&target, "Replaces", replaces.String())
+	assert.Equal(t, "sip:carol@example.com?Replaces=abc%4010.0.0.1%3Bto-tag%3D1234%3Bfrom-tag%3D5678", target.String())
+
+	req := NewRequest(REFER, Uri{Host: "example.com"})
+	req.AppendHeader(NewHeader("r", "<"+target.String()+">"))
+	req.AppendHeader(NewHeader("Referred-By", "<sip:alice@example.com>"))
+	referTo, ok, err := MessageReferTo(req)
+	require.NoError(t, err)
+	require.True(t, ok)
+	val, ok := UriHeader(referTo, "replaces")
+	require.True(t, ok)
+	parsed, err := ParseReplaces(val)
+	require.NoError(t, err)
+	assert.Equal(t, replaces, parsed)
+
+	referredBy, ok, err := MessageReferredBy(req)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "alice", referredBy.User)
+
+	_, err = ParseReplaces("abc;to-tag=1")
+	require.Error(t, err)
+	parsed, err = ParseReplaces("abc;from-tag=2;to-tag=1;early-only")
+	require.NoError(t, err)
+	assert.True(t, parsed.EarlyOnly)
+}
+
+func TestSipfrag(t *testing.T) {
+	body := NewSipfrag(StatusRinging, "Ringing")
+	assert.Equal(t, "SIP/2.0 180 Ringing\r\n", string(body))
+	code, reason, err := ParseSipfrag(body)
+	require.NoError(t, err)
+	assert.Equal(t, StatusRinging, code)
+	assert.Equal(t, "Ringing", reason)
+
+	code, _, err = ParseSipfrag([]byte("SIP/2.0 503 Service Unavailable\r\nRetry-After: 5\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, StatusServiceUnavailable, code)
+
+	_, _, err = ParseSipfrag([]byte("INVITE sip:bob@example.com SIP/2.0\r\n"))
+	require.Error(t, err)
+}
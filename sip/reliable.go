@@ -0,0 +1,150 @@
+package sip
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// OptionTag100rel is option tag for reliable provisional responses
+// https://datatracker.ietf.org/doc/html/rfc3262
+const OptionTag100rel = "100rel"
+
+// RAck is value of RAck header, sent in PRACK to acknowledge reliable provisional response
+// https://datatracker.ietf.org/doc/html/rfc3262#section-7.2
+type RAck struct {
+	RSeq   uint32
+	CSeq   uint32
+	Method RequestMethod
+}
+
+func (r RAck) String() string {
+	return fmt.Sprintf("%d %d %s", r.RSeq, r.CSeq, r.Method)
+}
+
+// ParseRAck parses RAck header value in format "<rseq> <cseq> <method>"
+func ParseRAck(value string) (RAck, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return RAck{}, fmt.Errorf("invalid RAck %q", value)
+	}
+
+	rseq, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return RAck{}, fmt.Errorf("invalid RAck response num %q: %w", fields[0], err)
+	}
+
+	cseq, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return RAck{}, fmt.Errorf("invalid RAck CSeq num %q: %w", fields[1], err)
+	}
+
+	return RAck{
+		RSeq:   uint32(rseq),
+		CSeq:   uint32(cseq),
+		Method: RequestMethod(strings.ToUpper(fields[2])),
+	}, nil
+}
+
+// HasOptionTag checks is option tag listed in headers like Supported or Require.
+// Header values can be comma separated and multiple headers are checked.
+func HasOptionTag(msg Message, headerName string, tag string) bool {
+	for _, h := range msg.GetHeaders(headerName) {
+		for _, v := range strings.Split(h.Value(), ",") {
+			if strings.EqualFold(strings.TrimSpace(v), tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ResponseRSeq returns RSeq header value of response
+func ResponseRSeq(res *Response) (uint32, error) {
+	h := res.GetHeader("RSeq")
+	if h == nil {
+		return 0, fmt.Errorf("missing RSeq header")
+	}
+
+	rseq, err := strconv.ParseUint(strings.TrimSpace(h.Value()), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid RSeq %q: %w", h.Value(), err)
+	}
+	return uint32(rseq), nil
+}
+
+// IsReliableProvisional checks is response reliable provisional response, which needs to be acknowledged with PRACK.
+// https://datatracker.ietf.org/doc/html/rfc3262#section-4
+func IsReliableProvisional(res *Response) bool {
+	if !res.IsProvisional() || res.StatusCode == StatusTrying {
+		return false
+	}
+	return res.GetHeader("RSeq") != nil && HasOptionTag(res, "Require", OptionTag100rel)
+}
+
+// NewPrackRequest creates PRACK request for reliable provisional response of INVITE.
+// PRACK is sent within early dialog created by response, so cseq must be next local CSeq of that dialog.
+// https://datatracker.ietf.org/doc/html/rfc3262#section-4
+func NewPrackRequest(inviteRequest *Request, inviteResponse *Response, cseq uint32) (*Request, error) {
+	rseq, err := ResponseRSeq(inviteResponse)
+	if err != nil {
+		return nil, err
+	}
+	inviteCSeq := inviteRequest.CSeq()
+	if inviteCSeq == nil {
+		return nil, fmt.Errorf("missing CSeq header")
+	}
+
+	recipient := inviteRequest.Recipient
+	if contact := inviteResponse.Contact(); contact != nil {
+		recipient = contact.Address
+	}
+
+	prackRequest := NewRequest(PRACK, *recipient.Clone())
+	prackRequest.SipVersion = inviteRequest.SipVersion
+
+	if via := inviteRequest.Via(); via != nil {
+		// PRACK is separate transaction
+		viaHop := via.Clone()
+		viaHop.Params.Add("branch", GenerateBranch())
+		prackRequest.AppendHeader(viaHop)
+	}
+
+	// Destination of INVITE is kept only if PRACK is routed same way
+	keepDestination := true
+	if len(inviteRequest.GetHeaders("Route")) > 0 {
+		CopyHeaders("Route", inviteRequest, prackRequest)
+	} else {
+		hdrs := inviteResponse.GetHeaders("Record-Route")
+		for i := len(hdrs) - 1; i >= 0; i-- {
+			h := NewHeader("Route", hdrs[i].Value())
+			prackRequest.AppendHeader(h)
+		}
+		keepDestination = len(hdrs) == 0 && inviteResponse.Contact() == nil
+	}
+
+	maxForwardsHeader := MaxForwardsHeader(70)
+	prackRequest.AppendHeader(&maxForwardsHeader)
+	if h := inviteRequest.From(); h != nil {
+		prackRequest.AppendHeader(HeaderClone(h))
+	}
+
+	if h := inviteResponse.To(); h != nil {
+		prackRequest.AppendHeader(HeaderClone(h))
+	}
+
+	if h := inviteRequest.CallID(); h != nil {
+		prackRequest.AppendHeader(HeaderClone(h))
+	}
+
+	prackRequest.AppendHeader(&CSeqHeader{SeqNo: cseq, MethodName: PRACK})
+	rack := RAck{RSeq: rseq, CSeq: inviteCSeq.SeqNo, Method: inviteCSeq.MethodName}
+	prackRequest.AppendHeader(NewHeader("RAck", rack.String()))
+
+	prackRequest.SetTransport(inviteRequest.Transport())
+	prackRequest.SetSource(inviteRequest.Source())
+	if keepDestination {
+		prackRequest.SetDestination(inviteRequest.Destination())
+	}
+	return prackRequest, nil
+}
@@ -0,0 +1,30 @@
+package sip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRAck(t *testing.T) {
+	rack, err := ParseRAck("776656 1 INVITE")
+	require.NoError(t, err)
+	assert.Equal(t, RAck{RSeq: 776656, CSeq: 1, Method: INVITE}, rack)
+	assert.Equal(t, "776656 1 INVITE", rack.String())
+
+	_, err = ParseRAck("776656 INVITE")
+	require.Error(t, err)
+	_, err = ParseRAck("abc 1 INVITE")
+	require.Error(t, err)
+}
+
+func TestHasOptionTag(t *testing.T) {
+	req := NewRequest(INVITE, Uri{})
+	req.AppendHeader(NewHeader("Supported", "timer, 100REL"))
+	req.AppendHeader(NewHeader("Require", "replaces"))
+
+	assert.True(t, HasOptionTag(req, "Supported", OptionTag100rel))
+	assert.True(t, HasOptionTag(req, "Supported", "timer"))
+	assert.False(t, HasOptionTag(req, "Require", OptionTag100rel))
+}
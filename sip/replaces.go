@@ -0,0 +1,120 @@
+package sip
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Replaces is value of Replaces header identifying dialog which is replaced.
+// Tags are from perspective of recipient: ToTag is its local tag and FromTag is its remote tag.
+// https://datatracker.ietf.org/doc/html/rfc3891#section-6.1
+type Replaces struct {
+	CallID  string
+	ToTag   string
+	FromTag string
+	// EarlyOnly allows replacing only early dialog
+	EarlyOnly bool
+}
+
+func (r Replaces) String() string {
+	val := r.CallID + ";to-tag=" + r.ToTag + ";from-tag=" + r.FromTag
+	if r.EarlyOnly {
+		val += ";early-only"
+	}
+	return val
+}
+
+// ParseReplaces parses Replaces header value in format "<callid>;to-tag=<tag>;from-tag=<tag>[;early-only]"
+func ParseReplaces(value string) (Replaces, error) {
+	callID, toTag, fromTag, params, err := parseDialogTags(value)
+	if err != nil {
+		return Replaces{}, fmt.Errorf("invalid Replaces %q: %w", value, err)
+	}
+	r := Replaces{CallID: callID, ToTag: toTag, FromTag: fromTag}
+	for _, p := range params {
+		if strings.EqualFold(p, "early-only") {
+			r.EarlyOnly = true
+		}
+	}
+	return r, nil
+}
+
+// MessageReplaces returns Replaces of message. Returned bool is false if header is not present.
+// Multiple Replaces headers are error.
+func MessageReplaces(msg Message) (Replaces, bool, error) {
+	hdrs := msg.GetHeaders("Replaces")
+	switch len(hdrs) {
+	case 0:
+		return Replaces{}, false, nil
+	case 1:
+	default:
+		return Replaces{}, true, fmt.Errorf("multiple Replaces headers")
+	}
+	r, err := ParseReplaces(hdrs[0].Value())
+	return r, true, err
+}
+
+// Join is value of Join header identifying dialog which new dialog joins, for example into conference.
+// Tags are from perspective of recipient same as in Replaces.
+// https://datatracker.ietf.org/doc/html/rfc3911#section-7.1
+type Join struct {
+	CallID  string
+	ToTag   string
+	FromTag string
+}
+
+func (j Join) String() string {
+	return j.CallID + ";to-tag=" + j.ToTag + ";from-tag=" + j.FromTag
+}
+
+// ParseJoin parses Join header value in format "<callid>;to-tag=<tag>;from-tag=<tag>"
+func ParseJoin(value string) (Join, error) {
+	callID, toTag, fromTag, _, err := parseDialogTags(value)
+	if err != nil {
+		return Join{}, fmt.Errorf("invalid Join %q: %w", value, err)
+	}
+	return Join{CallID: callID, ToTag: toTag, FromTag: fromTag}, nil
+}
+
+// MessageJoin returns Join of message. Returned bool is false if header is not present.
+// Multiple Join headers are error.
+func MessageJoin(msg Message) (Join, bool, error) {
+	hdrs := msg.GetHeaders("Join")
+	switch len(hdrs) {
+	case 0:
+		return Join{}, false, nil
+	case 1:
+	default:
+		return Join{}, true, fmt.Errorf("multiple Join headers")
+	}
+	j, err := ParseJoin(hdrs[0].Value())
+	return j, true, err
+}
+
+// parseDialogTags parses Call-ID with to-tag and from-tag parameters. Other parameters are returned as they are.
+func parseDialogTags(value string) (callID string, toTag string, fromTag string, params []string, err error) {
+	parts := strings.Split(value, ";")
+	callID = strings.TrimSpace(parts[0])
+	hasTo, hasFrom := false, false
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		name, val, _ := strings.Cut(p, "=")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "to-tag":
+			toTag, hasTo = strings.TrimSpace(val), true
+		case "from-tag":
+			fromTag, hasFrom = strings.TrimSpace(val), true
+		default:
+			params = append(params, p)
+		}
+	}
+	switch {
+	case callID == "":
+		return "", "", "", nil, fmt.Errorf("missing Call-ID")
+	case !hasTo:
+		return "", "", "", nil, fmt.Errorf("missing to-tag")
+	case !hasFrom:
+		return "", "", "", nil, fmt.Errorf("missing from-tag")
+	}
+	return callID, toTag, fromTag, params, nil
+}
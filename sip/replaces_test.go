@@ -0,0 +1,40 @@
+package sip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplacesJoin(t *testing.T) {
+	req := NewRequest(INVITE, Uri{User: "bob", Host: "example.com"})
+	_, ok, err := MessageReplaces(req)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	req.AppendHeader(NewHeader("Replaces", "98732@sip.example.com; from-tag=r33th4x0r ;to-tag=ff87ff;early-only"))
+	r, ok, err := MessageReplaces(req)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, Replaces{CallID: "98732@sip.example.com", ToTag: "ff87ff", FromTag: "r33th4x0r", EarlyOnly: true}, r)
+	assert.Equal(t, "98732@sip.example.com;to-tag=ff87ff;from-tag=r33th4x0r;early-only", r.String())
+
+	req.AppendHeader(NewHeader("Replaces", r.String()))
+	_, _, err = MessageReplaces(req)
+	require.Error(t, err)
+
+	req.AppendHeader(NewHeader("Join", "12adf2f34456gs5;to-tag=12345;from-tag=54321"))
+	j, ok, err := MessageJoin(req)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, Join{CallID: "12adf2f34456gs5", ToTag: "12345", FromTag: "54321"}, j)
+	assert.Equal(t, "12adf2f34456gs5;to-tag=12345;from-tag=54321", j.String())
+
+	for _, val := range []string{"", ";to-tag=1;from-tag=2", "abc;from-tag=2", "abc;to-tag=1"} {
+		_, err := ParseJoin(val)
+		require.Error(t, err, val)
+		_, err = ParseReplaces(val)
+		require.Error(t, err, val)
+	}
+}
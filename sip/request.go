@@ -40,7 +40,7 @@ func NewAckRequest(inviteRequest *Request, inviteResponse *Response, body []byte
 	} else {
 		hdrs := inviteResponse.GetHeaders("Record-Route")
 		for i := len(hdrs) - 1; i >= 0; i-- {
-			h := HeaderClone(hdrs[i])
+			h := NewHeader("Route", hdrs[i].Value())
 			ackRequest.AppendHeader(h)
 		}
 	}
@@ -126,7 +126,9 @@ func NewCancelRequest(requestForCancel *Request) *Request {
 }
 
 // NewByeRequest creates bye request from invite
-// TODO do some testing
+// NOTE: CSeq is only INVITE CSeq increased by one, which is not correct if there were
+// other requests within dialog.
+// Deprecated: use DialogClientSession.Bye, which tracks dialog CSeq, remote target and route set
 func NewByeRequest(inviteRequest *Request, inviteResponse *Response, body []byte) *Request {
 	Recipient := inviteRequest.Recipient
 
@@ -147,7 +149,7 @@ func NewByeRequest(inviteRequest *Request, inviteResponse *Response, body []byte
 	} else {
 		hdrs := inviteResponse.GetHeaders("Record-Route")
 		for i := len(hdrs) - 1; i >= 0; i-- {
-			h := HeaderClone(hdrs[i])
+			h := NewHeader("Route", hdrs[i].Value())
 			byeRequest.AppendHeader(h)
 		}
 	}
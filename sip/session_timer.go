@@ -0,0 +1,87 @@
+package sip
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Session timers RFC 4028
+// https://datatracker.ietf.org/doc/html/rfc4028
+const (
+	// OptionTagTimer is option tag for session timers
+	OptionTagTimer = "timer"
+	// MinSessionExpires is lowest session interval in seconds allowed by RFC 4028
+	MinSessionExpires uint32 = 90
+
+	RefresherUAC = "uac"
+	RefresherUAS = "uas"
+)
+
+// SessionExpires is value of Session-Expires header
+// https://datatracker.ietf.org/doc/html/rfc4028#section-4
+type SessionExpires struct {
+	// Delta is session interval in seconds
+	Delta uint32
+	// Refresher is "uac", "uas" or empty if not decided
+	Refresher string
+}
+
+func (se SessionExpires) String() string {
+	if se.Refresher == "" {
+		return strconv.FormatUint(uint64(se.Delta), 10)
+	}
+	return strconv.FormatUint(uint64(se.Delta), 10) + ";refresher=" + se.Refresher
+}
+
+// ParseSessionExpires parses Session-Expires header value in format "<delta>[;refresher=uac|uas]"
+func ParseSessionExpires(value string) (SessionExpires, error) {
+	parts := strings.Split(value, ";")
+	delta, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+	if err != nil {
+		return SessionExpires{}, fmt.Errorf("invalid Session-Expires %q: %w", value, err)
+	}
+
+	se := SessionExpires{Delta: uint32(delta)}
+	for _, p := range parts[1:] {
+		name, val, _ := strings.Cut(strings.TrimSpace(p), "=")
+		if strings.EqualFold(name, "refresher") {
+			se.Refresher = strings.ToLower(strings.TrimSpace(val))
+		}
+	}
+
+	switch se.Refresher {
+	case "", RefresherUAC, RefresherUAS:
+	default:
+		return SessionExpires{}, fmt.Errorf("invalid Session-Expires refresher %q", se.Refresher)
+	}
+	return se, nil
+}
+
+// MessageSessionExpires returns Session-Expires of message. Returned bool is false if header is not present
+func MessageSessionExpires(msg Message) (SessionExpires, bool, error) {
+	hdrs := msg.GetHeaders("Session-Expires")
+	if len(hdrs) == 0 {
+		return SessionExpires{}, false, nil
+	}
+	h := hdrs[0]
+	se, err := ParseSessionExpires(h.Value())
+	return se, true, err
+}
+
+// MessageMinSE returns Min-SE of message in seconds. Returned bool is false if header is not present
+func MessageMinSE(msg Message) (uint32, bool, error) {
+	hdrs := msg.GetHeaders("Min-SE")
+	if len(hdrs) == 0 {
+		return 0, false, nil
+	}
+	h := hdrs[0]
+
+	// Min-SE can have generic params, which are ignored
+	val, _, _ := strings.Cut(h.Value(), ";")
+	minSE, err := strconv.ParseUint(strings.TrimSpace(val), 10, 32)
+	if err != nil {
+		return 0, true, fmt.Errorf("invalid Min-SE %q: %w", h.Value(), err)
+	}
+	return uint32(minSE), true, nil
+}
@@ -0,0 +1,22 @@
+package sip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSessionExpires(t *testing.T) {
+	se, err := ParseSessionExpires("1800;refresher=uas")
+	require.NoError(t, err)
+	assert.Equal(t, SessionExpires{Delta: 1800, Refresher: RefresherUAS}, se)
+	assert.Equal(t, "1800;refresher=uas", se.String())
+
+	se, err = ParseSessionExpires("90")
+	require.NoError(t, err)
+	assert.Equal(t, SessionExpires{Delta: 90}, se)
+
+	_, err = ParseSessionExpires("1800;refresher=proxy")
+	require.Error(t, err)
+}
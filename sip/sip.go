@@ -67,6 +67,18 @@ func MakeDialogIDFromResponse(msg *Response) (string, error) {
 	return sipgo.MakeDialogIDFromResponse(msg)
 }
 
+// UASReadRequestDialogID creates dialog ID of message if receiver has UAS role.
+// returns error if callid or to tag or from tag does not exists
+func UASReadRequestDialogID(msg *Request) (string, error) {
+	return sipgo.UASReadRequestDialogID(msg)
+}
+
+// UACReadRequestDialogID creates dialog ID of message if receiver has UAC role.
+// returns error if callid or to tag or from tag does not exists
+func UACReadRequestDialogID(msg *Request) (string, error) {
+	return sipgo.UACReadRequestDialogID(msg)
+}
+
 // MakeDialogIDFromMessage creates dialog ID of message.
 // returns error if callid or to tag or from tag does not exists
 // Deprecated! Will be removed
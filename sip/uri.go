@@ -5,3 +5,14 @@ import (
 )
 
 type Uri = sipgo.Uri
+
+// ParseUri converts a string representation of a URI into a Uri object.
+func ParseUri(uriStr string, uri *Uri) error {
+	return sipgo.ParseUri(uriStr, uri)
+}
+
+// ParseAddressValue parses an address - such as from a From, To, or
+// Contact header. It returns display name and populates uri and headerParams
+func ParseAddressValue(addressText string, uri *Uri, headerParams HeaderParams) (string, error) {
+	return sipgo.ParseAddressValue(addressText, uri, headerParams)
+}
@@ -0,0 +1,557 @@
+package sipgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livekit/sipgo/sip"
+	"github.com/livekit/sipgo/transaction"
+)
+
+type SubscriberOption func(s *Subscriber)
+
+// WithSubscriberNotifyHandler sets handler called for every NOTIFY received within subscription,
+// after it is answered with 200 OK. Subscription terminated by NOTIFY is already ended.
+func WithSubscriberNotifyHandler(f func(sub *Subscription, req *sip.Request)) SubscriberOption {
+	return func(s *Subscriber) {
+		s.onNotify = f
+	}
+}
+
+// WithSubscriberForkHandler sets handler for subscriptions created by NOTIFY from other forks of SUBSCRIBE.
+// Without handler these subscriptions are unsubscribed.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.1.2.4
+func WithSubscriberForkHandler(f func(sub *Subscription)) SubscriberOption {
+	return func(s *Subscriber) {
+		s.onFork = f
+	}
+}
+
+// WithSubscriberClock sets clock used for refreshing subscriptions
+// Default: sip.SystemClock
+func WithSubscriberClock(c sip.Clock) SubscriberOption {
+	return func(s *Subscriber) {
+		s.clock = c
+	}
+}
+
+// Subscriber is subscriber handle. It sends SUBSCRIBE, creates subscriptions from 2xx response or NOTIFY,
+// whichever comes first, and refreshes them before they expire.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.1
+type Subscriber struct {
+	c          *Client
+	contactHDR sip.ContactHeader
+	log        *slog.Logger
+	clock      sip.Clock
+	onNotify   func(sub *Subscription, req *sip.Request)
+	onFork     func(sub *Subscription)
+
+	mu       sync.Mutex
+	requests map[string]*subscriberRequest // Call-ID and local tag -> SUBSCRIBE
+	subs     map[string]*Subscription      // dialog id -> subscription
+}
+
+// subscriberRequest matches NOTIFY to SUBSCRIBE that created subscription. Every fork of SUBSCRIBE
+// creates its own subscription with first NOTIFY.
+type subscriberRequest struct {
+	req   *sip.Request
+	event sip.Event
+	// main is subscription returned by WriteSubscribe
+	main *Subscription
+	subs map[string]*Subscription
+}
+
+// NewSubscriber creates subscriber handle. Contact header is added to SUBSCRIBE.
+// ReadNotify must be used as NOTIFY handler:
+//
+//	srv.OnNotify(func(req *sip.Request, tx sip.ServerTransaction) { subscriber.ReadNotify(req, tx) })
+func NewSubscriber(client *Client, contactHDR sip.ContactHeader, options ...SubscriberOption) *Subscriber {
+	s := &Subscriber{
+		c:          client,
+		contactHDR: contactHDR,
+		log:        slog.With("caller", "Subscriber"),
+		clock:      sip.SystemClock,
+		requests:   make(map[string]*subscriberRequest),
+		subs:       make(map[string]*Subscription),
+	}
+	for _, o := range options {
+		o(s)
+	}
+	return s
+}
+
+// Subscribe sends SUBSCRIBE for event to recipient and waits for final response.
+// Check WriteSubscribe for more details
+func (s *Subscriber) Subscribe(ctx context.Context, recipient sip.Uri, event sip.Event, headers ...sip.Header) (*Subscription, error) {
+	req := sip.NewRequest(sip.SUBSCRIBE, recipient)
+	req.AppendHeader(sip.NewHeader("Event", event.String()))
+	for _, h := range headers {
+		req.AppendHeader(h)
+	}
+	return s.WriteSubscribe(ctx, req)
+}
+
+// WriteSubscribe sends SUBSCRIBE request and waits for final response. Request must have Event header
+// and it can have Expires header, otherwise notifier chooses duration of subscription.
+// On 2xx response subscription is returned, which is refreshed until Unsubscribe is called or
+// notifier terminates it. On non 2xx final response ErrDialogResponse is returned.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.1.2.1
+func (s *Subscriber) WriteSubscribe(ctx context.Context, req *sip.Request) (*Subscription, error) {
+	event, ok, err := sip.MessageEvent(req)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("missing Event header")
+	}
+	if req.Contact() == nil {
+		req.AppendHeader(s.contactHDR.Clone())
+	}
+	// From tag and Call-ID must be known before sending, as NOTIFY can arrive before response
+	if err := clientRequestBuildReq(s.c, req); err != nil {
+		return nil, err
+	}
+	localTag, _ := req.From().Params.Get("tag")
+	key := req.CallID().Value() + ";" + localTag
+
+	sr := &subscriberRequest{req: req, event: event, subs: make(map[string]*Subscription)}
+	s.mu.Lock()
+	s.requests[key] = sr
+	s.mu.Unlock()
+
+	res, err := s.c.Do(ctx, req)
+	if err == nil && !res.IsSuccess() {
+		err = ErrDialogResponse{Res: res}
+	}
+
+	var sub *Subscription
+	if err == nil {
+		sub, err = s.onSuccess(sr, res)
+	}
+	if err != nil {
+		s.mu.Lock()
+		delete(s.requests, key)
+		subs := make([]*Subscription, 0, len(sr.subs))
+		for _, sub := range sr.subs {
+			subs = append(subs, sub)
+		}
+		s.mu.Unlock()
+		for _, sub := range subs {
+			sub.end()
+		}
+		return nil, err
+	}
+	return sub, nil
+}
+
+// onSuccess returns subscription for 2xx response. Subscription created by NOTIFY of same dialog
+// is returned if it exists.
+func (s *Subscriber) onSuccess(sr *subscriberRequest, res *sip.Response) (*Subscription, error) {
+	id, err := sip.MakeDialogIDFromResponse(res)
+	if err != nil {
+		return nil, err
+	}
+
+	expires := time.Duration(0)
+	if h := res.GetHeader("Expires"); h != nil {
+		sec, err := strconv.ParseUint(strings.TrimSpace(h.Value()), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Expires %q: %w", h.Value(), err)
+		}
+		expires = time.Duration(sec) * time.Second
+	}
+
+	s.mu.Lock()
+	sub, exists := sr.subs[id]
+	if !exists {
+		sub, err = s.newSubscription(sr, id)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		err = sub.establish(res)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		sr.subs[id] = sub
+		s.subs[id] = sub
+	}
+	fork := sr.main != nil && sr.main != sub
+	if sr.main == nil {
+		sr.main = sub
+	}
+	s.mu.Unlock()
+
+	// SUBSCRIBE could be resent with higher CSeq on digest challenge
+	sub.readResponse(sr.req, res)
+	if fork {
+		// Main subscription was created by NOTIFY from other fork
+		s.handleFork(sub)
+	}
+	if expires > 0 {
+		sub.setExpires(expires)
+	}
+	return sub, nil
+}
+
+// newSubscription creates subscription for dialog. Must be called with lock held.
+func (s *Subscriber) newSubscription(sr *subscriberRequest, id string) (*Subscription, error) {
+	cseq := sr.req.CSeq()
+	if cseq == nil {
+		return nil, fmt.Errorf("missing CSeq header")
+	}
+
+	sub := &Subscription{
+		dialogSession:    newDialogSession(s.c, id, s.deleteSubscription),
+		s:                s,
+		Event:            sr.event,
+		SubscribeRequest: sr.req,
+		state:            sip.SubscriptionState{State: sip.SubscriptionStatePending},
+	}
+	sub.callID = *sr.req.CallID()
+	sub.localHDR = *sr.req.From()
+	sub.contactHDR = sr.req.Contact()
+	sub.localCSeq = cseq.SeqNo
+	return sub, nil
+}
+
+// ReadNotify handles NOTIFY within subscription. NOTIFY from new fork of SUBSCRIBE creates new subscription.
+// Subscription is updated with Subscription-State and 200 OK is responded. Subscription terminated by
+// notifier is ended. For unknown subscription 481 is responded and ErrDialogDoesNotExists returned.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.1.3
+func (s *Subscriber) ReadNotify(req *sip.Request, tx sip.ServerTransaction) error {
+	sub, ss, err := s.matchNotify(req)
+	if err != nil {
+		var res *sip.Response
+		switch {
+		case errors.Is(err, ErrDialogDoesNotExists), errors.Is(err, ErrDialogInvalidCseq):
+			res = dialogErrorResponse(req, err)
+		default:
+			res = sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil)
+		}
+		if rerr := tx.Respond(res); rerr != nil {
+			return errors.Join(err, rerr)
+		}
+		return err
+	}
+
+	if ss.State == sip.SubscriptionStateTerminated {
+		sub.end()
+	} else if ss.Expires > 0 {
+		sub.setExpires(time.Duration(ss.Expires) * time.Second)
+	}
+
+	err = tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+	if s.onNotify != nil {
+		s.onNotify(sub, req)
+	}
+	return err
+}
+
+// matchNotify returns subscription of NOTIFY and its Subscription-State. Subscription is created
+// if NOTIFY is first one from fork of SUBSCRIBE.
+func (s *Subscriber) matchNotify(req *sip.Request) (*Subscription, sip.SubscriptionState, error) {
+	id, err := sip.UACReadRequestDialogID(req)
+	if err != nil {
+		return nil, sip.SubscriptionState{}, errors.Join(ErrDialogDoesNotExists, err)
+	}
+	event, ok, err := sip.MessageEvent(req)
+	if err != nil {
+		return nil, sip.SubscriptionState{}, err
+	}
+	if !ok {
+		return nil, sip.SubscriptionState{}, fmt.Errorf("missing Event header")
+	}
+	ss, ok, err := sip.MessageSubscriptionState(req)
+	if err != nil {
+		return nil, sip.SubscriptionState{}, err
+	}
+	if !ok {
+		return nil, sip.SubscriptionState{}, fmt.Errorf("missing Subscription-State header")
+	}
+
+	s.mu.Lock()
+	sub, exists := s.subs[id]
+	fork := false
+	if !exists {
+		localTag, _ := req.To().Params.Get("tag")
+		sr := s.requests[req.CallID().Value()+";"+localTag]
+		if sr == nil || !sr.event.Equal(event) {
+			s.mu.Unlock()
+			return nil, ss, ErrDialogDoesNotExists
+		}
+		if sub, err = s.newSubscription(sr, id); err == nil {
+			err = sub.establishNotify(req)
+		}
+		if err != nil {
+			s.mu.Unlock()
+			return nil, ss, err
+		}
+		sr.subs[id] = sub
+		s.subs[id] = sub
+		// Subscription created before 2xx response is main one, unless 2xx comes from other fork
+		fork = sr.main != nil
+	}
+	s.mu.Unlock()
+
+	if !sub.Event.Equal(event) {
+		return nil, ss, ErrDialogDoesNotExists
+	}
+	if exists {
+		if err := sub.readRequest(req); err != nil {
+			return nil, ss, err
+		}
+	}
+
+	sub.subMu.Lock()
+	sub.state = ss
+	sub.subMu.Unlock()
+
+	if fork {
+		s.handleFork(sub)
+	}
+	return sub, ss, nil
+}
+
+// handleFork passes subscription created by other fork to fork handler, or unsubscribes it
+func (s *Subscriber) handleFork(sub *Subscription) {
+	if s.onFork != nil {
+		s.onFork(sub)
+		return
+	}
+	go func() {
+		if err := sub.Unsubscribe(context.Background()); err != nil {
+			s.log.Info("Failed to unsubscribe forked subscription", "err", err, "id", sub.ID())
+		}
+	}()
+}
+
+// Subscription returns subscription by dialog ID
+func (s *Subscriber) Subscription(id string) (*Subscription, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[id]
+	return sub, ok
+}
+
+// UnsubscribeAll unsubscribes all subscriptions. It can be used on shutdown with UserAgent.OnShutdown
+func (s *Subscriber) UnsubscribeAll(ctx context.Context) error {
+	s.mu.Lock()
+	subs := make([]*Subscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		subs = append(subs, sub)
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	for _, sub := range subs {
+		if err := sub.Unsubscribe(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("subscription %q: %w", sub.ID(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Subscriber) deleteSubscription(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, exists := s.subs[id]
+	if !exists {
+		return
+	}
+	delete(s.subs, id)
+
+	localTag, _ := sub.localHDR.Params.Get("tag")
+	key := sub.callID.Value() + ";" + localTag
+	if sr := s.requests[key]; sr != nil {
+		delete(sr.subs, id)
+		if len(sr.subs) == 0 && sr.main != nil {
+			delete(s.requests, key)
+		}
+	}
+}
+
+// Subscription is subscriber side of subscription, which is dialog created by SUBSCRIBE.
+// It is refreshed before it expires, until Unsubscribe is called or notifier terminates it.
+// Context of subscription is canceled when it ends.
+type Subscription struct {
+	*dialogSession
+	s *Subscriber
+
+	// Event is event package and id of subscription
+	Event sip.Event
+	// SubscribeRequest is SUBSCRIBE that created subscription
+	SubscribeRequest *sip.Request
+
+	subMu        sync.Mutex
+	state        sip.SubscriptionState
+	expires      time.Time
+	timer        sip.Timer
+	unsubscribed bool
+}
+
+// SubscriptionState returns Subscription-State of last NOTIFY. Subscription is pending until first NOTIFY.
+func (sub *Subscription) SubscriptionState() sip.SubscriptionState {
+	sub.subMu.Lock()
+	defer sub.subMu.Unlock()
+	return sub.state
+}
+
+// Expires returns time when subscription expires, unless it is refreshed
+func (sub *Subscription) Expires() time.Time {
+	sub.subMu.Lock()
+	defer sub.subMu.Unlock()
+	return sub.expires
+}
+
+// Refresh sends SUBSCRIBE within dialog with same Expires as initial SUBSCRIBE.
+// Subscription is ended if notifier responds with 481.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.1.2.2
+func (sub *Subscription) Refresh(ctx context.Context) error {
+	res, err := sub.subscribe(ctx, sub.SubscribeRequest.GetHeader("Expires"))
+	if err != nil {
+		return err
+	}
+	if !res.IsSuccess() {
+		return ErrDialogResponse{Res: res}
+	}
+	if h := res.GetHeader("Expires"); h != nil {
+		if sec, err := strconv.ParseUint(strings.TrimSpace(h.Value()), 10, 32); err == nil && sec > 0 {
+			sub.setExpires(time.Duration(sec) * time.Second)
+		}
+	}
+	return nil
+}
+
+// Unsubscribe sends SUBSCRIBE with Expires 0. Subscription ends when NOTIFY with terminated state
+// is received, or after transaction timeout if it does not arrive.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.1.2.3
+func (sub *Subscription) Unsubscribe(ctx context.Context) error {
+	if sub.State() == sip.DialogStateEnded {
+		return nil
+	}
+
+	sub.subMu.Lock()
+	sub.unsubscribed = true
+	if sub.timer != nil {
+		sub.timer.Stop()
+	}
+	sub.timer = sub.s.clock.AfterFunc(transaction.Timer_F, sub.end)
+	sub.subMu.Unlock()
+
+	res, err := sub.subscribe(ctx, sip.NewHeader("Expires", "0"))
+	if err == nil && !res.IsSuccess() {
+		err = ErrDialogResponse{Res: res}
+	}
+	if err != nil {
+		sub.end()
+	}
+	return err
+}
+
+// subscribe sends SUBSCRIBE within dialog
+func (sub *Subscription) subscribe(ctx context.Context, expires sip.Header) (*sip.Response, error) {
+	req := sip.NewRequest(sip.SUBSCRIBE, sip.Uri{})
+	req.AppendHeader(sip.NewHeader("Event", sub.Event.String()))
+	if expires != nil {
+		req.AppendHeader(sip.HeaderClone(expires))
+	}
+	for _, h := range sub.SubscribeRequest.GetHeaders("Accept") {
+		req.AppendHeader(sip.HeaderClone(h))
+	}
+	return sub.Do(ctx, req)
+}
+
+// setExpires sets expiration granted by notifier and schedules refresh before it
+func (sub *Subscription) setExpires(d time.Duration) {
+	sub.subMu.Lock()
+	defer sub.subMu.Unlock()
+	if sub.unsubscribed || sub.State() == sip.DialogStateEnded {
+		return
+	}
+
+	sub.expires = sub.s.clock.Now().Add(d)
+	if sub.timer != nil {
+		sub.timer.Stop()
+	}
+	// Jitter spreads refreshes of many subscriptions
+	delay := d/2 + time.Duration(rand.Int63n(int64(d/4)+1))
+	sub.timer = sub.s.clock.AfterFunc(delay, sub.refresh)
+}
+
+// refresh is called by timer. In case refresh fails subscription is kept until it expires.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.1.2.2
+func (sub *Subscription) refresh() {
+	err := sub.Refresh(context.Background())
+	if err == nil {
+		return
+	}
+	sub.s.log.Info("Failed to refresh subscription", "err", err, "id", sub.ID())
+
+	sub.subMu.Lock()
+	defer sub.subMu.Unlock()
+	if sub.unsubscribed {
+		return
+	}
+	sub.timer = sub.s.clock.AfterFunc(sub.expires.Sub(sub.s.clock.Now()), sub.end)
+}
+
+// establish sets dialog from 2xx response of SUBSCRIBE
+// https://datatracker.ietf.org/doc/html/rfc3261#section-12.1.2
+func (sub *Subscription) establish(res *sip.Response) error {
+	remoteTarget := sub.SubscribeRequest.Recipient
+	if contact := res.Contact(); contact != nil {
+		remoteTarget = contact.Address
+	}
+	routeSet, err := dialogRouteSet(res.GetHeaders("Record-Route"), true)
+	if err != nil {
+		return err
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.remoteHDR = *res.To()
+	sub.remoteTarget = *remoteTarget.Clone()
+	sub.routeSet = routeSet
+	return nil
+}
+
+// establishNotify sets dialog from NOTIFY. Subscriber acts as UAS for dialog created by NOTIFY.
+// https://datatracker.ietf.org/doc/html/rfc6665#section-4.1.2.4
+func (sub *Subscription) establishNotify(req *sip.Request) error {
+	contact := req.Contact()
+	if contact == nil {
+		return fmt.Errorf("missing Contact header")
+	}
+	routeSet, err := dialogRouteSet(req.GetHeaders("Record-Route"), false)
+	if err != nil {
+		return err
+	}
+
+	from := req.From()
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.remoteHDR = sip.ToHeader{DisplayName: from.DisplayName, Address: from.Address, Params: from.Params}
+	sub.remoteTarget = *contact.Address.Clone()
+	sub.routeSet = routeSet
+	sub.remoteCSeq = req.CSeq().SeqNo
+	return nil
+}
+
+// end terminates subscription without sending request
+func (sub *Subscription) end() {
+	sub.subMu.Lock()
+	if sub.timer != nil {
+		sub.timer.Stop()
+	}
+	sub.subMu.Unlock()
+	sub.dialogSession.end()
+}
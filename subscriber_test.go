@@ -0,0 +1,219 @@
+package sipgo
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sipgo/fakes"
+	"github.com/livekit/sipgo/sip"
+)
+
+type testEventPackage struct {
+	mu           sync.Mutex
+	state        string
+	content      string
+	unsubscribed chan *NotifierSubscription
+}
+
+func (p *testEventPackage) Subscribe(sub *NotifierSubscription) (string, error) {
+	if sub.Request.From().Address.User == "mallory" {
+		return "", ErrSubscriptionRejected
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state, nil
+}
+
+func (p *testEventPackage) Content(sub *NotifierSubscription) (string, []byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return "text/plain", []byte(p.content), nil
+}
+
+func (p *testEventPackage) Unsubscribe(sub *NotifierSubscription) {
+	p.unsubscribed <- sub
+}
+
+func (p *testEventPackage) set(state string, content string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state, p.content = state, content
+}
+
+// testListenUDP listens on UDP, so that handlers can be set before server is started with ServeUDP
+func testListenUDP(t *testing.T) (net.PacketConn, sip.Uri) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	return conn, sip.Uri{Host: addr.IP.String(), Port: addr.Port}
+}
+
+func TestSubscription(t *testing.T) {
+	ua, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer ua.Close()
+	srv, err := NewServer(ua)
+	require.NoError(t, err)
+	conn, uri := testListenUDP(t)
+	clock := fakes.NewClock(time.Now())
+	pkg := &testEventPackage{state: sip.SubscriptionStateActive, content: "open", unsubscribed: make(chan *NotifierSubscription, 10)}
+	n := NewNotifier(testClient(t), sip.ContactHeader{Address: uri},
+		WithNotifierPackage("presence", pkg),
+		WithNotifierClock(clock),
+	)
+	srv.OnSubscribe(n.Subscribe)
+	go srv.ServeUDP(conn)
+
+	subUA, err := NewUA(WithUserAgentIP(net.ParseIP("127.0.0.1")))
+	require.NoError(t, err)
+	defer subUA.Close()
+	subSrv, err := NewServer(subUA)
+	require.NoError(t, err)
+	subConn, subURI := testListenUDP(t)
+	subClient, err := NewClient(subUA, WithClientHostname("127.0.0.1"))
+	require.NoError(t, err)
+
+	notifies := make(chan *sip.Request, 10)
+	forks := make(chan *Subscription, 1)
+	s := NewSubscriber(subClient, sip.ContactHeader{Address: subURI},
+		WithSubscriberNotifyHandler(func(sub *Subscription, req *sip.Request) {
+			notifies <- req
+		}),
+		WithSubscriberForkHandler(func(sub *Subscription) {
+			forks <- sub
+		}),
+	)
+	subSrv.OnNotify(func(req *sip.Request, tx sip.ServerTransaction) {
+		s.ReadNotify(req, tx)
+	})
+	go subSrv.ServeUDP(subConn)
+	require.Eventually(t, func() bool {
+		return subUA.TransportLayer().GetListenPort("udp") == subURI.Port
+	}, time.Second, 10*time.Millisecond)
+
+	readNotify := func(state string) *sip.Request {
+		t.Helper()
+		select {
+		case req := <-notifies:
+			ss, ok, err := sip.MessageSubscriptionState(req)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, state, ss.State)
+			return req
+		case <-time.After(2 * time.Second):
+			t.Fatalf("NOTIFY %s not received", state)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	presence := sip.Event{Package: "presence"}
+	expires := sip.ExpiresHeader(600)
+	sub, err := s.Subscribe(ctx, uri, presence, &expires)
+	require.NoError(t, err)
+	req := readNotify(sip.SubscriptionStateActive)
+	assert.Equal(t, "open", string(req.Body()))
+	assert.Equal(t, "presence", req.GetHeader("Event").Value())
+	assert.Equal(t, "active;expires=600", req.GetHeader("Subscription-State").Value())
+	assert.Equal(t, sip.SubscriptionStateActive, sub.SubscriptionState().State)
+	assert.False(t, sub.Expires().IsZero())
+
+	subs := n.Subscriptions()
+	require.Len(t, subs, 1)
+	nsub := subs[0]
+	assert.Equal(t, sub.ID(), nsub.DialogID())
+
+	pkg.set(sip.SubscriptionStateActive, "closed")
+	require.NoError(t, nsub.Notify(ctx))
+	assert.Equal(t, "closed", string(readNotify(sip.SubscriptionStateActive).Body()))
+
+	// Refresh triggers NOTIFY with current state
+	clock.Advance(100 * time.Second)
+	require.NoError(t, sub.Refresh(ctx))
+	readNotify(sip.SubscriptionStateActive)
+	assert.Equal(t, clock.Now().Add(600*time.Second), nsub.Expires())
+
+	t.Run("Fork", func(t *testing.T) {
+		fork := sip.NewRequest(sip.NOTIFY, subURI)
+		fork.AppendHeader(&sip.FromHeader{Address: uri, Params: sip.HeaderParams{"tag": "fork"}})
+		fork.AppendHeader(&sip.ToHeader{Address: sub.localHDR.Address, Params: sub.localHDR.Params.Clone()})
+		fork.AppendHeader(sip.HeaderClone(sub.SubscribeRequest.CallID()))
+		fork.AppendHeader(&sip.CSeqHeader{SeqNo: 1, MethodName: sip.NOTIFY})
+		fork.AppendHeader(&sip.ContactHeader{Address: sip.Uri{Host: "127.0.0.1", Port: 5099}})
+		fork.AppendHeader(sip.NewHeader("Event", "presence"))
+		fork.AppendHeader(sip.NewHeader("Subscription-State", "pending;expires=60"))
+
+		forkSub, ss, err := s.matchNotify(fork)
+		require.NoError(t, err)
+		assert.Equal(t, sip.SubscriptionStatePending, ss.State)
+		assert.NotEqual(t, sub.ID(), forkSub.ID())
+		assert.Equal(t, forkSub, <-forks)
+		require.NoError(t, forkSub.Close())
+
+		// Other event does not match subscription
+		fork.RemoveHeader("Event")
+		fork.AppendHeader(sip.NewHeader("Event", "dialog"))
+		fork.From().Params.Add("tag", "fork2")
+		_, _, err = s.matchNotify(fork)
+		require.ErrorIs(t, err, ErrDialogDoesNotExists)
+	})
+
+	require.NoError(t, sub.Unsubscribe(ctx))
+	req = readNotify(sip.SubscriptionStateTerminated)
+	assert.Equal(t, "terminated;reason=timeout", req.GetHeader("Subscription-State").Value())
+	assert.Equal(t, nsub, <-pkg.unsubscribed)
+	assert.Empty(t, n.Subscriptions())
+	<-sub.Context().Done()
+	_, exists := s.Subscription(sub.ID())
+	assert.False(t, exists)
+
+	t.Run("Pending", func(t *testing.T) {
+		pkg.set(sip.SubscriptionStatePending, "open")
+		expires := sip.ExpiresHeader(60)
+		sub, err := s.Subscribe(ctx, uri, presence, &expires)
+		require.NoError(t, err)
+		req := readNotify(sip.SubscriptionStatePending)
+		assert.Empty(t, req.Body())
+
+		subs := n.Subscriptions()
+		require.Len(t, subs, 1)
+		require.NoError(t, subs[0].Activate(ctx))
+		assert.Equal(t, "open", string(readNotify(sip.SubscriptionStateActive).Body()))
+
+		// Notifier terminates subscription when it expires
+		clock.Advance(60 * time.Second)
+		req = readNotify(sip.SubscriptionStateTerminated)
+		assert.Equal(t, "terminated;reason=timeout", req.GetHeader("Subscription-State").Value())
+		<-pkg.unsubscribed
+		<-sub.Context().Done()
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		_, err := s.Subscribe(ctx, uri, sip.Event{Package: "dialog"})
+		var resErr ErrDialogResponse
+		require.ErrorAs(t, err, &resErr)
+		assert.Equal(t, sip.StatusBadEvent, resErr.Res.StatusCode)
+		assert.Equal(t, "presence", resErr.Res.GetHeader("Allow-Events").Value())
+
+		expires := sip.ExpiresHeader(10)
+		_, err = s.Subscribe(ctx, uri, presence, &expires)
+		require.ErrorAs(t, err, &resErr)
+		assert.Equal(t, sip.StatusIntervalToBrief, resErr.Res.StatusCode)
+
+		req := sip.NewRequest(sip.SUBSCRIBE, uri)
+		req.AppendHeader(&sip.FromHeader{Address: sip.Uri{User: "mallory", Host: "127.0.0.1"}, Params: sip.HeaderParams{"tag": "1234"}})
+		req.AppendHeader(sip.NewHeader("Event", "presence"))
+		_, err = s.WriteSubscribe(ctx, req)
+		require.ErrorAs(t, err, &resErr)
+		assert.Equal(t, sip.StatusForbidden, resErr.Res.StatusCode)
+		assert.Empty(t, n.Subscriptions())
+	})
+}
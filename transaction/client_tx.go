@@ -14,17 +14,24 @@ type ClientTx struct {
 	commonTx
 	responses    chan *sip.Response
 	timer_a_time time.Duration // Current duration of timer A.
-	timer_a      *time.Timer
-	timer_b      *time.Timer
+	timer_a      sip.Timer
+	timer_b      sip.Timer
 	timer_d_time time.Duration // Current duration of timer D.
-	timer_d      *time.Timer
-	timer_m      *time.Timer
+	timer_d      sip.Timer
+	timer_m      sip.Timer
+
+	// prack tracks reliable provisional responses per early dialog To tag. RFC 3262
+	prack map[string]*clientReliable
+	// prackCSeq returns CSeq of PRACK within early dialog, when it is counted by dialog
+	prackCSeq func(res *sip.Response) uint32
+	// proxy is set for transactions forwarding request, for which PRACK is sent by UAC
+	proxy bool
 
 	mu        sync.RWMutex
 	closeOnce sync.Once
 }
 
-func NewClientTx(key string, origin *sip.Request, conn transport.Connection, logger *slog.Logger) *ClientTx {
+func NewClientTx(key string, origin *sip.Request, conn transport.Connection, logger *slog.Logger, timers Timers, clock sip.Clock) *ClientTx {
 	tx := &ClientTx{}
 	tx.key = key
 	// tx.conn = tpl
@@ -32,7 +39,13 @@ func NewClientTx(key string, origin *sip.Request, conn transport.Connection, log
 	// buffer chan - about ~10 retransmit responses
 	tx.responses = make(chan *sip.Response, 10)
 	tx.done = make(chan struct{})
+	tx.final = make(chan struct{})
 	tx.log = logger
+	tx.timers = timers.withDefaults()
+	tx.clock = clock
+	if tx.clock == nil {
+		tx.clock = sip.SystemClock
+	}
 
 	tx.origin = origin
 	return tx
@@ -60,19 +73,23 @@ func (tx *ClientTx) Init() error {
 		// tx.log.Tracef("timer_a set to %v", Timer_A)
 
 		tx.mu.Lock()
-		tx.timer_a_time = Timer_A
+		tx.timer_a_time = tx.timers.A()
 
-		tx.timer_a = time.AfterFunc(tx.timer_a_time, func() {
+		tx.timer_a = tx.clock.AfterFunc(tx.timer_a_time, func() {
 			tx.spinFsm(client_input_timer_a)
 		})
 		// Timer D is set to 32 seconds for unreliable transports
-		tx.timer_d_time = Timer_D
+		// and Timer K to T4 for non-INVITE transactions
+		tx.timer_d_time = tx.timers.D
+		if !tx.origin.IsInvite() {
+			tx.timer_d_time = tx.timers.K()
+		}
 		tx.mu.Unlock()
 	}
 
 	// Timer B - timeout
 	tx.mu.Lock()
-	tx.timer_b = time.AfterFunc(Timer_B, func() {
+	tx.timer_b = tx.clock.AfterFunc(tx.timers.B(), func() {
 		tx.mu.Lock()
 		tx.lastErr = fmt.Errorf("Timer_B timed out. %w", ErrTimeout)
 		tx.mu.Unlock()
@@ -90,6 +107,9 @@ func (tx *ClientTx) Receive(res *sip.Response) error {
 		tx.mu.Lock()
 		tx.lastResp = res
 		tx.mu.Unlock()
+		if !res.IsProvisional() {
+			tx.setFinal()
+		}
 
 		switch {
 		case res.IsProvisional():
@@ -241,6 +261,7 @@ func (tx *ClientTx) delete() {
 		close(tx.done)
 		close(tx.responses)
 		tx.mu.Unlock()
+		tx.setFinal()
 
 		// Maybe there is better way
 		tx.onTerminate(tx.key)
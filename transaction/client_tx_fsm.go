@@ -1,9 +1,5 @@
 package transaction
 
-import (
-	"time"
-)
-
 func (tx *ClientTx) inviteStateCalling(s FsmInput) FsmInput {
 	var spinfn FsmState
 	switch s {
@@ -201,8 +197,8 @@ func (tx *ClientTx) actResend() FsmInput {
 	if tx.timer_a != nil {
 		tx.timer_a_time *= 2
 		// For non-INVITE, cap timer A at T2 seconds.
-		if tx.timer_a_time > T2 {
-			tx.timer_a_time = T2
+		if tx.timer_a_time > tx.timers.T2 {
+			tx.timer_a_time = tx.timers.T2
 		}
 		tx.timer_a.Reset(tx.timer_a_time)
 	}
@@ -270,7 +266,7 @@ func (tx *ClientTx) actInviteFinal() FsmInput {
 
 	// tx.Log().Tracef("timer_d set to %v", tx.timer_d_time)
 
-	tx.timer_d = time.AfterFunc(tx.timer_d_time, func() {
+	tx.timer_d = tx.clock.AfterFunc(tx.timer_d_time, func() {
 		tx.spinFsm(client_input_timer_d)
 	})
 
@@ -298,7 +294,7 @@ func (tx *ClientTx) actFinal() FsmInput {
 
 	// tx.Log().Tracef("timer_d set to %v", tx.timer_d_time)
 	if tx.timer_d_time > 0 {
-		tx.timer_d = time.AfterFunc(tx.timer_d_time, func() {
+		tx.timer_d = tx.clock.AfterFunc(tx.timer_d_time, func() {
 			tx.spinFsm(client_input_timer_d)
 		})
 		return FsmInputNone
@@ -326,7 +322,7 @@ func (tx *ClientTx) actCancelTimeout() FsmInput {
 	if tx.timer_b != nil {
 		tx.timer_b.Stop()
 	}
-	tx.timer_b = time.AfterFunc(Timer_B, func() {
+	tx.timer_b = tx.clock.AfterFunc(tx.timers.B(), func() {
 		tx.spinFsm(client_input_timer_b)
 	})
 	tx.mu.Unlock()
@@ -403,7 +399,7 @@ func (tx *ClientTx) actPassupAccept() FsmInput {
 
 	// tx.Log().Tracef("timer_m set to %v", Timer_M)
 
-	tx.timer_m = time.AfterFunc(Timer_M, func() {
+	tx.timer_m = tx.clock.AfterFunc(tx.timers.M(), func() {
 		select {
 		case <-tx.done:
 			return
@@ -0,0 +1,58 @@
+package transaction
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sipgo/fakes"
+	"github.com/livekit/sipgo/sip"
+)
+
+func testClientTx(t testing.TB, req *sip.Request, clock sip.Clock) *ClientTx {
+	key, err := MakeClientTxKey(req)
+	require.NoError(t, err)
+	tx := NewClientTx(key, req, newTestConn(), slog.Default(), Timers{}, clock)
+	tx.OnTerminate(func(key string) {})
+	require.NoError(t, tx.Init())
+	t.Cleanup(tx.Terminate)
+	return tx
+}
+
+func testTerminated(tx *ClientTx) bool {
+	select {
+	case <-tx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// https://datatracker.ietf.org/doc/html/rfc3261#section-17.1.2.2
+func TestClientTxTimerK(t *testing.T) {
+	clock := fakes.NewClock(time.Now())
+	req := testRequest(t, sip.OPTIONS, "UDP", "127.0.0.2:5060")
+	tx := testClientTx(t, req, clock)
+	timers := DefaultTimers()
+
+	require.NoError(t, tx.Receive(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)))
+	clock.Advance(timers.K() - time.Millisecond)
+	assert.False(t, testTerminated(tx))
+	clock.Advance(time.Millisecond)
+	assert.True(t, testTerminated(tx))
+
+	t.Run("Invite", func(t *testing.T) {
+		// INVITE transaction keeps waiting Timer D for retransmissions of final response
+		req := testInvite(t, "UDP", "127.0.0.2:5060")
+		tx := testClientTx(t, req, clock)
+
+		require.NoError(t, tx.Receive(sip.NewResponseFromRequest(req, sip.StatusBusyHere, "Busy Here", nil)))
+		clock.Advance(timers.K())
+		assert.False(t, testTerminated(tx))
+		clock.Advance(timers.D - timers.K())
+		assert.True(t, testTerminated(tx))
+	})
+}
@@ -1,8 +1,12 @@
 package transaction
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/livekit/sipgo/sip"
 	"github.com/livekit/sipgo/transport"
@@ -27,20 +31,71 @@ type Layer struct {
 
 	clientTransactions *transactionStore
 	serverTransactions *transactionStore
+	// inviteTransactions indexes INVITE server transactions for matching PRACK
+	inviteTransactions *transactionStore
+
+	timers     Timers
+	timersFunc TimersFunc
+	clock      sip.Clock
+
+	// draining is set on shutdown, after which new requests outside of dialog are rejected
+	draining   atomic.Bool
+	retryAfter time.Duration
 
 	log *slog.Logger
 }
 
-func NewLayer(tpl *transport.Layer) *Layer {
+type LayerOption func(txl *Layer)
+
+// WithLayerTimers sets timers of transactions. Zero values are replaced with defaults
+// Default: DefaultTimers()
+func WithLayerTimers(t Timers) LayerOption {
+	return func(txl *Layer) {
+		txl.timers = t
+	}
+}
+
+// WithLayerTimersFunc sets function which can override timers for each transaction
+func WithLayerTimersFunc(f TimersFunc) LayerOption {
+	return func(txl *Layer) {
+		txl.timersFunc = f
+	}
+}
+
+// WithLayerClock sets clock used for transaction timers
+// Default: sip.SystemClock
+func WithLayerClock(c sip.Clock) LayerOption {
+	return func(txl *Layer) {
+		txl.clock = c
+	}
+}
+
+// WithLayerRetryAfter sets Retry-After of 503 responses for requests rejected during shutdown
+// Default: 5s
+func WithLayerRetryAfter(d time.Duration) LayerOption {
+	return func(txl *Layer) {
+		txl.retryAfter = d
+	}
+}
+
+func NewLayer(tpl *transport.Layer, options ...LayerOption) *Layer {
 	txl := &Layer{
 		tpl:                tpl,
 		clientTransactions: newTransactionStore(),
 		serverTransactions: newTransactionStore(),
+		inviteTransactions: newTransactionStore(),
 
 		reqHandler:    defaultRequestHandler,
 		unRespHandler: defaultUnhandledRespHandler,
+		timers:        DefaultTimers(),
+		clock:         sip.SystemClock,
+		retryAfter:    5 * time.Second,
 	}
 	txl.log = slog.With("caller", "transaction.Layer")
+	for _, o := range options {
+		o(txl)
+	}
+	txl.timers = txl.timers.withDefaults()
 	//Send all transport messages to our transaction layer
 	tpl.OnMessage(txl.handleMessage)
 	return txl
@@ -99,19 +154,78 @@ func (txl *Layer) handleRequest(req *sip.Request) {
 		return
 	}
 
-	tx = NewServerTx(key, req, conn, txl.log)
+	tx = NewServerTx(key, req, conn, txl.log, txl.RequestTimers(req), txl.clock)
+	tx.tpl = txl.tpl
 
 	if err := tx.Init(); err != nil {
 		txl.log.Error("Server tx init failed", "err", err)
 		return
 	}
+	// Requests within dialog are still accepted on shutdown, so that dialogs can be terminated
+	to := req.To()
+	rejected := txl.draining.Load() && (to == nil || !to.Params.Has("tag"))
+	tx.rejected = rejected
+
 	// put tx to store, to match retransmitting requests later
-	txl.serverTransactions.put(tx.Key(), tx)
 	tx.OnTerminate(txl.serverTxTerminate)
+	txl.serverTransactions.put(tx.Key(), tx)
+
+	if rejected {
+		res := sip.NewResponseFromRequest(req, sip.StatusServiceUnavailable, "Service Unavailable", nil)
+		res.AppendHeader(sip.NewHeader("Retry-After", strconv.Itoa(int(txl.retryAfter/time.Second))))
+		if err := tx.Respond(res); err != nil {
+			txl.log.Error("Server tx failed to reject request on shutdown", "err", err)
+		}
+		return
+	}
+
+	switch req.Method {
+	case sip.INVITE:
+		if key, ok := makeInvitePrackKey(req); ok {
+			txl.inviteTransactions.put(key, tx)
+		}
+	case sip.PRACK:
+		if !txl.handlePrack(req) {
+			res := sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil)
+			if err := tx.Respond(res); err != nil {
+				txl.log.Error("Server tx failed to respond PRACK", "err", err)
+			}
+			return
+		}
+	}
 
 	txl.reqHandler(req, tx)
 }
 
+// handlePrack matches PRACK with reliable provisional response of INVITE server transaction.
+// It returns false if transaction sent reliable provisional response, but PRACK does not match it,
+// so PRACK must be rejected with 481. PRACK for other transactions is passed to handler,
+// as reliable provisional responses can be handled by application.
+// https://datatracker.ietf.org/doc/html/rfc3262#section-3
+func (txl *Layer) handlePrack(req *sip.Request) bool {
+	h := req.GetHeader("RAck")
+	if h == nil {
+		return true
+	}
+	rack, err := sip.ParseRAck(h.Value())
+	if err != nil || rack.Method != sip.INVITE {
+		txl.log.Debug("PRACK with invalid RAck", "err", err, "rack", h.Value())
+		return true
+	}
+
+	callID, from := req.CallID(), req.From()
+	if callID == nil || from == nil {
+		return true
+	}
+	fromTag, _ := from.Params.Get("tag")
+
+	tx, exists := txl.inviteTransactions.get(makePrackKey(callID.Value(), fromTag, rack.CSeq))
+	if !exists {
+		return true
+	}
+	return tx.(*ServerTx).receivePrack(rack)
+}
+
 func (txl *Layer) handleResponse(res *sip.Response) {
 	key, err := MakeClientTxKey(res)
 	if err != nil {
@@ -127,6 +241,17 @@ func (txl *Layer) handleResponse(res *sip.Response) {
 		return
 	}
 
+	if tx.origin.IsInvite() && !tx.proxy && sip.IsReliableProvisional(res) {
+		prack, ok := tx.receiveReliable(res)
+		if !ok {
+			// Retransmission of already acknowledged provisional response
+			return
+		}
+		if prack != nil {
+			go txl.sendPrack(prack)
+		}
+	}
+
 	if err := tx.Receive(res); err != nil {
 		txl.log.Error("Client tx failed to receive response", "err", err)
 		return
@@ -134,6 +259,29 @@ func (txl *Layer) handleResponse(res *sip.Response) {
 }
 
 func (txl *Layer) Request(req *sip.Request) (*ClientTx, error) {
+	return txl.RequestWithTimers(req, txl.RequestTimers(req))
+}
+
+// RequestWithTimers is same as Request, but transaction uses passed timers instead of layer timers.
+// Zero values are replaced with defaults.
+func (txl *Layer) RequestWithTimers(req *sip.Request, timers Timers) (*ClientTx, error) {
+	return txl.request(req, timers, false, nil)
+}
+
+// RequestWithPrackCSeq is same as Request, but CSeq of PRACK sent for reliable provisional response
+// is returned by prackCSeq. It allows dialog to count PRACK together with other requests of early dialog.
+// Without it PRACK CSeq is counted from INVITE CSeq for every early dialog.
+func (txl *Layer) RequestWithPrackCSeq(req *sip.Request, prackCSeq func(res *sip.Response) uint32) (*ClientTx, error) {
+	return txl.request(req, txl.RequestTimers(req), false, prackCSeq)
+}
+
+// ProxyRequest is same as Request, but transaction is used for forwarding request by proxy.
+// Reliable provisional responses are passed up without sending PRACK, as PRACK is sent by UAC.
+func (txl *Layer) ProxyRequest(req *sip.Request) (*ClientTx, error) {
+	return txl.request(req, txl.RequestTimers(req), true, nil)
+}
+
+func (txl *Layer) request(req *sip.Request, timers Timers, proxy bool, prackCSeq func(res *sip.Response) uint32) (*ClientTx, error) {
 	if req.IsAck() {
 		return nil, fmt.Errorf("ACK request must be sent directly through transport")
 	}
@@ -158,10 +306,12 @@ func (txl *Layer) Request(req *sip.Request) (*ClientTx, error) {
 	}
 
 	// TODO
-	tx := NewClientTx(key, req, conn, txl.log)
+	tx := NewClientTx(key, req, conn, txl.log, timers, txl.clock)
 	if err != nil {
 		return nil, err
 	}
+	tx.proxy = proxy
+	tx.prackCSeq = prackCSeq
 
 	// Avoid allocations of anonymous functions
 	tx.OnTerminate(txl.clientTxTerminate)
@@ -175,6 +325,37 @@ func (txl *Layer) Request(req *sip.Request) (*ClientTx, error) {
 	return tx, nil
 }
 
+// sendPrack sends PRACK for reliable provisional response and waits for its final response
+func (txl *Layer) sendPrack(req *sip.Request) {
+	tx, err := txl.Request(req)
+	if err != nil {
+		txl.log.Error("Failed to send PRACK", "err", err)
+		return
+	}
+	defer tx.Terminate()
+
+	for {
+		select {
+		case res, more := <-tx.Responses():
+			if !more {
+				return
+			}
+			if res.IsProvisional() {
+				continue
+			}
+			if !res.IsSuccess() {
+				txl.log.Info("PRACK rejected", "res", res.StartLine())
+			}
+			return
+		case <-tx.Done():
+			if err := tx.Err(); err != nil {
+				txl.log.Info("PRACK transaction failed", "err", err)
+			}
+			return
+		}
+	}
+}
+
 func (txl *Layer) Respond(res *sip.Response) (*ServerTx, error) {
 	key, err := MakeServerTxKey(res)
 	if err != nil {
@@ -201,6 +382,11 @@ func (txl *Layer) clientTxTerminate(key string) {
 }
 
 func (txl *Layer) serverTxTerminate(key string) {
+	if tx, exists := txl.getServerTx(key); exists && tx.origin.IsInvite() {
+		if key, ok := makeInvitePrackKey(tx.origin); ok {
+			txl.inviteTransactions.drop(key)
+		}
+	}
 	if !txl.serverTransactions.drop(key) {
 		txl.log.Info("Non existing server tx was removed", "key", key)
 	}
@@ -224,6 +410,51 @@ func (txl *Layer) getServerTx(key string) (*ServerTx, bool) {
 	return tx.(*ServerTx), true
 }
 
+// Drain stops accepting new transactions. Requests outside of dialog are rejected with
+// 503 Service Unavailable and Retry-After, while requests within dialog and client requests
+// are still handled, so that in-flight transactions and dialogs can finish.
+func (txl *Layer) Drain() {
+	txl.draining.Store(true)
+}
+
+// Shutdown drains layer and waits until in-flight client and server transactions have final response.
+// Transactions which only absorb retransmissions after final response are not waited.
+// Context error is returned if transactions are not terminated before context is done.
+// Layer must be closed after to terminate remaining transactions.
+func (txl *Layer) Shutdown(ctx context.Context) error {
+	txl.Drain()
+	for {
+		pending := txl.pending()
+		if len(pending) == 0 {
+			txl.log.Debug("transaction layer drained")
+			return nil
+		}
+		for _, final := range pending {
+			select {
+			case <-final:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// pending returns channels closed on final response of transactions which are waited on shutdown
+func (txl *Layer) pending() []<-chan struct{} {
+	var pending []<-chan struct{}
+	for _, tx := range txl.clientTransactions.all() {
+		if tx := tx.(*ClientTx); !tx.finalized() {
+			pending = append(pending, tx.final)
+		}
+	}
+	for _, tx := range txl.serverTransactions.all() {
+		if tx := tx.(*ServerTx); !tx.rejected && !tx.finalized() {
+			pending = append(pending, tx.final)
+		}
+	}
+	return pending
+}
+
 func (txl *Layer) Close() {
 	for _, tx := range txl.clientTransactions.all() {
 		tx.Terminate()
@@ -238,3 +469,16 @@ func (txl *Layer) Close() {
 func (txl *Layer) Transport() sip.Transport {
 	return txl.tpl
 }
+
+// Timers returns timers used for transactions
+func (txl *Layer) Timers() Timers {
+	return txl.timers
+}
+
+// RequestTimers returns timers used for transaction of request
+func (txl *Layer) RequestTimers(req *sip.Request) Timers {
+	if txl.timersFunc == nil {
+		return txl.timers
+	}
+	return txl.timersFunc(req, txl.timers).withDefaults()
+}
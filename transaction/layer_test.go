@@ -0,0 +1,83 @@
+package transaction
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	sipgo "github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sipgo/sip"
+	"github.com/livekit/sipgo/transport"
+)
+
+// testLayerUDP creates layer serving UDP. It returns function which sends request from client socket
+// and waits for its final or reliable provisional response, and address of client socket
+func testLayerUDP(t testing.TB, handler RequestHandler) (*Layer, func(req *sip.Request) *sip.Response, string) {
+	tpl := transport.NewLayer(nil, sipgo.NewParser(), nil)
+	t.Cleanup(func() { tpl.Close() })
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	txl := NewLayer(tpl)
+	t.Cleanup(txl.Close)
+	txl.OnRequest(handler)
+	go tpl.ServeUDP(pc)
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	send := func(req *sip.Request) *sip.Response {
+		t.Helper()
+		_, err := client.WriteTo([]byte(req.String()), pc.LocalAddr())
+		require.NoError(t, err)
+		buf := make([]byte, 65535)
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			n, _, err := client.ReadFrom(buf)
+			require.NoError(t, err)
+			res := testCreateMessage(t, []string{string(buf[:n])}).(*sip.Response)
+			if res.CSeq().MethodName == req.Method && res.StatusCode != sip.StatusTrying {
+				return res
+			}
+		}
+	}
+	return txl, send, client.LocalAddr().String()
+}
+
+func TestLayerShutdown(t *testing.T) {
+	release := make(chan struct{})
+	txl, send, client := testLayerUDP(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		if req.Method == sip.INFO {
+			<-release
+		}
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+	})
+
+	// Completed transaction waits for Timer J, but it is not waited on shutdown
+	res := send(testRequest(t, sip.OPTIONS, "UDP", client))
+	require.Equal(t, sip.StatusOK, res.StatusCode)
+	require.Len(t, txl.serverTransactions.all(), 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, txl.Shutdown(ctx))
+
+	// Transaction without final response is waited
+	info := testRequest(t, sip.INFO, "UDP", client)
+	info.To().Params.Add("tag", "bobtag")
+	done := make(chan *sip.Response, 1)
+	go func() { done <- send(info) }()
+	require.Eventually(t, func() bool { return len(txl.pending()) == 1 }, time.Second, 10*time.Millisecond)
+
+	sctx, scancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer scancel()
+	assert.ErrorIs(t, txl.Shutdown(sctx), context.DeadlineExceeded)
+
+	close(release)
+	require.NoError(t, txl.Shutdown(context.Background()))
+	assert.Equal(t, sip.StatusOK, (<-done).StatusCode)
+}
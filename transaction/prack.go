@@ -0,0 +1,245 @@
+package transaction
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+// Reliable provisional responses RFC 3262
+// https://datatracker.ietf.org/doc/html/rfc3262
+
+// serverReliable tracks reliable provisional responses of INVITE server transaction.
+// Only one reliable provisional can be outstanding.
+type serverReliable struct {
+	rseq        uint32
+	outstanding *sip.Response
+	// acked is closed when outstanding response is acknowledged or retransmissions are stopped
+	acked chan struct{}
+	err   error
+
+	timer      sip.Timer
+	timerTime  time.Duration
+	timerTotal time.Duration
+}
+
+// clientReliable tracks reliable provisional responses received within early dialog of INVITE client transaction.
+type clientReliable struct {
+	rseq uint32
+	cseq uint32
+}
+
+// makePrackKey creates key for matching PRACK with INVITE server transaction
+func makePrackKey(callID string, fromTag string, cseq uint32) string {
+	var builder strings.Builder
+	builder.WriteString(callID)
+	builder.WriteString(TxSeperator)
+	builder.WriteString(fromTag)
+	builder.WriteString(TxSeperator)
+	builder.WriteString(strconv.FormatUint(uint64(cseq), 10))
+	return builder.String()
+}
+
+func makeInvitePrackKey(req *sip.Request) (string, bool) {
+	callID, from, cseq := req.CallID(), req.From(), req.CSeq()
+	if callID == nil || from == nil || cseq == nil {
+		return "", false
+	}
+	fromTag, _ := from.Params.Get("tag")
+	return makePrackKey(callID.Value(), fromTag, cseq.SeqNo), true
+}
+
+// isReliable checks should provisional response be sent reliably.
+// It is reliable if UAC requires 100rel, or if UAC supports it and response requires it.
+// https://datatracker.ietf.org/doc/html/rfc3262#section-3
+func (tx *ServerTx) isReliable(res *sip.Response) bool {
+	tx.mu.RLock()
+	proxy := tx.proxy
+	tx.mu.RUnlock()
+	if proxy || !tx.origin.IsInvite() || !res.IsProvisional() || res.StatusCode == sip.StatusTrying {
+		return false
+	}
+
+	if sip.HasOptionTag(tx.origin, "Require", sip.OptionTag100rel) {
+		if !sip.HasOptionTag(res, "Require", sip.OptionTag100rel) {
+			res.AppendHeader(sip.NewHeader("Require", sip.OptionTag100rel))
+		}
+		return true
+	}
+
+	return sip.HasOptionTag(tx.origin, "Supported", sip.OptionTag100rel) &&
+		sip.HasOptionTag(res, "Require", sip.OptionTag100rel)
+}
+
+// respondReliable handles responses while reliable provisional response is outstanding.
+// Next reliable provisional and 2xx are held until outstanding response is acknowledged with PRACK,
+// so it blocks until response can be sent or transaction terminates.
+func (tx *ServerTx) respondReliable(res *sip.Response) error {
+	reliable := tx.isReliable(res)
+	for {
+		tx.mu.Lock()
+		if !reliable && !res.IsSuccess() {
+			if !res.IsProvisional() {
+				// Non 2xx final response stops retransmissions of reliable provisional response
+				tx.stopReliable(nil)
+			}
+			tx.mu.Unlock()
+			return nil
+		}
+
+		if tx.prack.outstanding == nil {
+			if reliable {
+				tx.sendReliable(res)
+			}
+			tx.mu.Unlock()
+			return nil
+		}
+		acked := tx.prack.acked
+		tx.mu.Unlock()
+
+		tx.log.Debug("Response is held until reliable provisional response is acknowledged", "res", res.StartLine())
+		select {
+		case <-acked:
+		case <-tx.done:
+			return fmt.Errorf("transaction terminated before PRACK received")
+		}
+
+		tx.mu.RLock()
+		err := tx.prack.err
+		tx.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sendReliable sets RSeq on reliable provisional and starts retransmissions.
+// Retransmission interval starts at T1 and doubles until PRACK is received or 64*T1 passes.
+func (tx *ServerTx) sendReliable(res *sip.Response) {
+	if tx.prack.rseq == 0 {
+		// https://datatracker.ietf.org/doc/html/rfc3262#section-3
+		// Initial value MUST be chosen uniformly between 1 and 2**31 - 1
+		tx.prack.rseq = uint32(rand.Int31n(1<<31-1)) + 1
+	} else {
+		tx.prack.rseq++
+	}
+
+	for res.RemoveHeader("RSeq") {
+	}
+	res.AppendHeader(sip.NewHeader("RSeq", strconv.FormatUint(uint64(tx.prack.rseq), 10)))
+
+	tx.prack.outstanding = res
+	tx.prack.acked = make(chan struct{})
+	tx.prack.err = nil
+	tx.prack.timerTime = tx.timers.T1
+	tx.prack.timerTotal = 0
+	tx.prack.timer = tx.clock.AfterFunc(tx.prack.timerTime, tx.retransmitReliable)
+}
+
+func (tx *ServerTx) retransmitReliable() {
+	tx.mu.Lock()
+	res := tx.prack.outstanding
+	if res == nil || tx.prack.timer == nil {
+		tx.mu.Unlock()
+		return
+	}
+
+	tx.prack.timerTotal += tx.prack.timerTime
+	if tx.prack.timerTotal >= 64*tx.timers.T1 {
+		tx.stopReliable(fmt.Errorf("reliable provisional response not acknowledged. %w", ErrTimeout))
+		tx.mu.Unlock()
+
+		// https://datatracker.ietf.org/doc/html/rfc3262#section-3
+		// UAS SHOULD reject the original request with a 5xx response
+		rej := sip.NewResponseFromRequest(tx.origin, sip.StatusInternalServerError, "Server Internal Error", nil)
+		rej.ReplaceHeader(sip.HeaderClone(res.To()))
+		if err := tx.Respond(rej); err != nil {
+			tx.log.Error("send 5xx for not acknowledged reliable provisional response failed", "err", err)
+		}
+		return
+	}
+
+	// Last interval is shortened, so that response is rejected after 64*T1
+	tx.prack.timerTime = min(2*tx.prack.timerTime, 64*tx.timers.T1-tx.prack.timerTotal)
+	tx.prack.timer.Reset(tx.prack.timerTime)
+	tx.mu.Unlock()
+
+	if err := tx.writeResponse(res); err != nil {
+		tx.log.Debug("fail to retransmit reliable provisional response", "err", err, "res", res.StartLine())
+	}
+}
+
+// stopReliable stops retransmissions and releases held responses. Must be called under lock
+func (tx *ServerTx) stopReliable(err error) {
+	if tx.prack.timer != nil {
+		tx.prack.timer.Stop()
+		tx.prack.timer = nil
+	}
+	if tx.prack.outstanding != nil {
+		tx.prack.outstanding = nil
+		tx.prack.err = err
+		close(tx.prack.acked)
+	}
+}
+
+// receivePrack acknowledges outstanding reliable provisional response, which releases held responses.
+// It returns false if PRACK does not match outstanding response. PRACK is always matched by proxy transaction
+// and by transaction which did not send reliable provisional response.
+func (tx *ServerTx) receivePrack(rack sip.RAck) bool {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.proxy || tx.prack.rseq == 0 {
+		return true
+	}
+	if tx.prack.outstanding == nil || tx.prack.rseq != rack.RSeq {
+		return false
+	}
+	tx.stopReliable(nil)
+	return true
+}
+
+// receiveReliable validates reliable provisional response and returns PRACK for it.
+// It returns false if response is retransmission or out of order and must be discarded.
+// https://datatracker.ietf.org/doc/html/rfc3262#section-4
+func (tx *ClientTx) receiveReliable(res *sip.Response) (*sip.Request, bool) {
+	rseq, err := sip.ResponseRSeq(res)
+	if err != nil {
+		tx.log.Debug("Invalid reliable provisional response", "err", err, "res", res.StartLine())
+		return nil, true
+	}
+	tag, _ := res.To().Params.Get("tag")
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.prack == nil {
+		tx.prack = make(map[string]*clientReliable)
+	}
+
+	rel, exists := tx.prack[tag]
+	if exists && rseq != rel.rseq+1 {
+		return nil, false
+	}
+	if !exists {
+		// PRACK is first request within early dialog after INVITE
+		rel = &clientReliable{cseq: tx.origin.CSeq().SeqNo}
+		tx.prack[tag] = rel
+	}
+
+	rel.rseq = rseq
+	if tx.prackCSeq != nil {
+		rel.cseq = tx.prackCSeq(res)
+	} else {
+		rel.cseq++
+	}
+	prack, err := sip.NewPrackRequest(tx.origin, res, rel.cseq)
+	if err != nil {
+		tx.log.Error("Failed to create PRACK", "err", err, "res", res.StartLine())
+		return nil, true
+	}
+	return prack, true
+}
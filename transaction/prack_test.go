@@ -0,0 +1,290 @@
+package transaction
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	sipgo "github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sipgo/fakes"
+	"github.com/livekit/sipgo/sip"
+)
+
+// testConn is connection which records written messages
+type testConn struct {
+	msgs chan sip.Message
+}
+
+func newTestConn() *testConn {
+	return &testConn{msgs: make(chan sip.Message, 100)}
+}
+
+func (c *testConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5060}
+}
+
+func (c *testConn) WriteMsg(msg sip.Message) error {
+	c.msgs <- msg
+	return nil
+}
+
+func (c *testConn) Ref(i int) int           { return 1 }
+func (c *testConn) TryClose() (int, error)  { return 0, nil }
+func (c *testConn) Close() error            { return nil }
+func (c *testConn) expectNone(t testing.TB) { require.Len(t, c.msgs, 0) }
+func (c *testConn) read(t testing.TB) sip.Message {
+	t.Helper()
+	select {
+	case msg := <-c.msgs:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message written")
+		return nil
+	}
+}
+
+func testCreateMessage(t testing.TB, rawMsg []string) sip.Message {
+	msg, err := sipgo.ParseMessage([]byte(strings.Join(rawMsg, "\r\n")))
+	require.NoError(t, err)
+	return msg
+}
+
+func testInvite(t testing.TB, transport string, addr string, headers ...string) *sip.Request {
+	return testRequest(t, sip.INVITE, transport, addr, headers...)
+}
+
+func testRequest(t testing.TB, method sip.RequestMethod, transport string, addr string, headers ...string) *sip.Request {
+	lines := []string{
+		string(method) + " sip:bob@127.0.0.1:5060 SIP/2.0",
+		"Via: SIP/2.0/" + transport + " " + addr + ";branch=" + sip.GenerateBranch(),
+		"From: \"Alice\" <sip:alice@" + addr + ">;tag=" + fmt.Sprint(time.Now().UnixNano()),
+		"To: \"Bob\" <sip:bob@127.0.0.1:5060>",
+		"Call-ID: gotest-" + time.Now().Format(time.RFC3339Nano),
+		"CSeq: 1 " + string(method),
+	}
+	lines = append(lines, headers...)
+	lines = append(lines, "Content-Length: 0", "", "")
+	req := testCreateMessage(t, lines).(*sip.Request)
+	req.SetTransport(transport)
+	req.SetSource(addr)
+	return req
+}
+
+func testPrack(t testing.TB, invite *sip.Request, rack sip.RAck) *sip.Request {
+	via := invite.Via()
+	from := invite.From()
+	return testCreateMessage(t, []string{
+		"PRACK sip:bob@127.0.0.1:5060 SIP/2.0",
+		"Via: SIP/2.0/" + via.Transport + " " + invite.Source() + ";branch=" + sip.GenerateBranch(),
+		"From: " + from.Value(),
+		"To: \"Bob\" <sip:bob@127.0.0.1:5060>;tag=bobtag",
+		"Call-ID: " + invite.CallID().Value(),
+		"CSeq: 2 PRACK",
+		"RAck: " + rack.String(),
+		"Content-Length: 0",
+		"",
+		"",
+	}).(*sip.Request)
+}
+
+func testServerTx(t testing.TB, req *sip.Request, clock sip.Clock) (*ServerTx, *testConn) {
+	key, err := MakeServerTxKey(req)
+	require.NoError(t, err)
+	conn := newTestConn()
+	tx := NewServerTx(key, req, conn, slog.Default(), Timers{}, clock)
+	tx.OnTerminate(func(key string) {})
+	require.NoError(t, tx.Init())
+	t.Cleanup(tx.Terminate)
+	return tx, conn
+}
+
+func testReliableResponse(req *sip.Request, code sip.StatusCode) *sip.Response {
+	res := sip.NewResponseFromRequest(req, code, "Session Progress", nil)
+	res.To().Params.Add("tag", "bobtag")
+	return res
+}
+
+func TestServerTxReliableProvisional(t *testing.T) {
+	clock := fakes.NewClock(time.Now())
+	req := testInvite(t, "UDP", "127.0.0.2:5060", "Require: 100rel")
+	tx, conn := testServerTx(t, req, clock)
+	t1 := tx.timers.T1
+
+	res := testReliableResponse(req, sip.StatusSessionInProgress)
+	require.NoError(t, tx.Respond(res))
+	sent := conn.read(t).(*sip.Response)
+	rseq, err := sip.ResponseRSeq(sent)
+	require.NoError(t, err)
+	assert.True(t, sip.HasOptionTag(sent, "Require", sip.OptionTag100rel))
+
+	// Retransmissions start at T1 and interval doubles
+	interval := t1
+	for i := 0; i < 3; i++ {
+		clock.Advance(interval - time.Millisecond)
+		conn.expectNone(t)
+		clock.Advance(time.Millisecond)
+		retr := conn.read(t).(*sip.Response)
+		assert.Equal(t, sip.StatusSessionInProgress, retr.StatusCode)
+		interval *= 2
+	}
+
+	// PRACK for other response does not match
+	assert.False(t, tx.receivePrack(sip.RAck{RSeq: rseq + 1, CSeq: 1, Method: sip.INVITE}))
+	assert.True(t, tx.receivePrack(sip.RAck{RSeq: rseq, CSeq: 1, Method: sip.INVITE}))
+	assert.False(t, tx.receivePrack(sip.RAck{RSeq: rseq, CSeq: 1, Method: sip.INVITE}))
+
+	clock.Advance(64 * t1)
+	conn.expectNone(t)
+
+	// Next reliable provisional increments RSeq
+	require.NoError(t, tx.Respond(testReliableResponse(req, sip.StatusRinging)))
+	sent = conn.read(t).(*sip.Response)
+	next, err := sip.ResponseRSeq(sent)
+	require.NoError(t, err)
+	assert.Equal(t, rseq+1, next)
+}
+
+func TestServerTxReliableProvisionalHeld(t *testing.T) {
+	clock := fakes.NewClock(time.Now())
+	req := testInvite(t, "UDP", "127.0.0.2:5060", "Supported: 100rel")
+	tx, conn := testServerTx(t, req, clock)
+
+	// Response is reliable only if it requires 100rel, as UAC only supports it
+	require.NoError(t, tx.Respond(testReliableResponse(req, sip.StatusRinging)))
+	sent := conn.read(t).(*sip.Response)
+	assert.Nil(t, sent.GetHeader("RSeq"))
+
+	res := testReliableResponse(req, sip.StatusSessionInProgress)
+	res.AppendHeader(sip.NewHeader("Require", sip.OptionTag100rel))
+	require.NoError(t, tx.Respond(res))
+	rseq, err := sip.ResponseRSeq(conn.read(t).(*sip.Response))
+	require.NoError(t, err)
+
+	// 2xx is held until reliable provisional is acknowledged
+	done := make(chan error, 1)
+	go func() {
+		done <- tx.Respond(testReliableResponse(req, sip.StatusOK))
+	}()
+	select {
+	case <-done:
+		t.Fatal("2xx sent before PRACK")
+	case <-time.After(50 * time.Millisecond):
+	}
+	conn.expectNone(t)
+
+	require.True(t, tx.receivePrack(sip.RAck{RSeq: rseq, CSeq: 1, Method: sip.INVITE}))
+	require.NoError(t, <-done)
+	assert.Equal(t, sip.StatusOK, conn.read(t).(*sip.Response).StatusCode)
+}
+
+func TestServerTxReliableProvisionalTimeout(t *testing.T) {
+	clock := fakes.NewClock(time.Now())
+	req := testInvite(t, "UDP", "127.0.0.2:5060", "Require: 100rel")
+	tx, conn := testServerTx(t, req, clock)
+	t1 := tx.timers.T1
+
+	require.NoError(t, tx.Respond(testReliableResponse(req, sip.StatusSessionInProgress)))
+	conn.read(t)
+
+	clock.Advance(64*t1 - time.Millisecond)
+	for i := 0; i < 6; i++ {
+		assert.Equal(t, sip.StatusSessionInProgress, conn.read(t).(*sip.Response).StatusCode)
+	}
+	conn.expectNone(t)
+
+	// https://datatracker.ietf.org/doc/html/rfc3262#section-3
+	clock.Advance(time.Millisecond)
+	rej := conn.read(t).(*sip.Response)
+	assert.Equal(t, sip.StatusInternalServerError, rej.StatusCode)
+	tag, _ := rej.To().Params.Get("tag")
+	assert.Equal(t, "bobtag", tag)
+}
+
+func TestClientTxReceiveReliable(t *testing.T) {
+	req := testInvite(t, "UDP", "127.0.0.1:5060", "Supported: 100rel")
+	key, err := MakeClientTxKey(req)
+	require.NoError(t, err)
+	tx := NewClientTx(key, req, newTestConn(), slog.Default(), Timers{}, fakes.NewClock(time.Now()))
+
+	res := testReliableResponse(req, sip.StatusSessionInProgress)
+	res.AppendHeader(sip.NewHeader("Require", sip.OptionTag100rel))
+	res.AppendHeader(sip.NewHeader("RSeq", "10"))
+
+	prack, ok := tx.receiveReliable(res)
+	require.True(t, ok)
+	require.NotNil(t, prack)
+	assert.EqualValues(t, 2, prack.CSeq().SeqNo)
+	assert.Equal(t, "10 1 INVITE", prack.GetHeader("RAck").Value())
+
+	// Retransmission is discarded
+	_, ok = tx.receiveReliable(res)
+	assert.False(t, ok)
+
+	// Out of order response is discarded
+	res.ReplaceHeader(sip.NewHeader("RSeq", "12"))
+	_, ok = tx.receiveReliable(res)
+	assert.False(t, ok)
+
+	// CSeq of PRACK can be provided by dialog
+	tx.prackCSeq = func(res *sip.Response) uint32 { return 7 }
+	res.ReplaceHeader(sip.NewHeader("RSeq", "11"))
+	prack, ok = tx.receiveReliable(res)
+	require.True(t, ok)
+	assert.EqualValues(t, 7, prack.CSeq().SeqNo)
+}
+
+func TestLayerPrack(t *testing.T) {
+	pracks := make(chan *sip.Request, 1)
+	_, send, client := testLayerUDP(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		switch req.Method {
+		case sip.INVITE:
+			tx.Respond(testReliableResponse(req, sip.StatusSessionInProgress))
+		case sip.PRACK:
+			pracks <- req
+			tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+		}
+	})
+
+	invite := testInvite(t, "UDP", client, "Require: 100rel")
+	res := send(invite)
+	require.Equal(t, sip.StatusSessionInProgress, res.StatusCode)
+	rseq, err := sip.ResponseRSeq(res)
+	require.NoError(t, err)
+
+	t.Run("NotMatched", func(t *testing.T) {
+		res := send(testPrack(t, invite, sip.RAck{RSeq: rseq + 1, CSeq: 1, Method: sip.INVITE}))
+		assert.Equal(t, sip.StatusCallTransactionDoesNotExists, res.StatusCode)
+		assert.Len(t, pracks, 0)
+	})
+
+	t.Run("Matched", func(t *testing.T) {
+		res := send(testPrack(t, invite, sip.RAck{RSeq: rseq, CSeq: 1, Method: sip.INVITE}))
+		assert.Equal(t, sip.StatusOK, res.StatusCode)
+		require.Len(t, pracks, 1)
+		<-pracks
+	})
+
+	t.Run("NotReliable", func(t *testing.T) {
+		// PRACK for other transaction or for provisional response not sent reliably by transaction
+		// is left to application
+		res := send(testPrack(t, invite, sip.RAck{RSeq: rseq, CSeq: 2, Method: sip.INVITE}))
+		assert.Equal(t, sip.StatusOK, res.StatusCode)
+		require.Len(t, pracks, 1)
+		<-pracks
+
+		invite := testInvite(t, "UDP", client)
+		res = send(invite)
+		require.Equal(t, sip.StatusSessionInProgress, res.StatusCode)
+		require.Nil(t, res.GetHeader("RSeq"))
+
+		res = send(testPrack(t, invite, sip.RAck{RSeq: 1, CSeq: 1, Method: sip.INVITE}))
+		assert.Equal(t, sip.StatusOK, res.StatusCode)
+		require.Len(t, pracks, 1)
+	})
+}
@@ -18,22 +18,29 @@ type ServerTx struct {
 	lastCancel   *sip.Request
 	acks         chan *sip.Request
 	cancels      chan *sip.Request
-	timer_g      *time.Timer
+	timer_g      sip.Timer
 	timer_g_time time.Duration
-	timer_h      *time.Timer
-	timer_i      *time.Timer
+	timer_h      sip.Timer
+	timer_i      sip.Timer
 	timer_i_time time.Duration
-	timer_j      *time.Timer
-	timer_1xx    *time.Timer
-	timer_l      *time.Timer
+	timer_j      sip.Timer
+	timer_1xx    sip.Timer
+	timer_l      sip.Timer
 	reliable     bool
+	// rejected is set for transactions rejected during shutdown
+	rejected bool
+
+	// prack tracks reliable provisional responses. RFC 3262
+	prack serverReliable
+	// proxy is set for transactions of proxied requests, for which reliability is end to end
+	proxy bool
 
 	mu sync.RWMutex
 
 	closeOnce sync.Once
 }
 
-func NewServerTx(key string, origin *sip.Request, conn transport.Connection, logger *slog.Logger) *ServerTx {
+func NewServerTx(key string, origin *sip.Request, conn transport.Connection, logger *slog.Logger, timers Timers, clock sip.Clock) *ServerTx {
 	tx := new(ServerTx)
 	tx.key = key
 	tx.conn = conn
@@ -42,7 +49,13 @@ func NewServerTx(key string, origin *sip.Request, conn transport.Connection, log
 	tx.acks = make(chan *sip.Request)
 	tx.cancels = make(chan *sip.Request)
 	tx.done = make(chan struct{})
+	tx.final = make(chan struct{})
 	tx.log = logger
+	tx.timers = timers.withDefaults()
+	tx.clock = clock
+	if tx.clock == nil {
+		tx.clock = sip.SystemClock
+	}
 	tx.origin = origin
 	tx.reliable = transport.IsReliable(origin.Transport())
 	return tx
@@ -56,8 +69,8 @@ func (tx *ServerTx) Init() error {
 	if tx.reliable {
 		tx.timer_i_time = 0
 	} else {
-		tx.timer_g_time = Timer_G
-		tx.timer_i_time = Timer_I
+		tx.timer_g_time = tx.timers.G()
+		tx.timer_i_time = tx.timers.I()
 	}
 
 	tx.mu.Unlock()
@@ -66,7 +79,7 @@ func (tx *ServerTx) Init() error {
 	if tx.Origin().IsInvite() {
 		// tx.Log().Tracef("set timer_1xx to %v", Timer_1xx)
 		tx.mu.Lock()
-		tx.timer_1xx = time.AfterFunc(Timer_1xx, func() {
+		tx.timer_1xx = tx.clock.AfterFunc(tx.timers.Trying, func() {
 			trying := sip.NewResponseFromRequest(
 				tx.Origin(),
 				100,
@@ -118,7 +131,14 @@ func (tx *ServerTx) receiveRequest(req *sip.Request) (FsmInput, error) {
 
 func (tx *ServerTx) Respond(res *sip.Response) error {
 	if res.IsCancel() {
-		return tx.conn.WriteMsg(res)
+		return tx.writeResponse(res)
+	}
+
+	if tx.origin.IsInvite() {
+		// RFC 3262 reliable provisional responses
+		if err := tx.respondReliable(res); err != nil {
+			return err
+		}
 	}
 
 	input, err := tx.receiveRespond(res)
@@ -139,10 +159,11 @@ func (tx *ServerTx) receiveRespond(res *sip.Response) (FsmInput, error) {
 		tx.timer_1xx = nil
 	}
 
-	switch {
-	case res.IsProvisional():
+	if res.IsProvisional() {
 		return server_input_user_1xx, nil
-	case res.IsSuccess():
+	}
+	tx.setFinal()
+	if res.IsSuccess() {
 		return server_input_user_2xx, nil
 	}
 	return server_input_user_300_plus, nil
@@ -199,6 +220,20 @@ func (tx *ServerTx) cancelSend(r *sip.Request) {
 	}
 }
 
+// writeResponse sends response as described in RFC 3261 section 18.2.2.
+// Without transport layer response is sent over connection of request.
+func (tx *ServerTx) writeResponse(res *sip.Response) error {
+	if tx.tpl == nil {
+		return tx.conn.WriteMsg(res)
+	}
+	conn, err := tx.tpl.ServerResponseConnection(res)
+	if err != nil {
+		return err
+	}
+	defer conn.TryClose()
+	return conn.WriteMsg(res)
+}
+
 func (tx *ServerTx) passResp() error {
 	tx.mu.RLock()
 	lastResp := tx.lastResp
@@ -209,7 +244,7 @@ func (tx *ServerTx) passResp() error {
 	}
 
 	// tx.Log().Debug("actFinal")
-	err := tx.conn.WriteMsg(lastResp)
+	err := tx.writeResponse(lastResp)
 	if err != nil {
 		tx.log.Debug("fail to pass response", "err", err, "res", lastResp.StartLine())
 		tx.mu.Lock()
@@ -220,6 +255,15 @@ func (tx *ServerTx) passResp() error {
 	return nil
 }
 
+// SetProxy marks transaction as used by proxy. Reliable provisional responses are forwarded
+// without retransmissions and PRACK is passed to request handler, as reliability is end to end
+// between UAC and UAS. It must be called before first response.
+func (tx *ServerTx) SetProxy() {
+	tx.mu.Lock()
+	tx.proxy = true
+	tx.mu.Unlock()
+}
+
 func (tx *ServerTx) Terminate() {
 	tx.log.Debug("Server transaction terminating")
 	tx.delete()
@@ -252,6 +296,7 @@ func (tx *ServerTx) delete() {
 		tx.mu.Lock()
 		close(tx.done)
 		tx.mu.Unlock()
+		tx.setFinal()
 		tx.onTerminate(tx.key)
 
 		// TODO with ref this can be added, but normally we expect client does closing
@@ -284,6 +329,7 @@ func (tx *ServerTx) delete() {
 		tx.timer_1xx.Stop()
 		tx.timer_1xx = nil
 	}
+	tx.stopReliable(nil)
 	tx.mu.Unlock()
 	tx.log.Debug("Server transaction destroyed", "tx", tx.Key())
 }
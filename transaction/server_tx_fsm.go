@@ -1,10 +1,6 @@
 // Originally forked from https://github.com/ghettovoice/gosip by @ghetovoice
 package transaction
 
-import (
-	"time"
-)
-
 // invite state machine https://datatracker.ietf.org/doc/html/rfc3261#section-17.1.1.2
 // TODO needs to be refactored
 func (tx *ServerTx) inviteStateProcceeding(s FsmInput) FsmInput {
@@ -179,14 +175,14 @@ func (tx *ServerTx) actRespondComplete() FsmInput {
 		if tx.timer_g == nil {
 			// tx.Log().Tracef("timer_g set to %v", tx.timer_g_time)
 
-			tx.timer_g = time.AfterFunc(tx.timer_g_time, func() {
+			tx.timer_g = tx.clock.AfterFunc(tx.timer_g_time, func() {
 				// tx.Log().Trace("timer_g fired")
 				tx.spinFsm(server_input_timer_g)
 			})
 		} else {
 			tx.timer_g_time *= 2
-			if tx.timer_g_time > T2 {
-				tx.timer_g_time = T2
+			if tx.timer_g_time > tx.timers.T2 {
+				tx.timer_g_time = tx.timers.T2
 			}
 
 			// tx.Log().Tracef("timer_g reset to %v", tx.timer_g_time)
@@ -198,7 +194,7 @@ func (tx *ServerTx) actRespondComplete() FsmInput {
 
 	tx.mu.Lock()
 	if tx.timer_h == nil {
-		tx.timer_h = time.AfterFunc(Timer_H, func() {
+		tx.timer_h = tx.clock.AfterFunc(tx.timers.H(), func() {
 			// tx.Log().Trace("timer_h fired")
 			tx.spinFsm(server_input_timer_h)
 		})
@@ -215,7 +211,7 @@ func (tx *ServerTx) actRespondAccept() FsmInput {
 
 	tx.mu.Lock()
 	// tx.Log().Tracef("timer_l set to %v", Timer_L)
-	tx.timer_l = time.AfterFunc(Timer_L, func() {
+	tx.timer_l = tx.clock.AfterFunc(tx.timers.L(), func() {
 		// tx.Log().Trace("timer_l fired")
 		tx.spinFsm(server_input_timer_l)
 	})
@@ -236,7 +232,7 @@ func (tx *ServerTx) actFinal() FsmInput {
 	}
 
 	tx.mu.Lock()
-	tx.timer_j = time.AfterFunc(Timer_J, func() {
+	tx.timer_j = tx.clock.AfterFunc(tx.timers.J(), func() {
 		// tx.Log().Trace("timer_j fired")
 		tx.spinFsm(server_input_timer_j)
 	})
@@ -271,7 +267,7 @@ func (tx *ServerTx) actDelete() FsmInput {
 func (tx *ServerTx) actRespondDelete() FsmInput {
 	// tx.Log().Debug("actRespondDelete")
 	tx.delete()
-	err := tx.conn.WriteMsg(tx.lastResp)
+	err := tx.writeResponse(tx.lastResp)
 
 	if err != nil {
 		tx.mu.Lock()
@@ -304,7 +300,7 @@ func (tx *ServerTx) actConfirm() FsmInput {
 
 	// tx.Log().Tracef("timer_i set to %v", Timer_I)
 
-	tx.timer_i = time.AfterFunc(Timer_I, func() {
+	tx.timer_i = tx.clock.AfterFunc(tx.timers.I(), func() {
 		// tx.Log().Trace("timer_i fired")
 		tx.spinFsm(server_input_timer_i)
 	})
@@ -0,0 +1,93 @@
+package transaction
+
+import (
+	"time"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+// Timers configures transaction timers. Timers A to M are derived from T1, T2 and T4.
+// Zero values are replaced with defaults.
+// https://datatracker.ietf.org/doc/html/rfc3261#appendix-A
+type Timers struct {
+	// T1 is round trip time estimate. Default: 500ms
+	T1 time.Duration
+	// T2 is maximum retransmit interval for non-INVITE requests and INVITE responses. Default: 4s
+	T2 time.Duration
+	// T4 is maximum duration a message will remain in the network. Default: 5s
+	T4 time.Duration
+	// D is wait time for response retransmits of INVITE on unreliable transport. It is at least 32s by RFC
+	// Default: 32s
+	D time.Duration
+	// Trying is time after which server INVITE transaction sends 100 Trying. Default: 200ms
+	Trying time.Duration
+}
+
+// TimersFunc returns timers for transaction of request. It is called with configured timers
+// and can override them, for example with T1 estimated from round trip time of destination.
+type TimersFunc func(req *sip.Request, t Timers) Timers
+
+// DefaultTimers returns timers with values recommended by RFC 3261
+func DefaultTimers() Timers {
+	return Timers{
+		T1:     T1,
+		T2:     T2,
+		T4:     T4,
+		D:      Timer_D,
+		Trying: Timer_1xx,
+	}
+}
+
+// withDefaults replaces zero values with defaults
+func (t Timers) withDefaults() Timers {
+	d := DefaultTimers()
+	if t.T1 <= 0 {
+		t.T1 = d.T1
+	}
+	if t.T2 <= 0 {
+		t.T2 = d.T2
+	}
+	if t.T4 <= 0 {
+		t.T4 = d.T4
+	}
+	if t.D <= 0 {
+		t.D = d.D
+	}
+	if t.Trying <= 0 {
+		t.Trying = d.Trying
+	}
+	return t
+}
+
+// A is INVITE request retransmit interval, for UDP only
+func (t Timers) A() time.Duration { return t.T1 }
+
+// B is INVITE transaction timeout timer
+func (t Timers) B() time.Duration { return 64 * t.T1 }
+
+// E is non-INVITE request retransmit interval, UDP only
+func (t Timers) E() time.Duration { return t.T1 }
+
+// F is non-INVITE transaction timeout timer
+func (t Timers) F() time.Duration { return 64 * t.T1 }
+
+// G is INVITE response retransmit interval
+func (t Timers) G() time.Duration { return t.T1 }
+
+// H is wait time for ACK receipt
+func (t Timers) H() time.Duration { return 64 * t.T1 }
+
+// I is wait time for ACK retransmits
+func (t Timers) I() time.Duration { return t.T4 }
+
+// J is wait time for non-INVITE request retransmits
+func (t Timers) J() time.Duration { return 64 * t.T1 }
+
+// K is wait time for response retransmits
+func (t Timers) K() time.Duration { return t.T4 }
+
+// L is wait time for accepted INVITE request retransmits. RFC 6026
+func (t Timers) L() time.Duration { return 64 * t.T1 }
+
+// M is wait time for retransmission of 2xx to INVITE or additional 2xx from other branches. RFC 6026
+func (t Timers) M() time.Duration { return 64 * t.T1 }
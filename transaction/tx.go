@@ -12,13 +12,16 @@ type commonTx struct {
 	key string
 
 	origin *sip.Request
-	// tpl    *transport.Layer
+	tpl    *transport.Layer
 
 	conn     transport.Connection
 	lastResp *sip.Response
 
 	lastErr error
 	done    chan struct{}
+	// final is closed once final response is sent or received, or transaction is terminated
+	final     chan struct{}
+	finalOnce sync.Once
 
 	//State machine control
 	fsmMu    sync.RWMutex
@@ -26,6 +29,9 @@ type commonTx struct {
 
 	log         *slog.Logger
 	onTerminate FnTxTerminate
+
+	timers Timers
+	clock  sip.Clock
 }
 
 func (tx *commonTx) String() string {
@@ -57,6 +63,20 @@ func (tx *commonTx) Done() <-chan struct{} {
 	return tx.done
 }
 
+func (tx *commonTx) setFinal() {
+	tx.finalOnce.Do(func() { close(tx.final) })
+}
+
+// finalized checks is final response sent or received
+func (tx *commonTx) finalized() bool {
+	select {
+	case <-tx.final:
+		return true
+	default:
+		return false
+	}
+}
+
 func (tx *commonTx) OnTerminate(f FnTxTerminate) {
 	tx.onTerminate = f
 }
@@ -1,52 +1,321 @@
 package transport
 
 import (
+	"container/list"
 	"log/slog"
 	"sync"
+	"time"
+
+	"github.com/livekit/sipgo/sip"
 )
 
-// TODO Connection pool with keeping active connections longer
+// ConnectionPoolStats are counters of connection pool
+type ConnectionPoolStats struct {
+	// Active is number of connections in pool
+	Active int
+	// Idle is number of connections in pool which are not used by transactions
+	Idle int
+	// Created is total number of connections added to pool
+	Created uint64
+	// Closed is total number of connections removed from pool
+	Closed uint64
+}
+
+type ConnectionPoolOption func(p *ConnectionPool)
 
+// WithConnectionPoolMaxPerAddr sets number of parallel connections to same address.
+// New connection is created only when all existing are in use.
+// Default: 1
+func WithConnectionPoolMaxPerAddr(n int) ConnectionPoolOption {
+	return func(p *ConnectionPool) {
+		p.maxPerAddr = n
+	}
+}
+
+// WithConnectionPoolMaxConnections limits number of connections in pool.
+// When limit is reached least recently used idle connection is closed.
+// Default: 0, no limit
+func WithConnectionPoolMaxConnections(n int) ConnectionPoolOption {
+	return func(p *ConnectionPool) {
+		p.maxConns = n
+	}
+}
+
+// WithConnectionPoolIdleTimeout sets time after which idle connection without traffic is closed.
+// Default: 0, connections are kept until closed by remote
+func WithConnectionPoolIdleTimeout(d time.Duration) ConnectionPoolOption {
+	return func(p *ConnectionPool) {
+		p.idleTimeout = d
+	}
+}
+
+// WithConnectionPoolClock sets clock used for idle timeouts
+// Default: sip.SystemClock
+func WithConnectionPoolClock(c sip.Clock) ConnectionPoolOption {
+	return func(p *ConnectionPool) {
+		p.clock = c
+	}
+}
+
+type poolEntry struct {
+	addr        string
+	conn        Connection
+	idleRef     int
+	lastUsed    time.Time
+	idleTimeout time.Duration
+	timer       sip.Timer
+	elem        *list.Element
+}
+
+// ConnectionPool keeps connections by remote address. Multiple connections can exist for same address.
+// Connection is idle when it has no traffic and its reference is not increased above IdleConnection
+// by transactions.
 type ConnectionPool struct {
 	// TODO consider sync.Map way with atomic checks to reduce mutex contention
 	sync.RWMutex
-	m map[string]Connection
+	m      map[string][]*poolEntry
+	byConn map[Connection]*poolEntry
+	// lru keeps entries ordered by last usage, most recent in front
+	lru *list.List
+
+	maxPerAddr  int
+	maxConns    int
+	idleTimeout time.Duration
+	clock       sip.Clock
+
+	created uint64
+	closed  uint64
 }
 
-func NewConnectionPool() *ConnectionPool {
-	return &ConnectionPool{
-		m: make(map[string]Connection),
+func NewConnectionPool(options ...ConnectionPoolOption) *ConnectionPool {
+	p := &ConnectionPool{
+		m:          make(map[string][]*poolEntry),
+		byConn:     make(map[Connection]*poolEntry),
+		lru:        list.New(),
+		maxPerAddr: 1,
+		clock:      sip.SystemClock,
 	}
+	for _, o := range options {
+		o(p)
+	}
+	if p.maxPerAddr < 1 {
+		p.maxPerAddr = 1
+	}
+	return p
+}
+
+// isIdle checks is connection used by anyone except pool
+func (e *poolEntry) isIdle() bool {
+	return e.conn.Ref(0) <= e.idleRef
 }
 
+// Add adds accepted connection to pool. Connection is idle while its reference is not increased
 func (p *ConnectionPool) Add(a string, c Connection) {
-	// TODO how about multi connection support for same remote address
-	// We can then check ref count
+	p.add(a, c, false)
+}
+
+// addDialed adds connection created by us. Reference of creator is released
+// after first usage, so connection is idle with one reference less
+func (p *ConnectionPool) addDialed(a string, c Connection) {
+	p.add(a, c, true)
+}
 
+func (p *ConnectionPool) add(a string, c Connection, dialed bool) {
 	if c.Ref(0) < 1 {
 		c.Ref(1) // Make 1 reference count by default
 	}
+	idleRef := c.Ref(0)
+	if dialed {
+		idleRef--
+	}
+
 	p.Lock()
-	p.m[a] = c
+	if e, exists := p.byConn[c]; exists {
+		p.removeLocked(e)
+	}
+	e := &poolEntry{
+		addr:        a,
+		conn:        c,
+		idleRef:     idleRef,
+		lastUsed:    p.clock.Now(),
+		idleTimeout: p.idleTimeout,
+	}
+	// Connections above limit per address are replaced, oldest idle first.
+	// Connections used by transactions are kept, so limit can be exceeded until they are released
+	var replaced *poolEntry
+	if entries := p.m[a]; len(entries) >= p.maxPerAddr {
+		for _, en := range entries {
+			if en.isIdle() {
+				replaced = en
+				p.removeLocked(en)
+				break
+			}
+		}
+	}
+	e.elem = p.lru.PushFront(e)
+	p.m[a] = append(p.m[a], e)
+	p.byConn[c] = e
+	p.created++
+	if e.idleTimeout > 0 {
+		e.timer = p.clock.AfterFunc(e.idleTimeout, func() { p.checkIdle(e) })
+	}
+
+	var evicted *poolEntry
+	if p.maxConns > 0 && len(p.byConn) > p.maxConns {
+		evicted = p.evictLocked(e)
+	}
 	p.Unlock()
+
+	if replaced != nil {
+		p.closeEntry(replaced, "max connections per address reached")
+	}
+	if evicted != nil {
+		p.closeEntry(evicted, "max connections reached")
+	}
+}
+
+// evictLocked removes least recently used idle connection, skipping just added one
+func (p *ConnectionPool) evictLocked(skip *poolEntry) *poolEntry {
+	for el := p.lru.Back(); el != nil; el = el.Prev() {
+		e := el.Value.(*poolEntry)
+		if e == skip || !e.isIdle() {
+			continue
+		}
+		p.removeLocked(e)
+		return e
+	}
+	slog.Warn("Connection pool limit exceeded, no idle connection to evict", "max", p.maxConns, "active", len(p.byConn))
+	return nil
 }
 
 // Getting connection pool increases reference
 // Make sure you TryClose after finish
+// With multiple connections for address, least used one is returned.
 func (p *ConnectionPool) Get(a string) (c Connection) {
+	p.Lock()
+	entries := p.m[a]
+	if len(entries) == 0 {
+		p.Unlock()
+		return nil
+	}
+
+	e := entries[0]
+	ref := e.conn.Ref(0)
+	for _, en := range entries[1:] {
+		if r := en.conn.Ref(0); r < ref {
+			e, ref = en, r
+		}
+	}
+	p.touchLocked(e)
+	p.Unlock()
+
+	e.conn.Ref(1)
+	return e.conn
+}
+
+// Available checks can existing connection to address be reused. It is false if all connections
+// are in use and limit of connections per address is not reached, so new connection should be created.
+func (p *ConnectionPool) Available(a string) bool {
 	p.RLock()
-	c, exists := p.m[a]
-	p.RUnlock()
+	defer p.RUnlock()
+	entries := p.m[a]
+	if len(entries) == 0 || len(entries) >= p.maxPerAddr {
+		return true
+	}
+	for _, e := range entries {
+		if e.isIdle() {
+			return true
+		}
+	}
+	return false
+}
+
+// Touch marks connection as used, for example when data is received on it
+func (p *ConnectionPool) Touch(c Connection) {
+	p.Lock()
+	if e, exists := p.byConn[c]; exists {
+		p.touchLocked(e)
+	}
+	p.Unlock()
+}
+
+func (p *ConnectionPool) touchLocked(e *poolEntry) {
+	e.lastUsed = p.clock.Now()
+	p.lru.MoveToFront(e.elem)
+}
+
+// SetIdleTimeout overrides idle timeout of connection in pool. Zero disables timeout.
+func (p *ConnectionPool) SetIdleTimeout(c Connection, d time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+	e, exists := p.byConn[c]
 	if !exists {
-		return nil
+		return
+	}
+	e.idleTimeout = d
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	if d > 0 {
+		e.timer = p.clock.AfterFunc(d, func() { p.checkIdle(e) })
+	}
+}
+
+func (p *ConnectionPool) checkIdle(e *poolEntry) {
+	p.Lock()
+	if p.byConn[e.conn] != e || e.idleTimeout <= 0 {
+		p.Unlock()
+		return
 	}
-	c.Ref(1)
-	// TODO handling more references
-	// if c.Ref(1) <= 1 {
-	// 	return nil
-	// }
 
-	return c
+	elapsed := p.clock.Now().Sub(e.lastUsed)
+	switch {
+	case elapsed < e.idleTimeout:
+		e.timer = p.clock.AfterFunc(e.idleTimeout-elapsed, func() { p.checkIdle(e) })
+		p.Unlock()
+		return
+	case !e.isIdle():
+		e.timer = p.clock.AfterFunc(e.idleTimeout, func() { p.checkIdle(e) })
+		p.Unlock()
+		return
+	}
+	p.removeLocked(e)
+	p.Unlock()
+
+	p.closeEntry(e, "idle timeout")
+}
+
+func (p *ConnectionPool) closeEntry(e *poolEntry, reason string) {
+	slog.Debug("Closing pool connection", "addr", e.addr, "reason", reason)
+	if err := e.conn.Close(); err != nil {
+		slog.Debug("Closing conection return error", "err", err)
+	}
+}
+
+func (p *ConnectionPool) removeLocked(e *poolEntry) {
+	if p.byConn[e.conn] != e {
+		return
+	}
+	delete(p.byConn, e.conn)
+	p.lru.Remove(e.elem)
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+
+	entries := p.m[e.addr]
+	for i, en := range entries {
+		if en == e {
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	if len(entries) == 0 {
+		delete(p.m, e.addr)
+	} else {
+		p.m[e.addr] = entries
+	}
+	p.closed++
 }
 
 // CloseAndDelete closes connection and deletes from pool
@@ -59,28 +328,45 @@ func (p *ConnectionPool) CloseAndDelete(c Connection, addr string) {
 			slog.Warn("Closing conection return error", "err", err)
 		}
 	}
-	delete(p.m, addr)
+	if e, exists := p.byConn[c]; exists {
+		p.removeLocked(e)
+	}
 }
 
 // Clear will clear all connection from pool and close them
 func (p *ConnectionPool) Clear() {
 	p.Lock()
 	defer p.Unlock()
-	for _, c := range p.m {
-		if c.Ref(0) <= 0 {
-			continue
-		}
-		if err := c.Close(); err != nil {
-			slog.Warn("Closing conection return error", "err", err)
+	for c, e := range p.byConn {
+		if c.Ref(0) > 0 {
+			if err := c.Close(); err != nil {
+				slog.Warn("Closing conection return error", "err", err)
+			}
 		}
+		p.removeLocked(e)
 	}
-	// Remove all
-	p.m = make(map[string]Connection)
 }
 
 func (p *ConnectionPool) Size() int {
 	p.RLock()
-	l := len(p.m)
+	l := len(p.byConn)
 	p.RUnlock()
 	return l
 }
+
+// Stats returns counters of pool
+func (p *ConnectionPool) Stats() ConnectionPoolStats {
+	p.RLock()
+	defer p.RUnlock()
+	s := ConnectionPoolStats{
+		Active:  len(p.byConn),
+		Created: p.created,
+		Closed:  p.closed,
+	}
+	for _, e := range p.byConn {
+		if e.isIdle() {
+			s.Idle++
+		}
+	}
+	return s
+}
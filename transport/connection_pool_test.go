@@ -5,6 +5,10 @@ import (
 	"net"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/livekit/sipgo/fakes"
 )
@@ -28,6 +32,124 @@ func TestConnectionPool(t *testing.T) {
 	}
 }
 
+func testPoolConn(port int) *TCPConnection {
+	return &TCPConnection{
+		Conn: &fakes.TCPConn{
+			LAddr: net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: port},
+			RAddr: net.TCPAddr{IP: net.ParseIP("127.0.0.2"), Port: 5060},
+		},
+		refcount: 1 + IdleConnection,
+	}
+}
+
+func TestConnectionPoolMultiple(t *testing.T) {
+	pool := NewConnectionPool(WithConnectionPoolMaxPerAddr(2))
+	addr := "127.0.0.2:5060"
+	c1 := testPoolConn(1000)
+	pool.Add(addr, c1)
+	assert.True(t, pool.Available(addr))
+
+	// Busy connection allows second connection
+	c := pool.Get(addr)
+	require.Equal(t, c1, c)
+	assert.False(t, pool.Available(addr))
+
+	c2 := testPoolConn(1001)
+	pool.Add(addr, c2)
+	assert.True(t, pool.Available(addr))
+	// Least used connection is returned
+	c = pool.Get(addr)
+	require.Equal(t, c2, c)
+
+	// Limit is reached, existing busy connection is reused
+	assert.True(t, pool.Available(addr))
+
+	// Busy connections are not replaced
+	c3 := testPoolConn(1002)
+	pool.Add(addr, c3)
+	stats := pool.Stats()
+	assert.Equal(t, 3, stats.Active)
+	assert.EqualValues(t, 0, stats.Closed)
+
+	// Oldest idle connection is replaced and closed
+	c1.TryClose()
+	pool.Add(addr, testPoolConn(1003))
+	stats = pool.Stats()
+	assert.Equal(t, 3, stats.Active)
+	assert.EqualValues(t, 4, stats.Created)
+	assert.EqualValues(t, 1, stats.Closed)
+	assert.Equal(t, 0, c1.Ref(0))
+
+	pool.CloseAndDelete(c2, addr)
+	stats = pool.Stats()
+	assert.Equal(t, 2, stats.Active)
+	assert.Equal(t, 2, stats.Idle)
+	assert.EqualValues(t, 2, stats.Closed)
+}
+
+func TestConnectionPoolIdleTimeout(t *testing.T) {
+	clock := fakes.NewClock(time.Now())
+	pool := NewConnectionPool(WithConnectionPoolIdleTimeout(time.Minute), WithConnectionPoolClock(clock))
+
+	c1 := testPoolConn(1000)
+	c2 := testPoolConn(1001)
+	c3 := testPoolConn(1002)
+	pool.Add("127.0.0.2:5060", c1)
+	pool.Add("127.0.0.3:5060", c2)
+	pool.Add("127.0.0.4:5060", c3)
+	pool.SetIdleTimeout(c3, 0)
+
+	// Traffic postpones timeout
+	clock.Advance(30 * time.Second)
+	pool.Touch(c1)
+	// Connection in use is not closed
+	busy := pool.Get("127.0.0.3:5060")
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, 3, pool.Stats().Active)
+	assert.Equal(t, 3, pool.Size())
+
+	clock.Advance(30 * time.Second)
+	assert.Nil(t, pool.Get("127.0.0.2:5060"))
+	assert.Equal(t, 2, pool.Stats().Active)
+
+	busy.TryClose()
+	clock.Advance(time.Minute)
+	assert.Nil(t, pool.Get("127.0.0.3:5060"))
+
+	// Connection without timeout is kept
+	clock.Advance(time.Hour)
+	c := pool.Get("127.0.0.4:5060")
+	require.NotNil(t, c)
+	assert.Equal(t, 1, pool.Stats().Active)
+}
+
+func TestConnectionPoolMaxConnections(t *testing.T) {
+	clock := fakes.NewClock(time.Now())
+	pool := NewConnectionPool(WithConnectionPoolMaxConnections(2), WithConnectionPoolClock(clock))
+
+	c1 := testPoolConn(1000)
+	c2 := testPoolConn(1001)
+	pool.Add("127.0.0.2:5060", c1)
+	clock.Advance(time.Second)
+	pool.Add("127.0.0.3:5060", c2)
+	clock.Advance(time.Second)
+	// c1 is recently used
+	busy := pool.Get("127.0.0.2:5060")
+	busy.TryClose()
+
+	// Least recently used is evicted
+	pool.Add("127.0.0.4:5060", testPoolConn(1002))
+	assert.Nil(t, pool.Get("127.0.0.3:5060"))
+	assert.Equal(t, 2, pool.Stats().Active)
+
+	// Busy connections are not evicted
+	busy = pool.Get("127.0.0.2:5060")
+	pool.Get("127.0.0.4:5060")
+	pool.Add("127.0.0.5:5060", testPoolConn(1003))
+	assert.Equal(t, 3, pool.Stats().Active)
+	assert.Equal(t, 1, pool.Stats().Idle)
+}
+
 func BenchmarkConnectionPool(b *testing.B) {
 	slog.SetDefault(slog.New(
 		slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelWarn}),
@@ -0,0 +1,12 @@
+package transport
+
+// NAPTR is DNS Naming Authority Pointer record
+// https://datatracker.ietf.org/doc/html/rfc3403#section-4.1
+type NAPTR struct {
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Service     string
+	Regexp      string
+	Replacement string
+}
@@ -0,0 +1,386 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+// DNSResolver resolves DNS records needed for locating SIP servers.
+// Returned TTL is lowest TTL of records, or zero if it is unknown.
+type DNSResolver interface {
+	// LookupNAPTR can return no records if resolver does not support NAPTR, in which case
+	// transport is selected with SRV lookups
+	LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, time.Duration, error)
+	// LookupSRV looks up _service._proto.name, or name directly if service and proto are empty
+	LookupSRV(ctx context.Context, service, proto, name string) ([]*net.SRV, time.Duration, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error)
+}
+
+func srvName(service, proto, name string) string {
+	if service == "" && proto == "" {
+		return name
+	}
+	return "_" + service + "._" + proto + "." + name
+}
+
+// netDNSResolver is DNSResolver based on net.Resolver
+type netDNSResolver struct {
+	r *net.Resolver
+}
+
+// NewDNSResolver creates DNSResolver using r. TTL of records is unknown.
+// net.Resolver does not support NAPTR, so servers are located with SRV and A/AAAA lookups only.
+// To use NAPTR pass own DNSResolver with WithLayerDNSCache.
+func NewDNSResolver(r *net.Resolver) DNSResolver {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	return &netDNSResolver{r: r}
+}
+
+func (d *netDNSResolver) LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, time.Duration, error) {
+	return nil, 0, nil
+}
+
+func (d *netDNSResolver) LookupSRV(ctx context.Context, service, proto, name string) ([]*net.SRV, time.Duration, error) {
+	_, addrs, err := d.r.LookupSRV(ctx, service, proto, name)
+	return addrs, 0, err
+}
+
+func (d *netDNSResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	ips, err := d.r.LookupIPAddr(ctx, host)
+	return ips, 0, err
+}
+
+func isDNSNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+const (
+	DNSCacheDefaultTTL  = 60 * time.Second
+	DNSCacheMaxTTL      = time.Hour
+	DNSCacheNegativeTTL = 30 * time.Second
+	DNSCacheBlacklist   = 30 * time.Second
+
+	// dnsCacheSweepSize is number of entries after which expired entries are removed on insert
+	dnsCacheSweepSize = 1024
+)
+
+type dnsCacheEntry struct {
+	naptr   []*NAPTR
+	srv     []*net.SRV
+	ips     []net.IPAddr
+	err     error
+	expires time.Time
+}
+
+// DNSCache caches results of DNSResolver for their TTL. Names which do not exist are cached
+// for negative TTL, while temporary failures are not cached.
+// It also keeps blacklist of targets which were not reachable, so they are tried last.
+type DNSCache struct {
+	resolver DNSResolver
+
+	defaultTTL    time.Duration
+	maxTTL        time.Duration
+	negativeTTL   time.Duration
+	blacklistTime time.Duration
+	clock         sip.Clock
+
+	mu        sync.Mutex
+	entries   map[string]dnsCacheEntry
+	blacklist map[string]time.Time
+}
+
+type DNSCacheOption func(c *DNSCache)
+
+// WithDNSCacheTTL sets TTL used when resolver does not return TTL of records, and maximum TTL
+// Default: 60s and 1h
+func WithDNSCacheTTL(defaultTTL time.Duration, maxTTL time.Duration) DNSCacheOption {
+	return func(c *DNSCache) {
+		c.defaultTTL = defaultTTL
+		c.maxTTL = maxTTL
+	}
+}
+
+// WithDNSCacheNegativeTTL sets how long not existing names are cached. Zero disables negative caching
+// Default: 30s
+func WithDNSCacheNegativeTTL(ttl time.Duration) DNSCacheOption {
+	return func(c *DNSCache) {
+		c.negativeTTL = ttl
+	}
+}
+
+// WithDNSCacheBlacklist sets how long unreachable target is tried last. Zero disables blacklisting
+// Default: 30s
+func WithDNSCacheBlacklist(d time.Duration) DNSCacheOption {
+	return func(c *DNSCache) {
+		c.blacklistTime = d
+	}
+}
+
+// WithDNSCacheClock sets clock used for expiring records and blacklisted targets
+// Default: sip.SystemClock
+func WithDNSCacheClock(clock sip.Clock) DNSCacheOption {
+	return func(c *DNSCache) {
+		c.clock = clock
+	}
+}
+
+// NewDNSCache creates cache for resolver
+func NewDNSCache(resolver DNSResolver, options ...DNSCacheOption) *DNSCache {
+	c := &DNSCache{
+		resolver:      resolver,
+		defaultTTL:    DNSCacheDefaultTTL,
+		maxTTL:        DNSCacheMaxTTL,
+		negativeTTL:   DNSCacheNegativeTTL,
+		blacklistTime: DNSCacheBlacklist,
+		clock:         sip.SystemClock,
+		entries:       make(map[string]dnsCacheEntry),
+		blacklist:     make(map[string]time.Time),
+	}
+	for _, o := range options {
+		o(c)
+	}
+	return c
+}
+
+func (c *DNSCache) LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, time.Duration, error) {
+	e, ttl, err := c.lookup("NAPTR "+name, func() (dnsCacheEntry, time.Duration, error) {
+		r, ttl, err := c.resolver.LookupNAPTR(ctx, name)
+		return dnsCacheEntry{naptr: r}, ttl, err
+	})
+	return e.naptr, ttl, err
+}
+
+func (c *DNSCache) LookupSRV(ctx context.Context, service, proto, name string) ([]*net.SRV, time.Duration, error) {
+	e, ttl, err := c.lookup("SRV "+srvName(service, proto, name), func() (dnsCacheEntry, time.Duration, error) {
+		r, ttl, err := c.resolver.LookupSRV(ctx, service, proto, name)
+		return dnsCacheEntry{srv: r}, ttl, err
+	})
+	return e.srv, ttl, err
+}
+
+func (c *DNSCache) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	e, ttl, err := c.lookup("IP "+host, func() (dnsCacheEntry, time.Duration, error) {
+		r, ttl, err := c.resolver.LookupIPAddr(ctx, host)
+		return dnsCacheEntry{ips: r}, ttl, err
+	})
+	return e.ips, ttl, err
+}
+
+func (c *DNSCache) lookup(key string, resolve func() (dnsCacheEntry, time.Duration, error)) (dnsCacheEntry, time.Duration, error) {
+	key = strings.ToLower(strings.TrimSuffix(key, "."))
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	now := c.clock.Now()
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e, e.expires.Sub(now), e.err
+	}
+
+	// Concurrent lookups of same name are not merged, last result is cached
+	e, ttl, err := resolve()
+	switch {
+	case err == nil:
+		if ttl <= 0 {
+			ttl = c.defaultTTL
+		}
+		ttl = min(ttl, c.maxTTL)
+	case isDNSNotFound(err):
+		e.err = err
+		ttl = c.negativeTTL
+	default:
+		return e, 0, err
+	}
+	if ttl <= 0 {
+		return e, 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now = c.clock.Now()
+	if len(c.entries) >= dnsCacheSweepSize {
+		for k, v := range c.entries {
+			if !now.Before(v.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	e.expires = now.Add(ttl)
+	c.entries[key] = e
+	return e, ttl, err
+}
+
+// Flush removes all cached records and blacklisted targets
+func (c *DNSCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	clear(c.blacklist)
+}
+
+// Blacklist marks target as unreachable. It is tried after other targets until blacklist time passes.
+func (c *DNSCache) Blacklist(t Target) {
+	if c.blacklistTime <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blacklist[t.String()] = c.clock.Now().Add(c.blacklistTime)
+}
+
+// IsBlacklisted checks is target marked as unreachable
+func (c *DNSCache) IsBlacklisted(t Target) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isBlacklisted(t.String(), c.clock.Now())
+}
+
+func (c *DNSCache) isBlacklisted(key string, now time.Time) bool {
+	until, ok := c.blacklist[key]
+	if !ok {
+		return false
+	}
+	if !now.Before(until) {
+		delete(c.blacklist, key)
+		return false
+	}
+	return true
+}
+
+// sortBlacklisted moves blacklisted targets to the end keeping order of others
+func (c *DNSCache) sortBlacklisted(targets []Target) []Target {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.blacklist) == 0 {
+		return targets
+	}
+
+	now := c.clock.Now()
+	blacklisted := make(map[string]bool)
+	for _, t := range targets {
+		if key := t.String(); c.isBlacklisted(key, now) {
+			blacklisted[key] = true
+		}
+	}
+	if len(blacklisted) == 0 {
+		return targets
+	}
+
+	targets = slices.Clone(targets)
+	slices.SortStableFunc(targets, func(a, b Target) int {
+		ba, bb := blacklisted[a.String()], blacklisted[b.String()]
+		switch {
+		case ba == bb:
+			return 0
+		case bb:
+			return -1
+		}
+		return 1
+	})
+	return targets
+}
+
+// DNSTable is DNSResolver with static records. It is useful for tests or static configuration.
+// Names are matched case insensitive and without trailing dot.
+type DNSTable struct {
+	// TTL returned with records
+	TTL time.Duration
+
+	mu    sync.RWMutex
+	naptr map[string][]*NAPTR
+	srv   map[string][]*net.SRV
+	ips   map[string][]net.IPAddr
+}
+
+func NewDNSTable() *DNSTable {
+	return &DNSTable{
+		naptr: make(map[string][]*NAPTR),
+		srv:   make(map[string][]*net.SRV),
+		ips:   make(map[string][]net.IPAddr),
+	}
+}
+
+func dnsTableKey(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// AddNAPTR adds NAPTR records for name
+func (t *DNSTable) AddNAPTR(name string, records ...*NAPTR) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := dnsTableKey(name)
+	t.naptr[key] = append(t.naptr[key], records...)
+}
+
+// AddSRV adds SRV records for _service._proto.name
+func (t *DNSTable) AddSRV(service, proto, name string, records ...*net.SRV) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := dnsTableKey(srvName(service, proto, name))
+	t.srv[key] = append(t.srv[key], records...)
+}
+
+// AddIP adds A or AAAA records for host
+func (t *DNSTable) AddIP(host string, ips ...net.IP) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := dnsTableKey(host)
+	for _, ip := range ips {
+		t.ips[key] = append(t.ips[key], net.IPAddr{IP: ip})
+	}
+}
+
+// Remove removes all records of name
+func (t *DNSTable) Remove(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := dnsTableKey(name)
+	delete(t.naptr, key)
+	delete(t.srv, key)
+	delete(t.ips, key)
+}
+
+func (t *DNSTable) notFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (t *DNSTable) LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, time.Duration, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	r, ok := t.naptr[dnsTableKey(name)]
+	if !ok {
+		return nil, 0, t.notFound(name)
+	}
+	return slices.Clone(r), t.TTL, nil
+}
+
+func (t *DNSTable) LookupSRV(ctx context.Context, service, proto, name string) ([]*net.SRV, time.Duration, error) {
+	name = srvName(service, proto, name)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	r, ok := t.srv[dnsTableKey(name)]
+	if !ok {
+		return nil, 0, t.notFound(name)
+	}
+	return slices.Clone(r), t.TTL, nil
+}
+
+func (t *DNSTable) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	r, ok := t.ips[dnsTableKey(host)]
+	if !ok {
+		return nil, 0, t.notFound(host)
+	}
+	return slices.Clone(r), t.TTL, nil
+}
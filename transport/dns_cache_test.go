@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sipgo/fakes"
+)
+
+type countingDNS struct {
+	DNSResolver
+	queries atomic.Int32
+}
+
+func (d *countingDNS) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	d.queries.Add(1)
+	return d.DNSResolver.LookupIPAddr(ctx, host)
+}
+
+func TestDNSCache(t *testing.T) {
+	table := NewDNSTable()
+	table.AddIP("a.example.com", net.ParseIP("10.0.0.1"))
+	dns := &countingDNS{DNSResolver: table}
+
+	clock := fakes.NewClock(time.Now())
+	c := NewDNSCache(dns,
+		WithDNSCacheTTL(time.Minute, 10*time.Minute),
+		WithDNSCacheNegativeTTL(10*time.Second),
+		WithDNSCacheClock(clock),
+	)
+	ctx := context.Background()
+
+	t.Run("TTL", func(t *testing.T) {
+		table.TTL = 30 * time.Second
+		ips, ttl, err := c.LookupIPAddr(ctx, "a.example.com")
+		require.NoError(t, err)
+		require.Len(t, ips, 1)
+		assert.Equal(t, 30*time.Second, ttl)
+
+		// Cached until TTL expires, names are case insensitive
+		clock.Advance(20 * time.Second)
+		ips, ttl, err = c.LookupIPAddr(ctx, "A.example.com.")
+		require.NoError(t, err)
+		require.Len(t, ips, 1)
+		assert.Equal(t, 10*time.Second, ttl)
+		assert.EqualValues(t, 1, dns.queries.Load())
+
+		clock.Advance(10 * time.Second)
+		_, _, err = c.LookupIPAddr(ctx, "a.example.com")
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, dns.queries.Load())
+	})
+
+	t.Run("DefaultTTL", func(t *testing.T) {
+		c.Flush()
+		dns.queries.Store(0)
+		table.TTL = 0
+		_, ttl, err := c.LookupIPAddr(ctx, "a.example.com")
+		require.NoError(t, err)
+		assert.Equal(t, time.Minute, ttl)
+
+		table.TTL = 24 * time.Hour
+		c.Flush()
+		_, ttl, err = c.LookupIPAddr(ctx, "a.example.com")
+		require.NoError(t, err)
+		assert.Equal(t, 10*time.Minute, ttl)
+	})
+
+	t.Run("Negative", func(t *testing.T) {
+		dns.queries.Store(0)
+		_, _, err := c.LookupIPAddr(ctx, "b.example.com")
+		require.Error(t, err)
+		assert.True(t, isDNSNotFound(err))
+
+		table.AddIP("b.example.com", net.ParseIP("10.0.0.2"))
+		_, _, err = c.LookupIPAddr(ctx, "b.example.com")
+		require.Error(t, err)
+		assert.EqualValues(t, 1, dns.queries.Load())
+
+		clock.Advance(10 * time.Second)
+		ips, _, err := c.LookupIPAddr(ctx, "b.example.com")
+		require.NoError(t, err)
+		require.Len(t, ips, 1)
+		assert.EqualValues(t, 2, dns.queries.Load())
+	})
+
+	t.Run("Blacklist", func(t *testing.T) {
+		targets := []Target{
+			{Network: "udp", Addr: Addr{IP: net.ParseIP("10.0.0.1"), Port: 5060}},
+			{Network: "udp", Addr: Addr{IP: net.ParseIP("10.0.0.2"), Port: 5060}},
+			{Network: "udp", Addr: Addr{IP: net.ParseIP("10.0.0.3"), Port: 5060}},
+		}
+		c.Blacklist(targets[0])
+		assert.True(t, c.IsBlacklisted(targets[0]))
+		assert.Equal(t, []Target{targets[1], targets[2], targets[0]}, c.sortBlacklisted(targets))
+
+		clock.Advance(DNSCacheBlacklist)
+		assert.False(t, c.IsBlacklisted(targets[0]))
+		assert.Equal(t, targets, c.sortBlacklisted(targets))
+	})
+}
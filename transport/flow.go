@@ -0,0 +1,414 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	mrand "math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+// SIP Outbound flows RFC 5626
+// https://datatracker.ietf.org/doc/html/rfc5626
+var (
+	// FlowKeepAliveUDP is default keepalive interval for UDP flows
+	FlowKeepAliveUDP = 29 * time.Second
+	// FlowKeepAliveStream is default keepalive interval for connection oriented flows
+	FlowKeepAliveStream = 120 * time.Second
+	// FlowPongTimeout is time to wait for keepalive response
+	FlowPongTimeout = 10 * time.Second
+
+	ErrFlowFailed = errors.New("flow failed")
+)
+
+var (
+	crlfPing = []byte("\r\n\r\n")
+	crlfPong = []byte("\r\n")
+)
+
+// Flow is connection between local and remote address over which messages are exchanged.
+// https://datatracker.ietf.org/doc/html/rfc5626#section-3.3
+type Flow struct {
+	// Network is lowercase transport like udp, tcp, tls
+	Network    string
+	LocalAddr  string
+	RemoteAddr string
+}
+
+func (f Flow) String() string {
+	return f.Network + ":" + f.LocalAddr + "->" + f.RemoteAddr
+}
+
+// FlowFailureHandler is called when keepalive detects that flow is not working anymore
+type FlowFailureHandler func(f Flow, err error)
+
+// FlowTokens encodes flows into tokens which edge proxy puts into Path or Record-Route
+// and decodes them when requests are routed back to user agent.
+// Tokens are signed so that they can not be forged.
+// https://datatracker.ietf.org/doc/html/rfc5626#section-5.2
+type FlowTokens struct {
+	key []byte
+}
+
+const flowTokenMACSize = 10
+
+// NewFlowTokens creates flow tokens signed with key.
+// If key is empty random key is generated, which means tokens are valid only for this process.
+func NewFlowTokens(key []byte) *FlowTokens {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+	return &FlowTokens{key: key}
+}
+
+func (t *FlowTokens) mac(data []byte) []byte {
+	h := hmac.New(sha256.New, t.key)
+	h.Write(data)
+	return h.Sum(nil)[:flowTokenMACSize]
+}
+
+// Encode returns token of flow which can be used as user part of SIP URI
+func (t *FlowTokens) Encode(f Flow) string {
+	data := []byte(f.Network + "|" + f.LocalAddr + "|" + f.RemoteAddr)
+	buf := append(t.mac(data), data...)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// Decode returns flow of token. Error is returned if token is not valid or is not signed with same key
+func (t *FlowTokens) Decode(token string) (Flow, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(buf) <= flowTokenMACSize {
+		return Flow{}, fmt.Errorf("invalid flow token")
+	}
+	mac, data := buf[:flowTokenMACSize], buf[flowTokenMACSize:]
+	if !hmac.Equal(mac, t.mac(data)) {
+		return Flow{}, fmt.Errorf("invalid flow token signature")
+	}
+	parts := strings.Split(string(data), "|")
+	if len(parts) != 3 {
+		return Flow{}, fmt.Errorf("invalid flow token")
+	}
+	return Flow{Network: parts[0], LocalAddr: parts[1], RemoteAddr: parts[2]}, nil
+}
+
+// RequestFlow returns flow over which request was received
+func (l *Layer) RequestFlow(req *sip.Request) (Flow, error) {
+	network := NetworkToLower(req.Transport())
+	c, err := l.getConnection(network, req.Source())
+	if err != nil {
+		return Flow{}, err
+	}
+	defer c.TryClose()
+	return Flow{Network: network, LocalAddr: c.LocalAddr().String(), RemoteAddr: req.Source()}, nil
+}
+
+// FlowConnection returns connection of flow. It fails with ErrFlowFailed if connection
+// does not exist anymore, in which case proxy should respond with 430 Flow Failed.
+// Make sure you TryClose after finish.
+// https://datatracker.ietf.org/doc/html/rfc5626#section-5.3
+func (l *Layer) FlowConnection(f Flow) (Connection, error) {
+	c, err := l.getConnection(f.Network, f.RemoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrFlowFailed, err)
+	}
+	if c.LocalAddr().String() != f.LocalAddr {
+		c.TryClose()
+		return nil, fmt.Errorf("%w: connection %s is not active", ErrFlowFailed, f)
+	}
+	return c, nil
+}
+
+// OnFlowFailure adds handler called when flow with keepalive fails
+func (l *Layer) OnFlowFailure(h FlowFailureHandler) {
+	l.flows.mu.Lock()
+	defer l.flows.mu.Unlock()
+	l.flows.handlers = append(l.flows.handlers, h)
+}
+
+// KeepAlive starts keepalives on existing connection to addr.
+// Stream transports send double CRLF and expect single CRLF back, UDP sends STUN binding requests.
+// Keepalive is sent at random time between 80 and 100% of interval, or default interval of transport if zero.
+// If response is not received within FlowPongTimeout, connection is closed or STUN mapped address changes,
+// flow failure is reported to OnFlowFailure handlers and keepalive is stopped.
+// Existing keepalive of same flow is replaced.
+// https://datatracker.ietf.org/doc/html/rfc5626#section-4.4
+func (l *Layer) KeepAlive(network string, addr string, interval time.Duration) (*FlowKeepAlive, error) {
+	network = NetworkToLower(network)
+	if network == "ws" || network == "wss" {
+		return nil, fmt.Errorf("keepalive on %s: %w", network, ErrNetworkNotSuported)
+	}
+	if interval <= 0 {
+		interval = FlowKeepAliveStream
+		if network == "udp" {
+			interval = FlowKeepAliveUDP
+		}
+	}
+
+	c, err := l.getConnection(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &FlowKeepAlive{
+		l:        l,
+		conn:     c,
+		interval: interval,
+		log:      l.log,
+	}
+	if network == "udp" {
+		raddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			c.TryClose()
+			return nil, err
+		}
+		k.raddr = raddr
+		addr = raddr.String()
+	}
+	k.flow = Flow{Network: network, LocalAddr: c.LocalAddr().String(), RemoteAddr: addr}
+	k.key = flowKey(network, addr)
+
+	l.flows.mu.Lock()
+	prev := l.flows.flows[k.key]
+	l.flows.flows[k.key] = k
+	k.mu.Lock()
+	k.timer = l.clock.AfterFunc(k.nextInterval(), k.ping)
+	k.mu.Unlock()
+	l.flows.mu.Unlock()
+
+	if prev != nil {
+		prev.stop()
+	}
+	return k, nil
+}
+
+func flowKey(network string, raddr string) string {
+	return network + "|" + raddr
+}
+
+// flowKeepAlives tracks flows with keepalive. Transports report received
+// keepalive responses and closed connections to it.
+type flowKeepAlives struct {
+	mu       sync.Mutex
+	flows    map[string]*FlowKeepAlive
+	handlers []FlowFailureHandler
+}
+
+func newFlowKeepAlives() *flowKeepAlives {
+	return &flowKeepAlives{flows: make(map[string]*FlowKeepAlive)}
+}
+
+func (f *flowKeepAlives) get(network string, raddr string) *FlowKeepAlive {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.flows[flowKey(network, raddr)]
+}
+
+func (f *flowKeepAlives) remove(k *FlowKeepAlive) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.flows[k.key] == k {
+		delete(f.flows, k.key)
+	}
+}
+
+func (f *flowKeepAlives) failed(flow Flow, err error) {
+	f.mu.Lock()
+	handlers := f.handlers
+	f.mu.Unlock()
+	for _, h := range handlers {
+		h(flow, err)
+	}
+}
+
+// pong is called when CRLF keepalive response is received on stream connection
+func (f *flowKeepAlives) pong(network string, raddr string) {
+	if k := f.get(network, raddr); k != nil {
+		k.pong()
+	}
+}
+
+// stunResponse is called when STUN binding response is received on UDP
+func (f *flowKeepAlives) stunResponse(raddr string, msg stunMessage) {
+	if k := f.get("udp", raddr); k != nil {
+		k.stunResponse(msg)
+	}
+}
+
+// closed is called when connection is closed
+func (f *flowKeepAlives) closed(network string, raddr string) {
+	if k := f.get(network, raddr); k != nil {
+		k.fail(fmt.Errorf("%w: connection closed", ErrFlowFailed))
+	}
+}
+
+// FlowKeepAlive sends keepalives on flow. Created with Layer.KeepAlive
+type FlowKeepAlive struct {
+	l        *Layer
+	flow     Flow
+	key      string
+	conn     Connection
+	raddr    *net.UDPAddr
+	interval time.Duration
+	log      *slog.Logger
+
+	mu      sync.Mutex
+	timer   sip.Timer
+	waiting bool
+	txID    [12]byte
+	mapped  string
+	done    bool
+}
+
+// Flow returns flow on which keepalives are sent
+func (k *FlowKeepAlive) Flow() Flow {
+	return k.flow
+}
+
+// Stop stops sending keepalives. Flow failure is not reported after stop.
+func (k *FlowKeepAlive) Stop() {
+	k.stop()
+}
+
+// MappedAddr returns address of flow seen by server in STUN response. It is empty for stream transports.
+func (k *FlowKeepAlive) MappedAddr() string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.mapped
+}
+
+func (k *FlowKeepAlive) nextInterval() time.Duration {
+	// Random between 80 and 100% of interval
+	return k.interval - time.Duration(mrand.Int63n(int64(k.interval)/5+1))
+}
+
+func (k *FlowKeepAlive) ping() {
+	k.mu.Lock()
+	if k.done {
+		k.mu.Unlock()
+		return
+	}
+	var data []byte
+	if k.raddr != nil {
+		k.txID = stunNewTxID()
+		data = stunEncode(stunMessage{typ: stunBindingRequest, txID: k.txID})
+	} else {
+		data = crlfPing
+	}
+	k.waiting = true
+	k.timer = k.l.clock.AfterFunc(FlowPongTimeout, k.timeout)
+	k.mu.Unlock()
+
+	k.log.Debug("Sending keepalive", "flow", k.flow.String())
+	if err := k.write(data); err != nil {
+		k.fail(fmt.Errorf("%w: %w", ErrFlowFailed, err))
+	}
+}
+
+func (k *FlowKeepAlive) write(data []byte) error {
+	var err error
+	switch c := k.conn.(type) {
+	case *UDPConnection:
+		if c.raddr != nil {
+			_, err = c.Write(data)
+		} else {
+			_, err = c.WriteTo(data, k.raddr)
+		}
+	case *TCPConnection:
+		_, err = c.Write(data)
+	default:
+		err = fmt.Errorf("keepalive is not supported on %T", c)
+	}
+	return err
+}
+
+func (k *FlowKeepAlive) pong() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.receivedLocked()
+}
+
+func (k *FlowKeepAlive) receivedLocked() {
+	if k.done || !k.waiting {
+		return
+	}
+	k.waiting = false
+	k.timer.Stop()
+	k.timer = k.l.clock.AfterFunc(k.nextInterval(), k.ping)
+}
+
+func (k *FlowKeepAlive) stunResponse(msg stunMessage) {
+	k.mu.Lock()
+	if msg.typ != stunBindingResponse || msg.txID != k.txID || msg.mapped == nil {
+		k.mu.Unlock()
+		return
+	}
+
+	// Changed mapped address means that NAT binding was lost
+	// https://datatracker.ietf.org/doc/html/rfc5626#section-4.4.2
+	mapped := msg.mapped.String()
+	if k.mapped != "" && k.mapped != mapped {
+		prev := k.mapped
+		k.mu.Unlock()
+		k.fail(fmt.Errorf("%w: mapped address changed from %s to %s", ErrFlowFailed, prev, mapped))
+		return
+	}
+	k.mapped = mapped
+	k.receivedLocked()
+	k.mu.Unlock()
+}
+
+func (k *FlowKeepAlive) timeout() {
+	k.mu.Lock()
+	waiting := k.waiting && !k.done
+	k.mu.Unlock()
+	if waiting {
+		k.fail(fmt.Errorf("%w: keepalive response timeout", ErrFlowFailed))
+	}
+}
+
+func (k *FlowKeepAlive) fail(err error) {
+	if !k.stop() {
+		return
+	}
+	k.log.Info("Flow failed", "flow", k.flow.String(), "err", err)
+	k.l.flows.failed(k.flow, err)
+}
+
+// stop returns false if keepalive was already stopped
+func (k *FlowKeepAlive) stop() bool {
+	k.mu.Lock()
+	if k.done {
+		k.mu.Unlock()
+		return false
+	}
+	k.done = true
+	if k.timer != nil {
+		k.timer.Stop()
+	}
+	k.mu.Unlock()
+
+	k.l.flows.remove(k)
+	k.conn.TryClose()
+	return true
+}
+
+// isCRLFKeepAlive checks is data only CRLF keepalive
+func isCRLFKeepAlive(data []byte) bool {
+	return len(data) <= 4 && len(bytes.Trim(data, "\r\n")) == 0
+}
@@ -0,0 +1,190 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	sipgo "github.com/emiago/sipgo/sip"
+
+	"github.com/livekit/sipgo/fakes"
+)
+
+func TestFlowTokens(t *testing.T) {
+	tokens := NewFlowTokens([]byte("secret"))
+	flow := Flow{Network: "tcp", LocalAddr: "10.0.0.1:5060", RemoteAddr: "[2001:db8::1]:49152"}
+
+	token := tokens.Encode(flow)
+	decoded, err := tokens.Decode(token)
+	require.NoError(t, err)
+	assert.Equal(t, flow, decoded)
+
+	_, err = NewFlowTokens([]byte("other")).Decode(token)
+	require.Error(t, err)
+	_, err = tokens.Decode(token[:len(token)-2])
+	require.Error(t, err)
+	_, err = tokens.Decode("user")
+	require.Error(t, err)
+}
+
+func TestSTUN(t *testing.T) {
+	for _, addr := range []*net.UDPAddr{
+		{IP: net.ParseIP("192.0.2.1").To4(), Port: 32853},
+		{IP: net.ParseIP("2001:db8::1"), Port: 5060},
+	} {
+		msg := stunMessage{typ: stunBindingResponse, txID: stunNewTxID(), mapped: addr}
+		data := stunEncode(msg)
+		require.True(t, isSTUN(data))
+
+		decoded, err := stunDecode(data)
+		require.NoError(t, err)
+		assert.Equal(t, msg.txID, decoded.txID)
+		assert.Equal(t, addr.String(), decoded.mapped.String())
+	}
+
+	assert.False(t, isSTUN([]byte("OPTIONS sip:bob@example.com SIP/2.0\r\n\r\n")))
+	assert.False(t, isSTUN([]byte("\r\n\r\n")))
+}
+
+func TestKeepAliveTCP(t *testing.T) {
+	srv := NewLayer(nil, sipgo.NewParser(), nil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.ServeTCP(ln)
+	defer ln.Close()
+
+	clock := fakes.NewClock(time.Now())
+	l := NewLayer(nil, sipgo.NewParser(), nil, WithLayerClock(clock))
+	defer l.Close()
+	failed := make(chan error, 1)
+	l.OnFlowFailure(func(f Flow, err error) {
+		failed <- err
+	})
+
+	raddr := ln.Addr().(*net.TCPAddr)
+	_, err = l.tcp.CreateConnection(Addr{}, "", Addr{IP: raddr.IP, Port: raddr.Port}, l.handleMessage)
+	require.NoError(t, err)
+
+	k, err := l.KeepAlive("TCP", raddr.String(), time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, raddr.String(), k.Flow().RemoteAddr)
+
+	// Double CRLF ping is answered with CRLF pong
+	clock.Advance(time.Second)
+	require.Eventually(t, func() bool {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		return !k.waiting
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Closing connection on server side is flow failure
+	srv.Close()
+	select {
+	case err := <-failed:
+		require.True(t, errors.Is(err, ErrFlowFailed))
+	case <-time.After(2 * time.Second):
+		t.Fatal("flow failure not reported")
+	}
+	assert.Nil(t, l.flows.get("tcp", raddr.String()))
+}
+
+func TestKeepAliveUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	clock := fakes.NewClock(time.Now())
+	l := NewLayer(nil, sipgo.NewParser(), nil, WithLayerClock(clock))
+	defer l.Close()
+	failed := make(chan error, 1)
+	l.OnFlowFailure(func(f Flow, err error) {
+		failed <- err
+	})
+
+	raddr := pc.LocalAddr().(*net.UDPAddr)
+	_, err = l.udp.CreateConnection(Addr{}, "", Addr{IP: raddr.IP, Port: raddr.Port}, l.handleMessage)
+	require.NoError(t, err)
+
+	k, err := l.KeepAlive("udp", raddr.String(), time.Second)
+	require.NoError(t, err)
+
+	readBinding := func() (stunMessage, net.Addr) {
+		buf := make([]byte, 1500)
+		pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, src, err := pc.ReadFrom(buf)
+		require.NoError(t, err)
+		msg, err := stunDecode(buf[:n])
+		require.NoError(t, err)
+		require.EqualValues(t, stunBindingRequest, msg.typ)
+		return msg, src
+	}
+
+	clock.Advance(time.Second)
+	req, src := readBinding()
+	mapped := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}
+	_, err = pc.WriteTo(stunEncode(stunMessage{typ: stunBindingResponse, txID: req.txID, mapped: mapped}), src)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return k.MappedAddr() == mapped.String()
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Changed NAT binding is flow failure
+	require.True(t, clock.BlockUntil(1, time.Second))
+	clock.Advance(time.Second)
+	req, src = readBinding()
+	mapped.Port = 40001
+	_, err = pc.WriteTo(stunEncode(stunMessage{typ: stunBindingResponse, txID: req.txID, mapped: mapped}), src)
+	require.NoError(t, err)
+	select {
+	case err := <-failed:
+		require.True(t, errors.Is(err, ErrFlowFailed))
+		assert.Contains(t, err.Error(), "mapped address changed")
+	case <-time.After(2 * time.Second):
+		t.Fatal("flow failure not reported")
+	}
+
+	// Missing response is flow failure
+	k, err = l.KeepAlive("udp", raddr.String(), time.Second)
+	require.NoError(t, err)
+	clock.Advance(time.Second)
+	readBinding()
+	clock.Advance(FlowPongTimeout)
+	select {
+	case err := <-failed:
+		require.True(t, errors.Is(err, ErrFlowFailed))
+		assert.Contains(t, err.Error(), "timeout")
+	case <-time.After(2 * time.Second):
+		t.Fatal("flow failure not reported")
+	}
+}
+
+func TestUDPSTUNServer(t *testing.T) {
+	l := NewLayer(nil, sipgo.NewParser(), nil)
+	defer l.Close()
+	srvConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go l.ServeUDP(srvConn)
+	defer srvConn.Close()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	txID := stunNewTxID()
+	_, err = pc.WriteTo(stunEncode(stunMessage{typ: stunBindingRequest, txID: txID}), srvConn.LocalAddr())
+	require.NoError(t, err)
+
+	buf := make([]byte, 1500)
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	msg, err := stunDecode(buf[:n])
+	require.NoError(t, err)
+	assert.EqualValues(t, stunBindingResponse, msg.typ)
+	assert.Equal(t, txID, msg.txID)
+	assert.Equal(t, pc.LocalAddr().String(), msg.mapped.String())
+}
@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
@@ -8,6 +9,9 @@ import (
 	"log/slog"
 	"math/rand"
 	"net"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -37,8 +41,13 @@ type Layer struct {
 	listenPorts   map[string][]int
 	listenPortsMu sync.Mutex
 	dnsResolver   *net.Resolver
+	dns           *DNSCache
+	clock         sip.Clock
+	flows         *flowKeepAlives
+	poolOpts      []ConnectionPoolOption
 
-	handlers []sip.MessageHandler
+	handlers     []sip.MessageHandler
+	interceptors []MessageInterceptor
 
 	log *slog.Logger
 
@@ -46,6 +55,36 @@ type Layer struct {
 	ConnectionReuse bool
 }
 
+type LayerOption func(l *Layer)
+
+// MessageInterceptor is called before message handlers. Returning true consumes message
+// and it is not passed to handlers, for example when it is forwarded by stateless proxy.
+type MessageInterceptor func(msg sip.Message) bool
+
+// WithLayerDNSCache sets DNS cache used for locating servers.
+// Default: cache of NewDNSResolver with dns resolver passed to NewLayer
+func WithLayerDNSCache(c *DNSCache) LayerOption {
+	return func(l *Layer) {
+		l.dns = c
+	}
+}
+
+// WithLayerClock sets clock used for timers of transport layer
+// Default: sip.SystemClock
+func WithLayerClock(c sip.Clock) LayerOption {
+	return func(l *Layer) {
+		l.clock = c
+	}
+}
+
+// WithLayerConnectionPool sets options of connection pools of transports.
+// Pools use clock of layer.
+func WithLayerConnectionPool(options ...ConnectionPoolOption) LayerOption {
+	return func(l *Layer) {
+		l.poolOpts = append(l.poolOpts, options...)
+	}
+}
+
 // NewLayer creates transport layer.
 // dns Resolver
 // sip parser
@@ -54,14 +93,28 @@ func NewLayer(
 	dnsResolver *net.Resolver,
 	sipparser *sipgo.Parser,
 	tlsConfig *tls.Config,
+	options ...LayerOption,
 ) *Layer {
+	if dnsResolver == nil {
+		dnsResolver = net.DefaultResolver
+	}
+
 	l := &Layer{
 		transports:      make(map[string]Transport),
 		listenPorts:     make(map[string][]int),
 		dnsResolver:     dnsResolver,
+		clock:           sip.SystemClock,
+		flows:           newFlowKeepAlives(),
 		ConnectionReuse: true,
 	}
 
+	for _, o := range options {
+		o(l)
+	}
+	if l.dns == nil {
+		l.dns = NewDNSCache(NewDNSResolver(dnsResolver), WithDNSCacheClock(l.clock))
+	}
+
 	l.log = slog.With("caller", "transportlayer")
 
 	// Make some default transports available.
@@ -73,6 +126,17 @@ func NewLayer(
 	// TODO. Using default dial tls, but it needs to configurable via client
 	l.wss = NewWSSTransport(sipparser, tlsConfig)
 
+	poolOpts := append([]ConnectionPoolOption{WithConnectionPoolClock(l.clock)}, l.poolOpts...)
+	l.udp.pool = NewConnectionPool(poolOpts...)
+	l.tcp.pool = NewConnectionPool(poolOpts...)
+	l.tls.pool = NewConnectionPool(poolOpts...)
+	l.ws.pool = NewConnectionPool(poolOpts...)
+	l.wss.pool = NewConnectionPool(poolOpts...)
+
+	l.udp.flows = l.flows
+	l.tcp.flows = l.flows
+	l.tls.flows = l.flows
+
 	// Fill map for fast access
 	l.transports["udp"] = l.udp
 	l.transports["tcp"] = l.tcp
@@ -100,12 +164,28 @@ func (l *Layer) OnMessage(h sip.MessageHandler) {
 	l.handlers = append(l.handlers, h)
 }
 
+// OnMessageIntercept adds interceptor called for every message before handlers added with OnMessage.
+// It allows handling messages without transaction layer.
+func (l *Layer) OnMessageIntercept(h MessageInterceptor) {
+	l.interceptors = append(l.interceptors, h)
+}
+
 // handleMessage is transport layer for handling messages
 func (l *Layer) handleMessage(msg sip.Message) {
 	// We have to consider
 	// https://datatracker.ietf.org/doc/html/rfc3261#section-18.2.1 for some message editing
 	// Proxy further to other
 
+	if req, ok := msg.(*sip.Request); ok {
+		stampVia(req)
+	}
+
+	for _, h := range l.interceptors {
+		if h(msg) {
+			return
+		}
+	}
+
 	// 18.1.2 Receiving Responses
 	// States that transport should find transaction and if not, it should still forward message to core
 	// l.handler(msg)
@@ -186,6 +266,8 @@ func (l *Layer) addListenPort(network string, port int) {
 }
 
 func (l *Layer) GetListenPort(network string) int {
+	l.listenPortsMu.Lock()
+	defer l.listenPortsMu.Unlock()
 	ports, _ := l.listenPorts[network]
 	if len(ports) > 0 {
 		return ports[0]
@@ -193,39 +275,29 @@ func (l *Layer) GetListenPort(network string) int {
 	return 0
 }
 
+// WriteMsg sends message to its destination. Requests are sent as described in
+// ClientRequestConnection and responses as described in ServerResponseConnection
 func (l *Layer) WriteMsg(msg sip.Message) error {
+	if res, ok := msg.(*sip.Response); ok {
+		conn, err := l.ServerResponseConnection(res)
+		if err != nil {
+			return err
+		}
+		defer conn.TryClose()
+		return conn.WriteMsg(res)
+	}
+
 	network := msg.Transport()
 	addr := msg.Destination()
 	return l.WriteMsgTo(msg, addr, network)
 }
 
+// WriteMsgTo sends message. Response is sent over connection to addr.
 func (l *Layer) WriteMsgTo(msg sip.Message, addr string, network string) error {
-	/*s
-	// Client sending request, or we are sending responses
-	To consider
-		18.2.1
-		When the server transport receives a request over any transport, it
-		MUST examine the value of the "sent-by" parameter in the top Via
-		header field value.
-		If the host portion of the "sent-by" parameter
-	contains a domain name, or if it contains an IP address that differs
-	from the packet source address, the server MUST add a "received"
-	parameter to that Via header field value.  This parameter MUST
-	contain the source address from which the packet was received.
-	*/
-
 	var conn Connection
 	var err error
 
 	switch m := msg.(type) {
-	// RFC 3261 - 18.1.1.
-	// 	TODO
-	// 	If a request is within 200 bytes of the path MTU, or if it is larger
-	//    than 1300 bytes and the path MTU is unknown, the request MUST be sent
-	//    using an RFC 2914 [43] congestion controlled transport protocol, such
-	//    as TCP. If this causes a change in the transport protocol from the
-	//    one indicated in the top Via, the value in the top Via MUST be
-	//    changed.
 	case *sip.Request:
 		//Every new request must be handled in seperate connection
 		conn, err = l.ClientRequestConnection(m)
@@ -272,53 +344,102 @@ func (l *Layer) WriteMsgTo(msg sip.Message, addr string, network string) error {
 // It is wrapper for getting and creating connection
 //
 // In case req destination is DNS resolved, destination will be cached or in
-// other words SetDestination will be called
+// other words SetDestination will be called. Resolved targets are tried in order
+// until connection is created. https://datatracker.ietf.org/doc/html/rfc3263#section-4.3
 func (l *Layer) ClientRequestConnection(req *sip.Request) (c Connection, err error) {
-	network := NetworkToLower(req.Transport())
-	transport, ok := l.transports[network]
-	if !ok {
-		return nil, fmt.Errorf("transport %s is not supported", network)
+	// Now use Via header to determine our local address
+	// Here is from RFC statement:
+	//   Before a request is sent, the client transport MUST insert a value of
+	//   the "sent-by" field into the Via header field.  This field contains
+	//   an IP address or host name, and port.
+	viaHop := req.Via()
+	if viaHop == nil {
+		// NOTE: We are enforcing that client creates this header
+		return nil, fmt.Errorf("missing Via Header")
 	}
 
 	// Resolve our remote address
 	a := req.Destination()
-	host, port, err := sip.ParseAddr(a)
+	host, _, err := sip.ParseAddr(a)
 	if err != nil {
 		return nil, fmt.Errorf("build address target for %s: %w", a, err)
 	}
 
-	// dns srv lookup
-
-	raddr := Addr{
-		IP:   net.ParseIP(host),
-		Port: port,
+	targets, err := l.ResolveRequest(context.Background(), req)
+	if err != nil {
+		return nil, err
 	}
-	if raddr.IP == nil {
-		ctx := context.Background()
-		// TODO: how to cache this address, for example reusing in dialog routing
-		if err := l.resolveAddr(ctx, network, host, &raddr); err != nil {
-			return nil, err
+
+	// Request is serialized for checking size only if it can be sent over UDP
+	large := slices.ContainsFunc(targets, func(t Target) bool { return t.Network == "udp" }) && requestSizeExceeded(req)
+	for i, target := range targets {
+		// RFC 3261 - 18.1.1.
+		// If a request is within 200 bytes of the path MTU, or if it is larger
+		// than 1300 bytes and the path MTU is unknown, the request MUST be sent
+		// using an RFC 2914 [43] congestion controlled transport protocol, such
+		// as TCP. If this causes a change in the transport protocol from the
+		// one indicated in the top Via, the value in the top Via MUST be
+		// changed.
+		// Failed TCP target is blacklisted, so it is not dialed again for every large request
+		if tcpTarget := (Target{Network: "tcp", Addr: target.Addr}); large && target.Network == "udp" && !l.dns.IsBlacklisted(tcpTarget) {
+			c, err = l.clientTargetConnection(req, viaHop, host, tcpTarget)
+			if err == nil {
+				if net.ParseIP(host) == nil {
+					req.SetDestination(target.Addr.String())
+				}
+				return c, nil
+			}
+			l.log.Warn("Fail to switch large request to TCP, sending over UDP", "err", fmt.Errorf("%w: %w", ErrUDPMTUCongestion, err), "target", target.String())
+		}
+
+		c, err = l.clientTargetConnection(req, viaHop, host, target)
+		if err == nil {
+			if net.ParseIP(host) == nil {
+				// Save destination in request to avoid repeated resolving
+				req.SetDestination(target.Addr.String())
+			}
+			return c, nil
 		}
-		// Save destination in request to avoid repeated resolving
-		req.SetDestination(raddr.String())
+		if i < len(targets)-1 {
+			l.log.Debug("Failed to create connection, trying next target", "err", err, "target", target.String())
+		}
+	}
+	return nil, err
+}
+
+// requestSizeExceeded checks is request too large to be sent over UDP
+func requestSizeExceeded(req *sip.Request) bool {
+	buf := bufPool.Get().(*bytes.Buffer)
+	defer bufPool.Put(buf)
+	buf.Reset()
+	req.StringWrite(buf)
+	return udpSizeExceeded(buf.Len())
+}
+
+func (l *Layer) clientTargetConnection(req *sip.Request, viaHop *sip.ViaHeader, host string, target Target) (c Connection, err error) {
+	network := target.Network
+	transport, ok := l.transports[network]
+	if !ok {
+		return nil, fmt.Errorf("transport %s is not supported", network)
 	}
 
-	// Now use Via header to determine our local address
-	// Here is from RFC statement:
-	//   Before a request is sent, the client transport MUST insert a value of
-	//   the "sent-by" field into the Via header field.  This field contains
-	//   an IP address or host name, and port.
-	viaHop := req.Via()
-	if viaHop == nil {
-		// NOTE: We are enforcing that client creates this header
-		return nil, fmt.Errorf("missing Via Header")
+	// Transport selected by DNS must be used for request and advertised in Via
+	if tp := strings.ToUpper(network); req.Transport() != tp || viaHop.Transport != tp {
+		req.SetTransport(tp)
+		viaHop.Transport = tp
 	}
 
+	raddr := target.Addr
+
 	// TODO refactor code below
 	if l.ConnectionReuse {
 		viaHop.Params.Add("alias", "")
 		addr := raddr.String()
-		c, _ := transport.GetConnection(addr)
+		var c Connection
+		// New connection is created if existing are busy and more are allowed
+		if l.pool(network).Available(addr) {
+			c, _ = transport.GetConnection(addr)
+		}
 		if c != nil {
 			// Update Via sent by
 			// TODO avoid this parsing
@@ -373,6 +494,7 @@ func (l *Layer) ClientRequestConnection(req *sip.Request) (c Connection, err err
 
 	c, err = transport.CreateConnection(laddr, host, raddr, l.handleMessage)
 	if err != nil {
+		l.dns.Blacklist(target)
 		return nil, err
 	}
 
@@ -385,7 +507,7 @@ func (l *Layer) ClientRequestConnection(req *sip.Request) (c Connection, err err
 		l := c.LocalAddr()
 		laddrStr := l.String()
 
-		host, port, err = sip.ParseAddr(laddrStr)
+		host, port, err := sip.ParseAddr(laddrStr)
 		if err != nil {
 			return nil, fmt.Errorf("fail to parse local connection address network=%s addr=%s: %w", network, laddrStr, err)
 		}
@@ -398,33 +520,211 @@ func (l *Layer) ClientRequestConnection(req *sip.Request) (c Connection, err err
 	return c, nil
 }
 
-func (l *Layer) resolveAddr(ctx context.Context, network string, host string, addr *Addr) error {
-	// We need to try local resolving.
-	ip, err := net.ResolveIPAddr("ip", host)
+// stampVia adds received and rport parameters to top Via of request received from network.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-18.2.1
+// https://datatracker.ietf.org/doc/html/rfc3581#section-4
+func stampVia(req *sip.Request) {
+	via := req.Via()
+	if via == nil {
+		return
+	}
+	host, port, err := net.SplitHostPort(req.Source())
+	if err != nil {
+		return
+	}
+	if via.Params == nil {
+		via.Params = sip.NewParams()
+	}
+
+	// With rport received is always added
+	if rport, ok := via.Params.Get("rport"); ok && rport == "" {
+		via.Params.Add("rport", port)
+		via.Params.Add("received", host)
+		return
+	}
+
+	// Sent-by with domain name or different IP
+	ip := net.ParseIP(strings.Trim(via.Host, "[]"))
+	if ip == nil || !ip.Equal(net.ParseIP(host)) {
+		via.Params.Add("received", host)
+	}
+}
+
+// ServerResponseConnection returns connection for sending response, following RFC 3261 section 18.2.2
+// and RFC 3581. For reliable transports connection on which request was received is reused.
+// Otherwise response is sent to address from received and rport of top Via, then maddr, and
+// finally sent-by located with RFC 3263 section 5. Response destination is updated to selected address.
+// Make sure you TryClose after finish.
+// https://datatracker.ietf.org/doc/html/rfc3261#section-18.2.2
+func (l *Layer) ServerResponseConnection(res *sip.Response) (Connection, error) {
+	network := NetworkToLower(res.Transport())
+	via := res.Via()
+	if via == nil {
+		return l.getConnection(network, res.Destination())
+	}
+	if network == "" {
+		network = NetworkToLower(via.Transport)
+	}
+	transport, ok := l.transports[network]
+	if !ok {
+		return nil, fmt.Errorf("transport %s is not supported", network)
+	}
+
+	reliable := IsReliable(network)
+	if dest := res.Destination(); reliable && dest != "" {
+		if c, _ := transport.GetConnection(dest); c != nil {
+			return c, nil
+		}
+		l.log.Debug("Connection of request not found, sending response by Via", "addr", dest)
+	}
+
+	targets, err := l.responseTargets(context.Background(), via, network, reliable)
+	if err != nil {
+		return nil, err
+	}
+
+	// UDP response is sent from listener which received request, as client connection to same address
+	// has other source port. Source of response is set to local address of listener by NewResponseFromRequest
+	// https://datatracker.ietf.org/doc/html/rfc3581#section-4
+	if network == "udp" && len(targets) > 0 {
+		if c := l.udp.listener(res.Source()); c != nil {
+			res.SetDestination(targets[0].Addr.String())
+			return c, nil
+		}
+	}
+
+	for _, target := range targets {
+		addr := target.Addr.String()
+		c, _ := transport.GetConnection(addr)
+		if c == nil {
+			c, err = transport.CreateConnection(Addr{}, via.Host, target.Addr, l.handleMessage)
+			if err != nil {
+				l.dns.Blacklist(target)
+				continue
+			}
+		}
+		res.SetDestination(addr)
+		return c, nil
+	}
 	if err == nil {
-		addr.IP = ip.IP
-		return nil
+		err = fmt.Errorf("no target for response to %s", via.SentBy())
 	}
-	l.log.Debug("IP addr resolving failed, doing via dns resolver", "err", err)
+	return nil, err
+}
 
-	var lookupnet string
-	switch network {
-	case "udp":
-		lookupnet = "udp"
-	default:
-		lookupnet = "tcp"
+// responseTargets returns addresses where response should be sent by top Via.
+// rport is ignored for reliable transports, as new connection must be opened to sent-by port
+func (l *Layer) responseTargets(ctx context.Context, via *sip.ViaHeader, network string, reliable bool) ([]Target, error) {
+	port := via.Port
+	if port == 0 {
+		port = sip.DefaultPort(network)
 	}
 
-	_, addrs, err := l.dnsResolver.LookupSRV(ctx, "sip", lookupnet, host)
+	received, _ := via.Params.Get("received")
+	if ip := net.ParseIP(received); ip != nil {
+		if rport, _ := via.Params.Get("rport"); rport != "" && !reliable {
+			p, err := strconv.Atoi(rport)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Via rport %q: %w", rport, err)
+			}
+			port = p
+		}
+		return []Target{{Network: network, Addr: Addr{IP: ip, Port: port}}}, nil
+	}
+
+	if maddr, _ := via.Params.Get("maddr"); maddr != "" {
+		targets, err := lookupTargets(ctx, l.dns, maddr, port, network)
+		if err != nil {
+			return nil, err
+		}
+		return l.dns.sortBlacklisted(targets), nil
+	}
+
+	// https://datatracker.ietf.org/doc/html/rfc3263#section-5
+	targets, err := resolveTargets(ctx, l.dns, strings.Trim(via.Host, "[]"), via.Port, network, network == "tls" || network == "wss")
 	if err != nil {
-		return fmt.Errorf("fail to resolve target for %q: %w", host, err)
+		return nil, err
+	}
+	return l.dns.sortBlacklisted(targets), nil
+}
+
+// ResolveRequest returns targets where request can be sent, in order they should be tried.
+// Destination set on request is only resolved to IP addresses, otherwise server is located
+// from Route or Request-URI with NAPTR, if supported by DNSResolver, SRV and A/AAAA lookups.
+// Results are cached and blacklisted targets are returned last.
+// https://datatracker.ietf.org/doc/html/rfc3263#section-4
+func (l *Layer) ResolveRequest(ctx context.Context, req *sip.Request) ([]Target, error) {
+	network := NetworkToLower(req.Transport())
+	if dest := req.MessageData.Destination(); dest != "" {
+		host, port, err := sip.ParseAddr(dest)
+		if err != nil {
+			return nil, fmt.Errorf("build address target for %s: %w", dest, err)
+		}
+		targets, err := lookupTargets(ctx, l.dns, host, port, network)
+		if err != nil {
+			return nil, err
+		}
+		return l.dns.sortBlacklisted(targets), nil
+	}
+
+	uri := &req.Recipient
+	if hdr := req.Route(); hdr != nil {
+		uri = &hdr.Address
+	}
+
+	// Transport is selected by DNS unless it is forced by request or URI
+	explicit := req.MessageData.Transport() != ""
+	if uri.UriParams != nil {
+		if val, ok := uri.UriParams.Get("transport"); ok && val != "" {
+			explicit = true
+		}
+	}
+	if via := req.Via(); via != nil && via.Transport != "" && via.Transport != sip.DefaultProtocol {
+		explicit = true
+	}
+	if !explicit {
+		network = ""
+	}
+
+	targets, err := resolveTargets(ctx, l.dns, uri.Host, uri.Port, network, uri.IsEncrypted())
+	if err != nil {
+		return nil, err
+	}
+	return l.dns.sortBlacklisted(targets), nil
+}
+
+func (l *Layer) pool(network string) *ConnectionPool {
+	switch network {
+	case "udp":
+		return l.udp.pool
+	case "tcp":
+		return l.tcp.pool
+	case "tls":
+		return l.tls.pool
+	case "ws":
+		return l.ws.pool
+	case "wss":
+		return l.wss.pool
 	}
-	a := addrs[0]
-	addr.IP = net.ParseIP(a.Target[:len(a.Target)-1])
-	addr.Port = int(a.Port)
 	return nil
 }
 
+// ConnectionStats returns stats of connection pools by transport network like udp, tcp
+func (l *Layer) ConnectionStats() map[string]ConnectionPoolStats {
+	stats := make(map[string]ConnectionPoolStats, len(l.transports))
+	for network := range l.transports {
+		if p := l.pool(network); p != nil {
+			stats[network] = p.Stats()
+		}
+	}
+	return stats
+}
+
+// DNSCache returns cache used for locating servers
+func (l *Layer) DNSCache() *DNSCache {
+	return l.dns
+}
+
 // GetConnection gets existing or creates new connection based on addr
 func (l *Layer) GetConnection(network, addr string) (Connection, error) {
 	network = NetworkToLower(network)
@@ -1,9 +1,340 @@
 package transport
 
 import (
+	"net"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	sipgo "github.com/emiago/sipgo/sip"
+
+	"github.com/livekit/sipgo/sip"
 )
 
 func BenchmarkSend(b *testing.B) {
 
 }
+
+func testVia(transport string, host string, port int, params ...string) *sip.ViaHeader {
+	via := &sip.ViaHeader{
+		ProtocolName:    "SIP",
+		ProtocolVersion: "2.0",
+		Transport:       transport,
+		Host:            host,
+		Port:            port,
+		Params:          sip.NewParams(),
+	}
+	via.Params.Add("branch", sip.GenerateBranch())
+	for i := 0; i+1 < len(params); i += 2 {
+		via.Params.Add(params[i], params[i+1])
+	}
+	return via
+}
+
+func TestStampVia(t *testing.T) {
+	tests := []struct {
+		name     string
+		via      *sip.ViaHeader
+		source   string
+		received string
+		rport    string
+	}{
+		{name: "SameIP", via: testVia("UDP", "10.0.0.1", 5060), source: "10.0.0.1:5060"},
+		{name: "DifferentIP", via: testVia("UDP", "10.0.0.1", 5060), source: "192.0.2.1:5060", received: "192.0.2.1"},
+		{name: "Domain", via: testVia("TCP", "client.example.com", 0), source: "10.0.0.1:43000", received: "10.0.0.1"},
+		{name: "IPv6", via: testVia("UDP", "[2001:db8::1]", 5060), source: "[2001:db8::1]:5060"},
+		{name: "Rport", via: testVia("UDP", "10.0.0.1", 5060, "rport", ""), source: "10.0.0.1:40000", received: "10.0.0.1", rport: "40000"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := sip.NewRequest(sip.OPTIONS, sip.Uri{Host: "10.0.0.2"})
+			req.AppendHeader(tc.via)
+			req.SetSource(tc.source)
+			stampVia(req)
+
+			received, ok := tc.via.Params.Get("received")
+			assert.Equal(t, tc.received != "", ok)
+			assert.Equal(t, tc.received, received)
+			rport, _ := tc.via.Params.Get("rport")
+			assert.Equal(t, tc.rport, rport)
+		})
+	}
+}
+
+func TestServerResponseConnection(t *testing.T) {
+	dns := NewDNSTable()
+	dns.AddSRV("sip", "udp", "client.example.com", &net.SRV{Target: "a.client.example.com.", Port: 5090})
+	dns.AddIP("a.client.example.com", testIPs("127.0.0.4")...)
+	l := NewLayer(nil, sipgo.NewParser(), nil, WithLayerDNSCache(NewDNSCache(dns)))
+	defer l.Close()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	go l.ServeUDP(pc)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go l.ServeTCP(ln)
+	require.Eventually(t, func() bool {
+		l.udp.mu.Lock()
+		defer l.udp.mu.Unlock()
+		return len(l.udp.listeners) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	response := func(via *sip.ViaHeader, dest string) *sip.Response {
+		res := sip.NewResponse(sip.StatusOK, "OK")
+		res.AppendHeader(via)
+		res.SetTransport(via.Transport)
+		res.SetDestination(dest)
+		return res
+	}
+
+	t.Run("UDP", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			via    *sip.ViaHeader
+			expect string
+		}{
+			{name: "Rport", via: testVia("UDP", "10.0.0.1", 5060, "rport", "40000", "received", "127.0.0.2"), expect: "127.0.0.2:40000"},
+			{name: "Received", via: testVia("UDP", "10.0.0.1", 5070, "received", "127.0.0.2"), expect: "127.0.0.2:5070"},
+			{name: "Maddr", via: testVia("UDP", "127.0.0.3", 0, "maddr", "127.0.0.5"), expect: "127.0.0.5:5060"},
+			{name: "SentBy", via: testVia("UDP", "127.0.0.3", 5080), expect: "127.0.0.3:5080"},
+			{name: "SentBySRV", via: testVia("UDP", "client.example.com", 0), expect: "127.0.0.4:5090"},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				res := response(tc.via, "127.0.0.9:1000")
+				c, err := l.ServerResponseConnection(res)
+				require.NoError(t, err)
+				defer c.TryClose()
+				assert.Equal(t, pc.LocalAddr().String(), c.LocalAddr().String())
+				assert.Equal(t, tc.expect, res.Destination())
+			})
+		}
+
+		t.Run("ClientConnection", func(t *testing.T) {
+			// Dialed connection to same address has other source port, so listener is used
+			dialed, err := l.udp.CreateConnection(Addr{}, "127.0.0.3", Addr{IP: net.ParseIP("127.0.0.3"), Port: 5080}, l.handleMessage)
+			require.NoError(t, err)
+			defer dialed.Close()
+
+			res := response(testVia("UDP", "127.0.0.3", 5080), "127.0.0.3:5080")
+			c, err := l.ServerResponseConnection(res)
+			require.NoError(t, err)
+			defer c.TryClose()
+			assert.Equal(t, pc.LocalAddr().String(), c.LocalAddr().String())
+		})
+	})
+
+	t.Run("TCP", func(t *testing.T) {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		defer client.Close()
+
+		// Connection of request is reused
+		via := testVia("TCP", "10.0.0.1", 5060, "received", "127.0.0.1")
+		res := response(via, client.LocalAddr().String())
+		require.Eventually(t, func() bool {
+			c, err := l.ServerResponseConnection(res)
+			if err != nil {
+				return false
+			}
+			defer c.TryClose()
+			return c.LocalAddr().String() == ln.Addr().String()
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, client.LocalAddr().String(), res.Destination())
+
+		// Without connection, new one is opened to received and sent-by port
+		other, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer other.Close()
+		go func() {
+			conn, err := other.Accept()
+			if err == nil {
+				defer conn.Close()
+			}
+		}()
+
+		via = testVia("TCP", "10.0.0.1", other.Addr().(*net.TCPAddr).Port, "received", "127.0.0.1", "rport", "43000")
+		res = response(via, "127.0.0.1:1")
+		c, err := l.ServerResponseConnection(res)
+		require.NoError(t, err)
+		defer c.TryClose()
+		assert.Equal(t, other.Addr().String(), res.Destination())
+	})
+}
+
+func TestServerResponseListener(t *testing.T) {
+	l := NewLayer(nil, sipgo.NewParser(), nil)
+	defer l.Close()
+	l.OnMessage(func(msg sip.Message) {
+		if req, ok := msg.(*sip.Request); ok {
+			assert.NoError(t, l.WriteMsg(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)))
+		}
+	})
+
+	var listeners []net.PacketConn
+	for i := 0; i < 2; i++ {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer pc.Close()
+		go l.ServeUDP(pc)
+		listeners = append(listeners, pc)
+	}
+	require.Eventually(t, func() bool {
+		l.udp.mu.Lock()
+		defer l.udp.mu.Unlock()
+		return len(l.udp.listeners) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer client.Close()
+
+	// Response is sent from listener which received request, whichever is first
+	for _, pc := range []net.PacketConn{listeners[1], listeners[0]} {
+		req := strings.Join([]string{
+			"OPTIONS sip:bob@" + pc.LocalAddr().String() + " SIP/2.0",
+			"Via: SIP/2.0/UDP " + client.LocalAddr().String() + ";rport;branch=" + sip.GenerateBranch(),
+			"From: <sip:alice@127.0.0.1>;tag=alice",
+			"To: <sip:bob@127.0.0.1>",
+			"Call-ID: listener-test",
+			"CSeq: 1 OPTIONS",
+			"Content-Length: 0",
+			"",
+			"",
+		}, "\r\n")
+		_, err := client.WriteTo([]byte(req), pc.LocalAddr())
+		require.NoError(t, err)
+
+		buf := make([]byte, 2048)
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		_, from, err := client.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, pc.LocalAddr().String(), from.String())
+	}
+}
+
+func TestClientRequestConnectionLargeRequest(t *testing.T) {
+	l := NewLayer(nil, sipgo.NewParser(), nil)
+	defer l.Close()
+
+	// TCP and UDP listening on same port
+	var pc net.PacketConn
+	var ln net.Listener
+	for i := 0; i < 10 && ln == nil; i++ {
+		var err error
+		pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		ln, err = net.Listen("tcp", pc.LocalAddr().String())
+		if err != nil {
+			pc.Close()
+		}
+	}
+	require.NotNil(t, ln)
+	defer pc.Close()
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+
+	request := func(bodySize int) *sip.Request {
+		req := sip.NewRequest(sip.INVITE, sip.Uri{User: "bob", Host: "127.0.0.1", Port: port})
+		req.AppendHeader(testVia("UDP", "127.0.0.1", 0))
+		req.SetBody(make([]byte, bodySize))
+		return req
+	}
+
+	req := request(100)
+	c, err := l.ClientRequestConnection(req)
+	require.NoError(t, err)
+	c.TryClose()
+	assert.Equal(t, "UDP", req.Transport())
+	assert.Equal(t, "UDP", req.Via().Transport)
+
+	// Large request is switched to TCP
+	req = request(UDPMTUSize - 200)
+	c, err = l.ClientRequestConnection(req)
+	require.NoError(t, err)
+	c.TryClose()
+	assert.IsType(t, &TCPConnection{}, c)
+	assert.Equal(t, "TCP", req.Transport())
+	assert.Equal(t, "TCP", req.Via().Transport)
+
+	// UDP is used if TCP connection fails
+	udpOnly, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer udpOnly.Close()
+	port = udpOnly.LocalAddr().(*net.UDPAddr).Port
+	req = request(UDPMTUSize - 200)
+	c, err = l.ClientRequestConnection(req)
+	require.NoError(t, err)
+	c.TryClose()
+	assert.IsType(t, &UDPConnection{}, c)
+	assert.Equal(t, "UDP", req.Transport())
+	assert.Equal(t, "UDP", req.Via().Transport)
+
+	// Failed TCP target is not dialed again for next large request
+	tcpTarget := Target{Network: "tcp", Addr: Addr{IP: net.ParseIP("127.0.0.1"), Port: port}}
+	assert.True(t, l.DNSCache().IsBlacklisted(tcpTarget))
+	req = request(UDPMTUSize - 200)
+	c, err = l.ClientRequestConnection(req)
+	require.NoError(t, err)
+	c.TryClose()
+	assert.IsType(t, &UDPConnection{}, c)
+}
+
+func TestLayerConnectionPool(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	l := NewLayer(nil, sipgo.NewParser(), nil, WithLayerConnectionPool(WithConnectionPoolMaxPerAddr(2)))
+	defer l.Close()
+
+	raddr := ln.Addr().(*net.TCPAddr)
+	request := func() *sip.Request {
+		req := sip.NewRequest(sip.OPTIONS, sip.Uri{Host: "127.0.0.1", Port: raddr.Port, UriParams: sip.HeaderParams{"transport": "tcp"}})
+		req.AppendHeader(testVia("TCP", "127.0.0.1", 0))
+		return req
+	}
+
+	// Busy connection is not reused while limit per address is not reached
+	c1, err := l.ClientRequestConnection(request())
+	require.NoError(t, err)
+	c2, err := l.ClientRequestConnection(request())
+	require.NoError(t, err)
+	assert.NotSame(t, c1, c2)
+	c3, err := l.ClientRequestConnection(request())
+	require.NoError(t, err)
+	c1.TryClose()
+	c2.TryClose()
+	c3.TryClose()
+
+	stats := l.ConnectionStats()["tcp"]
+	assert.Equal(t, 2, stats.Active)
+	assert.Equal(t, 2, stats.Idle)
+	assert.EqualValues(t, 2, stats.Created)
+}
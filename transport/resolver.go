@@ -0,0 +1,233 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+// Target is resolved server address with transport which should be used for it
+type Target struct {
+	// Network is lowercase transport like udp, tcp, tls
+	Network string
+	Addr    Addr
+}
+
+func (t Target) String() string {
+	return t.Network + ":" + t.Addr.String()
+}
+
+// naptrServices maps NAPTR service field to transport
+// https://datatracker.ietf.org/doc/html/rfc3263#section-4.1
+// https://datatracker.ietf.org/doc/html/rfc7118#section-5.4
+var naptrServices = map[string]string{
+	"SIP+D2U":  "udp",
+	"SIP+D2T":  "tcp",
+	"SIPS+D2T": "tls",
+	"SIP+D2W":  "ws",
+	"SIPS+D2W": "wss",
+}
+
+// srvService returns SRV service and proto used for transport.
+// WebSocket transports do not have SRV records.
+func srvService(network string) (service string, proto string, ok bool) {
+	switch network {
+	case "udp":
+		return "sip", "udp", true
+	case "tcp":
+		return "sip", "tcp", true
+	case "tls":
+		return "sips", "tcp", true
+	}
+	return "", "", false
+}
+
+// resolveTargets locates servers for host following RFC 3263 section 4.
+// network is transport chosen by URI or request and empty if it should be selected by DNS.
+// Port is zero if not present in URI. Targets are returned in order they should be tried.
+// https://datatracker.ietf.org/doc/html/rfc3263#section-4
+func resolveTargets(ctx context.Context, dns DNSResolver, host string, port int, network string, secure bool) ([]Target, error) {
+	defaultNetwork := network
+	if defaultNetwork == "" {
+		defaultNetwork = "udp"
+		if secure {
+			defaultNetwork = "tls"
+		}
+	}
+
+	// Numeric IP or explicit port skips NAPTR and SRV
+	// https://datatracker.ietf.org/doc/html/rfc3263#section-4.2
+	if ip := net.ParseIP(host); ip != nil {
+		if port == 0 {
+			port = sip.DefaultPort(defaultNetwork)
+		}
+		return []Target{{Network: defaultNetwork, Addr: Addr{IP: ip, Port: port}}}, nil
+	}
+
+	if port != 0 {
+		return lookupTargets(ctx, dns, host, port, defaultNetwork)
+	}
+
+	if network == "" {
+		targets := resolveNAPTR(ctx, dns, host, secure)
+		if len(targets) > 0 {
+			return targets, nil
+		}
+	}
+
+	// No NAPTR records, SRV query is done for each supported transport
+	// https://datatracker.ietf.org/doc/html/rfc3263#section-4.1
+	networks := []string{network}
+	if network == "" {
+		networks = []string{"udp", "tcp"}
+		if secure {
+			networks = []string{"tls"}
+		}
+	}
+
+	var targets []Target
+	for _, n := range networks {
+		service, proto, ok := srvService(n)
+		if !ok {
+			continue
+		}
+		addrs, _, err := dns.LookupSRV(ctx, service, proto, host)
+		if err != nil {
+			continue
+		}
+		targets = append(targets, resolveSRV(ctx, dns, addrs, n)...)
+	}
+	if len(targets) > 0 {
+		return targets, nil
+	}
+
+	// No SRV records, host is resolved with default port of transport
+	// https://datatracker.ietf.org/doc/html/rfc3263#section-4.2
+	return lookupTargets(ctx, dns, host, sip.DefaultPort(defaultNetwork), defaultNetwork)
+}
+
+// resolveNAPTR selects transports from NAPTR records and resolves their SRV records.
+// https://datatracker.ietf.org/doc/html/rfc3263#section-4.1
+func resolveNAPTR(ctx context.Context, dns DNSResolver, host string, secure bool) []Target {
+	records, _, err := dns.LookupNAPTR(ctx, host)
+	if err != nil {
+		return nil
+	}
+
+	records = slices.Clone(records)
+	slices.SortStableFunc(records, func(a, b *NAPTR) int {
+		if a.Order != b.Order {
+			return int(a.Order) - int(b.Order)
+		}
+		return int(a.Preference) - int(b.Preference)
+	})
+
+	var targets []Target
+	for _, rec := range records {
+		// Only terminal SRV lookups are supported
+		if !strings.EqualFold(rec.Flags, "s") {
+			continue
+		}
+		network, ok := naptrServices[strings.ToUpper(rec.Service)]
+		if !ok {
+			continue
+		}
+		// SIPS URI must be resolved to TLS transports
+		if secure && network != "tls" && network != "wss" {
+			continue
+		}
+
+		addrs, _, err := dns.LookupSRV(ctx, "", "", rec.Replacement)
+		if err != nil {
+			continue
+		}
+		targets = append(targets, resolveSRV(ctx, dns, addrs, network)...)
+	}
+	return targets
+}
+
+// resolveSRV resolves addresses of SRV records in order of their priority and weight
+func resolveSRV(ctx context.Context, dns DNSResolver, addrs []*net.SRV, network string) []Target {
+	var targets []Target
+	for _, srv := range orderSRV(addrs) {
+		host := strings.TrimSuffix(srv.Target, ".")
+		// Target "." means that service is not available
+		if host == "" {
+			continue
+		}
+		t, err := lookupTargets(ctx, dns, host, int(srv.Port), network)
+		if err != nil {
+			continue
+		}
+		targets = append(targets, t...)
+	}
+	return targets
+}
+
+func lookupTargets(ctx context.Context, dns DNSResolver, host string, port int, network string) ([]Target, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []Target{{Network: network, Addr: Addr{IP: ip, Port: port}}}, nil
+	}
+
+	ips, _, err := dns.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("fail to resolve target for %q: %w", host, err)
+	}
+
+	targets := make([]Target, 0, len(ips))
+	for _, ip := range ips {
+		targets = append(targets, Target{Network: network, Addr: Addr{IP: ip.IP, Port: port}})
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("fail to resolve target for %q: no addresses", host)
+	}
+	return targets, nil
+}
+
+// orderSRV orders records by priority and within same priority randomly by weight.
+// https://datatracker.ietf.org/doc/html/rfc2782
+func orderSRV(addrs []*net.SRV) []*net.SRV {
+	addrs = slices.Clone(addrs)
+	slices.SortStableFunc(addrs, func(a, b *net.SRV) int {
+		return int(a.Priority) - int(b.Priority)
+	})
+
+	for i := 0; i < len(addrs); {
+		j := i + 1
+		for j < len(addrs) && addrs[j].Priority == addrs[i].Priority {
+			j++
+		}
+		shuffleByWeight(addrs[i:j])
+		i = j
+	}
+	return addrs
+}
+
+func shuffleByWeight(addrs []*net.SRV) {
+	sum := 0
+	for _, a := range addrs {
+		sum += int(a.Weight)
+	}
+	for len(addrs) > 1 {
+		// Records with zero weight have very small chance to be selected first
+		n := 0
+		if sum > 0 {
+			n = rand.Intn(sum + 1)
+		}
+		s := 0
+		for i, a := range addrs {
+			s += int(a.Weight)
+			if s >= n {
+				addrs[0], addrs[i] = addrs[i], addrs[0]
+				break
+			}
+		}
+		sum -= int(addrs[0].Weight)
+		addrs = addrs[1:]
+	}
+}
@@ -0,0 +1,156 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	sipgo "github.com/emiago/sipgo/sip"
+
+	"github.com/livekit/sipgo/sip"
+)
+
+func testIPs(ips ...string) []net.IP {
+	res := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		res = append(res, net.ParseIP(ip))
+	}
+	return res
+}
+
+func targetStrings(targets []Target) []string {
+	s := make([]string, 0, len(targets))
+	for _, t := range targets {
+		s = append(s, t.String())
+	}
+	return s
+}
+
+func TestResolveTargets(t *testing.T) {
+	dns := NewDNSTable()
+	dns.AddNAPTR("example.com",
+		&NAPTR{Order: 20, Preference: 10, Flags: "s", Service: "SIP+D2U", Replacement: "_sip._udp.example.com."},
+		&NAPTR{Order: 10, Preference: 20, Flags: "S", Service: "SIP+D2T", Replacement: "_sip._tcp.example.com."},
+		&NAPTR{Order: 10, Preference: 10, Flags: "s", Service: "SIPS+D2T", Replacement: "_sips._tcp.example.com."},
+		&NAPTR{Order: 10, Preference: 5, Flags: "s", Service: "SIP+D2X", Replacement: "_sip._x.example.com."},
+	)
+	dns.AddSRV("sip", "udp", "example.com", &net.SRV{Target: "a.example.com.", Port: 5060})
+	dns.AddSRV("sip", "tcp", "example.com", &net.SRV{Target: "a.example.com.", Port: 5060})
+	dns.AddSRV("sips", "tcp", "example.com", &net.SRV{Target: "a.example.com.", Port: 5061})
+	dns.AddSRV("sip", "udp", "srv.com",
+		&net.SRV{Target: "b.srv.com.", Port: 5070, Priority: 20},
+		&net.SRV{Target: "a.srv.com.", Port: 5060, Priority: 10},
+	)
+	dns.AddSRV("sip", "tcp", "srv.com", &net.SRV{Target: "a.srv.com.", Port: 5080, Priority: 10})
+	dns.AddIP("a.example.com", testIPs("10.0.0.1")...)
+	dns.AddIP("a.srv.com", testIPs("10.0.1.1", "10.0.1.2")...)
+	dns.AddIP("b.srv.com", testIPs("10.0.2.1")...)
+	dns.AddIP("host.com", testIPs("10.0.3.1")...)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		host    string
+		port    int
+		network string
+		secure  bool
+		expect  []string
+	}{
+		{name: "IP", host: "10.1.1.1", expect: []string{"udp:10.1.1.1:5060"}},
+		{name: "IPSecure", host: "10.1.1.1", secure: true, expect: []string{"tls:10.1.1.1:5061"}},
+		{name: "IPTransport", host: "10.1.1.1", port: 5070, network: "tcp", expect: []string{"tcp:10.1.1.1:5070"}},
+		{name: "Port", host: "a.srv.com", port: 5090, expect: []string{"udp:10.0.1.1:5090", "udp:10.0.1.2:5090"}},
+		{name: "NAPTR", host: "example.com", expect: []string{"tls:10.0.0.1:5061", "tcp:10.0.0.1:5060", "udp:10.0.0.1:5060"}},
+		{name: "NAPTRSecure", host: "example.com", secure: true, expect: []string{"tls:10.0.0.1:5061"}},
+		{name: "SRV", host: "srv.com", expect: []string{"udp:10.0.1.1:5060", "udp:10.0.1.2:5060", "udp:10.0.2.1:5070", "tcp:10.0.1.1:5080", "tcp:10.0.1.2:5080"}},
+		{name: "SRVTransport", host: "srv.com", network: "tcp", expect: []string{"tcp:10.0.1.1:5080", "tcp:10.0.1.2:5080"}},
+		{name: "A", host: "host.com", expect: []string{"udp:10.0.3.1:5060"}},
+		{name: "ASecure", host: "host.com", secure: true, expect: []string{"tls:10.0.3.1:5061"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			targets, err := resolveTargets(ctx, dns, tc.host, tc.port, tc.network, tc.secure)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expect, targetStrings(targets))
+		})
+	}
+
+	_, err := resolveTargets(ctx, dns, "unknown.com", 0, "", false)
+	require.Error(t, err)
+}
+
+func TestOrderSRV(t *testing.T) {
+	addrs := []*net.SRV{
+		{Target: "c.", Priority: 20, Weight: 100},
+		{Target: "a.", Priority: 10, Weight: 90},
+		{Target: "b.", Priority: 10, Weight: 10},
+		{Target: "z.", Priority: 10, Weight: 0},
+	}
+
+	first := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		ordered := orderSRV(addrs)
+		require.Len(t, ordered, 4)
+		assert.Equal(t, "c.", ordered[3].Target)
+		first[ordered[0].Target]++
+	}
+	// Selection within priority is proportional to weight
+	assert.Greater(t, first["a."], 800)
+	assert.Greater(t, first["b."], 30)
+	assert.Less(t, first["z."], 30)
+}
+
+func TestClientRequestConnectionFailover(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	// Closed port for first target
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	dns := NewDNSTable()
+	dns.AddSRV("sip", "tcp", "example.com",
+		&net.SRV{Target: "a.example.com.", Port: uint16(closedPort), Priority: 10},
+		&net.SRV{Target: "a.example.com.", Port: uint16(ln.Addr().(*net.TCPAddr).Port), Priority: 20},
+	)
+	dns.AddIP("a.example.com", testIPs("127.0.0.1")...)
+	l := NewLayer(nil, sipgo.NewParser(), nil, WithLayerDNSCache(NewDNSCache(dns)))
+	defer l.Close()
+
+	req := sip.NewRequest(sip.OPTIONS, sip.Uri{Host: "example.com", UriParams: sip.HeaderParams{"transport": "tcp"}})
+	req.AppendHeader(&sip.ViaHeader{
+		ProtocolName:    "SIP",
+		ProtocolVersion: "2.0",
+		Transport:       "TCP",
+		Host:            "127.0.0.1",
+		Params:          sip.NewParams(),
+	})
+
+	conn, err := l.ClientRequestConnection(req)
+	require.NoError(t, err)
+	defer conn.TryClose()
+	assert.Equal(t, ln.Addr().String(), req.Destination())
+	assert.Equal(t, "TCP", req.Transport())
+
+	// Unreachable target is tried last
+	targets, err := l.ResolveRequest(context.Background(), sip.NewRequest(sip.OPTIONS, *req.Recipient.Clone()))
+	require.NoError(t, err)
+	require.Len(t, targets, 2)
+	assert.Equal(t, ln.Addr().String(), targets[0].Addr.String())
+}
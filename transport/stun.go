@@ -0,0 +1,140 @@
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// Minimal STUN used for keepalives on UDP flows. Only Binding requests with
+// XOR-MAPPED-ADDRESS responses are supported.
+// https://datatracker.ietf.org/doc/html/rfc5626#section-8
+// https://datatracker.ietf.org/doc/html/rfc5389
+const (
+	stunHeaderSize  = 20
+	stunMagicCookie = 0x2112A442
+
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+
+	stunAttrMappedAddress    = 0x0001
+	stunAttrXORMappedAddress = 0x0020
+)
+
+type stunMessage struct {
+	typ    uint16
+	txID   [12]byte
+	mapped *net.UDPAddr
+}
+
+// isSTUN checks message header. SIP messages can not start with zero bits
+// so it is enough to demultiplex STUN from SIP on same socket.
+// https://datatracker.ietf.org/doc/html/rfc5389#section-6
+func isSTUN(data []byte) bool {
+	if len(data) < stunHeaderSize || data[0]&0xc0 != 0 {
+		return false
+	}
+	if binary.BigEndian.Uint32(data[4:8]) != stunMagicCookie {
+		return false
+	}
+	size := int(binary.BigEndian.Uint16(data[2:4]))
+	return size%4 == 0 && size+stunHeaderSize == len(data)
+}
+
+func stunNewTxID() (id [12]byte) {
+	rand.Read(id[:])
+	return id
+}
+
+func stunEncode(msg stunMessage) []byte {
+	buf := make([]byte, stunHeaderSize, stunHeaderSize+20)
+	binary.BigEndian.PutUint16(buf[0:2], msg.typ)
+	binary.BigEndian.PutUint32(buf[4:8], stunMagicCookie)
+	copy(buf[8:20], msg.txID[:])
+
+	if msg.mapped != nil {
+		ip := msg.mapped.IP.To4()
+		family := byte(0x01)
+		if ip == nil {
+			ip = msg.mapped.IP.To16()
+			family = 0x02
+		}
+		value := make([]byte, 4+len(ip))
+		value[1] = family
+		binary.BigEndian.PutUint16(value[2:4], uint16(msg.mapped.Port)^(stunMagicCookie>>16))
+		// Address is XOR-ed with magic cookie and transaction ID
+		for i := range ip {
+			value[4+i] = ip[i] ^ buf[4+i]
+		}
+		buf = binary.BigEndian.AppendUint16(buf, stunAttrXORMappedAddress)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(value)))
+		buf = append(buf, value...)
+	}
+
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)-stunHeaderSize))
+	return buf
+}
+
+func stunDecode(data []byte) (stunMessage, error) {
+	if !isSTUN(data) {
+		return stunMessage{}, errors.New("not a STUN message")
+	}
+
+	msg := stunMessage{typ: binary.BigEndian.Uint16(data[0:2])}
+	copy(msg.txID[:], data[8:20])
+
+	attrs := data[stunHeaderSize:]
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:2])
+		size := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+size > len(attrs) {
+			return stunMessage{}, errors.New("STUN attribute too short")
+		}
+		value := attrs[4 : 4+size]
+
+		switch typ {
+		case stunAttrXORMappedAddress, stunAttrMappedAddress:
+			addr, err := stunReadAddress(value, data, typ == stunAttrXORMappedAddress)
+			if err != nil {
+				return stunMessage{}, err
+			}
+			// XOR-MAPPED-ADDRESS is preferred if both are present
+			if msg.mapped == nil || typ == stunAttrXORMappedAddress {
+				msg.mapped = addr
+			}
+		}
+
+		// Attributes are padded to 4 bytes
+		next := 4 + (size+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	return msg, nil
+}
+
+func stunReadAddress(value []byte, header []byte, xor bool) (*net.UDPAddr, error) {
+	if len(value) < 4 {
+		return nil, errors.New("STUN address too short")
+	}
+	size := net.IPv4len
+	if value[1] == 0x02 {
+		size = net.IPv6len
+	}
+	if len(value) < 4+size {
+		return nil, errors.New("STUN address too short")
+	}
+
+	port := binary.BigEndian.Uint16(value[2:4])
+	ip := make(net.IP, size)
+	copy(ip, value[4:4+size])
+	if xor {
+		port ^= stunMagicCookie >> 16
+		for i := range ip {
+			ip[i] ^= header[4+i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
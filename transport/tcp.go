@@ -21,7 +21,9 @@ type TCPTransport struct {
 	parser    *sipgo.Parser
 	log       *slog.Logger
 
-	pool *ConnectionPool
+	pool      *ConnectionPool
+	flows     *flowKeepAlives
+	listeners listeners
 }
 
 func NewTCPTransport(par *sipgo.Parser) *TCPTransport {
@@ -45,13 +47,15 @@ func (t *TCPTransport) Network() string {
 
 func (t *TCPTransport) Close() error {
 	// return t.connections.Done()
+	err := t.listeners.close()
 	t.pool.Clear()
-	return nil
+	return err
 }
 
 // Serve is direct way to provide conn on which this worker will listen
 func (t *TCPTransport) Serve(l net.Listener, handler sip.MessageHandler) error {
 	t.log.Debug("begin listening on", "net", t.Network(), "addr", l.Addr())
+	t.listeners.add(l)
 	for {
 		conn, err := l.Accept()
 		if err != nil {
@@ -59,7 +63,7 @@ func (t *TCPTransport) Serve(l net.Listener, handler sip.MessageHandler) error {
 			return err
 		}
 
-		t.initConnection(conn, conn.RemoteAddr().String(), handler)
+		t.initConnection(conn, conn.RemoteAddr().String(), false, handler)
 	}
 }
 
@@ -111,11 +115,11 @@ func (t *TCPTransport) createConnection(laddr *net.TCPAddr, raddr *net.TCPAddr,
 	// 	return nil, fmt.Errorf("%s keepalive period err=%w", t, err)
 	// }
 
-	c := t.initConnection(conn, addr, handler)
+	c := t.initConnection(conn, addr, true, handler)
 	return c, nil
 }
 
-func (t *TCPTransport) initConnection(conn net.Conn, addr string, handler sip.MessageHandler) Connection {
+func (t *TCPTransport) initConnection(conn net.Conn, addr string, dialed bool, handler sip.MessageHandler) Connection {
 	// // conn.SetKeepAlive(true)
 	// conn.SetKeepAlivePeriod(3 * time.Second)
 
@@ -124,7 +128,11 @@ func (t *TCPTransport) initConnection(conn net.Conn, addr string, handler sip.Me
 		Conn:     conn,
 		refcount: 1 + IdleConnection,
 	}
-	t.pool.Add(addr, c)
+	if dialed {
+		t.pool.addDialed(addr, c)
+	} else {
+		t.pool.Add(addr, c)
+	}
 	go t.readConnection(c, addr, handler)
 	return c
 }
@@ -134,6 +142,7 @@ func (t *TCPTransport) readConnection(conn *TCPConnection, raddr string, handler
 	buf := make([]byte, transportBufferSize)
 
 	defer t.pool.CloseAndDelete(conn, raddr)
+	defer t.flows.closed(NetworkToLower(t.transport), raddr)
 
 	// Create stream parser context
 	par := t.parser.NewSIPStream()
@@ -150,18 +159,24 @@ func (t *TCPTransport) readConnection(conn *TCPConnection, raddr string, handler
 			return
 		}
 
+		t.pool.Touch(conn)
 		data := buf[:num]
 		if len(bytes.Trim(data, "\x00")) == 0 {
 			continue
 		}
 
-		// Check is keep alive
-		if len(data) <= 4 {
-			//One or 2 CRLF
-			if len(bytes.Trim(data, "\r\n")) == 0 {
-				t.log.Debug("Keep alive CRLF received")
+		// Check is keep alive. Double CRLF ping is answered with single CRLF pong
+		// https://datatracker.ietf.org/doc/html/rfc5626#section-4.4.1
+		if isCRLFKeepAlive(data) {
+			t.log.Debug("Keep alive CRLF received")
+			if bytes.Equal(data, crlfPing) {
+				if _, err := conn.Write(crlfPong); err != nil {
+					t.log.Debug("Fail to write keep alive pong", "err", err)
+				}
 				continue
 			}
+			t.flows.pong(NetworkToLower(t.transport), raddr)
+			continue
 		}
 
 		// TODO fallback to parseFull if message size limit is set
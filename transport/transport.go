@@ -1,8 +1,11 @@
 package transport
 
 import (
+	"errors"
+	"io"
 	"net"
 	"strconv"
+	"sync"
 
 	"github.com/livekit/sipgo/sip"
 )
@@ -49,3 +52,30 @@ type Addr struct {
 func (a *Addr) String() string {
 	return net.JoinHostPort(a.IP.String(), strconv.Itoa(a.Port))
 }
+
+// listeners keeps listeners served by transport, so that they are closed together with transport
+type listeners struct {
+	mu sync.Mutex
+	ls []io.Closer
+}
+
+func (l *listeners) add(c io.Closer) {
+	l.mu.Lock()
+	l.ls = append(l.ls, c)
+	l.mu.Unlock()
+}
+
+func (l *listeners) close() error {
+	l.mu.Lock()
+	ls := l.ls
+	l.ls = nil
+	l.mu.Unlock()
+
+	var errs []error
+	for _, c := range ls {
+		if err := c.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -19,8 +19,11 @@ var (
 	// Best performance is achieved with low value, to remove high concurency
 	UDPReadWorkers int = 1
 
+	// UDPMTUSize is path MTU used for switching large requests to TCP.
+	// Zero means that MTU is unknown and requests larger than 1300 bytes are switched
 	UDPMTUSize = 1500
 
+	// ErrUDPMTUCongestion is reported when large request could not be switched to TCP and it is sent over UDP
 	ErrUDPMTUCongestion = errors.New("size of packet larger than MTU")
 )
 
@@ -32,6 +35,7 @@ type UDPTransport struct {
 	pool      *ConnectionPool
 	mu        sync.Mutex
 	listeners []*UDPConnection
+	flows     *flowKeepAlives
 
 	log *slog.Logger
 }
@@ -45,6 +49,14 @@ func NewUDPTransport(par *sipgo.Parser) *UDPTransport {
 	return p
 }
 
+// udpSizeExceeded checks is message too large for UDP. RFC 3261 section 18.1.1
+func udpSizeExceeded(size int) bool {
+	if UDPMTUSize <= 0 {
+		return size > 1300
+	}
+	return size > UDPMTUSize-200
+}
+
 func (t *UDPTransport) String() string {
 	return "transport<UDP>"
 }
@@ -54,8 +66,19 @@ func (t *UDPTransport) Network() string {
 }
 
 func (t *UDPTransport) Close() error {
+	t.mu.Lock()
+	ls := t.listeners
+	t.listeners = nil
+	t.mu.Unlock()
+
+	var errs []error
+	for _, c := range ls {
+		if err := c.PacketConn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
 	t.pool.Clear()
-	return nil
+	return errors.Join(errs...)
 }
 
 // ServeConn is direct way to provide conn on which this worker will listen
@@ -95,14 +118,27 @@ func (t *UDPTransport) GetConnection(addr string) (Connection, error) {
 		return conn, nil
 	}
 
+	if l := t.listener(""); l != nil {
+		return l, nil
+	}
+	return nil, nil
+}
+
+// listener returns listener with local address laddr. If there is no such listener, first one is returned,
+// or nil if transport is used only in client mode
+func (t *UDPTransport) listener(laddr string) *UDPConnection {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	for _, l := range t.listeners {
+		if laddr != "" && l.LocalAddr().String() == laddr {
+			return l
+		}
+	}
 	// TODO: How to pick listener. Some address range mapping
 	if len(t.listeners) > 0 {
-		return t.listeners[0], nil
+		return t.listeners[0]
 	}
-
-	return nil, nil
+	return nil
 }
 
 // CreateConnection will create new connection
@@ -140,13 +176,14 @@ func (t *UDPTransport) CreateConnection(laddr Addr, host string, raddr Addr, han
 	t.log.Debug("New connection", "raddr", addr)
 
 	// Wrap it in reference
-	t.pool.Add(addr, c)
+	t.pool.addDialed(addr, c)
 	go t.readConnectedConnection(c, handler)
 	return c, err
 }
 
 func (t *UDPTransport) readConnection(conn *UDPConnection, handler sip.MessageHandler) {
 	buf := make([]byte, transportBufferSize)
+	laddr := conn.LocalAddr().String()
 	defer conn.Close()
 	for {
 		num, raddr, err := conn.ReadFrom(buf)
@@ -164,7 +201,12 @@ func (t *UDPTransport) readConnection(conn *UDPConnection, handler sip.MessageHa
 			continue
 		}
 
-		t.parseAndHandle(data, raddr.String(), handler)
+		if isSTUN(data) {
+			t.handleSTUN(conn, raddr, data)
+			continue
+		}
+
+		t.parseAndHandle(data, raddr.String(), laddr, handler)
 	}
 }
 
@@ -172,6 +214,7 @@ func (t *UDPTransport) readConnectedConnection(conn *UDPConnection, handler sip.
 	buf := make([]byte, transportBufferSize)
 	raddr := conn.raddr.String()
 	defer t.pool.CloseAndDelete(conn, raddr)
+	defer t.flows.closed("udp", raddr)
 
 	for {
 		num, err := conn.Read(buf)
@@ -185,12 +228,18 @@ func (t *UDPTransport) readConnectedConnection(conn *UDPConnection, handler sip.
 			return
 		}
 
+		t.pool.Touch(conn)
 		data := buf[:num]
 		if len(bytes.Trim(data, "\x00")) == 0 {
 			continue
 		}
 
-		t.parseAndHandle(data, raddr, handler)
+		if isSTUN(data) {
+			t.handleSTUN(conn, conn.raddr, data)
+			continue
+		}
+
+		t.parseAndHandle(data, raddr, "", handler)
 	}
 }
 
@@ -218,18 +267,47 @@ func (t *UDPTransport) readUDPConn(conn *net.UDPConn, handler sip.MessageHandler
 			continue
 		}
 
-		t.parseAndHandle(data, raddr.String(), handler)
+		t.parseAndHandle(data, raddr.String(), conn.LocalAddr().String(), handler)
 	}
 }
 
-func (t *UDPTransport) parseAndHandle(data []byte, src string, handler sip.MessageHandler) {
-	// Check is keep alive
-	if len(data) <= 4 {
-		//One or 2 CRLF
-		if len(bytes.Trim(data, "\r\n")) == 0 {
-			t.log.Debug("Keep alive CRLF received")
+// handleSTUN answers STUN binding requests used as keepalives by user agents
+// and passes binding responses to our keepalives.
+// https://datatracker.ietf.org/doc/html/rfc5626#section-8
+func (t *UDPTransport) handleSTUN(conn *UDPConnection, raddr net.Addr, data []byte) {
+	msg, err := stunDecode(data)
+	if err != nil {
+		t.log.Debug("failed to parse STUN", "err", err)
+		return
+	}
+
+	switch msg.typ {
+	case stunBindingRequest:
+		uaddr, ok := raddr.(*net.UDPAddr)
+		if !ok {
 			return
 		}
+		res := stunEncode(stunMessage{typ: stunBindingResponse, txID: msg.txID, mapped: uaddr})
+		if conn.raddr != nil {
+			_, err = conn.Write(res)
+		} else {
+			_, err = conn.WriteTo(res, raddr)
+		}
+		if err != nil {
+			t.log.Debug("Fail to write STUN response", "err", err)
+		}
+	case stunBindingResponse:
+		t.flows.stunResponse(raddr.String(), msg)
+	}
+}
+
+// parseAndHandle parses message received from src. Destination of message received on listener is set
+// to its local address, so that response is sent from same listener.
+func (t *UDPTransport) parseAndHandle(data []byte, src string, dst string, handler sip.MessageHandler) {
+	// Check is keep alive
+	if isCRLFKeepAlive(data) {
+		t.log.Debug("Keep alive CRLF received")
+		return
 	}
 
 	bytesPacketSize.WithLabelValues("udp", "read").Observe(float64(len(data)))
@@ -242,6 +320,9 @@ func (t *UDPTransport) parseAndHandle(data []byte, src string, handler sip.Messa
 
 	msg.SetTransport(TransportUDP)
 	msg.SetSource(src)
+	if dst != "" {
+		msg.SetDestination(dst)
+	}
 	handler(msg)
 }
 
@@ -350,10 +431,6 @@ func (c *UDPConnection) WriteMsg(msg sip.Message) error {
 
 	bytesPacketSize.WithLabelValues("udp", "write").Observe(float64(len(data)))
 
-	if len(data) > UDPMTUSize-200 {
-		return ErrUDPMTUCongestion
-	}
-
 	var n int
 	// TODO doing without if
 	if c.raddr != nil {
@@ -31,8 +31,9 @@ type WSTransport struct {
 	log       *slog.Logger
 	transport string
 
-	pool   *ConnectionPool
-	dialer ws.Dialer
+	pool      *ConnectionPool
+	dialer    ws.Dialer
+	listeners listeners
 }
 
 func NewWSTransport(par *sipgo.Parser) *WSTransport {
@@ -57,13 +58,15 @@ func (t *WSTransport) Network() string {
 }
 
 func (t *WSTransport) Close() error {
+	err := t.listeners.close()
 	t.pool.Clear()
-	return nil
+	return err
 }
 
 // Serve is direct way to provide conn on which this worker will listen
 func (t *WSTransport) Serve(l net.Listener, handler sip.MessageHandler) error {
 	t.log.Debug("begin listening on", "net", t.Network(), "addr", l.Addr())
+	t.listeners.add(l)
 
 	// Prepare handshake header writer from http.Header mapping.
 	// Some phones want to return this
@@ -115,7 +118,11 @@ func (t *WSTransport) initConnection(conn net.Conn, addr string, clientSide bool
 		refcount:   1,
 		clientSide: clientSide,
 	}
-	t.pool.Add(addr, c)
+	if clientSide {
+		t.pool.addDialed(addr, c)
+	} else {
+		t.pool.Add(addr, c)
+	}
 	go t.readConnection(c, addr, handler)
 	return c
 }
@@ -149,6 +156,7 @@ func (t *WSTransport) readConnection(conn *WSConnection, raddr string, handler s
 			continue
 		}
 
+		t.pool.Touch(conn)
 		data := buf[:num]
 
 		if len(bytes.Trim(data, "\x00")) == 0 {
@@ -1,8 +1,12 @@
 package sipgo
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"net"
+	"sync"
+	"time"
 
 	sipgo "github.com/emiago/sipgo/sip"
 
@@ -15,9 +19,15 @@ type UserAgent struct {
 	name        string
 	ip          net.IP
 	dnsResolver *net.Resolver
+	dnsCache    *transport.DNSCache
 	tlsConfig   *tls.Config
+	clock       sip.Clock
+	txOpts      []transaction.LayerOption
 	tp          *transport.Layer
 	tx          *transaction.Layer
+
+	mu         sync.Mutex
+	onShutdown []func(ctx context.Context)
 }
 
 type UserAgentOption func(s *UserAgent) error
@@ -48,6 +58,54 @@ func WithUserAgentDNSResolver(r *net.Resolver) UserAgentOption {
 	}
 }
 
+// WithUserAgentDNSCache allows customizing DNS cache used by transport layer for locating servers.
+// Cache can be created with any transport.DNSResolver, for example transport.DNSTable with static records
+func WithUserAgentDNSCache(c *transport.DNSCache) UserAgentOption {
+	return func(s *UserAgent) error {
+		s.dnsCache = c
+		return nil
+	}
+}
+
+// WithUserAgentTimers sets transaction timers T1, T2 and T4, from which other timers are derived.
+// Zero values are replaced with defaults
+// Default: transaction.DefaultTimers()
+func WithUserAgentTimers(t transaction.Timers) UserAgentOption {
+	return func(s *UserAgent) error {
+		s.txOpts = append(s.txOpts, transaction.WithLayerTimers(t))
+		return nil
+	}
+}
+
+// WithUserAgentTimersFunc allows overriding transaction timers per request,
+// for example with T1 estimated from round trip time of destination
+func WithUserAgentTimersFunc(f transaction.TimersFunc) UserAgentOption {
+	return func(s *UserAgent) error {
+		s.txOpts = append(s.txOpts, transaction.WithLayerTimersFunc(f))
+		return nil
+	}
+}
+
+// WithUserAgentClock sets clock used for timers of transaction and transport layer,
+// and for session timers of dialogs.
+// It allows using fake clock in tests
+// Default: sip.SystemClock
+func WithUserAgentClock(c sip.Clock) UserAgentOption {
+	return func(s *UserAgent) error {
+		s.clock = c
+		return nil
+	}
+}
+
+// WithUserAgentShutdownRetryAfter sets Retry-After of 503 responses for new requests received during Shutdown
+// Default: 5s
+func WithUserAgentShutdownRetryAfter(d time.Duration) UserAgentOption {
+	return func(s *UserAgent) error {
+		s.txOpts = append(s.txOpts, transaction.WithLayerRetryAfter(d))
+		return nil
+	}
+}
+
 // WithUserAgenTLSConfig allows customizing default tls config.
 func WithUserAgenTLSConfig(c *tls.Config) UserAgentOption {
 	return func(s *UserAgent) error {
@@ -82,8 +140,17 @@ func NewUA(options ...UserAgentOption) (*UserAgent, error) {
 	}
 
 	// TODO export parser to be configurable
-	ua.tp = transport.NewLayer(ua.dnsResolver, sipgo.NewParser(), ua.tlsConfig)
-	ua.tx = transaction.NewLayer(ua.tp)
+	var tpOpts []transport.LayerOption
+	txOpts := ua.txOpts
+	if ua.clock != nil {
+		tpOpts = append(tpOpts, transport.WithLayerClock(ua.clock))
+		txOpts = append(txOpts, transaction.WithLayerClock(ua.clock))
+	}
+	if ua.dnsCache != nil {
+		tpOpts = append(tpOpts, transport.WithLayerDNSCache(ua.dnsCache))
+	}
+	ua.tp = transport.NewLayer(ua.dnsResolver, sipgo.NewParser(), ua.tlsConfig, tpOpts...)
+	ua.tx = transaction.NewLayer(ua.tp, txOpts...)
 	return ua, nil
 }
 
@@ -95,6 +162,33 @@ func (ua *UserAgent) Close() error {
 	return ua.tp.Close()
 }
 
+// OnShutdown adds function called on Shutdown after new transactions are rejected
+// and before waiting for in-flight transactions. It can be used for terminating dialogs,
+// for example with DialogClient.ByeAll or ServerDialog.ByeAll
+func (ua *UserAgent) OnShutdown(f func(ctx context.Context)) {
+	ua.mu.Lock()
+	ua.onShutdown = append(ua.onShutdown, f)
+	ua.mu.Unlock()
+}
+
+// Shutdown gracefully stops user agent. New requests outside of dialog are rejected with
+// 503 Service Unavailable, OnShutdown functions are called and in-flight transactions are
+// waited to finish. After that listeners and connections are closed.
+// If context is done before transactions finish, they are terminated and context error is returned.
+func (ua *UserAgent) Shutdown(ctx context.Context) error {
+	ua.tx.Drain()
+
+	ua.mu.Lock()
+	onShutdown := ua.onShutdown
+	ua.mu.Unlock()
+	for _, f := range onShutdown {
+		f(ctx)
+	}
+
+	err := ua.tx.Shutdown(ctx)
+	return errors.Join(err, ua.Close())
+}
+
 // Listen adds listener for serve
 func (ua *UserAgent) setIP(ip net.IP) (err error) {
 	ua.ip = ip